@@ -0,0 +1,34 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+// Package apns2test provides mock APN service communication profiles
+// shared by apns2 tests and the bench harness. It is kept separate from
+// apns2 so that the mock service is not part of apns2 API.
+package apns2test
+
+import (
+	"time"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+)
+
+// Mock APN service communication profiles.
+var (
+	CommsTypical = apns2mock.CommsCfg{
+		MaxConcurrentStreams: 500,
+		MaxConns:             1000,
+		ConnectionDelay:      1 * time.Second,
+		ResponseTime:         20 * time.Millisecond,
+	}
+	Comms30ms = apns2mock.CommsCfg{
+		MaxConcurrentStreams: 500,
+		MaxConns:             1000,
+		ConnectionDelay:      30 * time.Millisecond,
+		ResponseTime:         30 * time.Millisecond,
+	}
+	CommsNoDelay = apns2mock.CommsCfg{
+		MaxConcurrentStreams: 500,
+		MaxConns:             1000,
+		ConnectionDelay:      0,
+		ResponseTime:         0,
+	}
+)
@@ -24,6 +24,18 @@ type RequestSigner interface {
 	SignRequest(r *http.Request) error
 }
 
+// TokenInvalidator is implemented by request signers that cache provider
+// tokens and can be told to discard them. When APN service rejects
+// a request for authentication reasons, the request is resubmitted once
+// after its signer's token has been invalidated.
+type TokenInvalidator interface {
+
+	// InvalidateToken discards the cached token if it was issued
+	// before the specified time. Tokens issued at or after that time
+	// are retained, so that concurrent failures cause a single refresh.
+	InvalidateToken(issuedBefore time.Time)
+}
+
 // DefaultTokenLifeSpan specifies the time duration for which
 // provier tokens are considered to be valid. At present APN service
 // stops honoring authentication tokens that are older than 1 hour.
@@ -32,6 +44,14 @@ type RequestSigner interface {
 // will use the new value.
 var DefaultTokenLifeSpan = 50 * time.Minute
 
+// MinSkewedTokenLifeSpan is the shortest token life span JWTSigner
+// resorts to when compensating for suspected clock skew.
+const MinSkewedTokenLifeSpan = 10 * time.Minute
+
+// clockSkewWindow is the time after token generation during which its
+// rejection by APN service is taken as a sign of clock skew.
+const clockSkewWindow = time.Minute
+
 // DefaultJWTSigningMethod method for APN requests is ES256.
 var DefaultJWTSigningMethod = jwt.SigningMethodES256
 
@@ -54,6 +74,12 @@ type JWTSigner struct {
 	// This is currently required to not exceed one hour.
 	TokenLifeSpan time.Duration
 
+	// CompensateClockSkew, if true, makes the signer halve the life span
+	// of its tokens, down to MinSkewedTokenLifeSpan, each time APN service
+	// rejects a freshly generated token, which suggests that local clock
+	// is skewed.
+	CompensateClockSkew bool
+
 	mu sync.Mutex
 	// Last generated token. This should not be accessed directly.
 	// Use GetToken() method, which may generated a new token
@@ -146,6 +172,46 @@ func (s *JWTSigner) GetToken() (*JWT, error) {
 	return tkn, nil
 }
 
+// InvalidateToken discards the current token if it was issued before
+// the specified time. A new token is generated upon next use.
+func (s *JWTSigner) InvalidateToken(issuedBefore time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := s.currentToken.Load()
+	if res != nil && res.(*JWT).IssuedAt.Before(issuedBefore) {
+		// Zero expiration time forces regeneration.
+		s.currentToken.Store(&JWT{})
+	}
+}
+
+// isFreshToken returns true if the current token was generated
+// shortly before the specified time.
+func (s *JWTSigner) isFreshToken(at time.Time) bool {
+	res := s.currentToken.Load()
+	if res == nil {
+		return false
+	}
+	iat := res.(*JWT).IssuedAt
+	return !iat.IsZero() && !iat.After(at) && at.Sub(iat) < clockSkewWindow
+}
+
+// clockSkewSuspected shortens token life span if CompensateClockSkew is set.
+func (s *JWTSigner) clockSkewSuspected() {
+	if !s.CompensateClockSkew {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokenLifeSpan == 0 {
+		// No token has been generated yet.
+		return
+	}
+	s.tokenLifeSpan /= 2
+	if s.tokenLifeSpan < MinSkewedTokenLifeSpan {
+		s.tokenLifeSpan = MinSkewedTokenLifeSpan
+	}
+}
+
 type noSigner struct{}
 
 func (s noSigner) SignRequest(r *http.Request) error {
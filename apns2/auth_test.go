@@ -118,6 +118,67 @@ func TestJWTSignerSignRequest(t *testing.T) {
 	assert.True(t, auth_test_jwtAsHeader.MatchString(h))
 }
 
+func TestJWTSignerInvalidateToken(t *testing.T) {
+	signingKey, err := cryptox.PKCS8PrivateKeyFromFile("../cryptox/test_data/pk_valid.p8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &JWTSigner{
+		KeyID:      "ABC123DEFG",
+		TeamID:     "DEF123GHIJ",
+		SigningKey: signingKey,
+	}
+	// no token yet
+	s.InvalidateToken(time.Now())
+	tk1, err := s.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token issued after the failure is retained
+	s.InvalidateToken(tk1.IssuedAt)
+	tk2, err := s.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, tk1 == tk2)
+	s.InvalidateToken(tk1.IssuedAt.Add(time.Nanosecond))
+	tk2, err = s.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, tk1 == tk2)
+}
+
+func TestJWTSignerClockSkew(t *testing.T) {
+	signingKey, err := cryptox.PKCS8PrivateKeyFromFile("../cryptox/test_data/pk_valid.p8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &JWTSigner{
+		KeyID:      "ABC123DEFG",
+		TeamID:     "DEF123GHIJ",
+		SigningKey: signingKey,
+	}
+	assert.False(t, s.isFreshToken(time.Now()))
+	tk, err := s.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, s.isFreshToken(tk.IssuedAt))
+	assert.False(t, s.isFreshToken(tk.IssuedAt.Add(-time.Second)))
+	assert.False(t, s.isFreshToken(tk.IssuedAt.Add(clockSkewWindow)))
+	// no compensation by default
+	s.clockSkewSuspected()
+	assert.Equal(t, DefaultTokenLifeSpan, s.tokenLifeSpan)
+	s.CompensateClockSkew = true
+	s.clockSkewSuspected()
+	assert.Equal(t, DefaultTokenLifeSpan/2, s.tokenLifeSpan)
+	for i := 0; i < 5; i++ {
+		s.clockSkewSuspected()
+	}
+	assert.Equal(t, MinSkewedTokenLifeSpan, s.tokenLifeSpan)
+}
+
 func TestNoSignerSignRequest(t *testing.T) {
 	s := NoSigner
 	req, err := http.NewRequest("POST", "", nil)
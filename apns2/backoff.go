@@ -4,6 +4,7 @@ package apns2
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/baobabus/go-apns/funit"
@@ -13,8 +14,13 @@ type backOffTracker struct {
 	initial time.Duration
 	max     time.Duration
 	jitter  funit.Measure
+	// source of jitter; global source is used if nil
+	rnd     *lockedRand
 	current time.Duration
 	end     time.Time
+	// custom back-off calculation; initial, max and jitter are ignored if set
+	fn       BackOffFunc
+	failures uint32
 }
 
 func (t *backOffTracker) update(status error) {
@@ -22,22 +28,24 @@ func (t *backOffTracker) update(status error) {
 		if now := time.Now(); now.After(t.end) {
 			// Ignore any failures before end time as they may be coming
 			// from a concurrent attempt.
-			if t.current == 0 {
-				t.current = t.initial
-			}
-			d := t.current
-			if t.jitter > 0 {
-				jtr := rand.Int63n(int64(funit.Measure(d) * t.jitter))
-				d += time.Duration(jtr)
-			}
-			if t.max > 0 && d > t.max {
-				d = t.max
+			var d time.Duration
+			if t.fn != nil {
+				t.failures++
+				d = t.fn(t.failures, ReasonClassNone)
+			} else {
+				if t.current == 0 {
+					t.current = t.initial
+				}
+				d = t.jittered(t.current)
+				if t.max > 0 && d > t.max {
+					d = t.max
+				}
+				t.current = t.current << 1
+				if t.max > 0 && t.current > t.max {
+					t.current = t.max
+				}
 			}
 			t.end = now.Add(d)
-			t.current = t.current << 1
-			if t.max > 0 && t.current > t.max {
-				t.current = t.max
-			}
 			logTrace(1, "backoff", "backing off for %v until %v", d, t.end)
 		}
 	} else {
@@ -45,11 +53,111 @@ func (t *backOffTracker) update(status error) {
 			// Ignore any success before end time as it may be coming
 			// from a concurrent attempt.
 			t.current = t.initial
-			logTrace(1, "backoff", "resetting to &v", t.current)
+			t.failures = 0
+			logTrace(1, "backoff", "resetting to %v", t.current)
 		}
 	}
 }
 
+// jittered returns d with a random amount of jitter added to it.
+func (t *backOffTracker) jittered(d time.Duration) time.Duration {
+	if t.jitter <= 0 {
+		return d
+	}
+	n := int64(funit.Measure(d) * t.jitter)
+	if n <= 0 {
+		return d
+	}
+	if t.rnd != nil {
+		return d + time.Duration(t.rnd.Int63n(n))
+	}
+	return d + time.Duration(rand.Int63n(n))
+}
+
+// lockedRand makes a rand.Rand safe for use in concurrent goroutines.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// newLockedRand returns a lockedRand using r or, if r is nil,
+// a time-seeded source.
+func newLockedRand(r *rand.Rand) *lockedRand {
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &lockedRand{r: r}
+}
+
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+// RetryBackOff specifies the delay before a failed push is retried.
+// The delay before the n-th retry is Base * 2^(n-1), capped at Max.
+type RetryBackOff struct {
+
+	// Base is the delay before the first retry.
+	Base time.Duration
+
+	// Max, if positive, is the maximum delay before a retry.
+	Max time.Duration
+
+	// Jitter is the maximum random amount, relative to the delay,
+	// that is added to it.
+	Jitter funit.Measure
+}
+
+// delay returns the delay before the n-th retry.
+func (b RetryBackOff) delay(n int, rnd *lockedRand) time.Duration {
+	if b.Base <= 0 || n <= 0 {
+		return 0
+	}
+	d := b.Base
+	for i := 1; i < n && (b.Max <= 0 || d < b.Max); i++ {
+		d <<= 1
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	t := backOffTracker{jitter: b.Jitter, rnd: rnd}
+	return t.jittered(d)
+}
+
+// BackOffFunc calculates the delay before the attempt-th consecutive retry
+// following a failure of the given reason class. Attempts are counted from 1.
+// When used for dial back-off, reason is always ReasonClassNone.
+type BackOffFunc func(attempt uint32, reason ReasonClass) time.Duration
+
+// ExponentialBackOff returns a BackOffFunc implementing jittered exponential
+// back-off as specified by b, regardless of the reason class. Its jitter
+// comes from the global math/rand source rather than from Client.Rand,
+// so it is not reproducible with a seeded Client.Rand.
+func ExponentialBackOff(b RetryBackOff) BackOffFunc {
+	return func(attempt uint32, _ ReasonClass) time.Duration {
+		return b.delay(int(attempt), nil)
+	}
+}
+
+// DefaultBackOff is a general purpose BackOffFunc. The delay starts
+// at one second and doubles with each attempt up to ten minutes,
+// with up to 10% of jitter added. Like any ExponentialBackOff, it does
+// not use Client.Rand.
+var DefaultBackOff = ExponentialBackOff(RetryBackOff{
+	Base:   time.Second,
+	Max:    10 * time.Minute,
+	Jitter: 10 * funit.Percent,
+})
+
+// reset clears any accumulated back-off, retaining the settings.
+func (t *backOffTracker) reset() {
+	t.current = 0
+	t.failures = 0
+	t.end = time.Time{}
+}
+
 func (t *backOffTracker) blackoutEnd() time.Time {
 	return t.end
 }
@@ -4,6 +4,7 @@ package apns2
 
 import (
 	"errors"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -126,3 +127,69 @@ func TestNoJitterCappedBackOffTracker(t *testing.T) {
 	d = time.Millisecond
 	assert.InDelta(t, time.Now().Add(d).UnixNano(), s.blackoutEnd().UnixNano(), backOffTesterTimeDelta)
 }
+
+func TestBackOffTrackerSeededJitter(t *testing.T) {
+	s1 := backOffTracker{jitter: 50 * funit.Percent, rnd: newLockedRand(rand.New(rand.NewSource(42)))}
+	s2 := backOffTracker{jitter: 50 * funit.Percent, rnd: newLockedRand(rand.New(rand.NewSource(42)))}
+	for i := 0; i < 10; i++ {
+		d := s1.jittered(time.Second)
+		assert.True(t, d >= time.Second && d < 1500*time.Millisecond)
+		assert.Equal(t, d, s2.jittered(time.Second))
+	}
+	// no jitter
+	s1.jitter = 0
+	assert.Equal(t, time.Second, s1.jittered(time.Second))
+}
+
+func TestRetryBackOffDelay(t *testing.T) {
+	b := RetryBackOff{Base: 100 * time.Millisecond, Max: time.Second}
+	assert.Equal(t, time.Duration(0), b.delay(0, nil))
+	assert.Equal(t, 100*time.Millisecond, b.delay(1, nil))
+	assert.Equal(t, 200*time.Millisecond, b.delay(2, nil))
+	assert.Equal(t, 800*time.Millisecond, b.delay(4, nil))
+	assert.Equal(t, time.Second, b.delay(5, nil))
+	assert.Equal(t, time.Second, b.delay(1000, nil))
+	// no base
+	assert.Equal(t, time.Duration(0), RetryBackOff{Max: time.Second}.delay(1, nil))
+	// jittered
+	b.Jitter = 50 * funit.Percent
+	d := b.delay(1, newLockedRand(rand.New(rand.NewSource(1))))
+	assert.True(t, d >= 100*time.Millisecond && d < 150*time.Millisecond)
+	assert.Equal(t, d, b.delay(1, newLockedRand(rand.New(rand.NewSource(1)))))
+}
+
+func TestBackOffTrackerFunc(t *testing.T) {
+	var got []uint32
+	s := backOffTracker{
+		initial: time.Hour,
+		fn: func(attempt uint32, reason ReasonClass) time.Duration {
+			assert.Equal(t, ReasonClassNone, reason)
+			got = append(got, attempt)
+			return time.Millisecond
+		},
+	}
+	s.update(backOffTesterErr)
+	assert.InDelta(t, time.Now().Add(time.Millisecond).UnixNano(), s.blackoutEnd().UnixNano(), backOffTesterTimeDelta)
+	// ignored until the end of back-off
+	s.update(backOffTesterErr)
+	time.Sleep(2 * time.Millisecond)
+	s.update(backOffTesterErr)
+	time.Sleep(2 * time.Millisecond)
+	s.update(nil)
+	s.update(backOffTesterErr)
+	assert.Equal(t, []uint32{1, 2, 1}, got)
+	s.reset()
+	s.update(backOffTesterErr)
+	assert.Equal(t, []uint32{1, 2, 1, 1}, got)
+}
+
+func TestExponentialBackOff(t *testing.T) {
+	f := ExponentialBackOff(RetryBackOff{Base: 100 * time.Millisecond, Max: time.Second})
+	assert.Equal(t, 100*time.Millisecond, f(1, ReasonClassThrottled))
+	assert.Equal(t, 400*time.Millisecond, f(3, ReasonClassNone))
+	assert.Equal(t, time.Second, f(100, ReasonClassRetriable))
+	for i := uint32(1); i < 20; i++ {
+		d := DefaultBackOff(i, ReasonClassNone)
+		assert.True(t, d >= time.Second && d < 11*time.Minute)
+	}
+}
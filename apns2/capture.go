@@ -0,0 +1,214 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/baobabus/go-apns/funit"
+)
+
+// CaptureRecord is a complete record of a single push attempt, including
+// HTTP headers and bodies of both the request and the response, captured
+// for a sampled fraction of traffic. See CommsCfg.CaptureRate.
+type CaptureRecord struct {
+
+	// Time at which the request was sent.
+	Time time.Time
+
+	// Duration is the time it took to receive the response.
+	Duration time.Duration
+
+	// Gateway is the APN service endpoint the request was sent to.
+	Gateway string
+
+	// Attempt is the ordinal number of the attempt, starting with 1.
+	Attempt int
+
+	// URL is the request URL.
+	URL string
+
+	// RequestHeader holds HTTP headers of the request. Authorization
+	// header, if present, is redacted.
+	RequestHeader http.Header
+
+	// RequestBody is the request body exactly as it was sent.
+	RequestBody []byte
+
+	// StatusCode, ResponseHeader and ResponseBody describe the response,
+	// if one was received.
+	StatusCode     int
+	ResponseHeader http.Header
+	ResponseBody   []byte
+
+	// Err is the text of the error encountered while attempting the push,
+	// if any.
+	Err string
+}
+
+// CaptureEmitter is implemented by receivers of captured push attempts.
+// Capture is called from a single goroutine, one record at a time.
+type CaptureEmitter interface {
+	Capture(r *CaptureRecord)
+}
+
+// DefaultCaptureBufferSize is the number of capture records buffered
+// for client's CaptureEmitter.
+const DefaultCaptureBufferSize = 100
+
+// captureSink samples push attempts and hands the captured records
+// over to the emitter and to the retention ring. Records are not handed
+// over to the emitter if it cannot keep up. Nil captureSink captures nothing.
+type captureSink struct {
+	id        string
+	threshold uint32
+	emitter   CaptureEmitter
+	queue     chan *CaptureRecord
+	ctl       chan struct{}
+	once      sync.Once
+
+	mu   sync.Mutex
+	ring []*CaptureRecord
+	pos  int
+	cnt  int
+}
+
+func newCaptureSink(id string, rate funit.Measure, retain int, emitter CaptureEmitter) *captureSink {
+	if rate <= 0 || emitter == nil && retain <= 0 {
+		return nil
+	}
+	res := &captureSink{id: id, threshold: math.MaxUint32}
+	if rate < 1 {
+		res.threshold = uint32(float64(rate) * math.MaxUint32)
+	}
+	if retain > 0 {
+		res.ring = make([]*CaptureRecord, retain)
+	}
+	if emitter != nil {
+		res.emitter = emitter
+		res.queue = make(chan *CaptureRecord, DefaultCaptureBufferSize)
+		res.ctl = make(chan struct{})
+		go res.run()
+	}
+	return res
+}
+
+// sampled reports whether attempts of the request are to be captured.
+// Sampling is based on the hash of notification's recipient and ApnsID,
+// so every attempt of a sampled request is captured. Notifications with
+// no ApnsID are sampled by recipient.
+func (s *captureSink) sampled(req *Request) bool {
+	if s == nil {
+		return false
+	}
+	if s.threshold == math.MaxUint32 {
+		return true
+	}
+	n := req.Notification
+	h := fnv.New32a()
+	h.Write([]byte(n.Recipient))
+	if n.Header != nil {
+		h.Write([]byte(n.Header.ChannelID))
+	}
+	h.Write([]byte(n.ApnsID))
+	return h.Sum32() < s.threshold
+}
+
+// newCaptureRecord captures the request part of a push attempt.
+func newCaptureRecord(req *Request, httpReq *http.Request, gateway string) *CaptureRecord {
+	res := &CaptureRecord{
+		Time:          time.Now(),
+		Gateway:       gateway,
+		Attempt:       req.attemptCnt + 1,
+		URL:           httpReq.URL.String(),
+		RequestHeader: make(http.Header, len(httpReq.Header)),
+	}
+	for k, v := range httpReq.Header {
+		if k == "Authorization" {
+			v = []string{"<redacted>"}
+		}
+		res.RequestHeader[k] = append([]string(nil), v...)
+	}
+	if body, ok := httpReq.Body.(*sliceReader); ok {
+		res.RequestBody = append([]byte(nil), body.buf...)
+	}
+	return res
+}
+
+func (s *captureSink) put(r *CaptureRecord) {
+	if s == nil {
+		return
+	}
+	if s.ring != nil {
+		s.mu.Lock()
+		s.ring[s.pos] = r
+		s.pos = (s.pos + 1) % len(s.ring)
+		if s.cnt < len(s.ring) {
+			s.cnt++
+		}
+		s.mu.Unlock()
+	}
+	if s.queue == nil {
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+	}
+}
+
+// retained returns retained records, oldest first.
+func (s *captureSink) retained() []*CaptureRecord {
+	if s == nil || s.ring == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]*CaptureRecord, 0, s.cnt)
+	for i := s.cnt; i > 0; i-- {
+		res = append(res, s.ring[(s.pos-i+len(s.ring))%len(s.ring)])
+	}
+	return res
+}
+
+// stop lets the sink emit any buffered records and exit.
+func (s *captureSink) stop() {
+	if s == nil || s.ctl == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.ctl)
+	})
+}
+
+func (s *captureSink) run() {
+	for {
+		select {
+		case r := <-s.queue:
+			s.emitter.Capture(r)
+		case <-s.ctl:
+			for {
+				select {
+				case r := <-s.queue:
+					s.emitter.Capture(r)
+				default:
+					logInfo(s.id, "Stopped.")
+					return
+				}
+			}
+		}
+	}
+}
+
+// Captures returns the most recent captured push attempts, oldest first.
+// Up to CommsCfg.CaptureRetain records are retained. It returns nil
+// if capturing is not enabled.
+func (c *Client) Captures() []*CaptureRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.captures.retained()
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/baobabus/go-apns/funit"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCaptureEmitter chan *CaptureRecord
+
+func (e testCaptureEmitter) Capture(r *CaptureRecord) {
+	e <- r
+}
+
+func TestCaptureSink(t *testing.T) {
+	assert.Nil(t, newCaptureSink("test", 0, 10, nil))
+	assert.Nil(t, newCaptureSink("test", funit.Percent, 0, nil))
+	var nilSink *captureSink
+	assert.False(t, nilSink.sampled(&Request{Notification: testNotif_Good}))
+	assert.Nil(t, nilSink.retained())
+	// sampling is deterministic and roughly proportional
+	s := newCaptureSink("test", 10*funit.Percent, 3, nil)
+	cnt := 0
+	for i := 0; i < 10000; i++ {
+		req := &Request{Notification: &Notification{Recipient: testNotif_Good.Recipient, ApnsID: fmt.Sprintf("id-%d", i)}}
+		if s.sampled(req) {
+			cnt++
+			assert.True(t, s.sampled(req))
+		}
+	}
+	assert.InDelta(t, 1000, cnt, 150)
+	assert.True(t, newCaptureSink("test", 1, 3, nil).sampled(&Request{Notification: testNotif_Good}))
+	// retention
+	for i := 1; i <= 4; i++ {
+		s.put(&CaptureRecord{Attempt: i})
+	}
+	var attempts []int
+	for _, r := range s.retained() {
+		attempts = append(attempts, r.Attempt)
+	}
+	assert.Equal(t, []int{2, 3, 4}, attempts)
+}
+
+func TestClient_Captures(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.CaptureRate = 1
+	c.CommsCfg.CaptureRetain = 10
+	emitter := make(testCaptureEmitter, 1)
+	c.CaptureEmitter = emitter
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	r := <-emitter
+	assert.Equal(t, 1, r.Attempt)
+	assert.Equal(t, 200, r.StatusCode)
+	assert.Equal(t, `{"aps":{"alert":"Ping!"}}`, string(r.RequestBody))
+	assert.Equal(t, "com.example.Alert", r.RequestHeader.Get("apns-topic"))
+	assert.Equal(t, "<redacted>", r.RequestHeader.Get("Authorization"))
+	assert.Contains(t, r.URL, testNotif_Good.Recipient)
+	assert.Empty(t, r.Err)
+	assert.Equal(t, []*CaptureRecord{r}, c.Captures())
+}
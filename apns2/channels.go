@@ -0,0 +1,219 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// BroadcastGateway holds Development & Production urls of APN service
+// channel management endpoints.
+var BroadcastGateway = struct {
+	Development string
+	Production  string
+}{
+	Development: "https://api-manage-broadcast.sandbox.push.apple.com:2195",
+	Production:  "https://api-manage-broadcast.push.apple.com:2196",
+}
+
+// MessageStoragePolicy specifies whether APN service stores the most recent
+// message sent on a broadcast channel for delivery to devices that are
+// offline at the time.
+type MessageStoragePolicy int
+
+const (
+	NoMessageStored         MessageStoragePolicy = 0
+	MostRecentMessageStored MessageStoragePolicy = 1
+)
+
+// ChannelRoot is the root URL path for channel management requests.
+const ChannelRoot = "/1/apps/"
+
+var ErrChannelManagerClosed = errors.New("apns2: channel manager is closed")
+
+// ChannelError indicates that APN service rejected a channel
+// management request.
+type ChannelError struct {
+
+	// StatusCode is the HTTP status code returned by APN service.
+	StatusCode int
+
+	// Reason is the rejection reason returned by APN service.
+	Reason string
+}
+
+func (e *ChannelError) Error() string {
+	return fmt.Sprintf("apns2: channel request failed with status %d: %s", e.StatusCode, e.Reason)
+}
+
+// ChannelManager manages broadcast channels of a single app. Broadcast
+// notifications are sent with a regular Client by setting ChannelID
+// on notification header.
+//
+// ChannelManager maintains its own connection to the channel management
+// endpoint. It is safe for use in concurrent goroutines.
+type ChannelManager struct {
+
+	// Gateway is the channel management endpoint. See BroadcastGateway.
+	Gateway string
+
+	// BundleID is the bundle identifier of the app whose channels
+	// are managed.
+	BundleID string
+
+	// Certificate and RootCA have the same meaning as in Client.
+	Certificate *tls.Certificate
+	RootCA      *tls.Certificate
+
+	// Signer, if not nil, is used to sign channel management requests.
+	Signer RequestSigner
+
+	// CommsCfg configures the connection to the management endpoint.
+	CommsCfg CommsCfg
+
+	mu     sync.Mutex
+	hc     *HTTPClient
+	closed bool
+}
+
+// CreateChannel creates a new broadcast channel and returns its identifier.
+func (m *ChannelManager) CreateChannel(ctx context.Context, policy MessageStoragePolicy) (string, error) {
+	body, err := json.Marshal(&channelInfo{Policy: policy, PushType: "LiveActivity"})
+	if err != nil {
+		return "", err
+	}
+	httpResp, err := m.do(ctx, "POST", "channels", "", body)
+	if err != nil {
+		return "", err
+	}
+	defer httpResp.Body.Close()
+	return httpResp.Header.Get("apns-channel-id"), nil
+}
+
+// ReadChannel returns message storage policy of the channel.
+func (m *ChannelManager) ReadChannel(ctx context.Context, channelID string) (MessageStoragePolicy, error) {
+	httpResp, err := m.do(ctx, "GET", "channels", channelID, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResp.Body.Close()
+	var res channelInfo
+	if err := json.NewDecoder(httpResp.Body).Decode(&res); err != nil {
+		return 0, err
+	}
+	return res.Policy, nil
+}
+
+// DeleteChannel deletes the channel.
+func (m *ChannelManager) DeleteChannel(ctx context.Context, channelID string) error {
+	httpResp, err := m.do(ctx, "DELETE", "channels", channelID, nil)
+	if err != nil {
+		return err
+	}
+	return httpResp.Body.Close()
+}
+
+// ListChannels returns identifiers of all channels of the app.
+func (m *ChannelManager) ListChannels(ctx context.Context) ([]string, error) {
+	httpResp, err := m.do(ctx, "GET", "all-channels", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	var res struct {
+		Channels []string `json:"channels"`
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return res.Channels, nil
+}
+
+// Close closes the connection to the management endpoint.
+// ChannelManager cannot be used after it is closed.
+func (m *ChannelManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.hc == nil {
+		return nil
+	}
+	return m.hc.Close()
+}
+
+type channelInfo struct {
+	Policy   MessageStoragePolicy `json:"message-storage-policy"`
+	PushType string               `json:"push-type"`
+}
+
+func (m *ChannelManager) httpClient() (*HTTPClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrChannelManagerClosed
+	}
+	if m.hc == nil {
+		hc, err := NewHTTPClient(m.Gateway, m.CommsCfg, m.Certificate, m.RootCA)
+		if err != nil {
+			return nil, err
+		}
+		m.hc = hc
+	}
+	return m.hc, nil
+}
+
+// do submits channel management request and returns the response
+// if it indicates success. The caller must close response body.
+func (m *ChannelManager) do(ctx context.Context, method string, resource string, channelID string, body []byte) (*http.Response, error) {
+	hc, err := m.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequest(method, m.Gateway+ChannelRoot+m.BundleID+"/"+resource, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", DefaultContentType)
+	}
+	if channelID != "" {
+		httpReq.Header.Set("apns-channel-id", channelID)
+	}
+	if m.Signer != nil {
+		if err := m.Signer.SignRequest(httpReq); err != nil {
+			return nil, err
+		}
+	}
+	if ctx != NoContext {
+		httpReq = httpReq.WithContext(ctx)
+	}
+	httpResp, err := hc.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if httpResp.StatusCode >= http.StatusOK && httpResp.StatusCode < http.StatusMultipleChoices {
+		return httpResp, nil
+	}
+	defer httpResp.Body.Close()
+	resp := &Response{StatusCode: httpResp.StatusCode}
+	lr := io.LimitReader(httpResp.Body, m.CommsCfg.maxResponseBodySize())
+	if err := json.NewDecoder(lr).Decode(resp); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if resp.Class() == ReasonClassAuth {
+		return nil, &AuthError{StatusCode: resp.StatusCode, Reason: resp.RejectionReason}
+	}
+	return nil, &ChannelError{StatusCode: resp.StatusCode, Reason: resp.RejectionReason}
+}
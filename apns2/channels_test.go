@@ -0,0 +1,134 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader_Broadcast(t *testing.T) {
+	h := &Header{Topic: "com.example.Alert", PushType: PushTypeLiveActivity, ChannelID: "dHN0LXNyY2gtY2hubA=="}
+	assert.Equal(t, [][2]string{
+		{"apns-topic", "com.example.Alert"},
+		{"apns-push-type", "liveactivity"},
+		{"apns-channel-id", "dHN0LXNyY2gtY2hubA=="},
+	}, h.getHTTPHeaders())
+	n := &Notification{Recipient: "abc", Header: h}
+	assert.True(t, n.IsBroadcast())
+	assert.Equal(t, BroadcastRoot+"com.example.Alert", n.path())
+	n = &Notification{Recipient: "abc", Header: &Header{}}
+	assert.False(t, n.IsBroadcast())
+	assert.Equal(t, RequestRoot+"abc", n.path())
+}
+
+func TestClient_Broadcast(t *testing.T) {
+	var path, channel string
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			channel = r.Header.Get("apns-channel-id")
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n := &Notification{
+		Header:  &Header{Topic: "com.example.Alert", PushType: PushTypeLiveActivity, ChannelID: "chan-1"},
+		Payload: testNotif_Good.Payload,
+	}
+	cb := make(chan *Result, 1)
+	if err := c.Push(n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.Equal(t, "/4/broadcast/apps/com.example.Alert", path)
+	assert.Equal(t, "chan-1", channel)
+}
+
+func TestChannelManager(t *testing.T) {
+	var mu sync.Mutex
+	channels := map[string]MessageStoragePolicy{}
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			id := r.Header.Get("apns-channel-id")
+			switch r.Method + " " + r.URL.Path {
+			case "POST /1/apps/com.example.Alert/channels":
+				var ci channelInfo
+				json.NewDecoder(r.Body).Decode(&ci)
+				channels["chan-1"] = ci.Policy
+				w.Header().Set("apns-channel-id", "chan-1")
+				w.WriteHeader(http.StatusCreated)
+			case "GET /1/apps/com.example.Alert/channels":
+				p, ok := channels[id]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"reason":"ChannelNotRegistered"}`))
+					return
+				}
+				json.NewEncoder(w).Encode(&channelInfo{Policy: p, PushType: "LiveActivity"})
+			case "DELETE /1/apps/com.example.Alert/channels":
+				delete(channels, id)
+				w.WriteHeader(http.StatusNoContent)
+			case "GET /1/apps/com.example.Alert/all-channels":
+				var ids []string
+				for id := range channels {
+					ids = append(ids, id)
+				}
+				json.NewEncoder(w).Encode(map[string][]string{"channels": ids})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	m := &ChannelManager{
+		Gateway:  s.URL,
+		BundleID: "com.example.Alert",
+		RootCA:   s.RootCertificate,
+		CommsCfg: commsTest_Fast,
+	}
+	ctx := context.Background()
+	id, err := m.CreateChannel(ctx, MostRecentMessageStored)
+	assert.Nil(t, err)
+	assert.Equal(t, "chan-1", id)
+	p, err := m.ReadChannel(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, MostRecentMessageStored, p)
+	ids, err := m.ListChannels(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"chan-1"}, ids)
+	assert.Nil(t, m.DeleteChannel(ctx, id))
+	_, err = m.ReadChannel(ctx, id)
+	if assert.IsType(t, &ChannelError{}, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*ChannelError).StatusCode)
+		assert.Equal(t, "ChannelNotRegistered", err.(*ChannelError).Reason)
+	}
+	assert.Nil(t, m.Close())
+	_, err = m.ListChannels(ctx)
+	assert.Equal(t, ErrChannelManagerClosed, err)
+}
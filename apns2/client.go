@@ -6,14 +6,22 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/baobabus/go-apns/funit"
 	"github.com/baobabus/go-apns/syncx"
 )
 
 // Gateway holds APN service's Development & Production urls.
 // These use default HTTPS port 443. According to Apple you can
-// alternatively use port 2197 if needed.
+// alternatively use port 2197 if needed. See GatewayWithPort.
 var Gateway = struct {
 	Development string
 	Production  string
@@ -22,16 +30,55 @@ var Gateway = struct {
 	Production:  "https://api.push.apple.com",
 }
 
+// Ports APN service is known to listen on.
+const (
+	DefaultPort     = 443
+	AlternativePort = 2197
+)
+
+// GatewayWithPort returns gateway URL with its port set to the specified
+// one. Apple supports DefaultPort and AlternativePort, but any port
+// can be used, e.g. when connecting through a proxy.
+func GatewayWithPort(gateway string, port int) (string, error) {
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return "", err
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	return u.String(), nil
+}
+
+// gatewayPort returns the port to be used to connect to gateway.
+func gatewayPort(gateway string) (string, error) {
+	u, err := url.ParseRequestURI(gateway)
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(authorityAddr(u.Scheme, u.Host))
+	return port, err
+}
+
 // APNS default root URL path.
 const RequestRoot = "/3/device/"
 
+// BroadcastRoot is the root URL path for broadcast pushes.
+const BroadcastRoot = "/4/broadcast/apps/"
+
 var (
 	ErrMissingAuth          = errors.New("apns2: authentication is not possible with no client certificate and no signer")
 	ErrClientNotRunning     = errors.New("apns2: client processing pipeline not running")
 	ErrClientAlreadyStarted = errors.New("apns2: client processing pipeline already started")
 	ErrClientAlreadyClosed  = errors.New("apns2: client processing pipeline already closed")
+	ErrClientClosing        = errors.New("apns2: client processing pipeline is shutting down")
 	ErrPushInterrupted      = errors.New("apns2: push request interrupted")
 	ErrCanceled             = errors.New("apns2: push request canceled")
+	ErrQuotaExceeded        = errors.New("apns2: notification quota exceeded")
+	ErrMaxConnsBelowMin     = errors.New("apns2: MaxConns must not be less than MinConns")
+	ErrNegativeMaxRate      = errors.New("apns2: MaxRate must not be negative")
+	ErrRetryOverflow        = errors.New("apns2: retry could not be resubmitted")
+	ErrCollapsed            = errors.New("apns2: notification collapsed within CollapseIDMinInterval")
+	ErrRetryExpired         = errors.New("apns2: push request exceeded MaxRetryAge")
+	ErrMissingNotification  = errors.New("apns2: push request has no notification")
 )
 
 // NoSigner can be used where a RequestSigner is required when a push request
@@ -72,6 +119,8 @@ type Client struct {
 	// Gateway is the APN service connection endpoint.
 	// Apple publishes two public endpoints: production and development.
 	// They are preconfigured in Gateway.Production and Gateway.Development.
+	// Gateway may specify a port, e.g. "https://api.push.apple.com:2197".
+	// If not specified, port 443 is used.
 	Gateway string
 
 	// CommsCfg contains communication settings to be used by the client.
@@ -95,19 +144,70 @@ type Client struct {
 	// Signer, if not nil, is used to sign individual requests to APN service.
 	Signer RequestSigner
 
+	// PayloadEncoder, if not nil, is used to encode notification payloads
+	// that are not already supplied as a string or a slice of bytes.
+	// If nil, encoding/json is used.
+	PayloadEncoder PayloadEncoder
+
 	// Queue for submitting push requests.
 	//
 	// You can use it directly in your code, especially in select statements
 	// when coordination with other channels is desired.
 	// Alternatively client's Push method can be used.
+	//
+	// Closing the queue indicates end of input and initiates soft shutdown.
+	// Requests already taken off the queue, including any of their retries,
+	// are still processed to completion. Use Done to learn when all results
+	// have been delivered.
 	Queue <-chan *Request
 
 	// Callback, if not nil, specifies the channel to which the outcome of
 	// the push request executions should be delivered.
 	// If Callback is nil and a request doesn't specify an alternative callback,
-	// requests execution result is silently dropped.
+	// requests execution result is silently dropped. Processing never
+	// blocks on a nil Callback. See also ProcCfg.DiscardResults.
 	Callback chan<- *Result
 
+	// ReceiptEmitter, if not nil, is given a delivery receipt for the final
+	// outcome of every push request. Receipts are buffered, and are dropped
+	// rather than slowing down the processing if the emitter cannot keep up.
+	ReceiptEmitter ReceiptEmitter
+
+	// CaptureEmitter, if not nil, is given a complete record of every
+	// push attempt sampled according to CommsCfg.CaptureRate. Records
+	// are dropped rather than slowing down the processing if the emitter
+	// cannot keep up.
+	CaptureEmitter CaptureEmitter
+
+	// OnConnCountChange, if not nil, is called every time the number
+	// of active connections to APN service changes. It is called
+	// synchronously from the governor and must not block.
+	OnConnCountChange func(old, new int)
+
+	// StreamerEvents, if not nil, receives an event for every lifecycle
+	// transition of client's streamers, each of which owns a single
+	// connection to APN service. Events are sent without blocking and
+	// are dropped if the channel is not ready to receive them, so
+	// a buffered channel should be used. The channel is not closed
+	// by the client.
+	StreamerEvents chan<- *StreamerEvent
+
+	// Rand, if not nil, is the source of randomness for all jitter applied
+	// by the client, such as CommsCfg.DialBackOffJitter. Supplying a source
+	// with a fixed seed makes the jitter reproducible, e.g. in tests and
+	// load test replays. If Rand is nil, a time-seeded source is used.
+	// Jitter calculated by BackOffFuncs, including DefaultBackOff and those
+	// built with ExponentialBackOff, does not come from Rand.
+	// The source is owned by the client from the time it is started
+	// and must not be used elsewhere, including by other clients.
+	Rand *rand.Rand
+
+	// Poller, if not nil, drives client's performance metrics sampling
+	// in place of its own ticker, and its Interval is used instead
+	// of ProcCfg.PollInterval. A single Poller can be shared by many
+	// clients to reduce timer overhead and stagger their evaluations.
+	Poller *Poller
+
 	retry chan *Request
 
 	out chan *Request
@@ -120,11 +220,71 @@ type Client struct {
 	cctl  chan struct{} // submitter control channel
 	gctl  chan struct{} // governor control channel
 	cdone chan struct{} // pipeline done processing signal
+	done  chan struct{} // closed once the client is fully stopped
+	idle  chan struct{} // signaled when no requests are pending
+
+	// shutdown outcome, set once done is closed
+	outcome *ShutdownOutcome
 
 	// counter for waits on outbound channel
 	waitCtr syncx.TickTockCounter
 	// counter of processed requests
 	rateCtr syncx.Counter
+
+	// lifetime counters, accessed atomically
+	connCnt      uint32
+	retryCnt     uint64
+	completedCnt uint64
+	admittedCnt  uint64
+	// number of accepted requests yet to be completed, accessed atomically
+	pendingCnt int64
+
+	collapseTracker *collapseTracker
+	tagTracker      *tagTracker
+	attemptTracker  *attemptTracker
+	dropTracker     *dropTracker
+	dialTracker     *dialTracker
+	reuseTracker    *reuseTracker
+	goAwayTracker   *goAwayTracker
+	statusTracker   *statusTracker
+	settleTracker   *settleTracker
+	topicLimiter    *topicLimiter
+	sched           *scheduler
+	dispatcher      *dispatcher
+	receipts        *receiptSink
+	captures        *captureSink
+	completions     *completionPool
+	rnd             *lockedRand
+	ipSlots         *ipSlots
+	dnsCache        *dnsCache
+	collapseLimiter *collapseLimiter
+
+	// primary gateway followed by fallback gateways, and the index
+	// of the active one, accessed atomically
+	gateways   []string
+	gatewayIdx int32
+
+	// input flow control state, guarded by mu
+	flow *flowState
+
+	// degraded state cause and last authentication failure, nil if healthy
+	healthMu   sync.Mutex
+	degraded   *DegradedError
+	authErr    *AuthError
+	saturation *SaturationError
+	hasAuthErr int32 // accessed atomically
+
+	// connection allowance shared with other clients, if any
+	budget *connBudget
+	// true if Callback is shared with other clients and must not be closed
+	sharedCallback bool
+}
+
+// flowState is an immutable snapshot of client's input flow control state.
+// Its changed channel is closed when the state is superseded.
+type flowState struct {
+	paused  bool
+	changed chan struct{}
 }
 
 const (
@@ -147,8 +307,17 @@ func (c *Client) Start(wg *sync.WaitGroup) error {
 	if c.state >= stateStarting {
 		return ErrClientAlreadyStarted
 	}
+	gateways, err := gatewayList(c.Gateway, c.CommsCfg.FallbackEndpoints)
+	if err != nil {
+		return err
+	}
+	c.gateways = gateways
+	c.gatewayIdx = 0
 	c.state = stateStarting
 	logInfo(c.Id, "Starting.")
+	if port, err := gatewayPort(c.Gateway); err == nil && port != strconv.Itoa(DefaultPort) && port != strconv.Itoa(AlternativePort) {
+		logWarn(c.Id, "Using non-standard APN service port %s.", port)
+	}
 	if wg != nil {
 		wg.Add(1)
 	}
@@ -157,15 +326,61 @@ func (c *Client) Start(wg *sync.WaitGroup) error {
 	c.cctl = make(chan struct{})
 	c.gctl = make(chan struct{})
 	c.cdone = make(chan struct{})
+	c.done = make(chan struct{})
+	c.idle = make(chan struct{}, 1)
 	c.out = make(chan *Request)
 	c.retry = make(chan *Request)
+	c.flow = &flowState{changed: make(chan struct{})}
+	c.receipts = newReceiptSink(c.Id+"-Receipts", c.ReceiptEmitter, c.ProcCfg.ReceiptBufferSize)
+	c.captures = newCaptureSink(c.Id+"-Captures", c.CommsCfg.CaptureRate, c.CommsCfg.CaptureRetain, c.CaptureEmitter)
+	c.completions = newCompletionPool(c.ProcCfg.CompletionWorkers)
+	c.rnd = newLockedRand(c.Rand)
+	c.ipSlots = newIPSlots(c.CommsCfg.MaxConnsPerIP)
+	c.dnsCache = newDNSCache(c.CommsCfg.DNSCacheTTL)
+	c.collapseLimiter = newCollapseLimiter(c.ProcCfg.CollapseIDMinInterval, c.ProcCfg.CoalesceCollapseIDs)
+	c.tagTracker = newTagTracker()
+	c.attemptTracker = newAttemptTracker()
+	c.dropTracker = newDropTracker()
+	c.dialTracker = newDialTracker()
+	c.reuseTracker = &reuseTracker{}
+	c.goAwayTracker = &goAwayTracker{}
+	c.topicLimiter = newTopicLimiter(c.ProcCfg.TopicConcurrency)
+	c.settleTracker = &settleTracker{}
+	c.sched = newScheduler(c)
+	c.wg.Add(1)
+	go c.sched.run(c.cctl, &c.wg)
+	c.collapseTracker = newCollapseTracker(c.Id, c.ProcCfg.CollapseIDTrackSize, c.ProcCfg.CollapseIDWarnRate)
+	pcfg := c.ProcCfg
+	if c.Poller != nil {
+		pcfg.PollInterval = c.Poller.Interval
+	}
+	c.statusTracker = newStatusTracker(pcfg.statusWindow(), pcfg.PollInterval)
 	c.gov = &governor{
-		id:      c.Id + "-Governor",
-		c:       c,
-		ctl:     c.gctl,
-		done:    c.cdone,
-		cfg:     c.ProcCfg,
-		minSust: c.ProcCfg.minSustainPollPeriods(),
+		id:        c.Id + "-Governor",
+		c:         c,
+		ctl:       c.gctl,
+		done:      c.cdone,
+		cfg:       pcfg,
+		minSust:   pcfg.minSustainPollPeriods(),
+		stallSust: pcfg.stallPollPeriods(),
+		dumps:     make(chan chan *DebugState),
+		maxConns:  make(chan uint32),
+		maxRate:   make(chan funit.Measure),
+	}
+	c.gov.curMaxConns = c.ProcCfg.MaxConns
+	c.gov.curMaxRate = math.Float64bits(float64(c.ProcCfg.MaxRate))
+	c.gov.dialErrTracker = newErrRateTracker(c.ProcCfg.DialFailureWindow, c.ProcCfg.MaxDialFailureRate)
+	if n := c.ProcCfg.MaxInFlightRetries; n > 0 {
+		c.gov.retrySlots = make(chan struct{}, n)
+	}
+	if c.ProcCfg.DispatchStrategy != nil {
+		c.dispatcher = newDispatcher(c.Id+"-Dispatcher", c.ProcCfg.DispatchStrategy)
+		if wg != nil {
+			wg.Add(1)
+		}
+		go c.dispatcher.run(c.out, c.ctl, wg)
+	} else {
+		c.dispatcher = nil
 	}
 	// TODO Figure out coordination of governor and retrier shutdowns.
 	go c.gov.run()
@@ -173,10 +388,22 @@ func (c *Client) Start(wg *sync.WaitGroup) error {
 	return nil
 }
 
-// Stop performs soft shutdown of the Client. All inflight requests are
-// given the chance to be executed.
+// Stop performs soft shutdown of the Client. No new push requests are
+// accepted, while all accepted requests are given the chance to be executed,
+// including any retries, until they reach their final outcome. Requests
+// that are held back until their NotBefore time are failed with
+// ErrPushInterrupted. Stop returns once processing pipeline is drained
+// and all results have been delivered, including calls to requests'
+// OnComplete funcs.
+//
+// Closing client's Queue has the same effect as calling Stop, except that
+// the shutdown proceeds asynchronously. See Done.
 func (c *Client) Stop() error {
 	c.mu.Lock()
+	if c.state == stateInitial {
+		c.mu.Unlock()
+		return ErrClientNotRunning
+	}
 	if c.state >= stateStopping {
 		c.mu.Unlock()
 		return ErrClientAlreadyClosed
@@ -184,6 +411,8 @@ func (c *Client) Stop() error {
 	c.state = stateStopping
 	logInfo(c.Id, "Stopping.")
 	close(c.cctl) // stop submitter
+	// Streamers must be able to drain the pipeline.
+	c.setPausedLocked(false)
 	c.mu.Unlock()
 	c.wg.Wait()
 	close(c.out)
@@ -193,17 +422,135 @@ func (c *Client) Stop() error {
 	case <-c.cdone:
 	case <-c.ctl:
 	}
-	if c.Callback != nil && c.Callback != NoCallback {
+	if c.Callback != nil && c.Callback != NoCallback && !c.sharedCallback {
 		close(c.Callback)
 	}
+	c.receipts.stop()
+	c.captures.stop()
+	c.completions.stop()
+	c.completions.wait()
+	c.mu.Lock()
+	c.closeDoneLocked(false)
+	c.mu.Unlock()
 	logInfo(c.Id, "Stopped.")
 	return nil
 }
 
+// Close performs soft shutdown of the Client just like Stop, but is safe
+// to be called any number of times from concurrent goroutines, such as
+// from a signal handler and a deferred call. Every call returns once
+// the client has been fully stopped. The call that initiated the shutdown
+// returns its result, while the others return ErrClientAlreadyClosed.
+func (c *Client) Close() error {
+	err := c.Stop()
+	if err == ErrClientAlreadyClosed {
+		<-c.Done()
+	}
+	return err
+}
+
+// Done returns a channel that is closed once the client has been fully
+// stopped, either by Stop, Kill or closure of client's Queue. It returns
+// nil if the client has not been started.
+func (c *Client) Done() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.done
+}
+
+// ShutdownOutcome tells whether client's shutdown was a clean drain
+// or a hard termination that abandoned pending push requests.
+type ShutdownOutcome struct {
+
+	// Clean is true if the client was stopped softly and every accepted
+	// push request reached its final outcome.
+	Clean bool
+
+	// Pending is the number of accepted push requests that had yet to reach
+	// their final outcome when the client was terminated. Their results
+	// may not have been delivered.
+	Pending uint64
+}
+
+// Outcome returns the outcome of client's shutdown. It is only available
+// once the channel returned by Done is closed, and ok is false before then.
+func (c *Client) Outcome() (res ShutdownOutcome, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.outcome == nil {
+		return res, false
+	}
+	return *c.outcome, true
+}
+
+// closeDoneLocked records shutdown outcome and closes done channel,
+// unless it has already been closed.
+func (c *Client) closeDoneLocked(terminated bool) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	var pending uint64
+	if n := atomic.LoadInt64(&c.pendingCnt); n > 0 {
+		pending = uint64(n)
+	}
+	c.outcome = &ShutdownOutcome{Clean: !terminated && pending == 0, Pending: pending}
+	if c.outcome.Clean {
+		logInfo(c.Id, "Drained cleanly.")
+	} else {
+		logWarn(c.Id, "Terminated with %d requests pending.", pending)
+	}
+	close(c.done)
+}
+
+// ShutdownResult describes the outcome of client's shutdown.
+type ShutdownResult struct {
+
+	// Drained is the number of push requests that were completed
+	// during the shutdown.
+	Drained uint64
+
+	// Abandoned is the number of push requests that were still pending
+	// when the shutdown was escalated to hard stop.
+	Abandoned uint64
+}
+
+// Shutdown performs soft shutdown of the Client allowing inflight requests
+// to be completed. If ctx is done before processing pipeline is drained,
+// the shutdown is escalated to hard stop and ctx.Err() is returned.
+// The returned result reports how many requests were drained and how many
+// were abandoned.
+func (c *Client) Shutdown(ctx context.Context) (ShutdownResult, error) {
+	start := atomic.LoadUint64(&c.completedCnt)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- c.Stop()
+	}()
+	var res ShutdownResult
+	var err error
+	select {
+	case err = <-stopped:
+	case <-ctx.Done():
+		if pending := atomic.LoadInt64(&c.pendingCnt); pending > 0 {
+			res.Abandoned = uint64(pending)
+		}
+		c.Kill()
+		<-stopped
+		err = ctx.Err()
+	}
+	res.Drained = atomic.LoadUint64(&c.completedCnt) - start
+	return res, err
+}
+
 // Kill performs hard shutdown of the Client without waiting for the processing
 // pipeline to unwind. Inflight requests are discarded.
 func (c *Client) Kill() error {
 	c.mu.Lock()
+	if c.state == stateInitial {
+		c.mu.Unlock()
+		return ErrClientNotRunning
+	}
 	if c.state >= stateTerminating {
 		c.mu.Unlock()
 		return ErrClientAlreadyClosed
@@ -216,11 +563,153 @@ func (c *Client) Kill() error {
 	}
 	close(c.gctl)
 	close(c.ctl) // unblock pending Stop() if there's one
+	c.receipts.stop()
+	c.captures.stop()
+	c.completions.stop()
+	c.closeDoneLocked(true)
 	c.mu.Unlock()
 	logInfo(c.Id, "Terminated.")
 	return nil
 }
 
+// Pause stops streamers from picking up new push requests while allowing
+// any inflight requests to complete. Connections to APN service are kept
+// open and no scaling takes place while the client is paused.
+// Pushes made while the client is paused will block once internal
+// buffers are exhausted.
+//
+// Pausing an already paused client has no effect. Soft shutdown
+// of the client implicitly resumes it.
+func (c *Client) Pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state < stateStarting || c.state > stateRunning {
+		return ErrClientNotRunning
+	}
+	if c.setPausedLocked(true) {
+		logInfo(c.Id, "Paused.")
+	}
+	return nil
+}
+
+// Resume lets streamers resume picking up push requests after the client
+// has been paused. Resuming a client that is not paused has no effect.
+func (c *Client) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state < stateStarting || c.state > stateRunning {
+		return ErrClientNotRunning
+	}
+	if c.setPausedLocked(false) {
+		logInfo(c.Id, "Resumed.")
+	}
+	return nil
+}
+
+// SetMaxConns changes the maximum allowed number of concurrent connections
+// of a running client. Lowering the limit below the current number of
+// connections winds down the excess connections, allowing their in-flight
+// requests to complete. Raising it allows further scaling up.
+// The new limit must not be less than ProcCfg.MinConns. Connection budget
+// shared with other clients of a MultiClient is not affected.
+func (c *Client) SetMaxConns(n uint32) error {
+	c.mu.RLock()
+	gov := c.gov
+	isRunning := c.state >= stateStarting && c.state <= stateRunning
+	c.mu.RUnlock()
+	if !isRunning || gov == nil {
+		return ErrClientNotRunning
+	}
+	if n < gov.cfg.MinConns || n == 0 {
+		return ErrMaxConnsBelowMin
+	}
+	select {
+	case gov.maxConns <- n:
+	case <-gov.done:
+		return ErrClientNotRunning
+	}
+	return nil
+}
+
+// SetMaxRate changes the throughput cap of a running client. Scaling
+// decisions respect the new cap from the next performance evaluation on.
+// Lowering the cap does not wind down any connections, but no further
+// scaling up takes place while the throughput exceeds it. Zero rate
+// removes the cap. The new rate must not be negative.
+//
+// For clarity it is best expressed in idiomatic way:
+//
+//	client.SetMaxRate(500 / funit.Second)
+func (c *Client) SetMaxRate(r funit.Measure) error {
+	c.mu.RLock()
+	gov := c.gov
+	isRunning := c.state >= stateStarting && c.state <= stateRunning
+	c.mu.RUnlock()
+	if !isRunning || gov == nil {
+		return ErrClientNotRunning
+	}
+	if r < 0 {
+		return ErrNegativeMaxRate
+	}
+	select {
+	case gov.maxRate <- r:
+	case <-gov.done:
+		return ErrClientNotRunning
+	}
+	return nil
+}
+
+// EffectiveConfig returns client's processing and communication
+// configurations with defaults in place of unset values, such as
+// DefaultReceiptBufferSize for zero ReceiptBufferSize. If the client is running,
+// MaxConns and MaxRate reflect any change made with SetMaxConns
+// and SetMaxRate.
+func (c *Client) EffectiveConfig() (ProcCfg, CommsCfg) {
+	c.mu.RLock()
+	gov := c.gov
+	c.mu.RUnlock()
+	procCfg := c.ProcCfg.effective()
+	if gov != nil {
+		procCfg.MaxConns = atomic.LoadUint32(&gov.curMaxConns)
+		procCfg.MaxRate = funit.Measure(math.Float64frombits(atomic.LoadUint64(&gov.curMaxRate)))
+	}
+	return procCfg, c.CommsCfg.effective()
+}
+
+// IsSaturated returns true if inbound blocking has been sustained
+// and cannot be relieved by scaling up, as connection count
+// or throughput limits have been reached. See ProcCfg.OnLoadShed.
+func (c *Client) IsSaturated() bool {
+	c.mu.RLock()
+	gov := c.gov
+	c.mu.RUnlock()
+	return gov != nil && atomic.LoadInt32(&gov.saturated) != 0
+}
+
+// IsPaused returns true if the client is paused.
+func (c *Client) IsPaused() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.flow != nil && c.flow.paused
+}
+
+// setPausedLocked updates flow state and reports whether it has changed.
+func (c *Client) setPausedLocked(paused bool) bool {
+	if c.flow == nil || c.flow.paused == paused {
+		return false
+	}
+	old := c.flow
+	c.flow = &flowState{paused: paused, changed: make(chan struct{})}
+	close(old.changed)
+	return true
+}
+
+func (c *Client) flowState() *flowState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.flow
+}
+
 // Push asynchronously sends a Notification to the APN service.
 // Context carries a deadline and a cancellation signal and allows you to close
 // long running requests when the context timeout is exceeded.
@@ -235,24 +724,44 @@ func (c *Client) Kill() error {
 // This method will block if downstream capacity is exceeded. For non-blocking
 // behavior or to allow coordination with activity on other channels consider
 // creating a Request instance and writing it to client's Queue directly.
+//
+// Once soft shutdown of the client has begun, ErrClientClosing is returned
+// immediately and the notification is not accepted for processing.
+// If ProcCfg.MaxTotal quota has been reached, ErrQuotaExceeded is returned.
 func (c *Client) Push(n *Notification, signer RequestSigner, ctx context.Context, callback chan<- *Result) error {
+	return c.push(&Request{
+		Notification: n,
+		Signer:       signer,
+		Context:      ctx,
+		Callback:     callback,
+	})
+}
+
+func (c *Client) push(req *Request) error {
 	c.mu.RLock()
 	state := c.state
+	isRunning := state >= stateStarting && state <= stateRunning
+	isOverQuota := c.gov != nil && c.isQuotaExceeded()
+	if isRunning && !isOverQuota {
+		// Stop must wait for us before closing outbound channel.
+		c.wg.Add(1)
+	}
 	c.mu.RUnlock()
-	if state < stateStarting || state > stateRunning {
+	if isOverQuota {
+		return ErrQuotaExceeded
+	}
+	if state == stateStopping {
+		return ErrClientClosing
+	}
+	if !isRunning {
 		return ErrClientNotRunning
 	}
+	defer c.wg.Done()
 	// Ensure that authentication is possible
-	if c.Certificate == nil && (signer == NoSigner || !c.HasSigner() && signer == DefaultSigner) {
+	if c.Certificate == nil && (req.Signer == NoSigner || !c.HasSigner() && req.Signer == DefaultSigner) {
 		return ErrMissingAuth
 	}
 	// Everything else is done asynchronously
-	req := &Request{
-		Notification: n,
-		Signer:       signer,
-		Context:      ctx,
-		Callback:     callback,
-	}
 	err := c.submit(req)
 	return err
 }
@@ -276,19 +785,37 @@ func (c *Client) runSubmitter(wg *sync.WaitGroup) {
 	if !done {
 		logInfo(c.Id+"-Submitter", "Running.")
 	}
+	// Once stopping, no new requests are taken, but retries keep being
+	// resubmitted until all accepted requests reach their final outcome.
+	queue := c.Queue
+	cctl := c.cctl
+	draining := false
 	for !done {
+		if draining && atomic.LoadInt64(&c.pendingCnt) <= 0 {
+			break
+		}
 		select {
-		case req, _ := <-c.retry:
+		case req := <-c.retry:
 			c.submit(req)
-		case req, ok := <-c.Queue:
+		case req, ok := <-queue:
 			if !ok {
-				// Queue is closed and we must do s soft shutdown.
-				// TODO Rework soft shutdown to account for retries.
-				done = true
+				// No more input. This is a soft shutdown.
+				logInfo(c.Id+"-Submitter", "Queue closed.")
+				queue = nil
+				go c.Stop()
 				break
 			}
-			c.submit(req)
-		case <-c.cctl:
+			if err := c.submit(req); err != nil {
+				c.reject(req, err)
+			}
+		case <-cctl:
+			logInfo(c.Id+"-Submitter", "Draining.")
+			queue = nil
+			cctl = nil
+			draining = true
+		case <-c.idle:
+			// Pending count is re-checked above.
+		case <-c.ctl:
 			done = true
 		}
 	}
@@ -303,7 +830,80 @@ func (c *Client) runSubmitter(wg *sync.WaitGroup) {
 }
 
 func (c *Client) submit(req *Request) (rerr error) {
-	c.rateCtr.Add(1)
+	isNew := !req.isAdmitted
+	isReturned := req.isReturned
+	req.isReturned = false
+	if isNew && c.gov.cfg.OnLoadShed != nil && atomic.LoadInt32(&c.gov.saturated) != 0 {
+		if r := c.gov.cfg.OnLoadShed(req); r != nil {
+			req = r
+		}
+	}
+	if isNew {
+		if max := c.gov.cfg.MaxTotal; max > 0 {
+			n := atomic.AddUint64(&c.admittedCnt, 1)
+			if n > max {
+				return ErrQuotaExceeded
+			}
+			if n == max {
+				// Last one in. Soft stop once it is handed over.
+				defer c.stopOnQuota()
+			}
+		}
+		req.isAdmitted = true
+		atomic.AddInt64(&c.pendingCnt, 1)
+		if f := c.gov.cfg.PushTypeResolver; f != nil {
+			resolvePushType(req, f)
+		}
+		if c.gov.cfg.AssignApnsID {
+			if err := assignApnsID(req, c.gov.cfg.MaxRetries); err != nil {
+				logWarn(c.Id, "Failed to generate apns-id: %v", err)
+			}
+		}
+		if !req.NotBefore.IsZero() && req.NotBefore.After(time.Now()) {
+			c.sched.add(req)
+			return
+		}
+	} else if isReturned {
+		// Already admitted and counted. It goes straight back out.
+	} else if c.gov.cfg.isRetryExpired(req, time.Now()) || c.gov.cfg.isRetryExpired(req, req.retryAt) {
+		c.gov.releaseRetrySlot(req)
+		c.drop(req, ErrRetryExpired)
+		return
+	} else if !req.retryAt.IsZero() && req.retryAt.After(time.Now()) {
+		c.sched.add(req)
+		return
+	}
+	if c.collapseLimiter != nil && req.attemptCnt == 0 && !isReturned {
+		outcome, prev := c.collapseLimiter.admit(req, time.Now())
+		if prev != nil {
+			c.drop(prev, ErrCollapsed)
+		}
+		switch outcome {
+		case collapseHold:
+			c.sched.add(req)
+			return
+		case collapseDrop:
+			c.drop(req, ErrCollapsed)
+			return
+		case collapseSkip:
+			// Superseded while held back and already reported.
+			return
+		}
+	}
+	if !isReturned {
+		c.rateCtr.Add(1)
+		// Queue time of scheduled requests is measured from their release.
+		req.queued = time.Now()
+		if req.firstQueued.IsZero() {
+			req.firstQueued = req.queued
+		}
+	}
+	// Only new requests are turned away once the client is stopping.
+	// Already accepted ones must make it through unless we are killed.
+	stop := c.ctl
+	if isNew {
+		stop = c.cctl
+	}
 	// TODO implement ctx timing out and cancellation checks
 	isBlocked := false
 	select {
@@ -317,13 +917,123 @@ func (c *Client) submit(req *Request) (rerr error) {
 	c.waitCtr.Tick()
 	select {
 	case c.out <- req:
-	case <-c.cctl:
+	case <-stop:
 		rerr = ErrPushInterrupted
+		if isNew {
+			c.decPending()
+		}
 	}
 	c.waitCtr.Tock()
 	return
 }
 
+// isQuotaExceeded returns true if ProcCfg.MaxTotal notifications
+// have already been accepted for processing.
+func (c *Client) isQuotaExceeded() bool {
+	max := c.gov.cfg.MaxTotal
+	return max > 0 && atomic.LoadUint64(&c.admittedCnt) >= max
+}
+
+// stopOnQuota initiates soft shutdown of the client upon reaching
+// notification quota.
+func (c *Client) stopOnQuota() {
+	logInfo(c.Id, "Quota of %d notifications reached.", c.gov.cfg.MaxTotal)
+	go c.Stop()
+}
+
+// reject reports the failure of a request that has not been accepted
+// for processing.
+func (c *Client) reject(req *Request, err error) {
+	c.dropTracker.record(err)
+	if f := req.OnComplete; f != nil {
+		c.completions.put(func() { f(nil, err) }, c.ctl)
+	}
+	tgt := resultTarget(req, c.Callback, c.ProcCfg.DiscardResults)
+	if tgt == nil {
+		return
+	}
+	res := &Result{
+		Notification: req.Notification,
+		Signer:       req.Signer,
+		Context:      req.Context,
+		ContentType:  req.ContentType,
+		Tag:          req.Tag,
+		Err:          err,
+		CompletedAt:  time.Now(),
+	}
+	// Delivered whenever the callback has room, even after hard stop.
+	select {
+	case tgt <- res:
+		return
+	default:
+	}
+	select {
+	case tgt <- res:
+	case <-c.ctl:
+	}
+}
+
+// resultTarget returns the channel to which the request's result should
+// be delivered, or nil if the result is to be discarded.
+func resultTarget(req *Request, dflt chan<- *Result, discard bool) chan<- *Result {
+	if discard || req.Callback == NoCallback {
+		return nil
+	}
+	tgt := dflt
+	if req.Callback != nil {
+		tgt = req.Callback
+	}
+	if tgt == NoCallback {
+		return nil
+	}
+	return tgt
+}
+
+// drop reports an accepted request as failed with err
+// without it being sent.
+func (c *Client) drop(req *Request, err error) {
+	c.decPending()
+	c.tagTracker.record(req.Tag, false)
+	c.reject(req, err)
+}
+
+// resubmit hands requests that were taken off the outbound channel
+// but never attempted back to the submitter. Unlike retries, they bypass
+// the retry forwarder and its policies, so they are never dropped unless
+// the client is killed. It does not block.
+func (c *Client) resubmit(reqs ...*Request) {
+	for _, req := range reqs {
+		req.isReturned = true
+	}
+	go func() {
+		for _, req := range reqs {
+			select {
+			case c.retry <- req:
+			case <-c.ctl:
+				return
+			}
+		}
+	}()
+}
+
+// complete accounts for a request reaching its final outcome.
+func (c *Client) complete(req *Request) {
+	c.decPending()
+	atomic.AddUint64(&c.completedCnt, 1)
+}
+
+// decPending accounts for an accepted request leaving processing pipeline
+// and signals the submitter when there are no more pending requests.
+func (c *Client) decPending() {
+	if atomic.AddInt64(&c.pendingCnt, -1) > 0 || c.idle == nil {
+		return
+	}
+	select {
+	case c.idle <- struct{}{}:
+	default:
+	}
+}
+
 func init() {
 	NoSigner = noSigner{}
 	NoCallback = make(chan *Result)
@@ -3,9 +3,15 @@
 package apns2
 
 import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/baobabus/go-apns/cryptox"
+	"github.com/baobabus/go-apns/funit"
 	"github.com/baobabus/go-apnsmock/apns2mock"
 	"github.com/stretchr/testify/assert"
 )
@@ -82,8 +88,717 @@ func TestClient_Signer_Good_1(t *testing.T) {
 		}
 		assert.Equal(t, tc.exp.Response.StatusCode, r.Response.StatusCode)
 		assert.Equal(t, tc.exp.Response.RejectionReason, r.Response.RejectionReason)
+		assert.True(t, r.Response.SendLatency > 0)
+		assert.True(t, r.Response.QueueLatency >= 0)
 		if r.Err != nil && tc.exp.Err == nil {
 			t.Fatal("Error in result:", r.Err)
 		}
 	}
 }
+
+func TestClient_StartGated(t *testing.T) {
+	var hits int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			apns2mock.DefaultHandler.ServeHTTP(w, r)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.StartMode = StartGated
+	err = c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	cb := make(chan *Result, 1)
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	if r.Response == nil {
+		t.Fatal("Should have gotten a response")
+	}
+	assert.Equal(t, 200, r.Response.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	// Streamer that has not been confirmed by the governor yet
+	// must neither take requests nor make roundtrips.
+	q := make(chan *Request)
+	w := &streamer{
+		id:        "Test-Streamer",
+		c:         c,
+		gov:       c.gov,
+		in:        q,
+		out:       cb,
+		warmStart: true,
+		ctl:       make(chan struct{}),
+		done:      make(chan *streamer, 1),
+		windDown:  make(chan struct{}),
+		exited:    make(chan struct{}),
+		draining:  make(chan struct{}),
+		gate:      make(chan struct{}),
+	}
+	w.errTracker = newErrRateTracker(c.ProcCfg.ConnErrorWindow, c.ProcCfg.MaxConnErrorRate)
+	if err := w.start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer close(w.ctl)
+	req := &Request{Notification: testNotif_Good, Signer: DefaultSigner, Context: NoContext, Callback: cb}
+	// admitted as if by the submitter
+	req.isAdmitted = true
+	atomic.AddInt64(&c.pendingCnt, 1)
+	select {
+	case q <- req:
+		t.Fatal("Gated streamer should not have taken the request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&w.served))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	// confirmed
+	close(w.gate)
+	select {
+	case q <- req:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Streamer should have taken the request once its gate was opened")
+	}
+	select {
+	case r = <-cb:
+		if assert.NotNil(t, r.Response) {
+			assert.Equal(t, 200, r.Response.StatusCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should have gotten a response")
+	}
+	assert.Equal(t, uint64(1), atomic.LoadUint64(&w.served))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestGatewayWithPort(t *testing.T) {
+	g, err := GatewayWithPort(Gateway.Production, AlternativePort)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.push.apple.com:2197", g)
+	g, err = GatewayWithPort("https://127.0.0.1:2197", 8443)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://127.0.0.1:8443", g)
+	_, err = GatewayWithPort("%zz", 443)
+	assert.NotNil(t, err)
+}
+
+func TestGatewayPort(t *testing.T) {
+	p, err := gatewayPort(Gateway.Production)
+	assert.Nil(t, err)
+	assert.Equal(t, "443", p)
+	p, err = gatewayPort("https://api.push.apple.com:2197")
+	assert.Nil(t, err)
+	assert.Equal(t, "2197", p)
+	_, err = gatewayPort("")
+	assert.NotNil(t, err)
+}
+
+func TestClient_Ping(t *testing.T) {
+	s := mustNewMockServer(t)
+	c := mustNewClient_Signer_Good(t, s)
+	assert.Nil(t, c.Ping(context.Background()))
+	s.Close()
+	assert.NotNil(t, c.Ping(context.Background()))
+}
+
+func TestClient_PingAuthFailure(t *testing.T) {
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"reason":"InvalidProviderToken"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	err = c.Ping(context.Background())
+	if assert.IsType(t, &AuthError{}, err) {
+		assert.Equal(t, ReasonInvalidProviderToken, err.(*AuthError).Reason)
+	}
+}
+
+func TestClient_PingProbeRequest(t *testing.T) {
+	paths := make(chan string, 2)
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason":"Unregistered"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	// default probe
+	assert.Nil(t, c.Ping(context.Background()))
+	assert.Equal(t, RequestRoot, <-paths)
+	// custom probe
+	c.CommsCfg.ProbeRequest = &ProbeRequest{
+		Notification: &Notification{Recipient: "probe", Payload: []byte("{}")},
+		IsExpected: func(resp *Response) bool {
+			return resp.RejectionReason == ReasonBadDeviceToken
+		},
+	}
+	err = c.Ping(context.Background())
+	assert.Equal(t, RequestRoot+"probe", <-paths)
+	if assert.IsType(t, &PingError{}, err) {
+		assert.Equal(t, http.StatusGone, err.(*PingError).StatusCode)
+	}
+}
+
+func TestClient_PushWhenClosing(t *testing.T) {
+	c := &Client{}
+	err := c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback)
+	assert.Equal(t, ErrClientNotRunning, err)
+	c.state = stateStopping
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback)
+	assert.Equal(t, ErrClientClosing, err)
+	c.state = stateClosed
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback)
+	assert.Equal(t, ErrClientNotRunning, err)
+}
+
+func TestClient_MalformedDeviceToken(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.ValidateDeviceTokens = true
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	n := *testNotif_Good
+	n.Recipient = "not-a-token"
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	res := <-cb
+	assert.Nil(t, res.Response)
+	if assert.IsType(t, &DeviceTokenError{}, res.Err) {
+		assert.Equal(t, "not-a-token", res.Err.(*DeviceTokenError).Token)
+	}
+	assert.Equal(t, map[string]uint64{DropReasonDeviceToken: 1}, c.Stats().DroppedRequests)
+}
+
+func TestClient_MalformedDeviceTokenDefault(t *testing.T) {
+	paths := make(chan string, 1)
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"reason":"BadDeviceToken"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	n := *testNotif_Good
+	n.Recipient = "not-a-token"
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	res := <-cb
+	assert.Equal(t, "/3/device/not-a-token", <-paths)
+	assert.Nil(t, res.Err)
+	if assert.NotNil(t, res.Response) {
+		assert.True(t, res.Response.IsBadDeviceToken())
+	}
+	assert.Empty(t, c.Stats().DroppedRequests)
+}
+
+func TestClient_OnLoadShed(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.OnLoadShed = func(req *Request) *Request {
+		n := *req.Notification
+		n.Payload = &Payload{APS: &APS{Alert: "core"}}
+		r := *req
+		r.Notification = &n
+		return &r
+	}
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	// not saturated
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	res := <-cb
+	assert.Equal(t, testNotif_Good.Payload, res.Notification.Payload)
+	assert.False(t, c.IsSaturated())
+	atomic.StoreInt32(&c.gov.saturated, 1)
+	assert.True(t, c.IsSaturated())
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	res = <-cb
+	assert.True(t, res.IsAccepted())
+	if p, ok := res.Notification.Payload.(*Payload); assert.True(t, ok) {
+		assert.Equal(t, "core", p.APS.Alert)
+	}
+}
+
+func TestClient_MaxTotal(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.MaxTotal = 2
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	cb := make(chan *Result, 2)
+	for i := 0; i < 2; i++ {
+		err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.True(t, (<-cb).IsAccepted())
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	assert.Equal(t, ErrQuotaExceeded, err)
+}
+
+func TestClient_QueueClosureAllowsRetries(t *testing.T) {
+	var attempts int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1)%2 == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.MaxRetries = 1
+	c.ProcCfg.RetryEval = DefaultRetryEval
+	cb := make(chan *Result, 1)
+	c.Callback = cb
+	q := make(chan *Request, 1)
+	c.Queue = q
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	q <- &Request{Notification: testNotif_Good, Signer: DefaultSigner, Context: NoContext}
+	close(q)
+	select {
+	case r := <-cb:
+		assert.True(t, r.IsAccepted())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should have gotten a result of the retry")
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client should have stopped after its queue was closed")
+	}
+	_, ok := <-cb
+	assert.False(t, ok)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.Equal(t, map[int]uint64{2: 1}, c.Stats().Attempts.Accepted)
+}
+
+func TestClient_SetMaxConns(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	assert.Equal(t, ErrClientNotRunning, c.SetMaxConns(2))
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	assert.Equal(t, ErrMaxConnsBelowMin, c.SetMaxConns(0))
+	assert.Nil(t, c.SetMaxConns(2))
+	st, err := c.DumpState()
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint32(2), st.MaxConns)
+	}
+}
+
+func TestClient_SetMaxRate(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	assert.Equal(t, ErrClientNotRunning, c.SetMaxRate(10/funit.Second))
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	assert.Equal(t, ErrNegativeMaxRate, c.SetMaxRate(-1))
+	assert.Nil(t, c.SetMaxRate(10/funit.Second))
+	st, err := c.DumpState()
+	if assert.Nil(t, err) {
+		assert.Equal(t, 10/funit.Second, st.MaxRate)
+	}
+	procCfg, _ := c.EffectiveConfig()
+	assert.Equal(t, 10/funit.Second, procCfg.MaxRate)
+}
+
+func TestClient_EffectiveConfig(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.SettlePeriod = time.Second
+	c.ProcCfg.ScaleDownSettlePeriod = time.Minute
+	c.CommsCfg.HTTP2PingInterval = time.Minute
+	procCfg, commsCfg := c.EffectiveConfig()
+	assert.Nil(t, procCfg.RetryEval)
+	assert.Equal(t, DefaultReceiptBufferSize, procCfg.ReceiptBufferSize)
+	assert.Equal(t, DefaultMaxRetryForwarders, procCfg.MaxRetryForwarders)
+	assert.Equal(t, DefaultCompletionWorkers, procCfg.CompletionWorkers)
+	assert.Equal(t, time.Second, procCfg.ScaleUpSettlePeriod)
+	assert.Equal(t, time.Minute, procCfg.ScaleDownSettlePeriod)
+	assert.Equal(t, c.ProcCfg.MinSustain, procCfg.StallPeriod)
+	assert.Equal(t, 15*time.Second, commsCfg.HTTP2PingTimeout)
+	assert.Equal(t, int64(DefaultMaxResponseBodySize), commsCfg.MaxResponseBodySize)
+	assert.Equal(t, DefaultFailoverAfter, commsCfg.FailoverAfter)
+	assert.Equal(t, DefaultFailbackInterval, commsCfg.FailbackInterval)
+	// configuration the client was set up with is left intact
+	assert.Nil(t, c.ProcCfg.RetryEval)
+	assert.Equal(t, time.Duration(0), c.ProcCfg.ScaleUpSettlePeriod)
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	assert.Nil(t, c.SetMaxConns(3))
+	// let the governor pick up the change
+	_, err := c.DumpState()
+	assert.Nil(t, err)
+	procCfg, _ = c.EffectiveConfig()
+	assert.Equal(t, uint32(3), procCfg.MaxConns)
+}
+
+func TestClient_PauseResume(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	assert.Equal(t, ErrClientNotRunning, c.Pause())
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	// Make sure the streamer is up and running.
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	assert.Nil(t, c.Pause())
+	assert.True(t, c.IsPaused())
+	go c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	select {
+	case <-cb:
+		t.Fatal("Should not have gotten a result while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Nil(t, c.Resume())
+	assert.False(t, c.IsPaused())
+	select {
+	case r := <-cb:
+		assert.True(t, r.IsAccepted())
+	case <-time.After(time.Second):
+		t.Fatal("Should have gotten a result after resuming")
+	}
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := c.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), res.Abandoned)
+	_, err = c.Shutdown(context.Background())
+	assert.Equal(t, ErrClientAlreadyClosed, err)
+}
+
+func TestClient_Close(t *testing.T) {
+	c := &Client{}
+	assert.Equal(t, ErrClientNotRunning, c.Close())
+	assert.Equal(t, ErrClientNotRunning, c.Stop())
+	assert.Equal(t, ErrClientNotRunning, c.Kill())
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c = mustNewClient_Signer_Good(t, s)
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- c.Close()
+		}()
+	}
+	closed := 0
+	for i := 0; i < 5; i++ {
+		err := <-errs
+		// every call returns only once fully stopped
+		select {
+		case <-c.Done():
+		default:
+			t.Fatal("Close returned before client was stopped")
+		}
+		if err == nil {
+			closed++
+		} else {
+			assert.Equal(t, ErrClientAlreadyClosed, err)
+		}
+	}
+	assert.Equal(t, 1, closed)
+	assert.Equal(t, ErrClientAlreadyClosed, c.Close())
+	assert.Equal(t, ErrClientAlreadyClosed, c.Kill())
+}
+
+func TestClient_Outcome(t *testing.T) {
+	c := &Client{}
+	_, ok := c.Outcome()
+	assert.False(t, ok)
+	// clean drain
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c = mustNewClient_Signer_Good(t, s)
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	_, ok = c.Outcome()
+	assert.False(t, ok)
+	assert.Nil(t, c.Stop())
+	res, ok := c.Outcome()
+	assert.True(t, ok)
+	assert.Equal(t, ShutdownOutcome{Clean: true}, res)
+	// termination with a request in flight
+	release := make(chan struct{})
+	received := make(chan struct{}, 1)
+	ws, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	defer close(release)
+	c = mustNewClient_Signer_Good(t, ws)
+	c.CommsCfg.RequestTimeout = 10 * time.Second
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	assert.Nil(t, c.Kill())
+	<-c.Done()
+	res, ok = c.Outcome()
+	assert.True(t, ok)
+	assert.Equal(t, ShutdownOutcome{Clean: false, Pending: 1}, res)
+}
+
+func TestClient_DumpState(t *testing.T) {
+	c := &Client{}
+	_, err := c.DumpState()
+	assert.Equal(t, ErrClientNotRunning, err)
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c = mustNewClient_Signer_Good(t, s)
+	err = c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 1)
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	ds, err := c.DumpState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, ds.Streamers, 1)
+	assert.Len(t, ds.Launchers, 0)
+	assert.False(t, ds.Streamers[0].IsGated)
+	assert.True(t, ds.Streamers[0].ConnAge > 0)
+	assert.False(t, ds.Streamers[0].LastResponse.IsZero())
+	assert.False(t, ds.Streamers[0].LastResponse.After(ds.Time))
+	c.Stop()
+	_, err = c.DumpState()
+	assert.Equal(t, ErrClientNotRunning, err)
+}
+
+func TestClient_LivenessTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var wedged string
+	addrs := map[string]bool{}
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			addrs[r.RemoteAddr] = true
+			if wedged == "" {
+				wedged = r.RemoteAddr
+			}
+			isWedged := wedged == r.RemoteAddr
+			mu.Unlock()
+			if isWedged {
+				<-release
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	defer close(release)
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = 10 * time.Second
+	c.ProcCfg.LivenessTimeout = 100 * time.Millisecond
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	cb := make(chan *Result, 2)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-cb:
+		assert.True(t, res.IsAccepted())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should have served the second request on a new connection")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, addrs, 2)
+}
+
+func TestClient_ScaleRecorder(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	rec := &ScaleRecorder{}
+	c.ProcCfg.OnScale = rec.Record
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 1)
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	c.Stop()
+	evs := rec.Events()
+	if assert.True(t, len(evs) > 0) {
+		assert.Equal(t, 0, evs[0].From)
+		assert.Equal(t, int(c.ProcCfg.MinConns), evs[0].To)
+		assert.Equal(t, ScaleUp, evs[0].Direction)
+		assert.Equal(t, ScaleReasonInitial, evs[0].Reason)
+	}
+	rec.Reset()
+	assert.Len(t, rec.Events(), 0)
+}
+
+func TestClient_OnConnCountChange(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	changes := make(chan [2]int, 10)
+	c.OnConnCountChange = func(old, new int) {
+		changes <- [2]int{old, new}
+	}
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	select {
+	case ch := <-changes:
+		assert.Equal(t, [2]int{0, 1}, ch)
+	case <-time.After(time.Second):
+		t.Fatal("Should have been notified")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/baobabus/go-apns/funit"
+)
+
+// collapseIDWarnWindow is the period over which per collapse ID send rate
+// is evaluated against ProcCfg.CollapseIDWarnRate.
+const collapseIDWarnWindow = time.Minute
+
+// collapseTracker counts notifications sent per collapse ID. Only a bounded
+// number of most recently used collapse IDs is tracked, least recently used
+// ones being evicted first.
+// It is safe for use in concurrent goroutines.
+type collapseTracker struct {
+	id       string
+	size     int
+	warnRate funit.Measure
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type collapseEntry struct {
+	collapseID string
+	count      uint64
+	winStart   time.Time
+	winCount   uint64
+	warned     bool
+}
+
+func newCollapseTracker(id string, size int, warnRate funit.Measure) *collapseTracker {
+	if size <= 0 {
+		return nil
+	}
+	return &collapseTracker{
+		id:       id,
+		size:     size,
+		warnRate: warnRate,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// add registers a single send of a notification with the specified
+// collapse ID.
+func (t *collapseTracker) add(collapseID string) {
+	if t == nil || collapseID == "" {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var e *collapseEntry
+	if el, ok := t.items[collapseID]; ok {
+		t.lru.MoveToFront(el)
+		e = el.Value.(*collapseEntry)
+	} else {
+		if t.lru.Len() >= t.size {
+			last := t.lru.Back()
+			delete(t.items, last.Value.(*collapseEntry).collapseID)
+			t.lru.Remove(last)
+		}
+		e = &collapseEntry{collapseID: collapseID, winStart: now}
+		t.items[collapseID] = t.lru.PushFront(e)
+	}
+	e.count++
+	if now.Sub(e.winStart) >= collapseIDWarnWindow {
+		e.winStart = now
+		e.winCount = 0
+		e.warned = false
+	}
+	e.winCount++
+	if t.warnRate > 0 && !e.warned && float64(e.winCount) > float64(t.warnRate)*collapseIDWarnWindow.Seconds() {
+		e.warned = true
+		logWarn(t.id, "Collapse ID %q: %d notifications sent within %v.", collapseID, e.winCount, collapseIDWarnWindow)
+	}
+}
+
+// counts returns a copy of current per collapse ID counts.
+func (t *collapseTracker) counts() map[string]uint64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[string]uint64, len(t.items))
+	for k, el := range t.items {
+		res[k] = el.Value.(*collapseEntry).count
+	}
+	return res
+}
+
+// reset discards all tracked collapse IDs.
+func (t *collapseTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lru.Init()
+	t.items = make(map[string]*list.Element)
+}
+
+// Outcomes of collapseLimiter.admit.
+const (
+	collapsePass = iota
+	collapseHold
+	collapseDrop
+	collapseSkip
+)
+
+// Request collapse limiting states.
+const (
+	collapseUnchecked = iota
+	collapseHeld
+	collapsePassed
+)
+
+// collapseLimiter enforces ProcCfg.CollapseIDMinInterval between sends
+// of notifications sharing a collapse ID to the same recipient.
+// It is safe for use in concurrent goroutines.
+type collapseLimiter struct {
+	interval time.Duration
+	coalesce bool
+
+	mu      sync.Mutex
+	slots   map[string]*collapseSlot
+	pruneAt int
+}
+
+type collapseSlot struct {
+	sent time.Time
+	// request held back to be sent once the interval elapses
+	held *Request
+}
+
+// collapseLimiterPruneSize is the number of tracked slots
+// above which stale ones are pruned.
+const collapseLimiterPruneSize = 1024
+
+func newCollapseLimiter(interval time.Duration, coalesce bool) *collapseLimiter {
+	if interval <= 0 {
+		return nil
+	}
+	return &collapseLimiter{
+		interval: interval,
+		coalesce: coalesce,
+		slots:    make(map[string]*collapseSlot),
+		pruneAt:  collapseLimiterPruneSize,
+	}
+}
+
+func collapseKey(req *Request) string {
+	n := req.Notification
+	if n == nil || n.Header == nil || n.Header.CollapseID == "" {
+		return ""
+	}
+	return n.Header.Topic + "\x00" + n.Recipient + "\x00" + n.Header.ChannelID + "\x00" + n.Header.CollapseID
+}
+
+// admit decides whether req can be sent at now. Requests sent within
+// the interval of a previous send with the same key are either dropped
+// or held back with their retryAt set to the end of the interval.
+// A request that was held back before and has since been superseded
+// by a later one is to be skipped. The superseded request is returned
+// in prev when req supersedes it.
+func (l *collapseLimiter) admit(req *Request, now time.Time) (outcome int, prev *Request) {
+	if req.collapseState == collapsePassed {
+		return collapsePass, nil
+	}
+	key := collapseKey(req)
+	if key == "" {
+		req.collapseState = collapsePassed
+		return collapsePass, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot := l.slots[key]
+	if req.collapseState == collapseHeld {
+		if slot == nil || slot.held != req {
+			return collapseSkip, nil
+		}
+		slot.held = nil
+		slot.sent = now
+		req.collapseState = collapsePassed
+		return collapsePass, nil
+	}
+	if slot == nil {
+		l.prune(now)
+		l.slots[key] = &collapseSlot{sent: now}
+		req.collapseState = collapsePassed
+		return collapsePass, nil
+	}
+	if slot.held == nil && now.Sub(slot.sent) >= l.interval {
+		slot.sent = now
+		req.collapseState = collapsePassed
+		return collapsePass, nil
+	}
+	if !l.coalesce {
+		return collapseDrop, nil
+	}
+	prev = slot.held
+	slot.held = req
+	req.collapseState = collapseHeld
+	req.retryAt = slot.sent.Add(l.interval)
+	return collapseHold, prev
+}
+
+// prune discards slots with no held requests whose interval has elapsed.
+// It only does so once the number of slots has grown enough since
+// the last pruning. Must be called with l.mu locked.
+func (l *collapseLimiter) prune(now time.Time) {
+	if len(l.slots) < l.pruneAt {
+		return
+	}
+	for k, slot := range l.slots {
+		if slot.held == nil && now.Sub(slot.sent) >= l.interval {
+			delete(l.slots, k)
+		}
+	}
+	l.pruneAt = 2 * len(l.slots)
+	if l.pruneAt < collapseLimiterPruneSize {
+		l.pruneAt = collapseLimiterPruneSize
+	}
+}
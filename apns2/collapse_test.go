@@ -0,0 +1,104 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollapseTracker(t *testing.T) {
+	var s *collapseTracker
+	// disabled
+	s = newCollapseTracker("test", 0, 0)
+	assert.Nil(t, s)
+	s.add("a")
+	assert.Nil(t, s.counts())
+	// bounded
+	s = newCollapseTracker("test", 2, 0)
+	s.add("a")
+	s.add("b")
+	s.add("a")
+	s.add("")
+	assert.Equal(t, map[string]uint64{"a": 2, "b": 1}, s.counts())
+	s.add("c") // evicts "b"
+	assert.Equal(t, map[string]uint64{"a": 2, "c": 1}, s.counts())
+}
+
+func TestCollapseLimiter(t *testing.T) {
+	assert.Nil(t, newCollapseLimiter(0, false))
+	n := &Notification{
+		Recipient: testNotif_Good.Recipient,
+		Header:    &Header{Topic: "com.example.Alert", CollapseID: "score"},
+	}
+	now := time.Now()
+	// dropping
+	l := newCollapseLimiter(time.Second, false)
+	res, _ := l.admit(&Request{Notification: n}, now)
+	assert.Equal(t, collapsePass, res)
+	res, _ = l.admit(&Request{Notification: n}, now.Add(500*time.Millisecond))
+	assert.Equal(t, collapseDrop, res)
+	res, _ = l.admit(&Request{Notification: n}, now.Add(time.Second))
+	assert.Equal(t, collapsePass, res)
+	// no collapse ID
+	res, _ = l.admit(&Request{Notification: testNotif_Good}, now)
+	assert.Equal(t, collapsePass, res)
+	// coalescing
+	l = newCollapseLimiter(time.Second, true)
+	l.admit(&Request{Notification: n}, now)
+	r1 := &Request{Notification: n}
+	res, prev := l.admit(r1, now.Add(100*time.Millisecond))
+	assert.Equal(t, collapseHold, res)
+	assert.Nil(t, prev)
+	assert.True(t, now.Add(time.Second).Equal(r1.retryAt))
+	r2 := &Request{Notification: n}
+	res, prev = l.admit(r2, now.Add(200*time.Millisecond))
+	assert.Equal(t, collapseHold, res)
+	assert.Equal(t, r1, prev)
+	// superseded request is skipped on release, the latest one is sent
+	res, _ = l.admit(r1, now.Add(time.Second))
+	assert.Equal(t, collapseSkip, res)
+	res, _ = l.admit(r2, now.Add(time.Second))
+	assert.Equal(t, collapsePass, res)
+	// and is not limited again on retry
+	res, _ = l.admit(r2, now.Add(time.Second))
+	assert.Equal(t, collapsePass, res)
+}
+
+func TestClient_CollapseIDMinInterval(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.CollapseIDMinInterval = 100 * time.Millisecond
+	c.ProcCfg.CoalesceCollapseIDs = true
+	queue := make(chan *Request)
+	c.Queue = queue
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n := &Notification{
+		Recipient: testNotif_Good.Recipient,
+		Header:    &Header{Topic: "com.example.Alert", CollapseID: "score"},
+		Payload:   testNotif_Good.Payload,
+	}
+	cb := make(chan *Result, 3)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		queue <- &Request{Notification: n, Callback: cb, Tag: strconv.Itoa(i)}
+	}
+	results := map[string]*Result{}
+	for i := 0; i < 3; i++ {
+		r := <-cb
+		results[r.Tag] = r
+	}
+	assert.Nil(t, results["0"].Err)
+	assert.Equal(t, ErrCollapsed, results["1"].Err)
+	assert.Nil(t, results["2"].Err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().DroppedRequests[DropReasonCollapsed])
+}
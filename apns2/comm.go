@@ -3,17 +3,23 @@
 package apns2
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
 	"net"
 	"time"
 
 	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apns/http2x"
 )
 
 // CommsCfg is a set of parameters that govern communications with APN servers.
 // Two baseline configuration sets are predefined by CommsFast and CommsSlow
-// global variables. You may define your own sets as needed to address
-// any specific requirements of your particular setup.
+// global variables. CommsProduction, CommsHighThroughput and CommsLowLatency
+// are production-oriented starting points. You may define your own sets
+// as needed to address any specific requirements of your particular setup.
 type CommsCfg struct {
 
 	// DialTimeout is the maximum amount of time a dial will wait for a connect
@@ -34,6 +40,11 @@ type CommsCfg struct {
 	// back-off time calculation.
 	DialBackOffJitter funit.Measure
 
+	// DialBackOffFunc, if not nil, calculates dial back-off times in place
+	// of MinDialBackOff, MaxDialBackOff and DialBackOffJitter. It is called
+	// with the number of consecutive failed dial attempts and ReasonClassNone.
+	DialBackOffFunc BackOffFunc
+
 	// RequestTimeout specifies a time limit for requests made by the
 	// HTTPClient. The timeout includes connection time, any redirects,
 	// and reading the response body.
@@ -43,13 +54,200 @@ type CommsCfg struct {
 	// connection. If zero, keep-alives are not enabled.
 	// Apple recommends not closing connections to APN service at all,
 	// but a sinsibly long duration is acceptable.
+	//
+	// Deprecated: KeepAlive is ambiguous as to the protocol layer it applies
+	// to. Use TCPKeepAlive and HTTP2PingInterval instead. KeepAlive is only
+	// used as TCP keep-alive period if TCPKeepAlive is zero.
 	KeepAlive time.Duration
 
-	// MaxConcurrentStreams is the maximum allowed number of concurrent streams
-	// per HTTP/2 connection. If connection's MAX_CONCURRENT_STREAMS option
-	// is invoked by the remote side with a lower value, the remote request
-	// will be honored if possible.
+	// TCPKeepAlive specifies the period of TCP keep-alive probes
+	// (SO_KEEPALIVE) on connections to APN service. TCP keep-alives detect
+	// dead peers and keep middleboxes from dropping idle connections.
+	// If zero, KeepAlive is used. If negative, TCP keep-alives are disabled.
+	TCPKeepAlive time.Duration
+
+	// HTTP2PingInterval, if positive, is the amount of time after which
+	// an HTTP/2 PING frame is sent on a connection on which no frames have
+	// been received. A connection that does not answer the PING within
+	// HTTP2PingTimeout is closed. Unlike TCP keep-alives, PINGs detect
+	// connections whose TLS or HTTP/2 layers have stopped responding.
+	// If zero, no PINGs are sent.
+	HTTP2PingInterval time.Duration
+
+	// HTTP2PingTimeout is the amount of time to wait for a response to
+	// HTTP/2 PING. If zero, 15 seconds is used.
+	HTTP2PingTimeout time.Duration
+
+	// MaxConcurrentStreams is the client's self-imposed limit on the number
+	// of concurrent outbound streams per HTTP/2 connection. It governs how
+	// many requests a streamer packs into its connection. If connection's
+	// MAX_CONCURRENT_STREAMS option is invoked by the remote side with
+	// a lower value, the remote request will be honored if possible.
+	// This value is not advertised to the server.
 	MaxConcurrentStreams uint32
+
+	// PipelineDepth, if positive, is the maximum number of requests
+	// a streamer has in flight on its connection before waiting for
+	// responses, regardless of the number of concurrent streams allowed.
+	// It bounds per-connection memory in memory-constrained environments.
+	// If zero, in-flight requests are only limited by MaxConcurrentStreams.
+	PipelineDepth uint32
+
+	// PrefetchDepth, if positive, is the number of requests each streamer
+	// takes off client's shared queue ahead of time and holds in a local
+	// buffer, smoothing throughput when per-request processing is uneven.
+	// Requests still buffered when a streamer winds down, recycles or
+	// abandons its connection are resubmitted, so none are lost.
+	// If zero, streamers read from the shared queue directly.
+	PrefetchDepth uint32
+
+	// AdvertisedMaxConcurrentStreams is the value of MAX_CONCURRENT_STREAMS
+	// setting the client sends to the server, limiting the number of streams
+	// the server may open. As the client does not accept server push, this
+	// is mostly of no consequence with APN service.
+	// If zero, the setting is not sent and the protocol default applies.
+	AdvertisedMaxConcurrentStreams uint32
+
+	// StreamRampUp is the period of time over which the number of concurrent
+	// streams allowed in a newly established HTTP/2 connection is gradually
+	// raised from 1 to MaxConcurrentStreams. This mirrors TCP slow-start and
+	// avoids overwhelming a fresh connection with a burst of streams.
+	// If zero, full concurrency is allowed right away.
+	StreamRampUp time.Duration
+
+	// RootCAs, if not nil, defines the set of root certificate authorities
+	// that are used to verify APN servers' certificates. If set, it takes
+	// precedence over Client's RootCA.
+	RootCAs *x509.CertPool
+
+	// PinnedKeys, if not empty, is the set of base64-encoded SHA-256 hashes
+	// of Subject Public Key Info of the certificates that are trusted
+	// to be presented by APN servers. At least one certificate in server's
+	// chain must match one of the pinned keys, otherwise connection fails
+	// with PinningError. See SPKIHash.
+	PinnedKeys []string
+
+	// ResolveInterval is the period with which APN service host name is
+	// re-resolved. If the address of an established connection is missing
+	// from several consecutive lookups, the connection is gracefully recycled.
+	// APN service returns a rotating subset of its addresses, so a single
+	// miss is not acted upon. If zero, host name is not re-resolved.
+	ResolveInterval time.Duration
+
+	// MaxConnsPerIP, if positive, is the maximum number of client's
+	// connections to any single resolved address of APN service host.
+	// New connections are made to the least used address, and no
+	// connection is made if all addresses are at the limit, even if
+	// ProcCfg.MaxConns allows more. This spreads the load across APN
+	// service front-ends when only few addresses are resolved. Reaching
+	// the limit is not a connection failure: it does not cause back-off
+	// or failover, and no scaling up takes place while it persists.
+	// If zero, connections are not limited per address.
+	MaxConnsPerIP uint32
+
+	// DNSCacheTTL, if positive, is the duration for which resolved addresses
+	// of APN service host are cached and reused by all client's dials and
+	// address checks. Expired addresses are re-resolved on the next use,
+	// and kept in use if re-resolution fails. Connections are made to
+	// the cached addresses in rotation.
+	// If zero, host name is resolved by the system resolver on every dial.
+	DNSCacheTTL time.Duration
+
+	// MaxResponseBodySize is the maximum number of bytes read from
+	// a response body. APN service error bodies are tiny, and anything
+	// beyond the limit is discarded, with the response flagged as
+	// BodyTruncated. If zero, DefaultMaxResponseBodySize is used.
+	MaxResponseBodySize int64
+
+	// FallbackEndpoints, if not empty, lists alternative APN service
+	// endpoints, such as AlternativePort on the same host, to fail over
+	// to in order when client's Gateway cannot sustain ProcCfg.MinConns
+	// connections for FailoverAfter. Failing back to the primary Gateway
+	// is attempted every FailbackInterval. Established connections are
+	// not affected by failing over.
+	FallbackEndpoints []Endpoint
+
+	// FailoverAfter is the amount of time the number of connections must
+	// stay below ProcCfg.MinConns before failing over to the next endpoint.
+	// If zero, DefaultFailoverAfter is used.
+	FailoverAfter time.Duration
+
+	// FailbackInterval is the amount of time after failing over at which
+	// failing back to the primary Gateway is attempted. If zero,
+	// DefaultFailbackInterval is used.
+	FailbackInterval time.Duration
+
+	// OnSend, if not nil, is called just before every push attempt is sent
+	// to APN service. It is a read-only hook intended for auditing, and
+	// device tokens are only exposed to it as hashes. It is called
+	// synchronously from the goroutine handling the attempt, so it must
+	// be quick and must not block.
+	OnSend func(RequestMeta)
+
+	// CaptureRate, if positive, is the fraction of push requests whose
+	// attempts are captured in full, including HTTP headers and bodies
+	// of requests and responses. Captured records are handed over
+	// to client's CaptureEmitter and the most recent CaptureRetain
+	// of them are retained for retrieval with client's Captures method.
+	// Sampling is hash-based and cheap enough for production use.
+	// Authorization headers are redacted.
+	//
+	//	CaptureRate = 0.1 * funit.Percent
+	CaptureRate funit.Measure
+
+	// CaptureRetain is the number of most recent captured push attempts
+	// retained for retrieval. See CaptureRate.
+	CaptureRetain int
+
+	// FrameTracer, if not nil, is called for every HTTP/2 frame sent
+	// or received on connections to APN service. It is a debugging aid
+	// and is called synchronously from connection reads and writes,
+	// so it must be quick and must not block. If nil, connections are
+	// not traced and there is no overhead.
+	FrameTracer http2x.FrameHandler
+
+	// ProbeRequest, if not nil, is the benign request sent to APN service
+	// to verify connectivity and credentials, such as by Client.Ping.
+	// It can be customized for a particular environment or mock server.
+	// If nil, DefaultProbeRequest is used.
+	ProbeRequest *ProbeRequest
+}
+
+// DefaultMaxResponseBodySize is the response body read limit
+// if CommsCfg.MaxResponseBodySize is not specified.
+const DefaultMaxResponseBodySize = 4096
+
+// Defaults applied to unset CommsCfg values.
+const (
+	defaultMinDialBackOff   = 4 * time.Second
+	defaultHTTP2PingTimeout = 15 * time.Second
+)
+
+func (c *CommsCfg) probeRequest() *ProbeRequest {
+	if c.ProbeRequest != nil {
+		return c.ProbeRequest
+	}
+	return &DefaultProbeRequest
+}
+
+func (c *CommsCfg) maxResponseBodySize() int64 {
+	if c.MaxResponseBodySize > 0 {
+		return c.MaxResponseBodySize
+	}
+	return DefaultMaxResponseBodySize
+}
+
+// readBody reads up to limit bytes of response body. It reports whether
+// the body was longer than that and got truncated.
+func readBody(body io.Reader, limit int64) ([]byte, bool, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		return buf[:limit], true, nil
+	}
+	return buf, false, nil
 }
 
 // CommsFast is a baseline set of communication settings for situations where
@@ -60,7 +258,7 @@ var CommsFast = CommsCfg{
 	MaxDialBackOff:       10 * time.Minute,
 	DialBackOffJitter:    10 * funit.Percent,
 	RequestTimeout:       30 * time.Second,
-	KeepAlive:            10 * time.Hour,
+	TCPKeepAlive:         10 * time.Hour,
 	MaxConcurrentStreams: 500,
 }
 
@@ -72,19 +270,104 @@ var CommsSlow = CommsCfg{
 	MaxDialBackOff:       10 * time.Minute,
 	DialBackOffJitter:    10 * funit.Percent,
 	RequestTimeout:       60 * time.Second,
-	KeepAlive:            10 * time.Hour,
+	TCPKeepAlive:         10 * time.Hour,
+	MaxConcurrentStreams: 500,
+}
+
+// CommsProduction is a general purpose set of communication settings
+// for production deployments. It is a good starting point when no
+// particular throughput or latency requirements are known.
+var CommsProduction = CommsCfg{
+	DialTimeout:          30 * time.Second,
+	MinDialBackOff:       5 * time.Second,
+	MaxDialBackOff:       10 * time.Minute,
+	DialBackOffJitter:    10 * funit.Percent,
+	RequestTimeout:       45 * time.Second,
+	TCPKeepAlive:         10 * time.Hour,
 	MaxConcurrentStreams: 500,
 }
 
+// CommsHighThroughput is a set of communication settings for high volume
+// pushes. It allows more concurrent streams per connection and ramps them
+// up gradually so that fresh connections are not overwhelmed.
+var CommsHighThroughput = CommsCfg{
+	DialTimeout:          30 * time.Second,
+	MinDialBackOff:       4 * time.Second,
+	MaxDialBackOff:       5 * time.Minute,
+	DialBackOffJitter:    10 * funit.Percent,
+	RequestTimeout:       60 * time.Second,
+	TCPKeepAlive:         10 * time.Hour,
+	MaxConcurrentStreams: 1000,
+	StreamRampUp:         10 * time.Second,
+}
+
+// CommsLowLatency is a set of communication settings for time-sensitive
+// pushes. It gives up on slow dials and requests quickly and retries
+// failed connects sooner.
+var CommsLowLatency = CommsCfg{
+	DialTimeout:          10 * time.Second,
+	MinDialBackOff:       2 * time.Second,
+	MaxDialBackOff:       2 * time.Minute,
+	DialBackOffJitter:    10 * funit.Percent,
+	RequestTimeout:       10 * time.Second,
+	TCPKeepAlive:         10 * time.Hour,
+	MaxConcurrentStreams: 200,
+}
+
 // CommsDefault is the set of communication settings that is used when
 // you do not supply an explicit comms configuration where one is needed.
 var CommsDefault = CommsSlow
 
-func makeDialer(commsCfg CommsCfg) func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+// lookupIPAddr is the host name resolver used for connection address checks.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// isAddrResolved resolves host with lookup and returns true if ip is one
+// of the resolved addresses.
+func isAddrResolved(ctx context.Context, lookup lookupFunc, host string, ip net.IP) (bool, error) {
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// effective returns a copy of c with defaults in place of unset values.
+func (c *CommsCfg) effective() CommsCfg {
+	res := *c
+	if res.MinDialBackOff <= 0 {
+		res.MinDialBackOff = defaultMinDialBackOff
+	}
+	res.TCPKeepAlive = c.tcpKeepAlive()
+	if res.PipelineDepth == 0 {
+		res.PipelineDepth = res.MaxConcurrentStreams
+	}
+	if res.HTTP2PingInterval > 0 && res.HTTP2PingTimeout <= 0 {
+		res.HTTP2PingTimeout = defaultHTTP2PingTimeout
+	}
+	res.MaxResponseBodySize = c.maxResponseBodySize()
+	res.FailoverAfter = c.failoverAfter()
+	res.FailbackInterval = c.failbackInterval()
+	return res
+}
+
+// tcpKeepAlive returns TCP keep-alive period to be set on the dialer.
+func (c *CommsCfg) tcpKeepAlive() time.Duration {
+	if c.TCPKeepAlive != 0 {
+		return c.TCPKeepAlive
+	}
+	return c.KeepAlive
+}
+
+func makeDialer(commsCfg CommsCfg) dialFunc {
 	return func(network, addr string, cfg *tls.Config) (net.Conn, error) {
 		dialer := &net.Dialer{
 			Timeout:   commsCfg.DialTimeout,
-			KeepAlive: commsCfg.KeepAlive,
+			KeepAlive: commsCfg.tcpKeepAlive(),
 		}
 		return tls.DialWithDialer(dialer, network, addr, cfg)
 	}
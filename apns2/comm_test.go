@@ -3,8 +3,15 @@
 package apns2
 
 import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/http2"
 )
 
@@ -35,3 +42,81 @@ func TestDialTimeout(t *testing.T) {
 		t.Fatal("Should not have connected")
 	}
 }
+
+func TestIsAddrResolved(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host != "api.push.apple.com" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}, {IP: net.ParseIP("17.0.0.2")}}, nil
+	}
+	ok, err := isAddrResolved(context.Background(), lookupIPAddr, "api.push.apple.com", net.ParseIP("17.0.0.2"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = isAddrResolved(context.Background(), lookupIPAddr, "api.push.apple.com", net.ParseIP("17.0.0.3"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = isAddrResolved(context.Background(), lookupIPAddr, "example.com", net.ParseIP("17.0.0.1"))
+	assert.Error(t, err)
+}
+
+func TestStreamerResolver(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	var mu sync.Mutex
+	calls := 0
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 2 {
+			return []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("17.0.0.2")}}, nil
+	}
+	s := &streamer{
+		id:         "test",
+		c:          &Client{},
+		httpClient: &HTTPClient{addr: "api.push.apple.com:443"},
+		recycle:    make(chan struct{}),
+	}
+	s.httpClient.setConnAddr(&net.TCPAddr{IP: net.ParseIP("17.0.0.1"), Port: 443})
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.runResolver(time.Millisecond, stop)
+	select {
+	case <-s.recycle:
+	case <-time.After(time.Second):
+		t.Fatal("Should have recycled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// a single miss is not enough, and a hit resets the count
+	assert.Equal(t, 2+resolveMissLimit, calls)
+}
+
+func TestReadBody(t *testing.T) {
+	b, truncated, err := readBody(strings.NewReader(`{"reason":"BadDeviceToken"}`), 100)
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, `{"reason":"BadDeviceToken"}`, string(b))
+	b, truncated, err = readBody(strings.NewReader("0123456789"), 10)
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Len(t, b, 10)
+	b, truncated, err = readBody(strings.NewReader("0123456789A"), 10)
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "0123456789", string(b))
+	c := &CommsCfg{}
+	assert.Equal(t, int64(DefaultMaxResponseBodySize), c.maxResponseBodySize())
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	c := &CommsCfg{KeepAlive: time.Minute}
+	assert.Equal(t, time.Minute, c.tcpKeepAlive())
+	c.TCPKeepAlive = 2 * time.Minute
+	assert.Equal(t, 2*time.Minute, c.tcpKeepAlive())
+	c.TCPKeepAlive = -1
+	assert.Equal(t, time.Duration(-1), c.tcpKeepAlive())
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+)
+
+// DefaultCompletionWorkers is the number of goroutines running requests'
+// OnComplete funcs if ProcCfg.CompletionWorkers is not specified.
+const DefaultCompletionWorkers = 8
+
+// completionQueuePerWorker is the number of pending OnComplete invocations
+// buffered per worker.
+const completionQueuePerWorker = 100
+
+// completionPool runs requests' OnComplete funcs on a bounded number
+// of worker goroutines, so that slow funcs do not hold up streamers.
+// Workers are only started once the first func is queued.
+// Nil completionPool runs funcs synchronously.
+type completionPool struct {
+	workers  int
+	queue    chan func()
+	ctl      chan struct{}
+	wg       sync.WaitGroup
+	runOnce  sync.Once
+	stopOnce sync.Once
+}
+
+func newCompletionPool(workers int) *completionPool {
+	if workers <= 0 {
+		workers = DefaultCompletionWorkers
+	}
+	return &completionPool{
+		workers: workers,
+		queue:   make(chan func(), workers*completionQueuePerWorker),
+		ctl:     make(chan struct{}),
+	}
+}
+
+// tryPut queues f without blocking. It returns false if the queue is full.
+func (p *completionPool) tryPut(f func()) bool {
+	if p == nil {
+		f()
+		return true
+	}
+	p.runOnce.Do(p.start)
+	select {
+	case p.queue <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// put queues f, waiting for room in the queue if necessary.
+// f is dropped if ctl is closed while waiting.
+func (p *completionPool) put(f func(), ctl <-chan struct{}) {
+	if p == nil {
+		f()
+		return
+	}
+	p.runOnce.Do(p.start)
+	select {
+	case p.queue <- f:
+	case <-ctl:
+	}
+}
+
+func (p *completionPool) start() {
+	p.wg.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.run()
+	}
+}
+
+// stop lets the workers run any queued funcs and exit.
+func (p *completionPool) stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.ctl)
+	})
+}
+
+// wait blocks until all workers have exited.
+func (p *completionPool) wait() {
+	if p == nil {
+		return
+	}
+	p.wg.Wait()
+}
+
+func (p *completionPool) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case f := <-p.queue:
+			f()
+		case <-p.ctl:
+			for {
+				select {
+				case f := <-p.queue:
+					f()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
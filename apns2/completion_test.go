@@ -0,0 +1,84 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionPool(t *testing.T) {
+	// nil pool runs funcs synchronously
+	var np *completionPool
+	called := false
+	assert.True(t, np.tryPut(func() { called = true }))
+	assert.True(t, called)
+	np.stop()
+	np.wait()
+
+	p := newCompletionPool(2)
+	assert.Equal(t, 2*completionQueuePerWorker, cap(p.queue))
+	var running, maxRunning, total int32
+	release := make(chan struct{})
+	f := func() {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&total, 1)
+	}
+	for i := 0; i < 10; i++ {
+		assert.True(t, p.tryPut(f))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxRunning))
+	close(release)
+	// queued funcs are run before workers exit
+	p.stop()
+	p.wait()
+	assert.Equal(t, int32(10), atomic.LoadInt32(&total))
+}
+
+func TestClient_OnComplete(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	cb := make(chan *Result, 1)
+	c.Callback = cb
+	q := make(chan *Request, 1)
+	c.Queue = q
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var resp *Response
+	var err error
+	q <- &Request{
+		Notification: testNotif_Good,
+		Signer:       DefaultSigner,
+		Context:      NoContext,
+		OnComplete: func(r *Response, e error) {
+			mu.Lock()
+			defer mu.Unlock()
+			resp, err = r, e
+		},
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	// Stop waits for OnComplete funcs to be run.
+	c.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Nil(t, err)
+	if assert.NotNil(t, resp) {
+		assert.True(t, resp.IsAccepted())
+	}
+}
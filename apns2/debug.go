@@ -0,0 +1,195 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apns/http2x"
+)
+
+// DebugState is a detailed snapshot of the internal state of Client's
+// processing pipeline. It is intended for troubleshooting rather than
+// for routine metrics collection, for which Stats should be used.
+type DebugState struct {
+
+	// Time at which the snapshot was taken.
+	Time time.Time
+
+	// Streamers holds the state of every active streamer.
+	Streamers []StreamerState
+
+	// Launchers holds the state of every pending streamer launch.
+	Launchers []LauncherState
+
+	// InWaits and InNoWaits are the numbers of continuous polling periods
+	// with and without blocking on the inbound channel respectively.
+	InWaits   uint32
+	InNoWaits uint32
+
+	// OutWaits and OutNoWaits are the numbers of continuous polling periods
+	// with and without blocking on the outbound channel respectively.
+	OutWaits   uint32
+	OutNoWaits uint32
+
+	// MaxConns is the effective limit on the number of connections.
+	// It differs from ProcCfg.MaxConns if changed with SetMaxConns.
+	MaxConns uint32
+
+	// MaxRate is the effective throughput cap. It differs from
+	// ProcCfg.MaxRate if changed with SetMaxRate.
+	MaxRate funit.Measure
+
+	// ConnsPerIP holds the number of connections per APN service address.
+	// It is only tracked if CommsCfg.MaxConnsPerIP is set, and is nil
+	// otherwise.
+	ConnsPerIP map[string]uint32
+
+	// LastScale is the time of the last scaling completion.
+	LastScale time.Time
+
+	// IsSettling is true if the governor is in the settle period following
+	// the last scaling and will not attempt to scale up.
+	IsSettling bool
+
+	// IsStalled is true if the processing pipeline is deemed stalled.
+	IsStalled bool
+
+	// IsClosing is true if the governor is shutting down.
+	IsClosing bool
+}
+
+// StreamerState is a snapshot of the state of a single streamer.
+type StreamerState struct {
+
+	// Id is the streamer's identifier as used in logs.
+	Id string
+
+	// IsGated is true if the streamer has not yet been allowed
+	// to consume push requests.
+	IsGated bool
+
+	// IsWindingDown is true if the streamer is being wound down
+	// and no longer consumes push requests.
+	IsWindingDown bool
+
+	// InFlight is the number of HTTP/2 streams currently reserved
+	// in the streamer's connection.
+	InFlight uint32
+
+	// ConnAge is the time elapsed since the streamer's connection
+	// was established, or 0 if it hasn't been.
+	ConnAge time.Duration
+
+	// FlowWindows holds HTTP/2 send flow-control windows of the streamer's
+	// connection. Low windows relative to payload sizes indicate that
+	// the connection is flow-control limited rather than stream-count
+	// limited. It is only available if ProcCfg.AllowHTTP2Incursion is set,
+	// and is nil otherwise.
+	FlowWindows *http2x.FlowWindows
+
+	// ErrorRate is the share of failed push attempts among the recent ones,
+	// as tracked for ProcCfg.MaxConnErrorRate. It is 0 if error rate
+	// tracking is disabled.
+	ErrorRate funit.Measure
+
+	// Served is the number of push requests the streamer's connection
+	// has served so far.
+	Served uint64
+
+	// LastResponse is the time the streamer last received a response
+	// from APN service, or zero time if it hasn't. A streamer that has
+	// requests in flight but hasn't received a response in a long time
+	// may be stuck, see ProcCfg.LivenessTimeout.
+	LastResponse time.Time
+}
+
+// LauncherState is a snapshot of the state of a pending streamer launch.
+type LauncherState struct {
+
+	// Id is the identifier of the streamer being launched.
+	Id string
+
+	// Elapsed is the time since the launch began.
+	Elapsed time.Duration
+}
+
+// DumpState returns a detailed snapshot of the internal state of client's
+// processing pipeline. The snapshot is taken by the governor in between
+// its other activities, so DumpState is safe for use in concurrent
+// goroutines. If the client is not running, ErrClientNotRunning is returned.
+func (c *Client) DumpState() (*DebugState, error) {
+	c.mu.RLock()
+	gov := c.gov
+	isRunning := c.state >= stateStarting && c.state <= stateStopping
+	c.mu.RUnlock()
+	if !isRunning || gov == nil {
+		return nil, ErrClientNotRunning
+	}
+	reply := make(chan *DebugState, 1)
+	select {
+	case gov.dumps <- reply:
+	case <-gov.done:
+		return nil, ErrClientNotRunning
+	}
+	return <-reply, nil
+}
+
+func (g *governor) dumpState() *DebugState {
+	now := time.Now()
+	res := &DebugState{
+		Time:       now,
+		Streamers:  make([]StreamerState, 0, len(g.streamers)),
+		Launchers:  make([]LauncherState, 0, len(g.launchers)),
+		InWaits:    g.inCtr.waits,
+		InNoWaits:  g.inCtr.noWaits,
+		OutWaits:   g.outCtr.waits,
+		OutNoWaits: g.outCtr.noWaits,
+		MaxConns:   g.cfg.MaxConns,
+		MaxRate:    g.cfg.MaxRate,
+		LastScale:  g.lastScale,
+		IsSettling: g.isSettling(now),
+		IsStalled:  g.isStalled,
+		IsClosing:  g.isClosing,
+	}
+	if g.c.ipSlots != nil {
+		res.ConnsPerIP = g.c.ipSlots.counts()
+	}
+	for s := range g.streamers {
+		res.Streamers = append(res.Streamers, s.dumpState(now))
+	}
+	for l := range g.launchers {
+		res.Launchers = append(res.Launchers, LauncherState{Id: l.id, Elapsed: now.Sub(l.started)})
+	}
+	return res
+}
+
+func (s *streamer) dumpState(now time.Time) StreamerState {
+	res := StreamerState{
+		Id:            s.id,
+		IsWindingDown: s.isWindingDown,
+		ErrorRate:     s.errTracker.rate(),
+		Served:        atomic.LoadUint64(&s.served),
+	}
+	if n := atomic.LoadInt64(&s.lastResponse); n != 0 {
+		res.LastResponse = time.Unix(0, n)
+	}
+	if s.gate != nil {
+		select {
+		case <-s.gate:
+		default:
+			res.IsGated = true
+		}
+	}
+	if s.httpClient != nil {
+		var since time.Time
+		res.InFlight, since = s.httpClient.connState()
+		if !since.IsZero() {
+			res.ConnAge = now.Sub(since)
+		}
+		res.FlowWindows = s.httpClient.flowWindows()
+	}
+	return res
+}
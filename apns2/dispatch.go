@@ -4,6 +4,8 @@ package apns2
 
 import (
 	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/baobabus/go-apns/funit"
@@ -20,8 +22,41 @@ type ProcCfg struct {
 
 	// RetryEval is the function that is called when a push attempt fails
 	// and retry eligibility needs to be determined.
+	// Rejections are only retried if their reason is classified as
+	// retriable, regardless of what RetryEval returns.
+	// If RetryEval is nil, failed requests are not retried.
+	// DefaultRetryEval can be used to retry all such rejections.
 	RetryEval func(*Response, error) bool
 
+	// AssignApnsID, if true, makes the client generate an ApnsID for every
+	// request that is eligible for retries and does not have one. Since all
+	// attempts to push a notification carry the same ApnsID, this allows
+	// APN service to recognize duplicate deliveries, such as those resulting
+	// from retrying a request that timed out after having been delivered.
+	// The generated ApnsID is set on a copy of the notification, which is
+	// then reported in the push result.
+	AssignApnsID bool
+
+	// RegenerateBadApnsID, if true, makes the client resubmit requests
+	// rejected with BadMessageId right away with a newly generated ApnsID.
+	// Only one such resubmission is made per request, and it does not
+	// count against MaxRetries. The new ApnsID is set on a copy of the
+	// notification, which is then reported in the push result.
+	RegenerateBadApnsID bool
+
+	// ValidateDeviceTokens, if true, makes the client fail requests
+	// addressed to malformed device tokens with *DeviceTokenError
+	// without sending them to APN service. See IsValidDeviceToken.
+	ValidateDeviceTokens bool
+
+	// PushTypeResolver, if not nil, is called for every new request whose
+	// notification has no PushType, allowing apns-push-type header
+	// to be derived centrally rather than set by every caller.
+	// The resolved push type, unless empty, is set on a copy
+	// of the notification, which is then reported in the push result.
+	// DefaultPushTypeResolver handles the common cases.
+	PushTypeResolver func(*Request) PushType
+
 	// MinConns is minimum number of concurrent connections to APN servers
 	// that should be kept open.
 	MinConns uint32
@@ -30,10 +65,23 @@ type ProcCfg struct {
 	// to APN service.
 	MaxConns uint32
 
+	// WarmConns, if above MinConns, is the number of connections that
+	// are kept open through short lulls in traffic, sparing renewed
+	// traffic the latency of dialing. Winding down stops at WarmConns
+	// until no requests have been submitted for WarmIdlePeriod, and only
+	// then proceeds down to MinConns.
+	WarmConns uint32
+
+	// WarmIdlePeriod is the amount of time with no requests submitted
+	// after which connections are wound down below WarmConns.
+	// If zero, DefaultWarmIdlePeriod is used.
+	WarmIdlePeriod time.Duration
+
 	// MaxRate is the throughput cap specified in notifications per second.
 	// It is not strictly enforced as would be the case with a true rate
 	// limiter. Instead it only prevents additional scaling from taking place
 	// once the specified rate is reached.
+	// It can be changed on a running client with Client.SetMaxRate.
 	MaxRate funit.Measure
 
 	// MaxBandwidth is the throughput cap specified in bits per second.
@@ -56,12 +104,24 @@ type ProcCfg struct {
 	// PollInterval is the time between performance metrics sampling attempts.
 	PollInterval time.Duration
 
+	// StatusWindow is the length of the sliding window over which response
+	// status codes are counted for Stats.RecentStatus. The window advances
+	// in steps of PollInterval. If zero, DefaultStatusWindow is used.
+	StatusWindow time.Duration
+
 	// SettlePeriod is the amount of time given to the processing for it to
 	// settle down at the new rate after successful scaling up or
 	// winding down attempt. Sustained performance analysis is ignored during
 	// this time and no new scaling attempt is made.
 	SettlePeriod time.Duration
 
+	// ScaleUpSettlePeriod and ScaleDownSettlePeriod, if positive, override
+	// SettlePeriod following scaling up and winding down respectively.
+	// This allows, for example, frequent wind-downs while rate-limiting
+	// scale-ups to avoid connection bursts.
+	ScaleUpSettlePeriod   time.Duration
+	ScaleDownSettlePeriod time.Duration
+
 	// AllowHTTP2Incursion controls whether it is OK to perform reflection-based
 	// probing of HTTP/2 layer. When enabled, scaler may access certain private
 	// properties in x/net/http2 package if needed for more precise performance
@@ -88,8 +148,326 @@ type ProcCfg struct {
 	// Setting HTTP2MetricsRefreshPeriod to 0 or negative value disables
 	// metrics refresh even if UsePreciseMetrics is false.
 	HTTP2MetricsRefreshPeriod time.Duration
+
+	// DispatchStrategy, if not nil, decides which streamer each push
+	// request is handed to. If nil, all streamers consume push requests
+	// from a single shared channel. See DispatchStrategy type declaration
+	// for additional details.
+	DispatchStrategy DispatchStrategy
+
+	// StartMode controls when newly connected streamers begin consuming
+	// push requests. By default streamers start consuming as soon as their
+	// connection is established. See StartMode type declaration
+	// for additional details.
+	StartMode StartMode
+
+	// CollapseIDTrackSize is the maximum number of distinct collapse IDs
+	// for which the number of sent notifications is tracked. Least recently
+	// used collapse IDs are evicted first. Tracking is disabled if
+	// CollapseIDTrackSize is 0.
+	CollapseIDTrackSize int
+
+	// CollapseIDWarnRate, if positive, is the rate of notifications sent
+	// with the same collapse ID above which a warning is logged. This helps
+	// catching runaway update loops. It is only effective if collapse ID
+	// tracking is enabled.
+	//
+	// For clarity it is best expressed in idiomatic way:
+	//
+	//	CollapseIDWarnRate = 10 / funit.Minute
+	CollapseIDWarnRate funit.Measure
+
+	// CollapseIDMinInterval, if positive, is the minimum amount of time
+	// between sends of notifications sharing a collapse ID to the same
+	// recipient. This protects devices from being hammered by runaway
+	// updates. Notifications submitted within the interval are dropped,
+	// failing with ErrCollapsed, unless CoalesceCollapseIDs is set.
+	// Retries are not affected.
+	CollapseIDMinInterval time.Duration
+
+	// CoalesceCollapseIDs, if true, makes notifications submitted within
+	// CollapseIDMinInterval be held back until the interval elapses,
+	// with each one superseding the previously held back one, which fails
+	// with ErrCollapsed. Only the latest notification is then sent.
+	CoalesceCollapseIDs bool
+
+	// OnStall, if not nil, is called when the processing pipeline appears
+	// to be stalled, i.e. when both the inbound and the outbound channels
+	// have experienced continuous blocking for at least StallPeriod.
+	// Neither scaling up nor winding down can help in such situation,
+	// which typically indicates that the consumer of the results
+	// is no longer receiving them.
+	// OnStall is called at most once per stall occurrence with the duration
+	// of the stall. It is called in its own goroutine.
+	OnStall func(stalled time.Duration)
+
+	// StallPeriod is the minimum duration of continuous blocking on both
+	// inbound and outbound channels after which the pipeline is considered
+	// to be stalled. If StallPeriod is 0, MinSustain is used.
+	StallPeriod time.Duration
+
+	// OnLoadShed, if not nil, is called for every new request submitted
+	// while the client is saturated, i.e. while inbound blocking has been
+	// sustained for MinSustain and cannot be relieved by scaling up due
+	// to MaxConns, MaxGoroutines, MaxRate or MaxBandwidth limits.
+	// If it returns a non-nil request, such as one with non-essential
+	// payload keys stripped, the returned request is processed in place
+	// of the submitted one. OnLoadShed is called synchronously from the
+	// submitting goroutine and must not block. See Client.IsSaturated.
+	OnLoadShed func(*Request) *Request
+
+	// OnSaturated, if not nil, is called once every time the client
+	// becomes saturated at MaxConns, i.e. when inbound blocking has been
+	// sustained for MinSustain while all of the allowed connections are
+	// in use. This signals that MaxConns needs to be raised or load shed.
+	// It is called in its own goroutine. See Client.CheckCapacity.
+	OnSaturated func(*SaturationError)
+
+	// OnAttempt, if not nil, is called upon completion of every push attempt,
+	// including the ones that are going to be retried. It is called
+	// synchronously from the goroutine handling the attempt and must not
+	// block. It is intended for collecting metrics.
+	OnAttempt func(*AttemptEvent)
+
+	// OnPayloadTooLarge, if not nil, is called when APN service rejects
+	// a request with 413 PayloadTooLarge status. If it returns a non-nil
+	// replacement request, such as one with a truncated alert body, the
+	// replacement is resubmitted in place of the rejected request.
+	// Only one such resubmission is made per request. If OnPayloadTooLarge
+	// is nil or returns nil, the rejection is final.
+	OnPayloadTooLarge func(*Request) *Request
+
+	// PayloadRetention controls whether notification payloads are retained
+	// in the results of failed push requests. By default payloads are
+	// retained, allowing the caller to resubmit failed notifications.
+	PayloadRetention PayloadRetention
+
+	// PayloadSchema, if not nil, is the JSON schema every encoded
+	// notification payload is validated against before it is sent.
+	// Notifications with non-conforming payloads fail with
+	// a *PayloadSchemaError without being sent to APN service.
+	// See CompilePayloadSchema.
+	PayloadSchema *PayloadSchema
+
+	// MaxConnErrorRate, if positive, is the share of failed push attempts
+	// among the most recent ConnErrorWindow attempts on a single connection
+	// above which the connection is abandoned and a new one is established
+	// in its place. This helps moving away from a degraded APN server.
+	// Only transport errors and server errors are counted.
+	//
+	// For clarity it is best expressed in idiomatic way:
+	//
+	//	MaxConnErrorRate = 20 * funit.Percent
+	MaxConnErrorRate funit.Measure
+
+	// ConnErrorWindow is the number of most recent push attempts
+	// over which connection error rate is evaluated.
+	// Error rate tracking is disabled if ConnErrorWindow is 0.
+	ConnErrorWindow uint32
+
+	// LivenessTimeout, if positive, is the amount of time a connection
+	// with push requests in flight may go without receiving a response
+	// from APN service before it is deemed wedged and abandoned, with
+	// a new one established in its place. This catches connections that
+	// silently stop making progress without producing any errors.
+	// Requests in flight on an abandoned connection complete or time out
+	// as usual.
+	LivenessTimeout time.Duration
+
+	// MaxDialFailureRate, if positive, is the share of failed connection
+	// attempts among the most recent DialFailureWindow attempts above which
+	// scaling up beyond MinConns is dampened to one new connection at a time,
+	// regardless of the blocking signal. This keeps the governor from piling
+	// on doomed connection attempts during connectivity problems.
+	//
+	//	MaxDialFailureRate = 50 * funit.Percent
+	MaxDialFailureRate funit.Measure
+
+	// DialFailureWindow is the number of most recent connection attempts
+	// over which dial failure rate is evaluated.
+	// Dial failure rate tracking is disabled if DialFailureWindow is 0.
+	DialFailureWindow uint32
+
+	// ReceiptBufferSize is the number of delivery receipts that can be
+	// buffered for Client's ReceiptEmitter. If 0, DefaultReceiptBufferSize
+	// is used.
+	ReceiptBufferSize int
+
+	// MinConnsGracePeriod, if positive, is the amount of time the number
+	// of established connections is allowed to stay below MinConns before
+	// the client is deemed degraded. Degraded state and the most recent
+	// connection error are reported by Client's Healthy method.
+	MinConnsGracePeriod time.Duration
+
+	// OnScale, if not nil, is called every time the governor decides
+	// to scale the number of connections. It is called synchronously
+	// from the governor and must not block. See ScaleRecorder.
+	OnScale func(*ScaleEvent)
+
+	// RetryOverflow specifies what happens to a retry that cannot be
+	// handed back to the processing pipeline right away, e.g. because
+	// the pipeline is back-pressured or shutting down. See RetryOverflowPolicy.
+	RetryOverflow RetryOverflowPolicy
+
+	// RetryReinjectTimeout, if positive, is the maximum amount of time
+	// a retry waits to be handed back to the processing pipeline with
+	// RetryOverflowBlock policy before being dropped. If 0, retries wait
+	// indefinitely.
+	RetryReinjectTimeout time.Duration
+
+	// MaxInFlightRetries, if positive, is the maximum number of retried
+	// requests being processed at once, from being resubmitted until
+	// reaching their final outcome. It reserves connection capacity for
+	// new requests, preventing a retry storm from starving them. Once
+	// the limit is reached, further retries wait in retry forwarders,
+	// subject to RetryOverflow and RetryReinjectTimeout.
+	// If 0, retries are not limited.
+	MaxInFlightRetries int
+
+	// WindDownGrace is the amount of time a streamer being wound down
+	// is given to complete its in-flight requests. Requests still in flight
+	// when the grace period expires are canceled and resubmitted through
+	// the retry path like any other retry, counting against their retry
+	// budget and MaxRetryAge. Requests that have exhausted it fail instead.
+	// If 0, in-flight requests are allowed to complete without a limit,
+	// subject to RequestTimeout.
+	WindDownGrace time.Duration
+
+	// MaxRetryForwarders is the maximum number of concurrent goroutines
+	// forwarding retried requests back to the processing pipeline, each
+	// buffering up to 500 requests. Once the limit is reached, streamers
+	// block on retries until one of the forwarders is done.
+	// If 0, DefaultMaxRetryForwarders is used.
+	MaxRetryForwarders int
+
+	// MaxTotal, if positive, is the maximum number of notifications
+	// the client accepts for processing over its lifetime. Every accepted
+	// notification counts once, regardless of its outcome and the number
+	// of attempts made. Once the quota is reached, the client initiates
+	// soft shutdown and further push requests are rejected with
+	// ErrQuotaExceeded.
+	MaxTotal uint64
+
+	// TopicConcurrency, if not empty, limits the number of concurrently
+	// in-flight push requests per notification topic across all
+	// connections. Requests over a topic's limit are held back until
+	// in-flight requests for the same topic complete, while requests for
+	// other topics proceed unaffected. Topics not listed are not limited.
+	// This protects healthy topics from a noisy one sharing the same client.
+	TopicConcurrency map[string]int
+
+	// MaxGoroutines, if positive, is a soft cap on the number of goroutines
+	// of client's streamers, pending streamer launches and retry forwarders.
+	// Once the cap is reached, no further scaling up takes place. Initial
+	// MinConns connections and replacements of lost connections are
+	// not affected. See Stats.Goroutines.
+	MaxGoroutines int
+
+	// DiscardResults enables fire-and-forget mode, in which results
+	// of push requests are discarded without being constructed or
+	// delivered to any callback, including the ones specified
+	// in individual requests. Delivery receipts and statistics
+	// are not affected.
+	DiscardResults bool
+
+	// CompletionWorkers is the maximum number of goroutines running
+	// requests' OnComplete funcs. Once all workers are busy and their
+	// queue is full, streamers wait for room in the queue.
+	// If 0, DefaultCompletionWorkers is used.
+	CompletionWorkers int
+
+	// RetryBackOffs, if not empty, specifies the delays before failed
+	// pushes are retried per reason class of the failure, e.g. so that
+	// throttled requests back off more than those that hit a transient
+	// server error. Connection errors are not retried, but requests whose
+	// roundtrips were canceled upon winding down are, and they are looked
+	// up under ReasonClassNone. Failures of classes that are not listed
+	// are retried right away, as are retries following provider token
+	// refresh.
+	RetryBackOffs map[ReasonClass]RetryBackOff
+
+	// RetryBackOffFunc, if not nil, calculates the delays before failed
+	// pushes are retried, taking precedence over RetryBackOffs. It is called
+	// with the number of attempts made so far and the reason class of the
+	// failure. A zero or negative delay retries right away.
+	RetryBackOffFunc BackOffFunc
+
+	// MaxRetryAge, if positive, is the maximum amount of time since
+	// a request was first queued for sending after which it is no longer
+	// retried, regardless of its remaining MaxRetries budget. Such requests
+	// fail with ErrRetryExpired. This bounds how stale a delivered
+	// notification can be following a long outage. Retries that are
+	// backing off are failed as soon as it is known that they would
+	// not be sent in time.
+	MaxRetryAge time.Duration
+}
+
+// isRetryExpired returns true if the request must not be retried at t.
+// Requests that have not been attempted yet, such as ones held back
+// for their topic or prefetched by a streamer, are not retries
+// and never expire.
+func (c *ProcCfg) isRetryExpired(req *Request, t time.Time) bool {
+	return c.MaxRetryAge > 0 && req.attemptCnt > 0 && !req.firstQueued.IsZero() && t.Sub(req.firstQueued) >= c.MaxRetryAge
 }
 
+// RetryOverflowPolicy specifies the handling of retries that cannot
+// be handed back to the processing pipeline right away.
+// Dropped retries are reported as failed with ErrRetryOverflow
+// and counted in Stats.DroppedRequests.
+type RetryOverflowPolicy uint
+
+const (
+	// RetryOverflowBlock makes retries wait to be handed back, for at most
+	// ProcCfg.RetryReinjectTimeout if one is set. This is the default.
+	RetryOverflowBlock RetryOverflowPolicy = iota
+
+	// RetryOverflowDrop makes retries that cannot be handed back
+	// right away be dropped.
+	RetryOverflowDrop
+)
+
+// DefaultWarmIdlePeriod is the idle period after which connections
+// are wound down below WarmConns if ProcCfg.WarmIdlePeriod is not specified.
+const DefaultWarmIdlePeriod = 10 * time.Minute
+
+// DefaultStatusWindow is the length of the sliding window of response
+// status codes if ProcCfg.StatusWindow is not specified.
+const DefaultStatusWindow = 5 * time.Minute
+
+// DefaultMaxRetryForwarders is the maximum number of concurrent retry
+// forwarders if ProcCfg.MaxRetryForwarders is not specified.
+const DefaultMaxRetryForwarders = 100
+
+// PayloadRetention specifies whether notification payloads are retained
+// in push results.
+type PayloadRetention uint
+
+const (
+	// RetainPayload keeps the original notification, including its payload,
+	// in all push results.
+	RetainPayload PayloadRetention = iota
+
+	// DropPayloadOnFailure replaces the notification in the results
+	// of failed pushes with a copy that only carries recipient and routing
+	// information, releasing the reference to the payload.
+	DropPayloadOnFailure
+)
+
+// StartMode specifies the point at which a newly launched streamer
+// begins pulling push requests from the dispatch channel.
+type StartMode uint
+
+const (
+	// StartEager lets a streamer start consuming push requests immediately
+	// upon establishing its connection.
+	StartEager StartMode = iota
+
+	// StartGated holds a newly connected streamer back until the governor
+	// has confirmed it as one of its active streamers. This avoids a brief
+	// window during which an unconfirmed streamer competes for requests.
+	StartGated
+)
+
 // MinBlockingProcConfig is a configuration with absolute mimimal processing
 // settings. It only allows a single connection to APN service with no scaling.
 // HTTP/2 layer metrics refresh is set to 500ms to allow proper handling
@@ -122,14 +500,29 @@ var UnlimitedProcConfig = ProcCfg{
 // If either PollInterval or MinSustain is not a valid time interval,
 // max uint32 is returned.
 func (c *ProcCfg) minSustainPollPeriods() uint32 {
-	if c.MinSustain == 0 {
+	return c.pollPeriods(c.MinSustain)
+}
+
+// stallPollPeriods returns the number of PollInterval periods per
+// StallPeriod time interval, or per MinSustain if StallPeriod is not set.
+// Rounding and invalid values are handled the same way
+// as in minSustainPollPeriods.
+func (c *ProcCfg) stallPollPeriods() uint32 {
+	if c.StallPeriod == 0 {
+		return c.minSustainPollPeriods()
+	}
+	return c.pollPeriods(c.StallPeriod)
+}
+
+func (c *ProcCfg) pollPeriods(d time.Duration) uint32 {
+	if d == 0 {
 		return 0
 	}
-	if c.MinSustain < 0 || c.PollInterval <= 0 {
+	if d < 0 || c.PollInterval <= 0 {
 		return ^uint32(0)
 	}
-	res := c.MinSustain / c.PollInterval
-	if c.MinSustain%c.PollInterval > 0 {
+	res := d / c.PollInterval
+	if d%c.PollInterval > 0 {
 		res++
 	}
 	return uint32(res)
@@ -161,14 +554,108 @@ func (c *ProcCfg) bandwidthAsSize() uint64 {
 	return uint64(float64(c.MaxBandwidth/funit.Byte)*n*float64(c.PollInterval)) / uint64(funit.Second.AsDuration())
 }
 
+// ScaleTargets returns the number of connections that the processing
+// pipeline would be scaled up and wound down to from the specified number
+// of connections n. Targets are computed with Scale and are kept within
+// MinConns and MaxConns limits.
+//
+// ScaleTargets does not modify the configuration and is intended
+// for inspecting how aggressively the configuration will scale.
+func (c *ProcCfg) ScaleTargets(n uint32) (up uint32, down uint32) {
+	return c.scaleTarget(n, forScaleUp), c.scaleTarget(n, forWindDown)
+}
+
+// settlePeriod returns the settle period applicable to scaling
+// in the specified direction.
+func (c *ProcCfg) settlePeriod(forScaleUp bool) time.Duration {
+	if forScaleUp && c.ScaleUpSettlePeriod > 0 {
+		return c.ScaleUpSettlePeriod
+	}
+	if !forScaleUp && c.ScaleDownSettlePeriod > 0 {
+		return c.ScaleDownSettlePeriod
+	}
+	return c.SettlePeriod
+}
+
+func (c *ProcCfg) statusWindow() time.Duration {
+	if c.StatusWindow > 0 {
+		return c.StatusWindow
+	}
+	return DefaultStatusWindow
+}
+
+func (c *ProcCfg) warmIdlePeriod() time.Duration {
+	if c.WarmIdlePeriod > 0 {
+		return c.WarmIdlePeriod
+	}
+	return DefaultWarmIdlePeriod
+}
+
+// effective returns a copy of c with defaults in place of unset values.
+func (c *ProcCfg) effective() ProcCfg {
+	res := *c
+	if res.ReceiptBufferSize <= 0 {
+		res.ReceiptBufferSize = DefaultReceiptBufferSize
+	}
+	if res.MaxRetryForwarders <= 0 {
+		res.MaxRetryForwarders = DefaultMaxRetryForwarders
+	}
+	if res.CompletionWorkers <= 0 {
+		res.CompletionWorkers = DefaultCompletionWorkers
+	}
+	res.WarmIdlePeriod = c.warmIdlePeriod()
+	res.StatusWindow = c.statusWindow()
+	res.ScaleUpSettlePeriod = c.settlePeriod(true)
+	res.ScaleDownSettlePeriod = c.settlePeriod(false)
+	if res.StallPeriod == 0 {
+		res.StallPeriod = c.MinSustain
+	}
+	return res
+}
+
+func (c *ProcCfg) scaleTarget(n uint32, forScaleUp bool) uint32 {
+	res := n
+	if c.Scale != nil {
+		if forScaleUp {
+			res = c.Scale.Apply(n)
+		} else {
+			res = c.Scale.ApplyInverse(n)
+		}
+	}
+	if res < c.MinConns {
+		res = c.MinConns
+	}
+	if res > c.MaxConns {
+		res = c.MaxConns
+	}
+	return res
+}
+
 type governor struct {
 	id   string
 	c    *Client
 	ctl  <-chan struct{}
-	done chan<- struct{}
+	done chan struct{}
 
 	cfg ProcCfg
 
+	// requests for internal state snapshots
+	dumps chan chan *DebugState
+
+	// requests for changing cfg.MaxConns
+	maxConns chan uint32
+
+	// copy of cfg.MaxConns for use outside of governor's goroutine,
+	// accessed atomically
+	curMaxConns uint32
+
+	// requests for changing cfg.MaxRate
+	maxRate chan funit.Measure
+
+	// bits of cfg.MaxRate for use outside of governor's goroutine,
+	// accessed atomically
+	curMaxRate uint64
+
 	// minimun number of continuous sampling periods of performance
 	// evaluation need to have an effect on scaling decision
 	minSust uint32
@@ -178,6 +665,11 @@ type governor struct {
 	inCtr  waitCounter
 	outCtr waitCounter
 
+	// minimum number of continuous sampling periods of blocking on both
+	// inbound and outbound channels for the pipeline to be deemed stalled
+	stallSust uint32
+	isStalled bool
+
 	// processing rate and bandwidth accumulators
 	countAcc *movingAcc
 	sizeAcc  *movingAcc
@@ -186,6 +678,10 @@ type governor struct {
 
 	retry chan *Request
 
+	// semaphore limiting the number of retries in flight,
+	// nil if not limited
+	retrySlots chan struct{}
+
 	// active streamers and pending launchers
 	streamers map[*streamer]chan struct{}
 	launchers map[*launcher]chan struct{}
@@ -196,12 +692,56 @@ type governor struct {
 	wExits chan *streamer
 	lExits chan *launcher
 
-	// time of last up- or down-scaling completion
-	lastScale time.Time
+	// time and direction of last up- or down-scaling completion
+	lastScale   time.Time
+	lastScaleUp bool
+
+	// end of the last polling period in which requests were submitted
+	lastActive time.Time
 
 	// tracker of blackout time due to back-off after failed connects
 	backOffTracker backOffTracker
 
+	// time since which fewer than MinConns streamers have been active
+	belowMinSince time.Time
+	// most recent streamer launch error
+	lastLaunchErr error
+	// number of consecutive failed launches
+	launchFailures uint32
+
+	// time of the most recent switch between gateways, see failover.go
+	lastSwitch time.Time
+	// set while failing back to the primary gateway is being attempted
+	isFailingBack bool
+	// gateway to return to if failing back is unsuccessful
+	fallbackIdx int
+
+	// number of active streamers being wound down
+	windingDown int
+
+	// numbers of streamer and launcher goroutines, and of buffered retry
+	// forwarders, accessed atomically
+	workerCnt int32
+	fwdCnt    int32
+
+	// set while scaling up is held back by cfg.MaxGoroutines
+	isGoroutineCapped bool
+
+	// tracker of recent launch failures, see cfg.MaxDialFailureRate
+	dialErrTracker *errRateTracker
+	// set while scaling up is dampened due to launch failures
+	isDialDamped bool
+
+	// set while scaling up is held back by cfg.MaxRate or cfg.MaxBandwidth
+	isRateCapped bool
+
+	// set while the pipeline is saturated, accessed atomically
+	saturated int32
+	// bits of the most recent scaling pressure, accessed atomically
+	pressure uint64
+	// time since which the pipeline has been saturated at MaxConns
+	atMaxSince time.Time
+
 	isClosing bool
 }
 
@@ -235,35 +775,80 @@ func (g *governor) run() {
 	g.lExits = make(chan *launcher)
 	g.streamers = make(map[*streamer]chan struct{})
 	g.launchers = make(map[*launcher]chan struct{})
-	g.backOffTracker.initial = 4 * time.Second
+	g.backOffTracker.initial = defaultMinDialBackOff
 	if g.c.CommsCfg.MinDialBackOff > 0 {
 		g.backOffTracker.initial = g.c.CommsCfg.MinDialBackOff
 	}
 	g.backOffTracker.max = g.c.CommsCfg.MaxDialBackOff
 	g.backOffTracker.jitter = g.c.CommsCfg.DialBackOffJitter
+	g.backOffTracker.rnd = g.c.rnd
+	g.backOffTracker.fn = g.c.CommsCfg.DialBackOffFunc
+	// slight buffering on the retry channel to improve performance
+	g.retry = make(chan *Request, 100)
+	g.lastActive = time.Now()
 	go g.runRetryForwarder()
 	// Launch first MinConns streamers
-	g.tryScaleUp()
+	g.tryScaleUp(ScaleReasonInitial)
 	var tkrChan <-chan time.Time
-	if g.cfg.PollInterval > 0 {
+	if p := g.c.Poller; p != nil {
+		sub := p.register()
+		defer p.unregister(sub)
+		tkrChan = sub
+	} else if g.cfg.PollInterval > 0 {
 		tkr := time.NewTicker(g.cfg.PollInterval)
 		defer tkr.Stop()
 		tkrChan = tkr.C
 	}
+	var livenessChan <-chan time.Time
+	if d := g.cfg.LivenessTimeout; d > 0 {
+		tkr := time.NewTicker(livenessCheckInterval(d))
+		defer tkr.Stop()
+		livenessChan = tkr.C
+	}
 	logInfo(g.id, "Running.")
 	for done := false; !done; {
+		atomic.StoreInt32(&g.workerCnt, int32(len(g.streamers)+len(g.launchers)))
 		select {
 		case l := <-g.lExits:
 			// launcher finished
 			delete(g.launchers, l)
-			g.backOffTracker.update(l.err)
+			if !l.capped {
+				g.backOffTracker.update(l.err)
+			}
+			if l.worker != nil || l.err != nil {
+				g.c.dialTracker.record(time.Since(l.started), l.worker == nil)
+				g.dialErrTracker.record(l.worker == nil)
+			}
 			if w := l.worker; w != nil {
+				g.launchFailures = 0
 				g.streamers[w] = w.ctl
-			} else if l.err != nil {
-				logWarn(g.id, "Error starting streamer: %v", l.err)
+				if g.excessConns() > 0 {
+					// MaxConns was lowered while we were launching.
+					g.windDown(1)
+				}
+				if d := g.c.dispatcher; d != nil {
+					d.add(w)
+				}
+				g.updateConnCount()
+				if w.gate != nil {
+					// confirmed - let gated streamer start consuming
+					close(w.gate)
+				}
+			} else {
+				g.c.budget.release(1)
+				if l.capped {
+					logInfo(g.id, "Not starting streamer: all addresses are at MaxConnsPerIP.")
+				} else if l.err != nil {
+					g.launchFailures++
+					g.lastLaunchErr = l.err
+					logWarn(g.id, "Error starting streamer: %v", l.err)
+				}
 			}
 			if len(g.launchers) == 0 {
-				g.lastScale = time.Now()
+				g.markScaled(time.Now(), forScaleUp)
+			}
+			if !l.capped {
+				g.evalFailback(l)
 			}
 			// TODO Handle failed launches
 		case w := <-g.wExits:
@@ -274,20 +859,41 @@ func (g *governor) run() {
 				g.isClosing = true
 			}
 			delete(g.streamers, w)
+			g.c.reuseTracker.record(atomic.LoadUint64(&w.served))
+			if d := g.c.dispatcher; d != nil {
+				d.remove(w)
+			}
+			if w.isWindingDown {
+				g.windingDown--
+			}
+			g.updateConnCount()
 			if w.didQuit {
 				// This needs to be on exponential back-off
 				g.launchStreamer()
+			} else {
+				g.c.budget.release(1)
 			}
+		case r := <-g.dumps:
+			r <- g.dumpState()
+		case n := <-g.maxConns:
+			g.setMaxConns(n)
+		case r := <-g.maxRate:
+			g.setMaxRate(r)
 		case <-tkrChan:
-			if g.isClosing {
+			if g.isClosing || g.c.IsPaused() {
 				break
 			}
 			s := g.updateCountersAndEvalScaling()
 			if s > 0 {
-				g.tryScaleUp()
+				g.tryScaleUp(ScaleReasonBlocking)
 			} else if s < 0 {
 				g.tryWindDown()
 			}
+			g.evalSaturation()
+		case now := <-livenessChan:
+			if !g.isClosing {
+				g.evalLiveness(now)
+			}
 		case <-g.ctl:
 			// Hard stop command
 			logInfo(g.id, "Terminating.")
@@ -296,6 +902,11 @@ func (g *governor) run() {
 		if !done && g.isClosing {
 			done = len(g.streamers) == 0 && len(g.launchers) == 0
 		}
+		if !done && !g.isClosing {
+			now := time.Now()
+			g.evalHealth(now)
+			g.evalFailover(now)
+		}
 	}
 	// signal launchers and streamers
 	logInfo(g.id, "Terminating launchers and streamers.")
@@ -305,6 +916,9 @@ func (g *governor) run() {
 	for i, _ := range g.streamers {
 		close(i.ctl)
 	}
+	g.c.budget.release(len(g.launchers) + len(g.streamers))
+	atomic.StoreInt32(&g.workerCnt, 0)
+	g.c.setSaturation(nil)
 	// TODO Signal forwarder to stop
 	logInfo(g.id, "Stopped.")
 	// Signal parent
@@ -316,6 +930,9 @@ func (g *governor) updateCountersAndEvalScaling() int {
 	shouldSize := g.cfg.MaxBandwidth > 0 && g.minSust > 0
 	ics, _ := g.c.waitCtr.Fold()
 	cnt := g.c.rateCtr.Draw()
+	if cnt > 0 {
+		g.lastActive = time.Now()
+	}
 	var ocs uint32
 	var osz uint64
 	// It is ok for the calls to Fold and Draw to not be fully synchronized.
@@ -329,19 +946,20 @@ func (g *governor) updateCountersAndEvalScaling() int {
 	}
 	g.inCtr.acc(ics)
 	g.outCtr.acc(ocs)
+	atomic.StoreUint64(&g.pressure, math.Float64bits(g.scalingPressure()))
+	g.evalStall()
 	if shouldCount {
 		cnt = g.countAcc.accumulate(cnt)
 	}
 	if shouldSize {
 		osz = g.sizeAcc.accumulate(osz)
 	}
+	g.isRateCapped = false
 	if g.inCtr.waits >= g.minSust && g.outCtr.noWaits >= g.minSust {
 		// We've been experiencing blocking long enough,
 		// but we must also not exceed allowed performance limits.
-		if shouldCount && cnt > g.maxCount {
-			return 0
-		}
-		if shouldSize && osz > g.maxSize {
+		if shouldCount && cnt > g.maxCount || shouldSize && osz > g.maxSize {
+			g.isRateCapped = true
 			return 0
 		}
 		return 1
@@ -351,31 +969,256 @@ func (g *governor) updateCountersAndEvalScaling() int {
 	return 0
 }
 
+// scalingPressure returns how strongly the wait counters push towards
+// scaling, in units of minSust. Positive values measure sustained inbound
+// blocking with outbound channels flowing freely, negative values measure
+// sustained absence of inbound blocking. Magnitude of 1 or more means that
+// the scaling threshold has been reached.
+func (g *governor) scalingPressure() float64 {
+	if g.minSust == 0 {
+		return 0
+	}
+	if n := g.inCtr.waits; n > 0 {
+		if g.outCtr.noWaits < n {
+			n = g.outCtr.noWaits
+		}
+		return float64(n) / float64(g.minSust)
+	}
+	return -float64(g.inCtr.noWaits) / float64(g.minSust)
+}
+
+// updateConnCount publishes the number of active streamers.
+// Client's OnConnCountChange hook is notified of any change.
+func (g *governor) updateConnCount() {
+	n := uint32(len(g.streamers))
+	old := atomic.SwapUint32(&g.c.connCnt, n)
+	if old != n && g.c.OnConnCountChange != nil {
+		g.c.OnConnCountChange(int(old), int(n))
+	}
+}
+
+// evalStall detects sustained simultaneous blocking on inbound
+// and outbound channels and notifies OnStall hook.
+func (g *governor) evalStall() {
+	if g.stallSust == 0 {
+		return
+	}
+	stalled := g.inCtr.waits >= g.stallSust && g.outCtr.waits >= g.stallSust
+	if stalled && !g.isStalled {
+		d := time.Duration(g.stallSust) * g.cfg.PollInterval
+		logWarn(g.id, "Processing stalled for %v.", d)
+		if g.cfg.OnStall != nil {
+			go g.cfg.OnStall(d)
+		}
+	}
+	g.isStalled = stalled
+}
+
+// evalSaturation detects sustained inbound blocking that cannot be
+// relieved by scaling up. Stalled pipeline is not deemed saturated.
+func (g *governor) evalSaturation() {
+	prov := uint32(len(g.streamers) + len(g.launchers) - g.windingDown)
+	capped := prov >= g.cfg.MaxConns || g.isGoroutineCapped || g.isRateCapped
+	saturated := g.inCtr.waits >= g.minSust && g.outCtr.noWaits >= g.minSust && capped
+	var v int32
+	if saturated {
+		v = 1
+	}
+	was := atomic.SwapInt32(&g.saturated, v) != 0
+	if saturated && !was {
+		logEvent(g.id, LogWarn, LogEventSaturated, logFields{LogFieldConns: prov}, "Saturated.")
+	} else if !saturated && was {
+		logInfo(g.id, "No longer saturated.")
+	}
+	atMax := saturated && prov >= g.cfg.MaxConns
+	if atMax && g.atMaxSince.IsZero() {
+		g.atMaxSince = time.Now()
+		err := &SaturationError{MaxConns: g.cfg.MaxConns, Since: g.atMaxSince}
+		g.c.setSaturation(err)
+		logWarn(g.id, "%v", err)
+		if g.cfg.OnSaturated != nil {
+			go g.cfg.OnSaturated(err)
+		}
+	} else if !atMax && !g.atMaxSince.IsZero() {
+		g.atMaxSince = time.Time{}
+		g.c.setSaturation(nil)
+	}
+}
+
+// evalHealth detects failure to sustain MinConns connections
+// for longer than MinConnsGracePeriod and updates client's health status.
+func (g *governor) evalHealth(now time.Time) {
+	if uint32(len(g.streamers)) >= g.cfg.MinConns {
+		g.belowMinSince = time.Time{}
+		if g.cfg.MinConnsGracePeriod > 0 && g.c.setDegraded(nil) {
+			logInfo(g.id, "Recovered.")
+		}
+		return
+	}
+	if g.belowMinSince.IsZero() {
+		g.belowMinSince = now
+		return
+	}
+	if g.cfg.MinConnsGracePeriod <= 0 || now.Sub(g.belowMinSince) < g.cfg.MinConnsGracePeriod || g.lastLaunchErr == nil {
+		return
+	}
+	err := &DegradedError{Since: g.belowMinSince, Err: g.lastLaunchErr}
+	if g.c.setDegraded(err) {
+		logWarn(g.id, "%v", err)
+	}
+}
+
+// livenessCheckInterval returns how often streamers are checked
+// for liveness given the liveness timeout d.
+func livenessCheckInterval(d time.Duration) time.Duration {
+	if d /= 4; d < 10*time.Millisecond {
+		d = 10 * time.Millisecond
+	}
+	return d
+}
+
+// evalLiveness abandons streamers that have had requests in flight
+// without receiving any response for longer than LivenessTimeout.
+func (g *governor) evalLiveness(now time.Time) {
+	for w := range g.streamers {
+		if w.isWindingDown || w.isUnresponsive {
+			continue
+		}
+		if inFlight, idle := w.liveness(now); inFlight > 0 && idle > g.cfg.LivenessTimeout {
+			fields := w.logFields(LogFieldIdle, idle.Seconds(), LogFieldInFlight, inFlight)
+			logEvent(w.id, LogWarn, LogEventStreamerUnresponsive, fields, "No response in %v with %d requests in flight. Abandoning connection.", idle, inFlight)
+			w.isUnresponsive = true
+			w.quit()
+		}
+	}
+}
+
 const (
 	forScaleUp  = true
 	forWindDown = false
 )
 
-func (g *governor) tryScaleUp() {
-	delta := g.allowedScaleDelta(forScaleUp)
+func (g *governor) tryScaleUp(reason string) {
+	delta := g.c.budget.reserve(g.allowedScaleDelta(forScaleUp))
 	logTrace(2, g.id, "tryScaleUp delta = %d", delta)
 	if delta <= 0 {
 		return
 	}
+	prov := len(g.streamers) + len(g.launchers)
+	logEvent(g.id, LogInfo, LogEventScaleUp, logFields{LogFieldDelta: delta, LogFieldConns: prov + delta, LogFieldReason: reason}, "Scaling up by %d to %d connections.", delta, prov+delta)
+	if g.cfg.OnScale != nil {
+		g.cfg.OnScale(&ScaleEvent{
+			Time:      time.Now(),
+			From:      prov,
+			To:        prov + delta,
+			Direction: ScaleUp,
+			Reason:    reason,
+		})
+	}
 	for i := 0; i < delta; i++ {
 		g.launchStreamer()
 	}
 }
 
 func (g *governor) tryWindDown() {
-	// TODO Implement winding down
+	delta := -g.allowedScaleDelta(forWindDown)
+	logTrace(2, g.id, "tryWindDown delta = %d", delta)
+	if delta <= 0 {
+		return
+	}
+	prov := len(g.streamers) + len(g.launchers) - g.windingDown
+	logEvent(g.id, LogInfo, LogEventWindDown, logFields{LogFieldDelta: -delta, LogFieldConns: prov - delta, LogFieldReason: ScaleReasonIdle}, "Winding down by %d to %d connections.", delta, prov-delta)
+	if g.cfg.OnScale != nil {
+		g.cfg.OnScale(&ScaleEvent{
+			Time:      time.Now(),
+			From:      prov,
+			To:        prov - delta,
+			Direction: ScaleDown,
+			Reason:    ScaleReasonIdle,
+		})
+	}
+	g.windDown(delta)
+	g.markScaled(time.Now(), forWindDown)
+}
+
+// windDown signals up to n active streamers to wind down.
+// Budget is released once wound down streamers exit.
+func (g *governor) windDown(n int) {
+	for w := range g.streamers {
+		if n == 0 {
+			break
+		}
+		if w.isWindingDown {
+			continue
+		}
+		w.isWindingDown = true
+		g.windingDown++
+		close(w.windDown)
+		n--
+	}
+}
+
+// excessConns returns the number of active streamers above cfg.MaxConns
+// that are not yet being wound down.
+func (g *governor) excessConns() int {
+	return len(g.streamers) - g.windingDown - int(g.cfg.MaxConns)
+}
+
+// setMaxConns applies a new connection limit and winds down
+// any connections in excess of it.
+func (g *governor) setMaxConns(n uint32) {
+	logInfo(g.id, "MaxConns changed from %d to %d.", g.cfg.MaxConns, n)
+	g.cfg.MaxConns = n
+	atomic.StoreUint32(&g.curMaxConns, n)
+	excess := g.excessConns()
+	if excess <= 0 {
+		return
+	}
+	if g.cfg.OnScale != nil {
+		prov := len(g.streamers) + len(g.launchers) - g.windingDown
+		g.cfg.OnScale(&ScaleEvent{
+			Time:      time.Now(),
+			From:      prov,
+			To:        prov - excess,
+			Direction: ScaleDown,
+			Reason:    ScaleReasonMaxConns,
+		})
+	}
+	g.windDown(excess)
+	g.markScaled(time.Now(), forWindDown)
+}
+
+func (g *governor) setMaxRate(r funit.Measure) {
+	logInfo(g.id, "MaxRate changed from %v to %v.", g.cfg.MaxRate, r)
+	g.cfg.MaxRate = r
+	atomic.StoreUint64(&g.curMaxRate, math.Float64bits(float64(r)))
+	if r > 0 && g.minSust > 0 {
+		if g.countAcc == nil {
+			g.countAcc = newMovingAcc(int(g.minSust))
+		}
+		g.maxCount = g.cfg.rateAsCount()
+	}
+}
+
+// markScaled records completion of up- or down-scaling.
+func (g *governor) markScaled(now time.Time, forScaleUp bool) {
+	g.lastScale = now
+	g.lastScaleUp = forScaleUp
+	g.c.settleTracker.enter(now, g.cfg.settlePeriod(forScaleUp))
+}
+
+// isSettling returns true if now falls within the settle period
+// following the last up- or down-scaling.
+func (g *governor) isSettling(now time.Time) bool {
+	return g.lastScale.Add(g.cfg.settlePeriod(g.lastScaleUp)).After(now)
 }
 
 func (g *governor) launchStreamer() {
 	wid := fmt.Sprintf(g.id+"-Streamer-%d", g.nextWId)
-	l := &launcher{gov: g, id: wid, done: g.lExits, ctl: make(chan struct{})}
+	l := &launcher{gov: g, id: wid, gateway: g.c.ActiveGateway(), done: g.lExits, ctl: make(chan struct{}), started: time.Now(), attempt: g.launchFailures + 1}
 	g.nextWId++
 	g.launchers[l] = l.ctl
+	g.emitStreamerEvent(wid, StreamerLaunching, "", nil)
 	go l.launch()
 }
 
@@ -385,40 +1228,117 @@ func (g *governor) allowedScaleDelta(forScaleUp bool) int {
 	}
 	now := time.Now()
 	switch {
-	case g.lastScale.Add(g.cfg.SettlePeriod).After(now):
+	case g.isSettling(now):
 		return 0
 	case g.backOffTracker.blackoutEnd().After(now):
 		return 0
 	}
-	prov := uint32(len(g.streamers) + len(g.launchers))
-	req := uint32(0)
-	if forScaleUp {
-		if prov >= g.cfg.MaxConns {
-			return 0
-		}
-		req = g.cfg.Scale.Apply(prov)
-	} else {
-		if prov <= g.cfg.MinConns {
-			return 0
+	prov := uint32(len(g.streamers) + len(g.launchers) - g.windingDown)
+	if forScaleUp && prov >= g.cfg.MaxConns {
+		return 0
+	}
+	if forScaleUp && g.c.ipSlots.isFull() {
+		return 0
+	}
+	floor := g.windDownFloor(now)
+	if !forScaleUp && prov <= floor {
+		return 0
+	}
+	res := int(g.cfg.scaleTarget(prov, forScaleUp)) - int(prov)
+	if !forScaleUp && res < int(floor)-int(prov) {
+		res = int(floor) - int(prov)
+	}
+	if forScaleUp && prov >= g.cfg.MinConns {
+		res = g.capByGoroutines(res)
+		res = g.dampenByDialFailures(res)
+	}
+	return res
+}
+
+// dampenByDialFailures limits scale-up delta to a single connection
+// while dial failure rate exceeds cfg.MaxDialFailureRate.
+func (g *governor) dampenByDialFailures(delta int) int {
+	if g.dialErrTracker == nil {
+		return delta
+	}
+	rate := g.dialErrTracker.rate()
+	damped := rate > g.cfg.MaxDialFailureRate
+	if damped != g.isDialDamped {
+		if damped {
+			logWarn(g.id, "Scaling up dampened by dial failure rate of %.0f%%.", float64(rate*100))
+		} else {
+			logInfo(g.id, "Dial failure rate back to %.0f%%, scaling up resumed.", float64(rate*100))
 		}
-		req = g.cfg.Scale.ApplyInverse(prov)
 	}
-	if req < g.cfg.MinConns {
-		req = g.cfg.MinConns
+	g.isDialDamped = damped
+	if damped && delta > 1 {
+		return 1
 	}
-	if req > g.cfg.MaxConns {
-		req = g.cfg.MaxConns
+	return delta
+}
+
+// releaseRetrySlot frees request's in-flight retry slot, if it holds one.
+func (g *governor) releaseRetrySlot(req *Request) {
+	if req.hasRetrySlot {
+		req.hasRetrySlot = false
+		<-g.retrySlots
 	}
-	return int(req) - int(prov)
+}
+
+// windDownFloor returns the number of connections below which
+// winding down does not go: WarmConns, unless there has been no traffic
+// for WarmIdlePeriod, or MinConns otherwise.
+func (g *governor) windDownFloor(now time.Time) uint32 {
+	warm := g.cfg.WarmConns
+	if warm > g.cfg.MaxConns {
+		warm = g.cfg.MaxConns
+	}
+	if warm <= g.cfg.MinConns || now.Sub(g.lastActive) >= g.cfg.warmIdlePeriod() {
+		return g.cfg.MinConns
+	}
+	return warm
+}
+
+// goroutines returns the number of goroutines counted
+// against cfg.MaxGoroutines.
+func (g *governor) goroutines() int {
+	return int(atomic.LoadInt32(&g.workerCnt) + atomic.LoadInt32(&g.fwdCnt))
+}
+
+// capByGoroutines limits scale-up delta to keep the number of goroutines
+// within cfg.MaxGoroutines.
+func (g *governor) capByGoroutines(delta int) int {
+	if g.cfg.MaxGoroutines <= 0 {
+		return delta
+	}
+	room := g.cfg.MaxGoroutines - len(g.streamers) - len(g.launchers) - int(atomic.LoadInt32(&g.fwdCnt))
+	capped := room < delta
+	if capped && !g.isGoroutineCapped {
+		logWarn(g.id, "Scaling up held back by MaxGoroutines of %d.", g.cfg.MaxGoroutines)
+	}
+	g.isGoroutineCapped = capped
+	if !capped {
+		return delta
+	}
+	if room < 0 {
+		return 0
+	}
+	return room
 }
 
 type launcher struct {
-	gov    *governor
-	id     string
-	done   chan<- *launcher
-	ctl    chan struct{}
-	err    error
-	worker *streamer
+	gov     *governor
+	id      string
+	gateway string
+	done    chan<- *launcher
+	ctl     chan struct{}
+	started time.Time
+	attempt uint32
+	err     error
+	worker  *streamer
+	// set if no connection was made because all addresses
+	// are at CommsCfg.MaxConnsPerIP, which is not a failure
+	capped bool
 }
 
 func (l *launcher) launch() {
@@ -426,55 +1346,122 @@ func (l *launcher) launch() {
 		id:        l.id,
 		c:         l.gov.c,
 		gov:       l.gov,
+		gateway:   l.gateway,
 		in:        l.gov.c.out,
 		out:       l.gov.c.Callback,
 		warmStart: true,
 		ctl:       make(chan struct{}),
 		done:      l.gov.wExits,
+		windDown:  make(chan struct{}),
+		exited:    make(chan struct{}),
+		draining:  make(chan struct{}),
+	}
+	if l.gov.c.dispatcher != nil {
+		w.feed = make(chan *Request)
+		w.in = w.feed
 	}
-	if l.err = w.start(nil); l.err == nil {
+	w.errTracker = newErrRateTracker(l.gov.cfg.ConnErrorWindow, l.gov.cfg.MaxConnErrorRate)
+	if l.gov.cfg.StartMode == StartGated {
+		w.gate = make(chan struct{})
+	}
+	if l.gov.c.ipSlots.isFull() {
+		l.capped = true
+	} else if err := w.start(nil); err == ErrConnsPerIPExceeded {
+		l.capped = true
+	} else if err != nil {
+		phase := LaunchPhaseSetup
+		if w.httpClient != nil {
+			phase = connectPhase(err)
+		}
+		l.err = &LaunchError{
+			Gateway: w.gateway,
+			Attempt: l.attempt,
+			Phase:   phase,
+			Elapsed: time.Since(l.started),
+			Err:     err,
+		}
+	}
+	if l.capped {
+		l.gov.emitStreamerEvent(l.id, StreamerExited, StreamerReasonConnsPerIP, nil)
+	} else if l.err == nil {
 		l.worker = w
+		l.gov.emitStreamerEvent(l.id, StreamerConnected, "", nil)
+	} else {
+		l.gov.emitStreamerEvent(l.id, StreamerExited, StreamerReasonLaunchFailed, l.err)
 	}
 	// read from ctl prevents blocking on done if the governor
 	// was commanded to terminate in the meantime
 	select {
 	case l.done <- l:
 	case <-l.ctl:
+		// The governor will never learn about our streamer,
+		// so we must terminate it ourselves.
+		if l.worker != nil {
+			close(l.worker.ctl)
+		}
 	}
 }
 
-// TODO Rework forwarder and streamers so that inbound channel can be closed
-// by the client to indicate end of input, while allowing any retry requests
-// to finish.
+// Retries are forwarded for as long as the governor is running. Client's
+// submitter keeps accepting them during soft shutdown until all pending
+// requests are done, so closing client's Queue does not cut retries short.
 func (g *governor) runRetryForwarder() {
-	if g.cfg.MaxRetries == 0 {
-		return
-	}
 	// Retry requests will be re-queued with the Client. We need to ensure
 	// that any blocking on the Client inbound channel is dealt with in a way
 	// that doesn't block our streamers.
 	// Rather than spinning goroutines for every retry send, we buffer
 	// the sends. 100 buffered forwarders with buffers of 500 requests each
 	// is more efficient than 50000 individual sender goroutines.
+	// The number of concurrent buffered forwarders is capped. Once the cap
+	// is reached, we stop reading retries, which back-pressures streamers.
 	var buf chan *Request
 	bufSize := 500
 	cnt := 0
-	// slight buffering on the inbound channel to improve performance
-	g.retry = make(chan *Request, 100)
+	maxFwds := g.cfg.MaxRetryForwarders
+	if maxFwds <= 0 {
+		maxFwds = DefaultMaxRetryForwarders
+	}
+	fwds := 0
+	fwdExits := make(chan struct{})
+	defer atomic.StoreInt32(&g.fwdCnt, 0)
 	logInfo(g.id+"-RetryForwarder", "Running.")
 	for done := false; !done; {
+		in := g.retry
+		if buf == nil && fwds >= maxFwds {
+			in = nil
+		}
 		select {
-		case req := <-g.retry:
-			if buf == nil || cnt >= bufSize {
-				if buf != nil {
-					// signal bufferedForwarder to return
-					close(buf)
-				}
+		case req := <-in:
+			if req.hasRetrySlot {
+				// Slot holders are the ones that free retry slots, so they
+				// must not queue up behind requests waiting for a slot.
+				// There are at most MaxInFlightRetries of them.
+				go g.reinject(req)
+				break
+			}
+			if buf == nil {
 				buf = make(chan *Request, bufSize)
-				go bufferedForwarder(buf, g.c, g.ctl)
+				fwds++
+				atomic.StoreInt32(&g.fwdCnt, int32(fwds))
+				go func(buf <-chan *Request) {
+					g.forwardRetries(buf)
+					select {
+					case fwdExits <- struct{}{}:
+					case <-g.ctl:
+					}
+				}(buf)
 				cnt = 0
 			}
 			buf <- req
+			cnt++
+			if cnt >= bufSize {
+				// signal bufferedForwarder to return once drained
+				close(buf)
+				buf = nil
+			}
+		case <-fwdExits:
+			fwds--
+			atomic.StoreInt32(&g.fwdCnt, int32(fwds))
 		case <-g.ctl:
 			done = true
 		}
@@ -482,7 +1469,10 @@ func (g *governor) runRetryForwarder() {
 	logInfo(g.id+"-RetryForwarder", "Stopped.")
 }
 
-func bufferedForwarder(in <-chan *Request, client *Client, ctl <-chan struct{}) {
+// forwardRetries resubmits requests from in to the client until in
+// is closed. Requests that cannot be resubmitted are dropped as specified
+// by cfg.RetryOverflow and cfg.RetryReinjectTimeout.
+func (g *governor) forwardRetries(in <-chan *Request) {
 	for done := false; !done; {
 		select {
 		case req, ok := <-in:
@@ -490,15 +1480,69 @@ func bufferedForwarder(in <-chan *Request, client *Client, ctl <-chan struct{})
 				done = true
 				break
 			}
+			done = !g.reinject(req)
+		case <-g.ctl:
+			done = true
+		}
+	}
+}
+
+// reinject hands req over to the client's submitter, first taking one
+// of cfg.MaxInFlightRetries slots for it if it is a retry. Waiting for
+// the slot and for the handover together is subject to cfg.RetryOverflow
+// and cfg.RetryReinjectTimeout. It returns false if the governor is stopped.
+func (g *governor) reinject(req *Request) bool {
+	needSlot := g.retrySlots != nil && req.attemptCnt > 0 && !req.hasRetrySlot
+	if g.cfg.RetryOverflow == RetryOverflowDrop {
+		if needSlot {
 			select {
-			case client.retry <- req:
-			case <-ctl:
-				done = true
+			case g.retrySlots <- struct{}{}:
+				req.hasRetrySlot = true
+			default:
+				g.dropRetry(req)
+				return true
 			}
-		case <-ctl:
-			done = true
+		}
+		select {
+		case g.c.retry <- req:
+		default:
+			g.dropRetry(req)
+		}
+		return true
+	}
+	var timeout <-chan time.Time
+	if d := g.cfg.RetryReinjectTimeout; d > 0 {
+		tmr := time.NewTimer(d)
+		defer tmr.Stop()
+		timeout = tmr.C
+	}
+	if needSlot {
+		select {
+		case g.retrySlots <- struct{}{}:
+			req.hasRetrySlot = true
+		case <-timeout:
+			g.dropRetry(req)
+			return true
+		case <-g.ctl:
+			return false
 		}
 	}
+	select {
+	case g.c.retry <- req:
+	case <-timeout:
+		g.dropRetry(req)
+	case <-g.ctl:
+		return false
+	}
+	return true
+}
+
+// dropRetry reports an accepted request that could not be reinjected
+// as failed with ErrRetryOverflow.
+func (g *governor) dropRetry(req *Request) {
+	logTrace(1, g.id+"-RetryForwarder", "Dropping retry: %v", ErrRetryOverflow)
+	g.releaseRetrySlot(req)
+	g.c.drop(req, ErrRetryOverflow)
 }
 
 type movingAcc struct {
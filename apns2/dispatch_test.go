@@ -3,8 +3,18 @@
 package apns2
 
 import (
+	"crypto/tls"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apns/scale"
+	"github.com/baobabus/go-apnsmock/apns2mock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,3 +58,672 @@ func TestMovingAcc(t *testing.T) {
 	assert.Equal(t, 1, s.pos)
 	assert.Equal(t, uint64(10), v)
 }
+
+func TestScaleTargets(t *testing.T) {
+	cfg := ProcCfg{
+		MinConns: 2,
+		MaxConns: 10,
+		Scale:    scale.Exponential(2),
+	}
+	up, down := cfg.ScaleTargets(0)
+	assert.Equal(t, uint32(2), up)
+	assert.Equal(t, uint32(2), down)
+	up, down = cfg.ScaleTargets(4)
+	assert.Equal(t, uint32(8), up)
+	assert.Equal(t, uint32(2), down)
+	up, down = cfg.ScaleTargets(8)
+	assert.Equal(t, uint32(10), up)
+	assert.Equal(t, uint32(4), down)
+	cfg.Scale = scale.Incremental(3)
+	up, down = cfg.ScaleTargets(6)
+	assert.Equal(t, uint32(9), up)
+	assert.Equal(t, uint32(3), down)
+	cfg.Scale = scale.Constant
+	up, down = cfg.ScaleTargets(6)
+	assert.Equal(t, uint32(6), up)
+	assert.Equal(t, uint32(6), down)
+}
+
+func TestSettlePeriod(t *testing.T) {
+	c := &ProcCfg{SettlePeriod: 5 * time.Second}
+	assert.Equal(t, 5*time.Second, c.settlePeriod(forScaleUp))
+	assert.Equal(t, 5*time.Second, c.settlePeriod(forWindDown))
+	c.ScaleUpSettlePeriod = 30 * time.Second
+	assert.Equal(t, 30*time.Second, c.settlePeriod(forScaleUp))
+	assert.Equal(t, 5*time.Second, c.settlePeriod(forWindDown))
+	c.ScaleDownSettlePeriod = time.Second
+	assert.Equal(t, time.Second, c.settlePeriod(forWindDown))
+}
+
+func TestEvalStall(t *testing.T) {
+	stalls := make(chan time.Duration, 10)
+	g := &governor{
+		id: "test",
+		cfg: ProcCfg{
+			PollInterval: 100 * time.Millisecond,
+			OnStall:      func(d time.Duration) { stalls <- d },
+		},
+		stallSust: 2,
+	}
+	g.inCtr.acc(1)
+	g.outCtr.acc(1)
+	g.evalStall()
+	assert.False(t, g.isStalled)
+	g.inCtr.acc(1)
+	g.outCtr.acc(1)
+	g.evalStall()
+	assert.True(t, g.isStalled)
+	assert.Equal(t, 200*time.Millisecond, <-stalls)
+	// no repeated notifications while stalled
+	g.inCtr.acc(1)
+	g.outCtr.acc(1)
+	g.evalStall()
+	assert.True(t, g.isStalled)
+	// outbound unblocked
+	g.inCtr.acc(1)
+	g.outCtr.acc(0)
+	g.evalStall()
+	assert.False(t, g.isStalled)
+	assert.Equal(t, 0, len(stalls))
+}
+
+func TestEvalHealth(t *testing.T) {
+	g := &governor{
+		id: "test",
+		c:  &Client{},
+		cfg: ProcCfg{
+			MinConns:            1,
+			MinConnsGracePeriod: time.Minute,
+		},
+		streamers: make(map[*streamer]chan struct{}),
+	}
+	now := time.Now()
+	g.evalHealth(now)
+	ok, err := g.c.Healthy()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	g.lastLaunchErr = errors.New("connection refused")
+	g.evalHealth(now.Add(30 * time.Second))
+	ok, _ = g.c.Healthy()
+	assert.True(t, ok)
+	g.evalHealth(now.Add(2 * time.Minute))
+	ok, err = g.c.Healthy()
+	assert.False(t, ok)
+	if assert.IsType(t, &DegradedError{}, err) {
+		assert.Equal(t, now, err.(*DegradedError).Since)
+		assert.Equal(t, g.lastLaunchErr, err.(*DegradedError).Err)
+	}
+	// recovered
+	g.streamers[&streamer{}] = nil
+	g.evalHealth(now.Add(3 * time.Minute))
+	ok, err = g.c.Healthy()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+}
+
+func TestSetMaxConns(t *testing.T) {
+	var events []*ScaleEvent
+	g := &governor{
+		id: "test",
+		c:  &Client{settleTracker: &settleTracker{}},
+		cfg: ProcCfg{
+			MinConns: 1,
+			MaxConns: 4,
+			OnScale:  func(e *ScaleEvent) { events = append(events, e) },
+		},
+		streamers: make(map[*streamer]chan struct{}),
+		launchers: make(map[*launcher]chan struct{}),
+	}
+	for i := 0; i < 3; i++ {
+		g.streamers[&streamer{windDown: make(chan struct{})}] = nil
+	}
+	// raising the limit does not affect active streamers
+	g.setMaxConns(8)
+	assert.Equal(t, uint32(8), g.cfg.MaxConns)
+	assert.Equal(t, 0, g.windingDown)
+	assert.Empty(t, events)
+	// lowering it winds down the excess
+	g.setMaxConns(1)
+	assert.Equal(t, 2, g.windingDown)
+	assert.Equal(t, 0, g.excessConns())
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, 3, events[0].From)
+		assert.Equal(t, 1, events[0].To)
+		assert.Equal(t, ScaleReasonMaxConns, events[0].Reason)
+	}
+	closed := 0
+	for w := range g.streamers {
+		select {
+		case <-w.windDown:
+			closed++
+		default:
+		}
+	}
+	assert.Equal(t, 2, closed)
+	// no scaling up at the limit
+	assert.Equal(t, 0, g.allowedScaleDelta(forScaleUp))
+}
+
+func TestMarkScaled(t *testing.T) {
+	g := &governor{
+		id: "test",
+		c:  &Client{settleTracker: &settleTracker{}},
+		cfg: ProcCfg{
+			ScaleUpSettlePeriod:   30 * time.Second,
+			ScaleDownSettlePeriod: time.Second,
+		},
+	}
+	now := time.Now()
+	g.markScaled(now, forWindDown)
+	assert.True(t, g.isSettling(now.Add(500*time.Millisecond)))
+	// settle period following a wind-down applies
+	assert.False(t, g.isSettling(now.Add(2*time.Second)))
+	_, total := g.c.settleTracker.totals(now.Add(2 * time.Second))
+	assert.Equal(t, time.Second, total)
+	g.markScaled(now, forScaleUp)
+	assert.True(t, g.isSettling(now.Add(2*time.Second)))
+	assert.False(t, g.isSettling(now.Add(31*time.Second)))
+}
+
+func TestSetMaxRate(t *testing.T) {
+	g := &governor{
+		id:      "test",
+		c:       &Client{},
+		cfg:     ProcCfg{MinSustain: 2 * time.Second, PollInterval: time.Second},
+		minSust: 2,
+	}
+	// enabling the cap starts rate tracking
+	g.setMaxRate(100 / funit.Second)
+	assert.NotNil(t, g.countAcc)
+	assert.Equal(t, uint64(200), g.maxCount)
+	assert.Equal(t, 100/funit.Second, funit.Measure(math.Float64frombits(atomic.LoadUint64(&g.curMaxRate))))
+	g.setMaxRate(10 / funit.Second)
+	assert.Equal(t, uint64(20), g.maxCount)
+	// removing it
+	g.setMaxRate(0)
+	assert.Equal(t, funit.Measure(0), g.cfg.MaxRate)
+}
+
+func TestCapByGoroutines(t *testing.T) {
+	g := &governor{
+		id:        "test",
+		c:         &Client{},
+		cfg:       ProcCfg{MinConns: 1, MaxConns: 100, Scale: scale.Incremental(4)},
+		streamers: make(map[*streamer]chan struct{}),
+		launchers: make(map[*launcher]chan struct{}),
+	}
+	for i := 0; i < 3; i++ {
+		g.streamers[&streamer{}] = nil
+	}
+	g.fwdCnt = 1
+	// no cap
+	assert.Equal(t, 4, g.capByGoroutines(4))
+	g.cfg.MaxGoroutines = 5
+	assert.Equal(t, 1, g.capByGoroutines(4))
+	assert.True(t, g.isGoroutineCapped)
+	assert.Equal(t, 1, g.allowedScaleDelta(forScaleUp))
+	g.fwdCnt = 3
+	assert.Equal(t, 0, g.capByGoroutines(4))
+	g.fwdCnt = 0
+	assert.Equal(t, 2, g.capByGoroutines(2))
+	assert.False(t, g.isGoroutineCapped)
+	// initial MinConns launches are not capped
+	g.streamers = make(map[*streamer]chan struct{})
+	g.cfg.MinConns = 10
+	g.cfg.MaxGoroutines = 2
+	assert.Equal(t, 10, g.allowedScaleDelta(forScaleUp))
+}
+
+func TestDampenByDialFailures(t *testing.T) {
+	g := &governor{
+		id:        "test",
+		c:         &Client{},
+		cfg:       ProcCfg{MinConns: 1, MaxConns: 100, Scale: scale.Incremental(4), MaxDialFailureRate: 0.5, DialFailureWindow: 4},
+		streamers: make(map[*streamer]chan struct{}),
+		launchers: make(map[*launcher]chan struct{}),
+	}
+	g.streamers[&streamer{}] = nil
+	// tracking disabled
+	assert.Equal(t, 4, g.dampenByDialFailures(4))
+	g.dialErrTracker = newErrRateTracker(g.cfg.DialFailureWindow, g.cfg.MaxDialFailureRate)
+	g.dialErrTracker.record(false)
+	g.dialErrTracker.record(true)
+	assert.Equal(t, 4, g.allowedScaleDelta(forScaleUp))
+	assert.False(t, g.isDialDamped)
+	g.dialErrTracker.record(true)
+	assert.Equal(t, 1, g.allowedScaleDelta(forScaleUp))
+	assert.True(t, g.isDialDamped)
+	// recovery
+	g.dialErrTracker.record(false)
+	g.dialErrTracker.record(false)
+	g.dialErrTracker.record(false)
+	assert.Equal(t, 4, g.allowedScaleDelta(forScaleUp))
+	assert.False(t, g.isDialDamped)
+	// initial MinConns launches are not dampened
+	for i := 0; i < 4; i++ {
+		g.dialErrTracker.record(true)
+	}
+	g.streamers = make(map[*streamer]chan struct{})
+	g.cfg.MinConns = 10
+	assert.Equal(t, 10, g.allowedScaleDelta(forScaleUp))
+}
+
+func TestEvalSaturation(t *testing.T) {
+	g := &governor{
+		id:        "test",
+		c:         &Client{},
+		cfg:       ProcCfg{MinConns: 1, MaxConns: 2},
+		minSust:   2,
+		streamers: map[*streamer]chan struct{}{&streamer{}: nil},
+	}
+	g.c.gov = g
+	isSaturated := func() bool {
+		g.evalSaturation()
+		return g.c.IsSaturated()
+	}
+	// blocking that scaling up can relieve
+	g.inCtr.acc(1)
+	g.inCtr.acc(1)
+	g.outCtr.acc(0)
+	g.outCtr.acc(0)
+	assert.False(t, isSaturated())
+	assert.Nil(t, g.c.CheckCapacity())
+	// at MaxConns
+	g.streamers[&streamer{}] = nil
+	assert.True(t, isSaturated())
+	if err, ok := g.c.CheckCapacity().(*SaturationError); assert.True(t, ok) {
+		assert.Equal(t, uint32(2), err.MaxConns)
+		assert.False(t, err.Since.IsZero())
+	}
+	assert.True(t, g.c.Stats().SaturatedAtMax)
+	// stalled
+	g.outCtr.acc(1)
+	assert.False(t, isSaturated())
+	assert.Nil(t, g.c.CheckCapacity())
+	g.outCtr.acc(0)
+	g.outCtr.acc(0)
+	// inbound no longer blocked
+	g.inCtr.acc(0)
+	assert.False(t, isSaturated())
+	// held back by rate limits
+	g.inCtr.acc(1)
+	g.inCtr.acc(1)
+	g.cfg.MaxConns = 10
+	assert.False(t, isSaturated())
+	g.isRateCapped = true
+	assert.True(t, isSaturated())
+	// saturated, but not at MaxConns
+	assert.Nil(t, g.c.CheckCapacity())
+}
+
+func TestScalingPressure(t *testing.T) {
+	g := &governor{minSust: 4}
+	assert.Equal(t, 0.0, g.scalingPressure())
+	// inbound blocking is offset by outbound blocking
+	g.inCtr.acc(1)
+	g.inCtr.acc(1)
+	g.outCtr.acc(1)
+	g.outCtr.acc(0)
+	assert.Equal(t, 0.25, g.scalingPressure())
+	g.inCtr.acc(1)
+	g.outCtr.acc(0)
+	assert.Equal(t, 0.5, g.scalingPressure())
+	// past the threshold
+	for i := 0; i < 4; i++ {
+		g.inCtr.acc(1)
+		g.outCtr.acc(0)
+	}
+	assert.Equal(t, 1.5, g.scalingPressure())
+	// no inbound blocking
+	g.inCtr.acc(0)
+	assert.Equal(t, -0.25, g.scalingPressure())
+	g.inCtr.acc(0)
+	g.inCtr.acc(0)
+	g.inCtr.acc(0)
+	assert.Equal(t, -1.0, g.scalingPressure())
+	g.minSust = 0
+	assert.Equal(t, 0.0, g.scalingPressure())
+}
+
+func TestOnSaturated(t *testing.T) {
+	got := make(chan *SaturationError, 2)
+	g := &governor{
+		id:        "test",
+		c:         &Client{},
+		cfg:       ProcCfg{MinConns: 1, MaxConns: 1, OnSaturated: func(err *SaturationError) { got <- err }},
+		minSust:   1,
+		streamers: map[*streamer]chan struct{}{&streamer{}: nil},
+	}
+	g.c.gov = g
+	g.inCtr.acc(1)
+	g.outCtr.acc(0)
+	g.evalSaturation()
+	g.evalSaturation()
+	select {
+	case err := <-got:
+		assert.Equal(t, uint32(1), err.MaxConns)
+	case <-time.After(time.Second):
+		t.Fatal("Should have called OnSaturated")
+	}
+	// only once per occurrence
+	select {
+	case <-got:
+		t.Fatal("Should not have called OnSaturated again")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMaxInFlightRetries(t *testing.T) {
+	ctl := make(chan struct{})
+	defer close(ctl)
+	g := &governor{
+		id:         "test",
+		c:          &Client{retry: make(chan *Request)},
+		ctl:        ctl,
+		retry:      make(chan *Request),
+		retrySlots: make(chan struct{}, 1),
+	}
+	go g.runRetryForwarder()
+	receive := func() *Request {
+		select {
+		case req := <-g.c.retry:
+			return req
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		}
+	}
+	// requests that are not retries do not take slots
+	g.retry <- &Request{}
+	if req := receive(); assert.NotNil(t, req) {
+		assert.False(t, req.hasRetrySlot)
+	}
+	r1 := &Request{attemptCnt: 1}
+	r2 := &Request{attemptCnt: 1}
+	g.retry <- r1
+	g.retry <- r2
+	assert.Equal(t, r1, receive())
+	assert.True(t, r1.hasRetrySlot)
+	assert.Nil(t, receive())
+	// retried again while holding the slot, it is not held up by r2
+	r1.attemptCnt++
+	g.retry <- r1
+	assert.Equal(t, r1, receive())
+	assert.True(t, r1.hasRetrySlot)
+	g.releaseRetrySlot(r1)
+	assert.False(t, r1.hasRetrySlot)
+	assert.Equal(t, r2, receive())
+	assert.True(t, r2.hasRetrySlot)
+	assert.Nil(t, receive())
+}
+
+func TestClient_MaxInFlightRetries(t *testing.T) {
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 3
+	c.ProcCfg.MaxInFlightRetries = 2
+	c.ProcCfg.RetryEval = func(*Response, error) bool { return true }
+	const n = 20
+	cb := make(chan *Result, n)
+	c.Callback = cb
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	for i := 0; i < n; i++ {
+		if err := c.Push(testNotif_Good, DefaultSigner, NoContext, DefaultCallback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-cb:
+			assert.False(t, r.IsAccepted())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %d of %d results delivered", i, n)
+		}
+	}
+	assert.Equal(t, 0, len(c.gov.retrySlots))
+}
+
+func TestRetryOverflow(t *testing.T) {
+	for _, cfg := range []ProcCfg{
+		{RetryOverflow: RetryOverflowDrop},
+		{RetryReinjectTimeout: 20 * time.Millisecond},
+	} {
+		ctl := make(chan struct{})
+		g := &governor{
+			id:    "test",
+			c:     &Client{retry: make(chan *Request), dropTracker: newDropTracker()},
+			ctl:   ctl,
+			cfg:   cfg,
+			retry: make(chan *Request),
+		}
+		go g.runRetryForwarder()
+		cb := make(chan *Result, 1)
+		g.c.pendingCnt = 1
+		g.retry <- &Request{Notification: testNotif_Good, Callback: cb, attemptCnt: 1, isAdmitted: true}
+		select {
+		case res := <-cb:
+			assert.Equal(t, ErrRetryOverflow, res.Err)
+		case <-time.After(time.Second):
+			t.Fatal("Should have dropped the retry")
+		}
+		assert.Equal(t, int64(0), atomic.LoadInt64(&g.c.pendingCnt))
+		assert.Equal(t, map[string]uint64{DropReasonRetryOverflow: 1}, g.c.dropTracker.counts())
+		close(ctl)
+	}
+	// blocking indefinitely by default
+	ctl := make(chan struct{})
+	defer close(ctl)
+	g := &governor{
+		id:    "test",
+		c:     &Client{retry: make(chan *Request)},
+		ctl:   ctl,
+		retry: make(chan *Request),
+	}
+	go g.runRetryForwarder()
+	req := &Request{attemptCnt: 1}
+	g.retry <- req
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, req, <-g.c.retry)
+}
+
+func TestRetrySlotOverflow(t *testing.T) {
+	for _, cfg := range []ProcCfg{
+		{RetryOverflow: RetryOverflowDrop},
+		{RetryReinjectTimeout: 20 * time.Millisecond},
+	} {
+		ctl := make(chan struct{})
+		g := &governor{
+			id:         "test",
+			c:          &Client{retry: make(chan *Request, 1), dropTracker: newDropTracker()},
+			ctl:        ctl,
+			cfg:        cfg,
+			retry:      make(chan *Request),
+			retrySlots: make(chan struct{}, 1),
+		}
+		// all slots are taken
+		g.retrySlots <- struct{}{}
+		go g.runRetryForwarder()
+		cb := make(chan *Result, 1)
+		g.c.pendingCnt = 1
+		g.retry <- &Request{Notification: testNotif_Good, Callback: cb, attemptCnt: 1, isAdmitted: true}
+		select {
+		case res := <-cb:
+			assert.Equal(t, ErrRetryOverflow, res.Err)
+		case <-time.After(time.Second):
+			t.Fatal("Should have dropped the retry")
+		}
+		assert.Len(t, g.c.retry, 0)
+		assert.Len(t, g.retrySlots, 1)
+		assert.Equal(t, map[string]uint64{DropReasonRetryOverflow: 1}, g.c.dropTracker.counts())
+		close(ctl)
+	}
+}
+
+func TestWarmConns(t *testing.T) {
+	g := &governor{
+		id:         "test",
+		c:          &Client{},
+		cfg:        ProcCfg{MinConns: 1, MaxConns: 10, WarmConns: 4, Scale: scale.Incremental(10)},
+		streamers:  make(map[*streamer]chan struct{}),
+		launchers:  make(map[*launcher]chan struct{}),
+		lastActive: time.Now(),
+	}
+	for i := 0; i < 8; i++ {
+		g.streamers[&streamer{}] = nil
+	}
+	// recently active, winding down stops at WarmConns
+	assert.Equal(t, -4, g.allowedScaleDelta(forWindDown))
+	g.windingDown = 4
+	assert.Equal(t, 0, g.allowedScaleDelta(forWindDown))
+	// idle for long enough
+	g.lastActive = time.Now().Add(-DefaultWarmIdlePeriod)
+	assert.Equal(t, -3, g.allowedScaleDelta(forWindDown))
+	g.cfg.WarmIdlePeriod = time.Hour
+	assert.Equal(t, 0, g.allowedScaleDelta(forWindDown))
+	// WarmConns does not exceed MaxConns
+	g.cfg.MaxConns = 2
+	assert.Equal(t, -2, g.allowedScaleDelta(forWindDown))
+	// not set
+	g.cfg.MaxConns = 10
+	g.cfg.WarmConns = 0
+	assert.Equal(t, -3, g.allowedScaleDelta(forWindDown))
+}
+
+func TestIsRetryExpired(t *testing.T) {
+	cfg := &ProcCfg{MaxRetryAge: time.Second}
+	now := time.Now()
+	req := &Request{firstQueued: now.Add(-2 * time.Second)}
+	// never attempted
+	assert.False(t, cfg.isRetryExpired(req, now))
+	req.attemptCnt = 1
+	assert.True(t, cfg.isRetryExpired(req, now))
+	assert.False(t, cfg.isRetryExpired(req, now.Add(-1500*time.Millisecond)))
+	// no limit
+	cfg.MaxRetryAge = 0
+	assert.False(t, cfg.isRetryExpired(req, now))
+}
+
+func TestRetryForwarderCap(t *testing.T) {
+	ctl := make(chan struct{})
+	defer close(ctl)
+	g := &governor{
+		id:    "test",
+		c:     &Client{retry: make(chan *Request)},
+		ctl:   ctl,
+		cfg:   ProcCfg{MaxRetryForwarders: 1},
+		retry: make(chan *Request),
+	}
+	go g.runRetryForwarder()
+	send := func() bool {
+		select {
+		case g.retry <- &Request{}:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+	// fill up the only allowed forwarder
+	for i := 0; i < 500; i++ {
+		if !send() {
+			t.Fatal("Should not have blocked")
+		}
+	}
+	assert.False(t, send())
+	// drain the forwarder
+	for i := 0; i < 500; i++ {
+		<-g.c.retry
+	}
+	assert.True(t, send())
+	<-g.c.retry
+}
+
+func TestClient_RetryForwardersSaturated(t *testing.T) {
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.CommsCfg.MaxConcurrentStreams = 10
+	c.ProcCfg.MinConns = 1
+	c.ProcCfg.MaxConns = 1
+	c.ProcCfg.MaxRetries = 2
+	c.ProcCfg.MaxRetryForwarders = 1
+	c.ProcCfg.RetryEval = func(*Response, error) bool { return true }
+	// Enough retries to fill up the only forwarder many times over.
+	const n = 2000
+	cb := make(chan *Result, n)
+	c.Callback = cb
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	go func() {
+		for i := 0; i < n; i++ {
+			if c.Push(testNotif_Good, DefaultSigner, NoContext, DefaultCallback) != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < n; i++ {
+		select {
+		case r := <-cb:
+			assert.False(t, r.IsAccepted())
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Only %d of %d results delivered", i, n)
+		}
+	}
+}
+
+type testTimeoutErr struct{}
+
+func (testTimeoutErr) Error() string   { return "timed out" }
+func (testTimeoutErr) Timeout() bool   { return true }
+func (testTimeoutErr) Temporary() bool { return true }
+
+func TestLaunchError(t *testing.T) {
+	assert.Equal(t, LaunchPhaseDial, connectPhase(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.Equal(t, LaunchPhaseDial, connectPhase(&net.DNSError{Err: "no such host"}))
+	assert.Equal(t, LaunchPhaseDial, connectPhase(&net.DNSError{Err: "timeout", IsTimeout: true}))
+	assert.Equal(t, LaunchPhaseConnect, connectPhase(testTimeoutErr{}))
+	assert.Equal(t, LaunchPhaseHandshake, connectPhase(errors.New("x509: certificate signed by unknown authority")))
+	// failed setup
+	c := &Client{RootCA: &tls.Certificate{Certificate: [][]byte{{1, 2, 3}}}}
+	g := &governor{id: "test", c: c}
+	done := make(chan *launcher, 1)
+	l := &launcher{gov: g, id: "test-Streamer-0", gateway: "https://primary", done: done, ctl: make(chan struct{}), started: time.Now(), attempt: 3}
+	l.launch()
+	assert.Equal(t, l, <-done)
+	assert.Nil(t, l.worker)
+	if err, ok := l.err.(*LaunchError); assert.True(t, ok) {
+		assert.Equal(t, "https://primary", err.Gateway)
+		assert.Equal(t, uint32(3), err.Attempt)
+		assert.Equal(t, LaunchPhaseSetup, err.Phase)
+		assert.NotNil(t, err.Err)
+		assert.Contains(t, err.Error(), "failed during setup")
+	}
+}
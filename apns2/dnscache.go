@@ -0,0 +1,127 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+type lookupFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+// dnsCache caches resolved addresses of host names for the duration
+// of its TTL. It is shared by all connections of a client. A nil
+// cache resolves every lookup with lookupIPAddr.
+type dnsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+	next    uint32 // rotation index for dial
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &dnsCache{ttl: ttl, entries: make(map[string]*dnsEntry)}
+}
+
+// lookupIPAddr returns cached addresses of host, resolving it anew
+// if there is no entry or the entry has expired. If the refresh fails,
+// expired addresses are returned as long as there are any.
+func (c *dnsCache) lookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if c == nil {
+		return lookupIPAddr(ctx, host)
+	}
+	now := time.Now()
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil && now.Before(e.expires) {
+		res := e.addrs
+		c.mu.Unlock()
+		return res, nil
+	}
+	c.mu.Unlock()
+	addrs, err := lookupIPAddr(ctx, host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil || len(addrs) == 0 {
+		if e := c.entries[host]; e != nil {
+			return e.addrs, nil
+		}
+		return addrs, err
+	}
+	if e := c.entries[host]; e != nil {
+		e.addrs, e.expires = addrs, now.Add(c.ttl)
+	} else {
+		c.entries[host] = &dnsEntry{addrs: addrs, expires: now.Add(c.ttl)}
+	}
+	return addrs, nil
+}
+
+// rotate returns addrs reordered to start from the next address
+// in rotation for host.
+func (c *dnsCache) rotate(host string, addrs []net.IPAddr) []net.IPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[host]
+	if e == nil || len(addrs) < 2 {
+		return addrs
+	}
+	i := int(e.next % uint32(len(addrs)))
+	e.next++
+	res := make([]net.IPAddr, 0, len(addrs))
+	res = append(res, addrs[i:]...)
+	return append(res, addrs[:i]...)
+}
+
+// dial resolves the host in addr through the cache and dials
+// the resolved addresses in turn, starting from the next one
+// in rotation, until a connection is made.
+func (c *dnsCache) dial(dial dialFunc, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return dial(network, addr, cfg)
+	}
+	ips, err := c.lookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	cfg = withServerName(cfg, host)
+	for _, ip := range c.rotate(host, ips) {
+		var conn net.Conn
+		conn, err = dial(network, net.JoinHostPort(ip.IP.String(), port), cfg)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return nil, err
+}
+
+// withServerName returns cfg with ServerName set to host unless
+// it is already set.
+func withServerName(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	return cfg
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSCache(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookups := 0
+	var fail bool
+	ip := "17.0.0.1"
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if fail {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+	}
+	assert.Nil(t, newDNSCache(0))
+	// nil cache resolves every time
+	var nc *dnsCache
+	nc.lookupIPAddr(context.Background(), "api.push.apple.com")
+	nc.lookupIPAddr(context.Background(), "api.push.apple.com")
+	assert.Equal(t, 2, lookups)
+
+	lookups = 0
+	c := newDNSCache(50 * time.Millisecond)
+	addrs, err := c.lookupIPAddr(context.Background(), "api.push.apple.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "17.0.0.1", addrs[0].IP.String())
+	c.lookupIPAddr(context.Background(), "api.push.apple.com")
+	assert.Equal(t, 1, lookups)
+	// expired entry is refreshed
+	ip = "17.0.0.2"
+	time.Sleep(60 * time.Millisecond)
+	addrs, err = c.lookupIPAddr(context.Background(), "api.push.apple.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "17.0.0.2", addrs[0].IP.String())
+	assert.Equal(t, 2, lookups)
+	// failed refresh keeps expired addresses
+	fail = true
+	time.Sleep(60 * time.Millisecond)
+	addrs, err = c.lookupIPAddr(context.Background(), "api.push.apple.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "17.0.0.2", addrs[0].IP.String())
+	// unknown host fails
+	_, err = c.lookupIPAddr(context.Background(), "example.com")
+	assert.NotNil(t, err)
+}
+
+func TestDNSCacheDial(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookups := 0
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		return []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}, {IP: net.ParseIP("17.0.0.2")}}, nil
+	}
+	var dialed []string
+	var serverNames []string
+	refused := ""
+	dial := func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		serverNames = append(serverNames, cfg.ServerName)
+		if addr == refused {
+			return nil, errors.New("connection refused")
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	c := newDNSCache(time.Minute)
+	// addresses are dialed in rotation
+	_, err := c.dial(dial, "tcp", "api.push.apple.com:443", nil)
+	assert.Nil(t, err)
+	_, err = c.dial(dial, "tcp", "api.push.apple.com:443", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"17.0.0.1:443", "17.0.0.2:443"}, dialed)
+	assert.Equal(t, []string{"api.push.apple.com", "api.push.apple.com"}, serverNames)
+	assert.Equal(t, 1, lookups)
+	// failed address is skipped
+	dialed = nil
+	refused = "17.0.0.1:443"
+	_, err = c.dial(dial, "tcp", "api.push.apple.com:443", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"17.0.0.1:443", "17.0.0.2:443"}, dialed)
+	// literal addresses are dialed as is
+	dialed = nil
+	_, err = c.dial(dial, "tcp", "127.0.0.1:8443", &tls.Config{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:8443"}, dialed)
+	assert.Equal(t, 1, lookups)
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"time"
+)
+
+// StreamerPhase is a stage in the lifecycle of a streamer,
+// which owns a single connection to APN service.
+type StreamerPhase uint
+
+const (
+	// StreamerLaunching is reported when a new streamer is being launched.
+	StreamerLaunching StreamerPhase = iota
+
+	// StreamerConnected is reported once streamer's connection
+	// has been established.
+	StreamerConnected
+
+	// StreamerProbing is reported when a connected streamer is held back
+	// from consuming push requests until confirmed by the governor.
+	// See StartGated.
+	StreamerProbing
+
+	// StreamerActive is reported when a streamer starts consuming
+	// push requests.
+	StreamerActive
+
+	// StreamerDraining is reported when a streamer stops consuming push
+	// requests and waits for its in-flight requests to complete.
+	StreamerDraining
+
+	// StreamerExited is reported when a streamer is done,
+	// or when it fails to launch.
+	StreamerExited
+)
+
+func (p StreamerPhase) String() string {
+	switch p {
+	case StreamerLaunching:
+		return "launching"
+	case StreamerConnected:
+		return "connected"
+	case StreamerProbing:
+		return "probing"
+	case StreamerActive:
+		return "active"
+	case StreamerDraining:
+		return "draining"
+	case StreamerExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// Reasons reported in StreamerEvent for draining and exited phases.
+const (
+	// StreamerReasonLaunchFailed is reported when a connection
+	// could not be established.
+	StreamerReasonLaunchFailed = "launch failed"
+
+	// StreamerReasonConnsPerIP is reported when no connection is made
+	// because all addresses of APN service host are at CommsCfg.MaxConnsPerIP.
+	StreamerReasonConnsPerIP = "conns per ip"
+
+	// StreamerReasonWoundDown is reported when the governor winds
+	// the streamer down.
+	StreamerReasonWoundDown = "wound down"
+
+	// StreamerReasonRecycled is reported when the connection is gracefully
+	// recycled, e.g. because its address is no longer resolved.
+	StreamerReasonRecycled = "recycled"
+
+	// StreamerReasonInputClosed is reported during client's soft shutdown.
+	StreamerReasonInputClosed = "input closed"
+
+	// StreamerReasonQuit is reported when the connection became unusable.
+	StreamerReasonQuit = "quit"
+
+	// StreamerReasonTerminated is reported during client's hard shutdown.
+	StreamerReasonTerminated = "terminated"
+)
+
+// StreamerEvent describes a single lifecycle transition of a streamer.
+type StreamerEvent struct {
+
+	// StreamerId is the streamer's identifier as used in logs.
+	StreamerId string
+
+	// Time at which the transition took place.
+	Time time.Time
+
+	// Phase the streamer entered.
+	Phase StreamerPhase
+
+	// Reason is set for StreamerDraining and StreamerExited phases.
+	Reason string
+
+	// Err is the launch error if the streamer failed to launch.
+	Err error
+
+	// Served is set for StreamerExited phase to the number of push requests
+	// the streamer's connection served over its lifetime.
+	Served uint64
+}
+
+// emitStreamerEvent hands the event over to client's StreamerEvents channel.
+// Events are dropped if the channel is not ready to receive them.
+func (g *governor) emitStreamerEvent(id string, phase StreamerPhase, reason string, err error) {
+	if g == nil || g.c == nil || g.c.StreamerEvents == nil {
+		return
+	}
+	g.sendStreamerEvent(&StreamerEvent{
+		StreamerId: id,
+		Time:       time.Now(),
+		Phase:      phase,
+		Reason:     reason,
+		Err:        err,
+	})
+}
+
+// emitStreamerExited reports an exited streamer along with
+// the number of push requests it served.
+func (g *governor) emitStreamerExited(id string, reason string, served uint64) {
+	if g == nil || g.c == nil || g.c.StreamerEvents == nil {
+		return
+	}
+	g.sendStreamerEvent(&StreamerEvent{
+		StreamerId: id,
+		Time:       time.Now(),
+		Phase:      StreamerExited,
+		Reason:     reason,
+		Served:     served,
+	})
+}
+
+func (g *governor) sendStreamerEvent(ev *StreamerEvent) {
+	select {
+	case g.c.StreamerEvents <- ev:
+	default:
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamerPhaseString(t *testing.T) {
+	assert.Equal(t, "launching", StreamerLaunching.String())
+	assert.Equal(t, "probing", StreamerProbing.String())
+	assert.Equal(t, "exited", StreamerExited.String())
+	assert.Equal(t, "unknown", StreamerPhase(100).String())
+}
+
+func TestEmitStreamerEvent(t *testing.T) {
+	// no channel
+	g := &governor{c: &Client{}}
+	g.emitStreamerEvent("test", StreamerActive, "", nil)
+	// full channel does not block
+	evs := make(chan *StreamerEvent, 1)
+	g.c.StreamerEvents = evs
+	g.emitStreamerEvent("test", StreamerDraining, StreamerReasonWoundDown, nil)
+	g.emitStreamerEvent("test", StreamerExited, StreamerReasonWoundDown, nil)
+	ev := <-evs
+	assert.Equal(t, "test", ev.StreamerId)
+	assert.Equal(t, StreamerDraining, ev.Phase)
+	assert.Equal(t, StreamerReasonWoundDown, ev.Reason)
+	assert.False(t, ev.Time.IsZero())
+	assert.Len(t, evs, 0)
+}
+
+func TestClient_StreamerEvents(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.StartMode = StartGated
+	evs := make(chan *StreamerEvent, 100)
+	c.StreamerEvents = evs
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	assert.Nil(t, c.Stop())
+	var phases []StreamerPhase
+	var last *StreamerEvent
+	for len(evs) > 0 {
+		last = <-evs
+		phases = append(phases, last.Phase)
+	}
+	assert.Equal(t, []StreamerPhase{
+		StreamerLaunching,
+		StreamerConnected,
+		StreamerProbing,
+		StreamerActive,
+		StreamerDraining,
+		StreamerExited,
+	}, phases)
+	if assert.NotNil(t, last) {
+		assert.Equal(t, StreamerReasonInputClosed, last.Reason)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Endpoint is an alternative APN service endpoint to fail over to.
+// See CommsCfg.FallbackEndpoints.
+type Endpoint struct {
+
+	// Host is the host name or IP address of the endpoint.
+	// If empty, the host of client's Gateway is used.
+	Host string
+
+	// Port is the port of the endpoint. If zero, the port
+	// of client's Gateway is used.
+	Port int
+}
+
+// gateway returns the gateway URL for the endpoint, derived from
+// the primary gateway URL.
+func (e Endpoint) gateway(primary string) (string, error) {
+	u, err := url.ParseRequestURI(primary)
+	if err != nil {
+		return "", err
+	}
+	host := e.Host
+	if host == "" {
+		host = u.Hostname()
+	}
+	port := strconv.Itoa(e.Port)
+	if e.Port == 0 {
+		if port, err = gatewayPort(primary); err != nil {
+			return "", err
+		}
+	}
+	u.Host = net.JoinHostPort(host, port)
+	return u.String(), nil
+}
+
+// Defaults for CommsCfg.FailoverAfter and CommsCfg.FailbackInterval.
+const (
+	DefaultFailoverAfter    = time.Minute
+	DefaultFailbackInterval = 10 * time.Minute
+)
+
+func (c *CommsCfg) failoverAfter() time.Duration {
+	if c.FailoverAfter > 0 {
+		return c.FailoverAfter
+	}
+	return DefaultFailoverAfter
+}
+
+func (c *CommsCfg) failbackInterval() time.Duration {
+	if c.FailbackInterval > 0 {
+		return c.FailbackInterval
+	}
+	return DefaultFailbackInterval
+}
+
+// gatewayList returns primary gateway URL followed by the URLs
+// of the fallback endpoints.
+func gatewayList(primary string, fallbacks []Endpoint) ([]string, error) {
+	res := []string{primary}
+	for _, e := range fallbacks {
+		gw, err := e.gateway(primary)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, gw)
+	}
+	return res, nil
+}
+
+// ActiveGateway returns the gateway URL new connections to APN service
+// are established with. It is client's Gateway unless the client has
+// failed over to one of CommsCfg.FallbackEndpoints.
+func (c *Client) ActiveGateway() string {
+	if len(c.gateways) == 0 {
+		return c.Gateway
+	}
+	return c.gateways[atomic.LoadInt32(&c.gatewayIdx)]
+}
+
+// evalFailover fails over to the next gateway if the active one has not
+// been able to sustain MinConns connections for longer than FailoverAfter.
+// Failing back to the primary gateway is periodically attempted while
+// the client is healthy on a fallback.
+func (g *governor) evalFailover(now time.Time) {
+	if len(g.c.gateways) < 2 {
+		return
+	}
+	cur := int(atomic.LoadInt32(&g.c.gatewayIdx))
+	if !g.belowMinSince.IsZero() {
+		since := g.belowMinSince
+		if g.lastSwitch.After(since) {
+			since = g.lastSwitch
+		}
+		if now.Sub(since) < g.c.CommsCfg.failoverAfter() || g.lastLaunchErr == nil {
+			return
+		}
+		g.isFailingBack = false
+		g.switchGateway((cur+1)%len(g.c.gateways), now)
+		logWarn(g.id, "Failed over to %s: %v", g.c.ActiveGateway(), g.lastLaunchErr)
+		g.restoreMinConns()
+		return
+	}
+	if cur == 0 || g.isFailingBack || now.Sub(g.lastSwitch) < g.c.CommsCfg.failbackInterval() {
+		return
+	}
+	// Probe the primary by recycling a single connection. The rest
+	// are recycled once the replacement is established.
+	logInfo(g.id, "Attempting to fail back to %s.", g.c.gateways[0])
+	g.fallbackIdx = cur
+	g.isFailingBack = true
+	g.switchGateway(0, now)
+	for w := range g.streamers {
+		if w.gateway != g.c.gateways[0] && !w.isWindingDown {
+			w.triggerRecycle()
+			break
+		}
+	}
+}
+
+// evalFailback completes or abandons a fail-back attempt
+// based on the outcome of a launch to the primary gateway.
+func (g *governor) evalFailback(l *launcher) {
+	if !g.isFailingBack || l.gateway != g.c.gateways[0] {
+		return
+	}
+	g.isFailingBack = false
+	if l.worker == nil {
+		g.switchGateway(g.fallbackIdx, time.Now())
+		logWarn(g.id, "Failed to fail back: %v", l.err)
+		g.restoreMinConns()
+		return
+	}
+	logInfo(g.id, "Failed back to %s.", g.c.gateways[0])
+	for w := range g.streamers {
+		if w.gateway != g.c.gateways[0] && !w.isWindingDown {
+			w.triggerRecycle()
+		}
+	}
+}
+
+func (g *governor) switchGateway(idx int, now time.Time) {
+	atomic.StoreInt32(&g.c.gatewayIdx, int32(idx))
+	g.lastSwitch = now
+	// Back-off accumulated against the previous endpoint does not apply.
+	g.backOffTracker.reset()
+}
+
+// restoreMinConns launches streamers to bring the number of connections
+// up to MinConns, bypassing settle periods.
+func (g *governor) restoreMinConns() {
+	prov := len(g.streamers) + len(g.launchers) - g.windingDown
+	n := g.c.budget.reserve(int(g.cfg.MinConns) - prov)
+	if n <= 0 {
+		return
+	}
+	if g.cfg.OnScale != nil {
+		g.cfg.OnScale(&ScaleEvent{
+			Time:      time.Now(),
+			From:      prov,
+			To:        prov + n,
+			Direction: ScaleUp,
+			Reason:    ScaleReasonFailover,
+		})
+	}
+	for i := 0; i < n; i++ {
+		g.launchStreamer()
+	}
+}
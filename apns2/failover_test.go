@@ -0,0 +1,125 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointGateway(t *testing.T) {
+	gw, err := Endpoint{Port: AlternativePort}.gateway(Gateway.Production)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.push.apple.com:2197", gw)
+	gw, err = Endpoint{Host: "apns.example.com"}.gateway(Gateway.Production)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://apns.example.com:443", gw)
+	gw, err = Endpoint{Host: "10.0.0.1", Port: 8443}.gateway("https://api.push.apple.com:2197")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1:8443", gw)
+	_, err = Endpoint{Port: AlternativePort}.gateway("api.push.apple.com")
+	assert.NotNil(t, err)
+}
+
+func TestGatewayList(t *testing.T) {
+	gws, err := gatewayList(Gateway.Production, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{Gateway.Production}, gws)
+	gws, err = gatewayList(Gateway.Production, []Endpoint{{Port: AlternativePort}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{Gateway.Production, "https://api.push.apple.com:2197"}, gws)
+	c := &Client{Gateway: "bad", CommsCfg: CommsCfg{FallbackEndpoints: []Endpoint{{Port: 1}}}}
+	assert.NotNil(t, c.Start(nil))
+}
+
+func TestFailback(t *testing.T) {
+	newGovernor := func() (*governor, *streamer, *streamer) {
+		c := &Client{
+			Gateway:  "https://primary",
+			gateways: []string{"https://primary", "https://fallback"},
+			CommsCfg: CommsCfg{FailbackInterval: time.Minute},
+		}
+		c.gatewayIdx = 1
+		w1 := &streamer{gateway: "https://fallback", recycle: make(chan struct{})}
+		w2 := &streamer{gateway: "https://fallback", recycle: make(chan struct{})}
+		g := &governor{
+			c:          c,
+			cfg:        ProcCfg{MinConns: 2},
+			streamers:  map[*streamer]chan struct{}{w1: nil, w2: nil},
+			lastSwitch: time.Now(),
+		}
+		return g, w1, w2
+	}
+	recycled := func(w *streamer) bool {
+		select {
+		case <-w.recycle:
+			return true
+		default:
+			return false
+		}
+	}
+	// not yet
+	g, w1, w2 := newGovernor()
+	g.evalFailover(time.Now())
+	assert.Equal(t, "https://fallback", g.c.ActiveGateway())
+	assert.False(t, g.isFailingBack)
+	// unsuccessful
+	now := time.Now().Add(2 * time.Minute)
+	g.evalFailover(now)
+	assert.Equal(t, "https://primary", g.c.ActiveGateway())
+	assert.True(t, g.isFailingBack)
+	assert.True(t, recycled(w1) != recycled(w2))
+	g.evalFailback(&launcher{gateway: "https://primary", err: errors.New("refused")})
+	assert.Equal(t, "https://fallback", g.c.ActiveGateway())
+	assert.False(t, g.isFailingBack)
+	g.evalFailover(time.Now().Add(time.Second))
+	assert.False(t, g.isFailingBack)
+	// successful
+	g, w1, w2 = newGovernor()
+	g.evalFailover(now)
+	assert.True(t, g.isFailingBack)
+	g.evalFailback(&launcher{gateway: "https://fallback", err: errors.New("refused")})
+	assert.True(t, g.isFailingBack)
+	g.evalFailback(&launcher{gateway: "https://primary", worker: &streamer{}})
+	assert.False(t, g.isFailingBack)
+	assert.Equal(t, "https://primary", g.c.ActiveGateway())
+	assert.True(t, recycled(w1))
+	assert.True(t, recycled(w2))
+}
+
+func TestFailover(t *testing.T) {
+	c := &Client{
+		Gateway:  "https://primary",
+		gateways: []string{"https://primary", "https://fallback1", "https://fallback2"},
+		CommsCfg: CommsCfg{FailoverAfter: time.Minute},
+	}
+	g := &governor{c: c, backOffTracker: backOffTracker{initial: time.Second}}
+	t0 := time.Now()
+	// healthy
+	g.evalFailover(t0)
+	assert.Equal(t, "https://primary", c.ActiveGateway())
+	// not for long enough
+	g.belowMinSince = t0
+	g.lastLaunchErr = errors.New("refused")
+	g.backOffTracker.update(g.lastLaunchErr)
+	g.evalFailover(t0.Add(30 * time.Second))
+	assert.Equal(t, "https://primary", c.ActiveGateway())
+	// endpoints are tried in order
+	g.evalFailover(t0.Add(time.Minute))
+	assert.Equal(t, "https://fallback1", c.ActiveGateway())
+	assert.True(t, g.backOffTracker.blackoutEnd().IsZero())
+	assert.Equal(t, time.Second, g.backOffTracker.initial)
+	g.evalFailover(t0.Add(90 * time.Second))
+	assert.Equal(t, "https://fallback1", c.ActiveGateway())
+	g.evalFailover(t0.Add(2 * time.Minute))
+	assert.Equal(t, "https://fallback2", c.ActiveGateway())
+	g.evalFailover(t0.Add(3 * time.Minute))
+	assert.Equal(t, "https://primary", c.ActiveGateway())
+	// no fallbacks
+	g = &governor{c: &Client{Gateway: "https://primary"}, belowMinSince: t0, lastLaunchErr: errors.New("refused")}
+	g.evalFailover(t0.Add(time.Hour))
+	assert.Equal(t, "https://primary", g.c.ActiveGateway())
+}
@@ -0,0 +1,315 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DegradedError indicates that the client has not been able to sustain
+// ProcCfg.MinConns connections to APN service for longer than
+// ProcCfg.MinConnsGracePeriod.
+type DegradedError struct {
+
+	// Since is the time at which the number of connections
+	// fell below MinConns.
+	Since time.Time
+
+	// Err is the most recent connection error.
+	Err error
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("apns2: unable to sustain minimum connections since %v: %v", e.Since.Format(time.RFC3339), e.Err)
+}
+
+// SaturationError indicates that the client is saturated at MaxConns:
+// inbound blocking has been sustained for ProcCfg.MinSustain while
+// all of the allowed connections are in use. Demand exceeds capacity,
+// and MaxConns needs to be raised or load shed to avoid accumulating
+// latency.
+type SaturationError struct {
+
+	// MaxConns is the connection limit in effect.
+	MaxConns uint32
+
+	// Since is the time at which saturation at MaxConns was detected.
+	Since time.Time
+}
+
+func (e *SaturationError) Error() string {
+	return fmt.Sprintf("apns2: saturated at MaxConns of %d since %v", e.MaxConns, e.Since.Format(time.RFC3339))
+}
+
+// Phases of establishing a connection to APN service reported in LaunchError.
+const (
+	// LaunchPhaseSetup is the construction of HTTP client
+	// from client's configuration.
+	LaunchPhaseSetup = "setup"
+
+	// LaunchPhaseDial covers host resolution and TCP connection.
+	LaunchPhaseDial = "dial"
+
+	// LaunchPhaseHandshake covers TLS handshake and certificate verification.
+	LaunchPhaseHandshake = "TLS handshake"
+
+	// LaunchPhaseConnect is reported when the failure cannot be attributed
+	// to either dialing or TLS handshake, such as when DialTimeout
+	// is exceeded.
+	LaunchPhaseConnect = "connect"
+)
+
+// LaunchError is reported when a new connection to APN service
+// cannot be established. It is logged, delivered in StreamerEvent.Err
+// and carried by DegradedError.
+type LaunchError struct {
+
+	// Gateway is the APN service endpoint being connected to.
+	Gateway string
+
+	// Attempt is the number of consecutive failed attempts
+	// including this one.
+	Attempt uint32
+
+	// Phase is one of LaunchPhase... constants.
+	Phase string
+
+	// Elapsed is the time spent on the attempt.
+	Elapsed time.Duration
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *LaunchError) Error() string {
+	return fmt.Sprintf("apns2: connecting to %s failed during %s after %v (attempt %d): %v", e.Gateway, e.Phase, e.Elapsed, e.Attempt, e.Err)
+}
+
+// connectPhase attributes a connection error to the phase
+// it most likely occurred in.
+func connectPhase(err error) string {
+	switch e := err.(type) {
+	case *net.DNSError:
+		return LaunchPhaseDial
+	case *net.OpError:
+		if e.Op == "dial" {
+			return LaunchPhaseDial
+		}
+	case net.Error:
+		if e.Timeout() {
+			return LaunchPhaseConnect
+		}
+	}
+	return LaunchPhaseHandshake
+}
+
+// AuthError indicates that APN service rejects client's credentials.
+// Requests rejected for authentication reasons are not retried, other than
+// once after provider token refresh.
+type AuthError struct {
+
+	// StatusCode is the HTTP status code returned by APN service.
+	StatusCode int
+
+	// Reason is the rejection reason returned by APN service.
+	Reason string
+
+	// ClockSkewSuspected is true if a provider token was rejected
+	// immediately after having been generated, which suggests that
+	// local clock is skewed.
+	ClockSkewSuspected bool
+}
+
+func (e *AuthError) Error() string {
+	if e.ClockSkewSuspected {
+		return fmt.Sprintf("apns2: authentication rejected with status %d: %s (possible clock skew)", e.StatusCode, e.Reason)
+	}
+	return fmt.Sprintf("apns2: authentication rejected with status %d: %s", e.StatusCode, e.Reason)
+}
+
+// Healthy returns true if the client is able to sustain the minimum
+// required number of connections to APN service and its credentials are
+// accepted by APN service. If it is not, the returned error is either
+// a *DegradedError carrying the persistent connection error, or an *AuthError
+// describing the most recent authentication failure. Connection health
+// is only evaluated if ProcCfg.MinConnsGracePeriod is set.
+func (c *Client) Healthy() (bool, error) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if c.degraded != nil {
+		return false, c.degraded
+	}
+	if c.authErr != nil {
+		return false, c.authErr
+	}
+	return true, nil
+}
+
+// setAuthFailure records or, if err is nil, clears authentication failure.
+func (c *Client) setAuthFailure(err *AuthError) {
+	if err == nil && atomic.LoadInt32(&c.hasAuthErr) == 0 {
+		// fast path for the common case
+		return
+	}
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if err != nil && c.authErr == nil {
+		logWarn(c.Id, "%v", err)
+	} else if err == nil && c.authErr != nil {
+		logInfo(c.Id, "Authentication recovered.")
+	}
+	c.authErr = err
+	if err != nil {
+		atomic.StoreInt32(&c.hasAuthErr, 1)
+	} else {
+		atomic.StoreInt32(&c.hasAuthErr, 0)
+	}
+}
+
+// setDegraded updates client's degraded state. It returns true
+// if the client transitioned between healthy and degraded.
+func (c *Client) setDegraded(err *DegradedError) bool {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	changed := (c.degraded == nil) != (err == nil)
+	c.degraded = err
+	return changed
+}
+
+// CheckCapacity returns a *SaturationError if the client is saturated
+// at MaxConns, and nil otherwise. See ProcCfg.OnSaturated.
+func (c *Client) CheckCapacity() error {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if c.saturation != nil {
+		return c.saturation
+	}
+	return nil
+}
+
+// setSaturation records or, if err is nil, clears saturation at MaxConns.
+// It returns true if the client transitioned into or out of saturation.
+func (c *Client) setSaturation(err *SaturationError) bool {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	changed := (c.saturation == nil) != (err == nil)
+	c.saturation = err
+	return changed
+}
+
+// ProbeRequest describes a request sent to APN service solely to verify
+// connectivity and credentials. It should be side-effect free, i.e.
+// rejected by APN service quickly and without delivering anything.
+// See CommsCfg.ProbeRequest.
+type ProbeRequest struct {
+
+	// Notification is posted as is, except that it is signed with client's
+	// signer, if any. Recipient may be left empty.
+	Notification *Notification
+
+	// IsExpected, if not nil, reports whether resp is the expected
+	// response to the probe. If nil, any rejection with a 4xx status
+	// other than 429 is expected. Authentication failures are never
+	// expected.
+	IsExpected func(resp *Response) bool
+}
+
+// DefaultProbeRequest posts an empty payload with no device token,
+// which APN service rejects as such.
+var DefaultProbeRequest = ProbeRequest{
+	Notification: &Notification{Header: &Header{}, Payload: []byte("{}")},
+}
+
+// isExpected reports whether resp is the expected response to the probe.
+func (p *ProbeRequest) isExpected(resp *Response) bool {
+	if p.IsExpected != nil {
+		return p.IsExpected(resp)
+	}
+	return resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests
+}
+
+// PingError indicates that APN service responded to a connectivity probe
+// in an unexpected way.
+type PingError struct {
+
+	// StatusCode is the HTTP status code returned by APN service.
+	StatusCode int
+
+	// Reason is the rejection reason returned by APN service.
+	Reason string
+}
+
+func (e *PingError) Error() string {
+	return fmt.Sprintf("apns2: ping failed with status %d: %s", e.StatusCode, e.Reason)
+}
+
+// Ping verifies connectivity to APN service without delivering
+// a notification. It establishes a new connection, independent of
+// client's processing pipeline, and submits CommsCfg.ProbeRequest,
+// by default a signed request with no device token, which APN service
+// is expected to reject as such. Ping returns nil if the connection
+// is established and the response is as expected by the probe.
+// Authentication failures are reported as *AuthError and other
+// unexpected responses as *PingError.
+//
+// The client does not need to be started for Ping to work.
+func (c *Client) Ping(ctx context.Context) error {
+	gateway := c.ActiveGateway()
+	hc, err := NewHTTPClient(gateway, c.CommsCfg, c.Certificate, c.RootCA)
+	if err != nil {
+		return err
+	}
+	defer hc.Close()
+	return c.probe(ctx, hc, gateway)
+}
+
+// probe submits CommsCfg.ProbeRequest to gateway over hc and evaluates
+// the response.
+func (c *Client) probe(ctx context.Context, hc *HTTPClient, gateway string) error {
+	p := c.CommsCfg.probeRequest()
+	n := p.Notification
+	if n.Header == nil {
+		m := *n
+		m.Header = &Header{}
+		n = &m
+	}
+	httpReq, err := http.NewRequest("POST", gateway+n.path(), nil)
+	if err != nil {
+		return err
+	}
+	if err := n.write(httpReq, c.PayloadEncoder); err != nil {
+		return err
+	}
+	if c.Signer != nil {
+		if err := c.Signer.SignRequest(httpReq); err != nil {
+			return err
+		}
+	}
+	if ctx != NoContext {
+		httpReq = httpReq.WithContext(ctx)
+	}
+	httpResp, err := hc.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	resp := &Response{StatusCode: httpResp.StatusCode}
+	lr := io.LimitReader(httpResp.Body, c.CommsCfg.maxResponseBodySize())
+	if err := json.NewDecoder(lr).Decode(resp); err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case resp.Class() == ReasonClassAuth:
+		return &AuthError{StatusCode: resp.StatusCode, Reason: resp.RejectionReason}
+	case p.isExpected(resp):
+		return nil
+	}
+	return &PingError{StatusCode: resp.StatusCode, Reason: resp.RejectionReason}
+}
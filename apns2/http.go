@@ -40,6 +40,10 @@ type HTTPClient struct {
 	precise bool
 	pollInt time.Duration
 	cfgCap  uint32
+	rampDur time.Duration
+	// maximum number of reserved streams regardless of stream capacity,
+	// 0 if not limited
+	depth uint32
 
 	mu       sync.Mutex
 	cond     *sync.Cond
@@ -49,6 +53,27 @@ type HTTPClient struct {
 	cnt      uint32
 	closed   bool
 
+	// address and time of the most recently dialed connection; guarded
+	// by their own mutex as dialing may happen while mu is held
+	addrMu   sync.Mutex
+	connIP   net.IP
+	connTime time.Time
+
+	// per-address connection limiter shared with other client's
+	// connections, nil if not limited; must be set before the first dial
+	ipSlots *ipSlots
+
+	// resolved address cache shared with other client's connections,
+	// nil if not caching; must be set before the first dial
+	dns *dnsCache
+
+	// handler of GOAWAY frames received on the connection, nil if not
+	// watched; must be set before the first dial
+	onGoAway http2x.FrameHandler
+
+	// start of concurrent streams ramp-up
+	rampStart time.Time
+
 	tkr *time.Ticker
 	ctl chan struct{}
 
@@ -58,11 +83,13 @@ type HTTPClient struct {
 // NewHTTPClient creates a new HTTPClient for handling HTTP requests
 // to a single specified gateway.
 // TLS client certificate cCert and custom root certificate authority rootCA
-// certificate are optional and can be nil.
+// certificate are optional and can be nil. If commsCfg specifies RootCAs,
+// rootCA is ignored.
 func NewHTTPClient(gateway string, commsCfg CommsCfg, cCert *tls.Certificate, rootCA *tls.Certificate) (*HTTPClient, error) {
 	t := &http2.Transport{
-		DialTLS:            makeDialer(commsCfg),
 		DisableCompression: true, // As per Apple spec
+		ReadIdleTimeout:    commsCfg.HTTP2PingInterval,
+		PingTimeout:        commsCfg.HTTP2PingTimeout,
 	}
 	tlsConfig := t.TLSClientConfig
 	if cCert != nil {
@@ -86,6 +113,18 @@ func NewHTTPClient(gateway string, commsCfg CommsCfg, cCert *tls.Certificate, ro
 		certpool.AddCert(rCert)
 		tlsConfig.RootCAs = certpool
 	}
+	if commsCfg.RootCAs != nil {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.RootCAs = commsCfg.RootCAs
+	}
+	if len(commsCfg.PinnedKeys) > 0 {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.VerifyPeerCertificate = makePinVerifier(commsCfg.PinnedKeys)
+	}
 	t.TLSClientConfig = tlsConfig
 	url, _ := url.ParseRequestURI(gateway)
 	res := &HTTPClient{
@@ -97,6 +136,30 @@ func NewHTTPClient(gateway string, commsCfg CommsCfg, cCert *tls.Certificate, ro
 		precise: false,
 		pollInt: 0,
 		cfgCap:  1,
+		rampDur: commsCfg.StreamRampUp,
+		depth:   commsCfg.PipelineDepth,
+	}
+	dial := makeDialer(commsCfg)
+	t.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		if res.ipSlots != nil {
+			conn, err = res.ipSlots.dial(res.dns.lookupIPAddr, dial, network, addr, cfg, commsCfg.DialTimeout)
+		} else if res.dns != nil {
+			conn, err = res.dns.dial(dial, network, addr, cfg)
+		} else {
+			conn, err = dial(network, addr, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.setConnAddr(conn.RemoteAddr())
+		conn = http2x.WithGoAwayHandler(conn, res.onGoAway)
+		conn = http2x.WithFrameTracer(conn, commsCfg.FrameTracer)
+		if v := commsCfg.AdvertisedMaxConcurrentStreams; v > 0 {
+			conn = http2x.WithSettings(conn, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: v})
+		}
+		return conn, nil
 	}
 	return res, nil
 }
@@ -108,6 +171,10 @@ func (c *HTTPClient) init() {
 		c.connPool, _ = http2x.GetClientConnPool(c.Client.Transport)
 		c.refreshCap()
 	}
+	if c.rampDur > 0 {
+		c.rampStart = time.Now()
+		go c.runRampUp()
+	}
 	if c.connPool != nil && c.pollInt > 0 {
 		c.tkr = time.NewTicker(c.pollInt)
 		c.ctl = make(chan struct{})
@@ -132,6 +199,25 @@ func (c *HTTPClient) getClientConn() (*http2.ClientConn, error) {
 	return http2x.GetClientConn(c.connPool, c.addr)
 }
 
+// flowWindows returns a snapshot of flow-control windows of client's
+// HTTP/2 connection, or nil if HTTP/2 incursion is disabled, no connection
+// has been established, or the windows cannot be determined.
+func (c *HTTPClient) flowWindows() *http2x.FlowWindows {
+	if _, since := c.connState(); since.IsZero() {
+		// Avoid dialing.
+		return nil
+	}
+	conn, err := c.getClientConn()
+	if conn == nil || err != nil {
+		return nil
+	}
+	res, err := http2x.GetFlowWindows(conn)
+	if err != nil {
+		return nil
+	}
+	return &res
+}
+
 // ReservedStream returns a reserved HTTP2Stream in the client's
 // HTTP/2 connection, or a non-nil error.
 func (c *HTTPClient) ReservedStream(cancel func(<-chan struct{}) error) (*HTTP2Stream, error) {
@@ -142,7 +228,7 @@ func (c *HTTPClient) ReservedStream(cancel func(<-chan struct{}) error) (*HTTP2S
 		c.refreshCapLocked()
 	}
 	var cerr error
-	for cnlLaunched := false; c.effCap > 0 && c.cnt >= c.effCap && cerr == nil; {
+	for cnlLaunched := false; c.isAtCapLocked(time.Now()) && cerr == nil; {
 		if !cnlLaunched && cancel != nil {
 			done := make(chan struct{})
 			defer close(done)
@@ -196,12 +282,42 @@ func (c *HTTPClient) Close() error {
 	return nil
 }
 
+func (c *HTTPClient) setConnAddr(addr net.Addr) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	c.addrMu.Lock()
+	c.connIP = tcpAddr.IP
+	c.connTime = time.Now()
+	c.addrMu.Unlock()
+}
+
+// connAddr returns the IP address of the most recently dialed connection,
+// or nil if no connection has been established yet.
+func (c *HTTPClient) connAddr() net.IP {
+	c.addrMu.Lock()
+	defer c.addrMu.Unlock()
+	return c.connIP
+}
+
+// connState returns the number of reserved streams and the time
+// the most recent connection was established.
+func (c *HTTPClient) connState() (inFlight uint32, since time.Time) {
+	c.mu.Lock()
+	inFlight = c.cnt
+	c.mu.Unlock()
+	c.addrMu.Lock()
+	defer c.addrMu.Unlock()
+	return inFlight, c.connTime
+}
+
 func (c *HTTPClient) release() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.cnt > 0 {
 		c.cnt--
-		if c.cnt < c.effCap {
+		if c.cnt < c.effCap || c.cnt < c.depth {
 			c.cond.Broadcast()
 		}
 	}
@@ -234,6 +350,54 @@ func (c *HTTPClient) refreshCapLocked() {
 	}
 }
 
+// rampStepCnt is the number of steps in which concurrent streams ramp-up
+// is carried out.
+const rampStepCnt = 10
+
+// rampedCapLocked returns effective stream capacity further limited
+// by the ramp-up in progress, if any. It never returns less than 1
+// unless effective capacity is 0.
+func (c *HTTPClient) rampedCapLocked(now time.Time) uint32 {
+	if c.rampDur <= 0 || c.effCap <= 1 {
+		return c.effCap
+	}
+	elapsed := now.Sub(c.rampStart)
+	if elapsed >= c.rampDur {
+		return c.effCap
+	}
+	res := 1 + uint32(int64(c.cfgCap-1)*int64(elapsed)/int64(c.rampDur))
+	if res > c.effCap {
+		res = c.effCap
+	}
+	return res
+}
+
+// isAtCapLocked returns true if no more streams can be reserved
+// until some are released.
+func (c *HTTPClient) isAtCapLocked(now time.Time) bool {
+	if c.depth > 0 && c.cnt >= c.depth {
+		return true
+	}
+	return c.effCap > 0 && c.cnt >= c.rampedCapLocked(now)
+}
+
+// runRampUp wakes up any stream reservation waiters as stream capacity
+// is being ramped up.
+func (c *HTTPClient) runRampUp() {
+	tkr := time.NewTicker(c.rampDur / rampStepCnt)
+	defer tkr.Stop()
+	for i := 0; i < rampStepCnt; i++ {
+		<-tkr.C
+		c.mu.Lock()
+		closed := c.closed
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+}
+
 // HTTP2Stream is a token indicating a stream reservation in one
 // of the HTTPClient's HTTP/2 connections.
 type HTTP2Stream struct {
@@ -3,9 +3,14 @@
 package apns2
 
 import (
+	"net"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/baobabus/go-apns/http2x"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
 )
 
 func TestGetClientConnNoHTTP2Incursion(t *testing.T) {
@@ -35,6 +40,26 @@ func TestGetClientConn(t *testing.T) {
 	}
 }
 
+func TestSetConnAddrWhileLocked(t *testing.T) {
+	c := &HTTPClient{}
+	// Dialing may happen while capacity is being refreshed under mu.
+	c.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		c.setConnAddr(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Should not have blocked")
+	}
+	c.mu.Unlock()
+	assert.True(t, net.IPv4(10, 0, 0, 1).Equal(c.connAddr()))
+	_, since := c.connState()
+	assert.False(t, since.IsZero())
+}
+
 func TestReservedStreamNoContention(t *testing.T) {
 	s := mustNewMockServer(t)
 	defer s.Close()
@@ -47,3 +72,125 @@ func TestReservedStreamNoContention(t *testing.T) {
 	st.Close()
 	assert.Equal(t, uint32(0), c.cnt)
 }
+
+func TestPipelineDepth(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	cfg := CommsFast
+	cfg.PipelineDepth = 2
+	c, err := NewHTTPClient(s.URL, cfg, nil, s.RootCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.initOnce.Do(c.init)
+	c.effCap = 10
+	st1, _ := c.ReservedStream(nil)
+	c.ReservedStream(nil)
+	reserved := make(chan struct{})
+	go func() {
+		c.ReservedStream(nil)
+		close(reserved)
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("Should have waited for a release")
+	case <-time.After(20 * time.Millisecond):
+	}
+	st1.Close()
+	select {
+	case <-reserved:
+	case <-time.After(time.Second):
+		t.Fatal("Should have reserved after a release")
+	}
+	// not limited by stream capacity
+	c.mu.Lock()
+	c.effCap = 0
+	c.mu.Unlock()
+	assert.True(t, c.isAtCapLocked(time.Now()))
+	c.depth = 0
+	assert.False(t, c.isAtCapLocked(time.Now()))
+}
+
+func TestRampedCap(t *testing.T) {
+	c := &HTTPClient{cfgCap: 101, effCap: 101}
+	now := time.Now()
+	assert.Equal(t, uint32(101), c.rampedCapLocked(now))
+	c.rampDur = 10 * time.Second
+	c.rampStart = now
+	assert.Equal(t, uint32(1), c.rampedCapLocked(now))
+	assert.Equal(t, uint32(51), c.rampedCapLocked(now.Add(5*time.Second)))
+	assert.Equal(t, uint32(101), c.rampedCapLocked(now.Add(10*time.Second)))
+	c.effCap = 20
+	assert.Equal(t, uint32(20), c.rampedCapLocked(now.Add(5*time.Second)))
+	c.effCap = 0
+	assert.Equal(t, uint32(0), c.rampedCapLocked(now.Add(5*time.Second)))
+}
+
+func TestAdvertisedMaxConcurrentStreams(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	cfg := CommsFast
+	cfg.AdvertisedMaxConcurrentStreams = 1
+	c, err := NewHTTPClient(s.URL, cfg, nil, s.RootCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp, err := c.Get(s.URL + "/3/device/00fc13adff785122b4ad28809a3420982341241421348097878e577c991de8f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestHTTP2Ping(t *testing.T) {
+	cfg := commsTest_Fast
+	cfg.HTTP2PingInterval = 30 * time.Second
+	cfg.HTTP2PingTimeout = 5 * time.Second
+	c, err := NewHTTPClient("https://localhost", cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := c.Transport.(*http2.Transport)
+	assert.Equal(t, 30*time.Second, tr.ReadIdleTimeout)
+	assert.Equal(t, 5*time.Second, tr.PingTimeout)
+}
+
+func TestFrameTracer(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	cfg := CommsFast
+	cfg.AdvertisedMaxConcurrentStreams = 1
+	var mu sync.Mutex
+	var settings *http2x.FrameEvent
+	headers := 0
+	cfg.FrameTracer = func(ev *http2x.FrameEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case ev.Outbound && ev.Type == http2.FrameSettings && settings == nil:
+			settings = ev
+		case !ev.Outbound && ev.Type == http2.FrameHeaders:
+			headers++
+		}
+	}
+	c, err := NewHTTPClient(s.URL, cfg, nil, s.RootCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	resp, err := c.Get(s.URL + "/3/device/00fc13adff785122b4ad28809a3420982341241421348097878e577c991de8f0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, headers)
+	// injected settings are traced
+	if assert.NotNil(t, settings) {
+		assert.Contains(t, settings.Settings, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 1})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrConnsPerIPExceeded is returned when a connection cannot be established
+// because every resolved address of APN service host already has
+// CommsCfg.MaxConnsPerIP connections.
+var ErrConnsPerIPExceeded = errors.New("apns2: all APN service addresses are at MaxConnsPerIP")
+
+type dialFunc func(network, addr string, cfg *tls.Config) (net.Conn, error)
+
+// ipSlots tracks the number of connections per remote IP address
+// and spreads new connections across the resolved addresses.
+type ipSlots struct {
+	max uint32
+
+	mu  sync.Mutex
+	cnt map[string]uint32
+	// most recently resolved addresses
+	last []net.IPAddr
+}
+
+func newIPSlots(max uint32) *ipSlots {
+	if max == 0 {
+		return nil
+	}
+	return &ipSlots{max: max, cnt: make(map[string]uint32)}
+}
+
+// acquire takes a slot on the least used of ips that is below the cap.
+// It returns nil if all ips are at the cap.
+func (l *ipSlots) acquire(ips []net.IPAddr) net.IP {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var res net.IP
+	var min uint32
+	for _, a := range ips {
+		n := l.cnt[a.IP.String()]
+		if n < l.max && (res == nil || n < min) {
+			res, min = a.IP, n
+		}
+	}
+	if res != nil {
+		l.cnt[res.String()]++
+	}
+	return res
+}
+
+// isFull returns true if all of the most recently resolved addresses
+// are at the cap. Nil ipSlots is never full.
+func (l *ipSlots) isFull() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.last) == 0 {
+		return false
+	}
+	for _, a := range l.last {
+		if l.cnt[a.IP.String()] < l.max {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *ipSlots) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := ip.String()
+	if l.cnt[k] <= 1 {
+		delete(l.cnt, k)
+		return
+	}
+	l.cnt[k]--
+}
+
+// counts returns a snapshot of the number of connections per IP address.
+func (l *ipSlots) counts() map[string]uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make(map[string]uint32, len(l.cnt))
+	for k, v := range l.cnt {
+		res[k] = v
+	}
+	return res
+}
+
+// dial resolves the host in addr with lookup and dials the least used
+// address that is below the cap. The lookup is bounded by timeout,
+// if positive. The slot is released when the connection is closed.
+func (l *ipSlots) dial(lookup lookupFunc, dial dialFunc, network, addr string, cfg *tls.Config, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ips, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.last = ips
+	l.mu.Unlock()
+	ip := l.acquire(ips)
+	if ip == nil {
+		return nil, ErrConnsPerIPExceeded
+	}
+	conn, err := dial(network, net.JoinHostPort(ip.String(), port), withServerName(cfg, host))
+	if err != nil {
+		l.release(ip)
+		return nil, err
+	}
+	closer := &slotCloser{release: func() { l.release(ip) }}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// http2.Transport relies on ConnectionState.
+		return &tlsSlotConn{Conn: tlsConn, closer: closer}, nil
+	}
+	return &slotConn{Conn: conn, closer: closer}, nil
+}
+
+// slotCloser runs release exactly once.
+type slotCloser struct {
+	once    sync.Once
+	release func()
+}
+
+func (c *slotCloser) done() {
+	c.once.Do(c.release)
+}
+
+type slotConn struct {
+	net.Conn
+	closer *slotCloser
+}
+
+func (c *slotConn) Close() error {
+	c.closer.done()
+	return c.Conn.Close()
+}
+
+type tlsSlotConn struct {
+	*tls.Conn
+	closer *slotCloser
+}
+
+func (c *tlsSlotConn) Close() error {
+	c.closer.done()
+	return c.Conn.Close()
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apns/scale"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPSlots(t *testing.T) {
+	assert.Nil(t, newIPSlots(0))
+	ips := []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}, {IP: net.ParseIP("17.0.0.2")}}
+	l := newIPSlots(2)
+	// connections are spread across addresses
+	assert.Equal(t, "17.0.0.1", l.acquire(ips).String())
+	assert.Equal(t, "17.0.0.2", l.acquire(ips).String())
+	assert.Equal(t, "17.0.0.1", l.acquire(ips).String())
+	assert.Equal(t, "17.0.0.2", l.acquire(ips).String())
+	// and capped
+	assert.Nil(t, l.acquire(ips))
+	assert.Equal(t, map[string]uint32{"17.0.0.1": 2, "17.0.0.2": 2}, l.counts())
+	l.release(net.ParseIP("17.0.0.2"))
+	assert.Equal(t, "17.0.0.2", l.acquire(ips).String())
+	l.release(net.ParseIP("17.0.0.1"))
+	l.release(net.ParseIP("17.0.0.1"))
+	assert.Equal(t, map[string]uint32{"17.0.0.2": 2}, l.counts())
+}
+
+func TestIPSlotsDial(t *testing.T) {
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host != "api.push.apple.com" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}, {IP: net.ParseIP("17.0.0.2")}}, nil
+	}
+	var dialed []string
+	var serverNames []string
+	dial := func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		serverNames = append(serverNames, cfg.ServerName)
+		if addr == "17.0.0.2:443" {
+			return nil, errors.New("connection refused")
+		}
+		c, _ := net.Pipe()
+		return tls.Client(c, cfg), nil
+	}
+	l := newIPSlots(1)
+	assert.False(t, l.isFull())
+	c1, err := l.dial(lookupIPAddr, dial, "tcp", "api.push.apple.com:443", &tls.Config{}, time.Second)
+	assert.Nil(t, err)
+	// ConnectionState must be available to http2.Transport
+	_, ok := c1.(interface{ ConnectionState() tls.ConnectionState })
+	assert.True(t, ok)
+	// failed dial releases the slot
+	_, err = l.dial(lookupIPAddr, dial, "tcp", "api.push.apple.com:443", &tls.Config{}, time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]uint32{"17.0.0.1": 1}, l.counts())
+	assert.Equal(t, []string{"17.0.0.1:443", "17.0.0.2:443"}, dialed)
+	assert.Equal(t, []string{"api.push.apple.com", "api.push.apple.com"}, serverNames)
+	// the other address is still available
+	l.acquire([]net.IPAddr{{IP: net.ParseIP("17.0.0.2")}})
+	_, err = l.dial(lookupIPAddr, dial, "tcp", "api.push.apple.com:443", &tls.Config{}, time.Second)
+	assert.Equal(t, ErrConnsPerIPExceeded, err)
+	assert.True(t, l.isFull())
+	// closing releases the slot exactly once
+	c1.Close()
+	c1.Close()
+	assert.Equal(t, map[string]uint32{"17.0.0.2": 1}, l.counts())
+	assert.False(t, l.isFull())
+	_, err = l.dial(lookupIPAddr, dial, "tcp", "nowhere:443", &tls.Config{}, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestIPSlotsDialLookupTimeout(t *testing.T) {
+	lookup := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	dial := func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+		t.Fatal("Should not have dialed")
+		return nil, nil
+	}
+	l := newIPSlots(1)
+	start := time.Now()
+	_, err := l.dial(lookup, dial, "tcp", "api.push.apple.com:443", &tls.Config{}, 20*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestLaunchCapped(t *testing.T) {
+	c := &Client{ipSlots: newIPSlots(1)}
+	ips := []net.IPAddr{{IP: net.ParseIP("17.0.0.1")}}
+	c.ipSlots.last = ips
+	c.ipSlots.acquire(ips)
+	g := &governor{id: "test", c: c}
+	done := make(chan *launcher, 1)
+	l := &launcher{gov: g, id: "test-Streamer-0", gateway: "https://primary", done: done, ctl: make(chan struct{}), started: time.Now(), attempt: 1}
+	l.launch()
+	assert.Equal(t, l, <-done)
+	// Reaching the cap is not a failure.
+	assert.True(t, l.capped)
+	assert.Nil(t, l.err)
+	assert.Nil(t, l.worker)
+	// no scaling up while capped
+	g.cfg = ProcCfg{MinConns: 1, MaxConns: 4, Scale: scale.Incremental(1)}
+	g.streamers = make(map[*streamer]chan struct{})
+	g.launchers = make(map[*launcher]chan struct{})
+	assert.Equal(t, 0, g.allowedScaleDelta(forScaleUp))
+	c.ipSlots.release(ips[0].IP)
+	assert.NotEqual(t, 0, g.allowedScaleDelta(forScaleUp))
+}
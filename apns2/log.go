@@ -1,9 +1,14 @@
 package apns2
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Logger interface is extracted from log.Logger to aid in configuring
@@ -44,6 +49,55 @@ const (
 // should be logged.
 var LogLevel = LogNotice
 
+// LogTraceSampling is a runtime-wide setting that limits trace level logging
+// to one in every LogTraceSampling trace entries. This allows occasional
+// visibility into high-frequency trace paths without flooding the log.
+// Values 0 and 1 disable sampling.
+var LogTraceSampling uint32
+
+// LogJSON is a runtime-wide setting that makes this package emit each log
+// entry as a single-line JSON record rather than free-form text. Every
+// record has "time", "level", "component" and "msg" fields. Records of
+// notable governor and streamer events also have an "event" field along
+// with event specific fields, such as "streamer_id", "conn_age",
+// "in_flight" and "delta". Durations are in seconds. See the LogField
+// and LogEvent constants.
+// Records are written with Log.Print, so Log's prefix and flags should
+// normally be cleared.
+var LogJSON bool
+
+// LogField names the fields of structured log records that are shared
+// by governor and streamer events. See LogJSON.
+const (
+	LogFieldStreamerID = "streamer_id" // id of the streamer the event is about
+	LogFieldConnAge    = "conn_age"    // seconds since streamer's connection was established
+	LogFieldInFlight   = "in_flight"   // number of requests in flight on the connection
+	LogFieldServed     = "served"      // number of requests served by the connection
+	LogFieldReason     = "reason"      // reason for the event, e.g. a StreamerReason value
+	LogFieldDelta      = "delta"       // change in the number of connections
+	LogFieldConns      = "conns"       // number of connections after the change
+	LogFieldIdle       = "idle"        // seconds without a response from APN service
+)
+
+// Events logged with structured fields. See LogJSON.
+const (
+	LogEventStreamerRunning      = "streamer_running"
+	LogEventStreamerDraining     = "streamer_draining"
+	LogEventStreamerStopped      = "streamer_stopped"
+	LogEventStreamerUnresponsive = "streamer_unresponsive"
+	LogEventStreamerErrorRate    = "streamer_error_rate"
+	LogEventGoAway               = "goaway"
+	LogEventScaleUp              = "scale_up"
+	LogEventWindDown             = "wind_down"
+	LogEventSaturated            = "saturated"
+)
+
+// logFields are the event specific fields of a structured log record.
+type logFields map[string]interface{}
+
+// number of trace entries seen since start, accessed atomically
+var traceCnt uint32
+
 var severityStrs = map[Severity]string{
 	LogError:    "ERROR ",
 	LogWarn:     "WARNING ",
@@ -83,13 +137,72 @@ func logInfo(id string, format string, v ...interface{}) {
 }
 
 func logTrace(level uint, id string, format string, v ...interface{}) {
-	logTag(id, LogInfo+Severity(level+1), format, v...)
+	tag := LogInfo + Severity(level+1)
+	if tag > LogLevel || !sampleTrace() {
+		return
+	}
+	logTag(id, tag, format, v...)
+}
+
+// sampleTrace returns true if the current trace entry should be logged
+// as per LogTraceSampling.
+func sampleTrace() bool {
+	n := atomic.LoadUint32(&LogTraceSampling)
+	if n <= 1 {
+		return true
+	}
+	return atomic.AddUint32(&traceCnt, 1)%n == 1
+}
+
+// logEvent logs a notable event. With LogJSON set, the event and its fields
+// are included in the record, otherwise only the message is logged.
+func logEvent(id string, tag Severity, event string, fields logFields, format string, v ...interface{}) {
+	if tag > LogLevel || tag > LogInfo && !sampleTrace() {
+		return
+	}
+	if LogJSON {
+		logJSON(id, tag, event, fields, fmt.Sprintf(format, v...))
+		return
+	}
+	logTag(id, tag, format, v...)
+}
+
+// logJSON writes a structured log record.
+func logJSON(id string, tag Severity, event string, fields logFields, msg string) {
+	Log.Print(string(jsonRecord(time.Now(), id, tag, event, fields, msg)))
+}
+
+// jsonRecord returns JSON encoding of a structured log record.
+func jsonRecord(t time.Time, id string, tag Severity, event string, fields logFields, msg string) []byte {
+	rec := make(map[string]interface{}, len(fields)+5)
+	for k, v := range fields {
+		rec[k] = v
+	}
+	rec["time"] = t.UTC().Format(time.RFC3339Nano)
+	rec["level"] = strings.ToLower(strings.TrimSpace(tag.String()))
+	if len(id) > 0 {
+		rec["component"] = id
+	}
+	if len(event) > 0 {
+		rec["event"] = event
+	}
+	rec["msg"] = msg
+	b, err := json.Marshal(rec)
+	if err != nil {
+		// Fields are of basic types, so this is not expected.
+		b, _ = json.Marshal(map[string]string{"level": "error", "component": id, "msg": err.Error()})
+	}
+	return b
 }
 
 func logTag(id string, tag Severity, format string, v ...interface{}) {
 	if tag > LogLevel {
 		return
 	}
+	if LogJSON {
+		logJSON(id, tag, "", nil, fmt.Sprintf(format, v...))
+		return
+	}
 	format = tag.String() + format
 	if len(id) > 0 {
 		format = id + ": " + format
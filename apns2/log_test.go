@@ -0,0 +1,60 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleTrace(t *testing.T) {
+	defer func(n uint32) { LogTraceSampling = n }(LogTraceSampling)
+	LogTraceSampling = 0
+	assert.True(t, sampleTrace())
+	LogTraceSampling = 1
+	assert.True(t, sampleTrace())
+	LogTraceSampling = 3
+	cnt := 0
+	for i := 0; i < 30; i++ {
+		if sampleTrace() {
+			cnt++
+		}
+	}
+	assert.Equal(t, 10, cnt)
+}
+
+func TestJSONRecord(t *testing.T) {
+	ts := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	b := jsonRecord(ts, "Test-Streamer-1", LogWarn, LogEventStreamerStopped, logFields{LogFieldServed: 3}, "Stopped after serving 3 requests.")
+	var rec map[string]interface{}
+	if assert.Nil(t, json.Unmarshal(b, &rec)) {
+		assert.Equal(t, "2017-05-01T12:00:00Z", rec["time"])
+		assert.Equal(t, "warning", rec["level"])
+		assert.Equal(t, "Test-Streamer-1", rec["component"])
+		assert.Equal(t, LogEventStreamerStopped, rec["event"])
+		assert.Equal(t, "Stopped after serving 3 requests.", rec["msg"])
+		assert.Equal(t, 3.0, rec[LogFieldServed])
+	}
+	// free-form entries
+	b = jsonRecord(ts, "", LogTrace(0), "", nil, "Max streams = 10")
+	rec = nil
+	if assert.Nil(t, json.Unmarshal(b, &rec)) {
+		assert.Equal(t, "trace", rec["level"])
+		assert.Equal(t, "Max streams = 10", rec["msg"])
+		_, ok := rec["event"]
+		assert.False(t, ok)
+		_, ok = rec["component"]
+		assert.False(t, ok)
+	}
+}
+
+func TestStreamerLogFields(t *testing.T) {
+	s := &streamer{id: "test", started: time.Now().Add(-time.Second)}
+	f := s.logFields(LogFieldReason, StreamerReasonRecycled)
+	assert.Equal(t, "test", f[LogFieldStreamerID])
+	assert.Equal(t, StreamerReasonRecycled, f[LogFieldReason])
+	assert.True(t, f[LogFieldConnAge].(float64) >= 1)
+}
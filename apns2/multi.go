@@ -0,0 +1,380 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoCredential is returned when a push request's topic is not served
+	// by any of MultiClient's credentials.
+	ErrNoCredential = errors.New("apns2: no credential configured for topic")
+
+	// ErrMinConnsOverBudget is returned when MultiClient's pools combined
+	// would need more than ProcCfg.MaxConns connections to honor MinConns.
+	ErrMinConnsOverBudget = errors.New("apns2: MinConns of all credentials combined exceed MaxConns")
+)
+
+// Credential is a set of authentication credentials that can be used
+// to push notifications for one or more topics.
+type Credential struct {
+
+	// Certificate, if not nil, is used in the client side configuration
+	// of the TLS connections to APN servers.
+	Certificate *tls.Certificate
+
+	// Signer, if not nil, is used to sign individual requests to APN service.
+	Signer RequestSigner
+
+	// Topics lists the topics that this credential can be used for.
+	Topics []string
+
+	// Weight determines the share of requests routed to this credential
+	// when more than one credential serves the same topic.
+	// Zero weight is treated as 1.
+	Weight uint
+}
+
+// MultiClient serves many topics with multiple authentication credentials.
+// Each push request is routed to one of the credentials that serve request's
+// topic. Requests are distributed among multiple credentials serving
+// the same topic in a weighted round-robin manner.
+//
+// Each credential is given its own processing pipeline and pool
+// of connections. All pools share a single scaling budget: the total number
+// of connections never exceeds ProcCfg.MaxConns.
+type MultiClient struct {
+
+	// Id identifies client in log entries.
+	Id string
+
+	// Gateway is the APN service connection endpoint.
+	Gateway string
+
+	// CommsCfg contains communication settings to be used by the client.
+	CommsCfg CommsCfg
+
+	// ProcCfg contains autoscaling settings. MinConns applies to each
+	// credential's pool individually, while MaxConns is the limit
+	// for all pools combined.
+	ProcCfg ProcCfg
+
+	// RootCA, if not nil, can be used to specify an alternative root
+	// certificate authority.
+	RootCA *tls.Certificate
+
+	// Credentials lists all of the authentication credentials to use.
+	Credentials []*Credential
+
+	// PayloadEncoder, if not nil, is used to encode notification payloads.
+	// See Client.PayloadEncoder.
+	PayloadEncoder PayloadEncoder
+
+	// Poller, if not nil, drives performance metrics sampling
+	// of all pools. See Client.Poller.
+	Poller *Poller
+
+	// Queue for submitting push requests.
+	Queue <-chan *Request
+
+	// Callback, if not nil, specifies the channel to which the outcome of
+	// the push request executions should be delivered.
+	Callback chan<- *Result
+
+	mu      sync.Mutex
+	clients []*Client
+	inputs  []chan *Request
+	routes  map[string]*wrrGroup
+	wg      sync.WaitGroup
+	ctl     chan struct{}
+	done    chan struct{}
+	started bool
+	stopped bool
+	killed  bool
+}
+
+// Start starts processing pipelines for all of the credentials.
+// If the client has already been started, ErrClientAlreadyStarted
+// error is returned.
+func (m *MultiClient) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		return ErrClientAlreadyStarted
+	}
+	if len(m.Id) == 0 {
+		m.Id = "MultiClient"
+	}
+	if uint64(len(m.Credentials))*uint64(m.ProcCfg.MinConns) > uint64(m.ProcCfg.MaxConns) {
+		return ErrMinConnsOverBudget
+	}
+	budget := &connBudget{max: m.ProcCfg.MaxConns}
+	m.routes = make(map[string]*wrrGroup)
+	for i, cred := range m.Credentials {
+		if cred.Certificate == nil && cred.Signer == nil {
+			return ErrMissingAuth
+		}
+		in := make(chan *Request)
+		c := &Client{
+			Id:             fmt.Sprintf("%s-%d", m.Id, i),
+			Gateway:        m.Gateway,
+			CommsCfg:       m.CommsCfg,
+			ProcCfg:        m.ProcCfg,
+			Certificate:    cred.Certificate,
+			RootCA:         m.RootCA,
+			Signer:         cred.Signer,
+			PayloadEncoder: m.PayloadEncoder,
+			Poller:         m.Poller,
+			Queue:          in,
+			Callback:       m.Callback,
+			budget:         budget,
+			sharedCallback: true,
+		}
+		for _, t := range cred.Topics {
+			grp := m.routes[t]
+			if grp == nil {
+				grp = &wrrGroup{}
+				m.routes[t] = grp
+			}
+			grp.add(i, cred.Weight)
+		}
+		m.clients = append(m.clients, c)
+		m.inputs = append(m.inputs, in)
+	}
+	for i, c := range m.clients {
+		if err := c.Start(nil); err != nil {
+			for _, c := range m.clients[:i] {
+				c.Kill()
+			}
+			return err
+		}
+	}
+	m.started = true
+	m.ctl = make(chan struct{})
+	m.done = make(chan struct{})
+	m.wg.Add(1)
+	go m.runRouter()
+	return nil
+}
+
+// Stop performs soft shutdown of all processing pipelines.
+func (m *MultiClient) Stop() error {
+	m.mu.Lock()
+	if !m.started || m.stopped {
+		m.mu.Unlock()
+		return ErrClientAlreadyClosed
+	}
+	m.stopped = true
+	close(m.ctl)
+	m.mu.Unlock()
+	m.wg.Wait()
+	for _, c := range m.clients {
+		c.Stop()
+	}
+	if m.Callback != nil && m.Callback != NoCallback {
+		close(m.Callback)
+	}
+	m.closeDone()
+	return nil
+}
+
+// Kill performs hard shutdown of all processing pipelines.
+// See Client.Kill for details.
+func (m *MultiClient) Kill() error {
+	m.mu.Lock()
+	if !m.started {
+		m.mu.Unlock()
+		return ErrClientNotRunning
+	}
+	stopped := m.killed
+	select {
+	case <-m.done:
+		stopped = true
+	default:
+	}
+	if stopped {
+		m.mu.Unlock()
+		return ErrClientAlreadyClosed
+	}
+	m.killed = true
+	if !m.stopped {
+		m.stopped = true
+		close(m.ctl)
+	}
+	m.mu.Unlock()
+	for _, c := range m.clients {
+		c.Kill()
+	}
+	m.closeDone()
+	return nil
+}
+
+// Done returns a channel that is closed once all processing pipelines
+// have been stopped, either by Stop or Kill. It returns nil if the client
+// has not been started.
+func (m *MultiClient) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.done
+}
+
+// closeDone closes done channel, unless it has already been closed.
+func (m *MultiClient) closeDone() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+}
+
+// Push asynchronously sends a Notification to the APN service using
+// one of the credentials configured for notification's topic.
+// See Client.Push for details.
+func (m *MultiClient) Push(n *Notification, ctx context.Context, callback chan<- *Result) error {
+	i, err := m.route(n)
+	if err != nil {
+		return err
+	}
+	return m.clients[i].Push(n, DefaultSigner, ctx, callback)
+}
+
+// route returns the index of the client that should handle
+// the notification.
+func (m *MultiClient) route(n *Notification) (int, error) {
+	if n == nil {
+		return 0, &RequestError{ErrMissingNotification}
+	}
+	topic := ""
+	if n.Header != nil {
+		topic = n.Header.Topic
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grp := m.routes[topic]
+	if grp == nil {
+		return 0, ErrNoCredential
+	}
+	return grp.next(), nil
+}
+
+func (m *MultiClient) runRouter() {
+	defer m.wg.Done()
+	for {
+		select {
+		case req, ok := <-m.Queue:
+			if !ok {
+				return
+			}
+			i, err := m.route(req.Notification)
+			if err != nil {
+				res := &Result{
+					Notification: req.Notification,
+					Signer:       req.Signer,
+					Context:      req.Context,
+					Err:          err,
+					CompletedAt:  time.Now(),
+				}
+				cb := req.Callback
+				if cb == nil {
+					cb = m.Callback
+				}
+				if cb != nil && cb != NoCallback {
+					select {
+					case cb <- res:
+					case <-m.ctl:
+						return
+					}
+				}
+				break
+			}
+			select {
+			case m.inputs[i] <- req:
+			case <-m.ctl:
+				return
+			}
+		case <-m.ctl:
+			return
+		}
+	}
+}
+
+// wrrGroup implements smooth weighted round-robin selection of clients
+// identified by their indexes.
+type wrrGroup struct {
+	members []*wrrMember
+	total   int
+}
+
+type wrrMember struct {
+	idx     int
+	weight  int
+	current int
+}
+
+func (g *wrrGroup) add(idx int, weight uint) {
+	w := int(weight)
+	if w <= 0 {
+		w = 1
+	}
+	g.members = append(g.members, &wrrMember{idx: idx, weight: w})
+	g.total += w
+}
+
+func (g *wrrGroup) next() int {
+	var best *wrrMember
+	for _, m := range g.members {
+		m.current += m.weight
+		if best == nil || m.current > best.current {
+			best = m
+		}
+	}
+	best.current -= g.total
+	return best.idx
+}
+
+// connBudget is a connection allowance shared by multiple governors.
+// Nil connBudget imposes no limit.
+type connBudget struct {
+	mu   sync.Mutex
+	max  uint32
+	used uint32
+}
+
+// reserve reserves up to n connections and returns the number
+// of connections actually reserved.
+func (b *connBudget) reserve(n int) int {
+	if b == nil || n <= 0 {
+		return n
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if avail := int(b.max) - int(b.used); n > avail {
+		n = avail
+	}
+	if n < 0 {
+		n = 0
+	}
+	b.used += uint32(n)
+	return n
+}
+
+// release returns n previously reserved connections to the budget.
+func (b *connBudget) release(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if uint32(n) > b.used {
+		n = int(b.used)
+	}
+	b.used -= uint32(n)
+}
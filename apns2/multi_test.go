@@ -0,0 +1,158 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWRRGroup(t *testing.T) {
+	g := &wrrGroup{}
+	g.add(0, 5)
+	g.add(1, 1)
+	g.add(2, 1)
+	cnts := make([]int, 3)
+	seq := make([]int, 0, 7)
+	for i := 0; i < 7; i++ {
+		n := g.next()
+		cnts[n]++
+		seq = append(seq, n)
+	}
+	assert.Equal(t, []int{5, 1, 1}, cnts)
+	// smooth distribution
+	assert.Equal(t, []int{0, 0, 1, 0, 2, 0, 0}, seq)
+}
+
+func TestConnBudget(t *testing.T) {
+	var nb *connBudget
+	assert.Equal(t, 5, nb.reserve(5))
+	nb.release(5)
+	b := &connBudget{max: 4}
+	assert.Equal(t, 3, b.reserve(3))
+	assert.Equal(t, 1, b.reserve(3))
+	assert.Equal(t, 0, b.reserve(1))
+	b.release(2)
+	assert.Equal(t, 2, b.reserve(5))
+	b.release(10)
+	assert.Equal(t, uint32(0), b.used)
+}
+
+func TestMultiClient_Routing(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	tmpl := mustNewClient_Signer_Good(t, s)
+	m := &MultiClient{
+		Gateway:  s.URL,
+		RootCA:   s.RootCertificate,
+		CommsCfg: commsTest_Fast,
+		ProcCfg:  MinBlockingProcConfig,
+		Credentials: []*Credential{
+			{Signer: tmpl.Signer, Topics: []string{"com.example.Alert"}},
+		},
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	cb := make(chan *Result, 1)
+	err := m.Push(testNotif_Good, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.True(t, r.IsAccepted())
+	err = m.Push(&Notification{
+		Recipient: testNotif_Good.Recipient,
+		Header:    &Header{Topic: "com.example.Other"},
+		Payload:   testNotif_Good.Payload,
+	}, NoContext, cb)
+	assert.Equal(t, ErrNoCredential, err)
+}
+
+func TestMultiClient_MissingNotification(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	tmpl := mustNewClient_Signer_Good(t, s)
+	q := make(chan *Request)
+	m := &MultiClient{
+		Gateway:  s.URL,
+		RootCA:   s.RootCertificate,
+		CommsCfg: commsTest_Fast,
+		ProcCfg:  MinBlockingProcConfig,
+		Credentials: []*Credential{
+			{Signer: tmpl.Signer, Topics: []string{"com.example.Alert"}},
+		},
+		Queue: q,
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	cb := make(chan *Result, 1)
+	err := m.Push(nil, NoContext, cb)
+	if assert.IsType(t, &RequestError{}, err) {
+		assert.Equal(t, ErrMissingNotification, err.(*RequestError).error)
+	}
+	q <- &Request{Callback: cb}
+	r := <-cb
+	if assert.IsType(t, &RequestError{}, r.Err) {
+		assert.Equal(t, ErrMissingNotification, r.Err.(*RequestError).error)
+	}
+}
+
+func TestMultiClient_MinConnsOverBudget(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	tmpl := mustNewClient_Signer_Good(t, s)
+	m := &MultiClient{
+		Gateway:  s.URL,
+		RootCA:   s.RootCertificate,
+		CommsCfg: commsTest_Fast,
+		ProcCfg:  MinBlockingProcConfig,
+		Credentials: []*Credential{
+			{Signer: tmpl.Signer, Topics: []string{"com.example.Alert"}},
+			{Signer: tmpl.Signer, Topics: []string{"com.example.Other"}},
+		},
+	}
+	assert.Equal(t, ErrMinConnsOverBudget, m.Start())
+	m.ProcCfg.MaxConns = 2
+	if assert.Nil(t, m.Start()) {
+		m.Stop()
+	}
+}
+
+func TestMultiClient_Kill(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	tmpl := mustNewClient_Signer_Good(t, s)
+	m := &MultiClient{
+		Gateway:  s.URL,
+		RootCA:   s.RootCertificate,
+		CommsCfg: commsTest_Fast,
+		ProcCfg:  MinBlockingProcConfig,
+		Credentials: []*Credential{
+			{Signer: tmpl.Signer, Topics: []string{"com.example.Alert"}},
+		},
+	}
+	assert.Nil(t, m.Done())
+	assert.Equal(t, ErrClientNotRunning, m.Kill())
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := m.Done()
+	assert.NotNil(t, done)
+	assert.Nil(t, m.Kill())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Should have been done")
+	}
+	assert.Equal(t, ErrClientAlreadyClosed, m.Kill())
+	assert.Equal(t, ErrClientAlreadyClosed, m.Stop())
+	for _, c := range m.clients {
+		assert.Equal(t, ErrClientAlreadyClosed, c.Kill())
+	}
+}
@@ -3,7 +3,6 @@
 package apns2
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,6 +29,59 @@ const (
 	PriorityHigh = 10
 )
 
+// PushType is the value of apns-push-type header. It must accurately
+// reflect the contents of the notification payload.
+// Values known at the time of writing are listed below.
+type PushType string
+
+const (
+	PushTypeAlert        PushType = "alert"
+	PushTypeBackground   PushType = "background"
+	PushTypeLocation     PushType = "location"
+	PushTypeVOIP         PushType = "voip"
+	PushTypeComplication PushType = "complication"
+	PushTypeFileProvider PushType = "fileprovider"
+	PushTypeMDM          PushType = "mdm"
+	PushTypeLiveActivity PushType = "liveactivity"
+	PushTypePushToTalk   PushType = "pushtotalk"
+)
+
+// Device token length limits in hexadecimal digits. APN service currently
+// issues 32-byte tokens, but Apple advises against assuming a fixed length.
+const (
+	minDeviceTokenLen = 64
+	maxDeviceTokenLen = 200
+)
+
+// IsValidDeviceToken returns true if the token is well-formed, i.e. it is
+// an even number of hexadecimal digits of plausible length. A well-formed
+// token may still be rejected by APN service as unknown or expired.
+func IsValidDeviceToken(token string) bool {
+	if len(token) < minDeviceTokenLen || len(token) > maxDeviceTokenLen || len(token)%2 != 0 {
+		return false
+	}
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// DeviceTokenError indicates that a notification's recipient is not
+// a well-formed device token. Such notifications are failed without
+// being sent to APN service.
+type DeviceTokenError struct {
+
+	// Token is the offending device token.
+	Token string
+}
+
+func (e *DeviceTokenError) Error() string {
+	return fmt.Sprintf("apns2: malformed device token %q", e.Token)
+}
+
 // Notification holds the data that is to be pushed to the recipient
 // as well as any routing information required to deliver it.
 // Routing headers and the notification payload are meant to remain immutable
@@ -47,6 +99,7 @@ type Notification struct {
 	ApnsID string
 
 	// Recipient is the device token of the notification target.
+	// It is not used for broadcast notifications.
 	Recipient string
 
 	// Header is a reference to a structure containing routing information.
@@ -95,16 +148,25 @@ type Header struct {
 	// and does not store the notification or attempt to redeliver it.
 	Expiration time.Time
 
+	// PushType, if set, is sent as apns-push-type header.
+	PushType PushType
+
+	// ChannelID, if set, turns the notification into a broadcast push to
+	// all devices subscribed to the channel. Broadcast pushes are sent to
+	// the app identified by Topic rather than to an individual Recipient.
+	// Channels can be managed with ChannelManager.
+	ChannelID string
+
 	httpHeaders atomic.Value
 }
 
-func (n *Notification) write(r *http.Request) error {
-	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+func (n *Notification) write(r *http.Request, enc PayloadEncoder) error {
+	r.Header.Set("Content-Type", DefaultContentType)
 	if n.ApnsID != "" {
 		r.Header.Set("apns-id", n.ApnsID)
 	}
 	n.Header.write(r)
-	body, err := n.newPayloadReader()
+	body, err := n.newPayloadReader(enc)
 	if err != nil {
 		return err
 	}
@@ -117,7 +179,21 @@ func (n *Notification) write(r *http.Request) error {
 	return nil
 }
 
-func (n *Notification) newPayloadReader() (*sliceReader, error) {
+// path returns request URL path the notification is to be posted to.
+func (n *Notification) path() string {
+	if n.IsBroadcast() {
+		return BroadcastRoot + n.Header.Topic
+	}
+	return RequestRoot + n.Recipient
+}
+
+// IsBroadcast returns true if the notification is to be broadcast
+// on a channel.
+func (n *Notification) IsBroadcast() bool {
+	return n.Header != nil && n.Header.ChannelID != ""
+}
+
+func (n *Notification) newPayloadReader(enc PayloadEncoder) (*sliceReader, error) {
 	var buf []byte
 	switch n.Payload.(type) {
 	case []byte:
@@ -126,7 +202,7 @@ func (n *Notification) newPayloadReader() (*sliceReader, error) {
 		buf = []byte(n.Payload.(string))
 	default:
 		var err error
-		buf, err = json.Marshal(n.Payload)
+		buf, err = encodePayload(enc, n.Payload)
 		if err != nil {
 			return nil, err
 		}
@@ -155,6 +231,12 @@ func (h *Header) getHTTPHeaders() [][2]string {
 	if !h.Expiration.IsZero() {
 		hdrs = append(hdrs, [...]string{"apns-expiration", fmt.Sprintf("%v", h.Expiration.Unix())})
 	}
+	if h.PushType != "" {
+		hdrs = append(hdrs, [...]string{"apns-push-type", string(h.PushType)})
+	}
+	if h.ChannelID != "" {
+		hdrs = append(hdrs, [...]string{"apns-channel-id", h.ChannelID})
+	}
 	h.httpHeaders.Store(hdrs)
 	return hdrs
 }
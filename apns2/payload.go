@@ -3,10 +3,39 @@
 package apns2
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"sync/atomic"
 )
 
+// ErrInvalidPayload is returned if a custom PayloadEncoder produces output
+// that is not a JSON dictionary.
+var ErrInvalidPayload = errors.New("apns2: encoded payload is not a JSON dictionary")
+
+// PayloadEncoder encodes notification payloads into JSON. It allows a faster
+// JSON library, or an encoder tailored to a specific payload shape, to be
+// used in place of encoding/json. json.Marshal is a valid PayloadEncoder,
+// as are Marshal functions of most JSON libraries.
+// Output of a PayloadEncoder must be a JSON dictionary.
+type PayloadEncoder func(v interface{}) ([]byte, error)
+
+// encodePayload encodes v with enc, or with json.Marshal if enc is nil.
+// Output of custom encoders is validated.
+func encodePayload(enc PayloadEncoder, v interface{}) ([]byte, error) {
+	if enc == nil {
+		return json.Marshal(v)
+	}
+	res, err := enc(v)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimLeft(res, " \t\r\n"); len(t) == 0 || t[0] != '{' || !json.Valid(res) {
+		return nil, ErrInvalidPayload
+	}
+	return res, nil
+}
+
 // Payload is the container for the actual data to be delivered
 // to the notification recipient.
 // How a payload is utilized is not constrained, but the intent is
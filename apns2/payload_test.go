@@ -0,0 +1,41 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodePayload(t *testing.T) {
+	p := map[string]interface{}{"aps": map[string]interface{}{"alert": "Ping!"}}
+	exp, _ := json.Marshal(p)
+	// default
+	res, err := encodePayload(nil, p)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, res)
+	// custom
+	calls := 0
+	enc := func(v interface{}) ([]byte, error) {
+		calls++
+		return json.Marshal(v)
+	}
+	res, err = encodePayload(enc, p)
+	assert.Nil(t, err)
+	assert.Equal(t, exp, res)
+	assert.Equal(t, 1, calls)
+	// encoder failure
+	failed := errors.New("failed")
+	_, err = encodePayload(func(interface{}) ([]byte, error) { return nil, failed }, p)
+	assert.Equal(t, failed, err)
+	// invalid output
+	for _, out := range []string{"", "[1]", `"aps"`, `{"aps":`} {
+		_, err = encodePayload(func(interface{}) ([]byte, error) { return []byte(out), nil }, p)
+		assert.Equal(t, ErrInvalidPayload, err, out)
+	}
+	_, err = encodePayload(func(interface{}) ([]byte, error) { return []byte(" {}"), nil }, p)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+)
+
+// PinningError is returned when none of the certificates presented
+// by the server match any of the pinned public keys.
+type PinningError struct {
+	// Subject is the common name of the leaf certificate presented
+	// by the server.
+	Subject string
+}
+
+func (e *PinningError) Error() string {
+	return "apns2: no pinned public key in certificate chain of " + e.Subject
+}
+
+// SPKIHash returns base64-encoded SHA-256 hash of certificate's
+// Subject Public Key Info. This is the form in which pinned public keys
+// are specified in CommsCfg.PinnedKeys.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// makePinVerifier returns a function suitable for use as
+// tls.Config.VerifyPeerCertificate that succeeds only if at least one
+// of the server's certificates has one of the pinned public keys.
+func makePinVerifier(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	pinSet := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		pinSet[p] = true
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var subject string
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if pinSet[SPKIHash(cert)] {
+					return nil
+				}
+			}
+		}
+		// Verification may have been skipped altogether,
+		// in which case raw certificates are all we have.
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			if len(subject) == 0 {
+				subject = cert.Subject.CommonName
+			}
+			if pinSet[SPKIHash(cert)] {
+				return nil
+			}
+		}
+		return &PinningError{Subject: subject}
+	}
+}
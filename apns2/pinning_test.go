@@ -0,0 +1,50 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"crypto/x509"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinnedKeys(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	cert, err := x509.ParseCertificate(s.RootCertificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	cfg := commsTest_Fast
+	cfg.RootCAs = pool
+	// matching pin
+	cfg.PinnedKeys = []string{SPKIHash(cert)}
+	c, err := NewHTTPClient(s.URL, cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// mismatching pin
+	cfg.PinnedKeys = []string{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}
+	c, err = NewHTTPClient(s.URL, cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(s.URL)
+	if err == nil {
+		t.Fatal("Should have failed pinning")
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	_, ok := err.(*PinningError)
+	assert.True(t, ok, "Should have gotten PinningError, got %v", err)
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+	"time"
+)
+
+// Poller drives performance metrics sampling of many clients with a single
+// timer, in place of each client's own PollInterval ticker. Clients are
+// polled one at a time in round-robin order, evenly staggered across
+// Interval, so that each of them is polled once per Interval and their
+// evaluations never coincide.
+//
+// Poller is shared by assigning it to Client's or MultiClient's Poller field
+// before the clients are started. It runs for as long as any of the clients
+// using it is running. Interval must not be changed once Poller is in use.
+type Poller struct {
+
+	// Interval is the time between consecutive polls of each client.
+	// It takes the place of ProcCfg.PollInterval of all clients that
+	// use the Poller. Clients are not polled if Interval is not positive.
+	Interval time.Duration
+
+	mu   sync.Mutex
+	subs []chan time.Time
+	next int
+	stop chan struct{}
+}
+
+// register adds a new subscriber and returns the channel on which
+// it will receive polls. Nil channel is returned if Interval is not
+// positive.
+func (p *Poller) register() chan time.Time {
+	if p.Interval <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Same as with time.Ticker, polls are dropped for slow subscribers.
+	res := make(chan time.Time, 1)
+	p.subs = append(p.subs, res)
+	if p.stop == nil {
+		p.stop = make(chan struct{})
+		go p.run(p.stop)
+	}
+	return res
+}
+
+// unregister removes the subscriber. The poller stops once
+// the last subscriber is removed.
+func (p *Poller) unregister(sub chan time.Time) {
+	if sub == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.subs {
+		if s == sub {
+			p.subs = append(p.subs[:i], p.subs[i+1:]...)
+			break
+		}
+	}
+	if len(p.subs) == 0 && p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *Poller) run(stop <-chan struct{}) {
+	tmr := time.NewTimer(p.step())
+	defer tmr.Stop()
+	for {
+		select {
+		case now := <-tmr.C:
+			p.poll(now)
+			tmr.Reset(p.step())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// step returns the time until the next subscriber is to be polled.
+func (p *Poller) step() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.subs); n > 1 {
+		return p.Interval / time.Duration(n)
+	}
+	return p.Interval
+}
+
+// poll polls the next subscriber in turn.
+func (p *Poller) poll(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.subs) == 0 {
+		return
+	}
+	if p.next >= len(p.subs) {
+		p.next = 0
+	}
+	select {
+	case p.subs[p.next] <- now:
+	default:
+	}
+	p.next++
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller(t *testing.T) {
+	p := &Poller{Interval: 60 * time.Millisecond}
+	s1 := p.register()
+	s2 := p.register()
+	var t1, t2 []time.Time
+	end := time.After(310 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case v := <-s1:
+			t1 = append(t1, v)
+		case v := <-s2:
+			t2 = append(t2, v)
+		case <-end:
+			done = true
+		}
+	}
+	// Each subscriber is polled once per interval...
+	assert.InDelta(t, 5, len(t1), 1)
+	assert.InDelta(t, 5, len(t2), 1)
+	// ...and the polls are staggered.
+	if assert.NotEmpty(t, t1) && assert.NotEmpty(t, t2) {
+		d := t2[0].Sub(t1[0])
+		assert.True(t, d >= 20*time.Millisecond && d <= 40*time.Millisecond, "%v", d)
+	}
+	p.unregister(s1)
+	p.mu.Lock()
+	assert.NotNil(t, p.stop)
+	p.mu.Unlock()
+	p.unregister(s2)
+	p.mu.Lock()
+	assert.Nil(t, p.stop)
+	assert.Empty(t, p.subs)
+	p.mu.Unlock()
+}
+
+func TestPollerDisabled(t *testing.T) {
+	p := &Poller{}
+	sub := p.register()
+	assert.Nil(t, sub)
+	p.unregister(sub)
+	assert.Nil(t, p.stop)
+}
+
+func TestClient_Poller(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	p := &Poller{Interval: 10 * time.Millisecond}
+	var cs []*Client
+	for i := 0; i < 2; i++ {
+		c := mustNewClient_Signer_Good(t, s)
+		c.CommsCfg.RequestTimeout = time.Second
+		c.Poller = p
+		if err := c.Start(nil); err != nil {
+			t.Fatal(err)
+		}
+		cs = append(cs, c)
+	}
+	for _, c := range cs {
+		assert.Equal(t, p.Interval, c.gov.cfg.PollInterval)
+		cb := make(chan *Result, 1)
+		if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, (<-cb).IsAccepted())
+	}
+	p.mu.Lock()
+	assert.Len(t, p.subs, 2)
+	p.mu.Unlock()
+	for _, c := range cs {
+		c.Stop()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	assert.Empty(t, p.subs)
+	assert.Nil(t, p.stop)
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Topic suffixes that identify push types other than alert and background.
+var pushTypeTopicSuffixes = []struct {
+	suffix   string
+	pushType PushType
+}{
+	{".voip-ptt", PushTypePushToTalk},
+	{".voip", PushTypeVOIP},
+	{".complication", PushTypeComplication},
+	{".pushkit.fileprovider", PushTypeFileProvider},
+	{".push-type.liveactivity", PushTypeLiveActivity},
+	{".location-query", PushTypeLocation},
+}
+
+// DefaultPushTypeResolver infers apns-push-type from request's topic
+// and payload. Topics with suffixes reserved for VoIP, push to talk,
+// complication, file provider, live activity and location query pushes
+// resolve to the corresponding push type. Otherwise the payload is
+// inspected: it resolves to PushTypeAlert if it has an alert, a badge
+// or a sound, and to PushTypeBackground if it only has content-available.
+// An empty PushType is returned if the push type cannot be inferred,
+// including for payloads of custom types other than *Payload, []byte
+// and string.
+func DefaultPushTypeResolver(req *Request) PushType {
+	n := req.Notification
+	if n == nil {
+		return ""
+	}
+	if h := n.Header; h != nil {
+		for _, s := range pushTypeTopicSuffixes {
+			if strings.HasSuffix(h.Topic, s.suffix) {
+				return s.pushType
+			}
+		}
+	}
+	switch p := n.Payload.(type) {
+	case *Payload:
+		if p == nil {
+			return ""
+		}
+		m, _ := p.mergedMap()["aps"].(map[string]interface{})
+		return pushTypeOfAPS(m["alert"] != nil || m["badge"] != nil || m["sound"] != nil, m["content-available"] != nil)
+	case []byte:
+		return pushTypeOfRaw(p)
+	case string:
+		return pushTypeOfRaw([]byte(p))
+	}
+	return ""
+}
+
+func pushTypeOfAPS(isAlert bool, isBackground bool) PushType {
+	switch {
+	case isAlert:
+		return PushTypeAlert
+	case isBackground:
+		return PushTypeBackground
+	}
+	return ""
+}
+
+// pushTypeOfRaw infers push type from JSON encoded payload.
+func pushTypeOfRaw(b []byte) PushType {
+	var v struct {
+		APS map[string]json.RawMessage `json:"aps"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return ""
+	}
+	m := v.APS
+	return pushTypeOfAPS(m["alert"] != nil || m["badge"] != nil || m["sound"] != nil, m["content-available"] != nil)
+}
+
+// resolvePushType sets the push type returned by resolve on a copy
+// of request's notification and its header, unless the notification
+// already has a push type or the resolved one is empty.
+func resolvePushType(req *Request, resolve func(*Request) PushType) {
+	n := req.Notification
+	if n == nil || n.Header != nil && n.Header.PushType != "" {
+		return
+	}
+	t := resolve(req)
+	if t == "" {
+		return
+	}
+	var h Header
+	if n.Header != nil {
+		h = Header{
+			Topic:      n.Header.Topic,
+			CollapseID: n.Header.CollapseID,
+			Priority:   n.Header.Priority,
+			Expiration: n.Header.Expiration,
+			ChannelID:  n.Header.ChannelID,
+		}
+	}
+	h.PushType = t
+	nn := *n
+	nn.Header = &h
+	req.Notification = &nn
+}
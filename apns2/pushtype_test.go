@@ -0,0 +1,90 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPushTypeResolver(t *testing.T) {
+	resolve := func(topic string, payload interface{}) PushType {
+		return DefaultPushTypeResolver(&Request{Notification: &Notification{
+			Header:  &Header{Topic: topic},
+			Payload: payload,
+		}})
+	}
+	alert := &Payload{APS: &APS{Alert: "Ping!"}}
+	assert.Equal(t, PushTypeAlert, resolve("com.example", alert))
+	assert.Equal(t, PushTypeAlert, resolve("com.example", &Payload{APS: &APS{Badge: 1}}))
+	assert.Equal(t, PushTypeAlert, resolve("com.example", &Payload{Raw: map[string]interface{}{"aps": map[string]interface{}{"sound": "ping"}}}))
+	assert.Equal(t, PushTypeBackground, resolve("com.example", &Payload{APS: &APS{ContentAvailable: true}}))
+	assert.Equal(t, PushType(""), resolve("com.example", &Payload{Raw: map[string]interface{}{"acme": 1}}))
+	assert.Equal(t, PushTypeAlert, resolve("com.example", `{"aps":{"alert":"Ping!"}}`))
+	assert.Equal(t, PushTypeBackground, resolve("com.example", []byte(`{"aps":{"content-available":1}}`)))
+	assert.Equal(t, PushType(""), resolve("com.example", []byte(`{"aps":`)))
+	assert.Equal(t, PushType(""), resolve("com.example", struct{}{}))
+	// topic suffixes take precedence
+	assert.Equal(t, PushTypeVOIP, resolve("com.example.voip", alert))
+	assert.Equal(t, PushTypePushToTalk, resolve("com.example.voip-ptt", alert))
+	assert.Equal(t, PushTypeComplication, resolve("com.example.complication", alert))
+	assert.Equal(t, PushTypeFileProvider, resolve("com.example.pushkit.fileprovider", alert))
+	assert.Equal(t, PushTypeLiveActivity, resolve("com.example.push-type.liveactivity", alert))
+	assert.Equal(t, PushTypeLocation, resolve("com.example.location-query", alert))
+	assert.Equal(t, PushType(""), DefaultPushTypeResolver(&Request{}))
+}
+
+func TestResolvePushType(t *testing.T) {
+	exp := time.Now()
+	n := &Notification{
+		Recipient: testNotif_Good.Recipient,
+		Header:    &Header{Topic: "com.example", CollapseID: "c", Priority: PriorityLow, Expiration: exp, ChannelID: "ch"},
+		Payload:   testNotif_Good.Payload,
+	}
+	n.Header.getHTTPHeaders()
+	req := &Request{Notification: n}
+	resolvePushType(req, DefaultPushTypeResolver)
+	// original is not modified
+	assert.Equal(t, PushType(""), n.Header.PushType)
+	assert.Equal(t, &Header{Topic: "com.example", CollapseID: "c", Priority: PriorityLow, Expiration: exp, ChannelID: "ch", PushType: PushTypeAlert}, req.Notification.Header)
+	assert.Contains(t, req.Notification.Header.getHTTPHeaders(), [2]string{"apns-push-type", "alert"})
+	// explicit push type is kept
+	req = &Request{Notification: &Notification{Header: &Header{PushType: PushTypeMDM}, Payload: testNotif_Good.Payload}}
+	resolvePushType(req, DefaultPushTypeResolver)
+	assert.Equal(t, PushTypeMDM, req.Notification.Header.PushType)
+	// missing header
+	n = &Notification{Payload: testNotif_Good.Payload}
+	req = &Request{Notification: n}
+	resolvePushType(req, DefaultPushTypeResolver)
+	assert.Equal(t, PushTypeAlert, req.Notification.Header.PushType)
+	// unresolved
+	req = &Request{Notification: n}
+	resolvePushType(req, func(*Request) PushType { return "" })
+	assert.Equal(t, n, req.Notification)
+}
+
+func TestClient_PushTypeResolver(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.PushTypeResolver = DefaultPushTypeResolver
+	var sent PushType
+	c.CommsCfg.OnSend = func(m RequestMeta) { sent = m.PushType }
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.Nil(t, r.Err)
+	assert.Equal(t, PushTypeAlert, sent)
+	assert.Equal(t, PushTypeAlert, r.Notification.Header.PushType)
+	assert.Equal(t, PushType(""), testNotif_Good.Header.PushType)
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeliveryReceipt is a record of the final outcome of a push request
+// suitable for persistence in an audit trail.
+type DeliveryReceipt struct {
+
+	// ApnsID is the notification identifier returned by APN service
+	// or, if no response was received, the one supplied in the notification.
+	ApnsID string
+
+	// UniqueID is the value of apns-unique-id header returned by APN service.
+	// APN service only returns it in development environment.
+	UniqueID string
+
+	// Recipient is the device token of the notification target.
+	Recipient string
+
+	// Topic is the topic of the notification.
+	Topic string
+
+	// Timestamp is the time at which the outcome was determined.
+	Timestamp time.Time
+
+	// StatusCode is the HTTP status code returned by APN service,
+	// or 0 if no response was received.
+	StatusCode int
+
+	// Reason is the rejection reason returned by APN service, if any.
+	Reason string
+
+	// Err is the text of the error encountered while attempting the push,
+	// if any.
+	Err string
+}
+
+// ReceiptEmitter is implemented by receivers of delivery receipts.
+// Emit is called from a single goroutine, one receipt at a time.
+type ReceiptEmitter interface {
+	Emit(r *DeliveryReceipt)
+}
+
+// DefaultReceiptBufferSize is the number of delivery receipts buffered
+// for the emitter if ProcCfg.ReceiptBufferSize is not specified.
+const DefaultReceiptBufferSize = 1000
+
+func newDeliveryReceipt(req *Request, resp *Response, err error) *DeliveryReceipt {
+	res := &DeliveryReceipt{
+		ApnsID:    req.Notification.ApnsID,
+		Recipient: req.Notification.Recipient,
+		Timestamp: time.Now(),
+	}
+	if h := req.Notification.Header; h != nil {
+		res.Topic = h.Topic
+	}
+	if resp != nil {
+		if resp.ApnsID != "" {
+			res.ApnsID = resp.ApnsID
+		}
+		res.UniqueID = resp.UniqueID
+		res.StatusCode = resp.StatusCode
+		res.Reason = resp.RejectionReason
+	}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	return res
+}
+
+// receiptSink decouples receipt emission from request processing.
+// Receipts are buffered and are dropped if the buffer is full, so that
+// a slow emitter does not degrade throughput.
+// Nil receiptSink discards all receipts.
+type receiptSink struct {
+	id      string
+	emitter ReceiptEmitter
+	queue   chan *DeliveryReceipt
+	ctl     chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+func newReceiptSink(id string, emitter ReceiptEmitter, bufSize int) *receiptSink {
+	if emitter == nil {
+		return nil
+	}
+	if bufSize <= 0 {
+		bufSize = DefaultReceiptBufferSize
+	}
+	res := &receiptSink{
+		id:      id,
+		emitter: emitter,
+		queue:   make(chan *DeliveryReceipt, bufSize),
+		ctl:     make(chan struct{}),
+	}
+	go res.run()
+	return res
+}
+
+func (s *receiptSink) put(r *DeliveryReceipt) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// stop lets the sink emit any buffered receipts and exit.
+func (s *receiptSink) stop() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.ctl)
+	})
+}
+
+func (s *receiptSink) droppedCount() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *receiptSink) resetDropped() {
+	if s == nil {
+		return
+	}
+	atomic.StoreUint64(&s.dropped, 0)
+}
+
+func (s *receiptSink) run() {
+	for {
+		select {
+		case r := <-s.queue:
+			s.emitter.Emit(r)
+		case <-s.ctl:
+			for {
+				select {
+				case r := <-s.queue:
+					s.emitter.Emit(r)
+				default:
+					logInfo(s.id, "Stopped.")
+					return
+				}
+			}
+		}
+	}
+}
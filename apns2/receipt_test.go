@@ -0,0 +1,49 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type chanEmitter chan *DeliveryReceipt
+
+func (e chanEmitter) Emit(r *DeliveryReceipt) {
+	e <- r
+}
+
+func TestNewDeliveryReceipt(t *testing.T) {
+	req := &Request{Notification: testNotif_BadDevice}
+	r := newDeliveryReceipt(req, &Response{ApnsID: "abc", StatusCode: 400, RejectionReason: ReasonBadDeviceToken}, nil)
+	assert.Equal(t, "abc", r.ApnsID)
+	assert.Equal(t, testNotif_BadDevice.Recipient, r.Recipient)
+	assert.Equal(t, "com.example.Alert", r.Topic)
+	assert.Equal(t, 400, r.StatusCode)
+	assert.Equal(t, ReasonBadDeviceToken, r.Reason)
+	assert.Equal(t, "", r.Err)
+	r = newDeliveryReceipt(req, nil, errors.New("failed"))
+	assert.Equal(t, 0, r.StatusCode)
+	assert.Equal(t, "failed", r.Err)
+}
+
+func TestReceiptSink(t *testing.T) {
+	var ns *receiptSink
+	ns.put(&DeliveryReceipt{})
+	ns.stop()
+	assert.Nil(t, newReceiptSink("test", nil, 0))
+	e := make(chanEmitter)
+	s := newReceiptSink("test", e, 1)
+	s.put(&DeliveryReceipt{ApnsID: "1"})
+	assert.Equal(t, "1", (<-e).ApnsID)
+	// emitter is blocked on the next receipt, buffer holds one more
+	s.put(&DeliveryReceipt{ApnsID: "2"})
+	for s.droppedCount() == 0 {
+		s.put(&DeliveryReceipt{ApnsID: "x"})
+	}
+	s.stop()
+	s.stop()
+	assert.Equal(t, "2", (<-e).ApnsID)
+}
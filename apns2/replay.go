@@ -0,0 +1,158 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ReplayRecordVersion is the version of ReplayRecord format produced
+// by NewReplayRecord.
+const ReplayRecordVersion = 1
+
+var (
+	ErrPayloadNotRetained      = errors.New("apns2: notification payload not retained")
+	ErrUnsupportedReplayRecord = errors.New("apns2: unsupported replay record version")
+)
+
+// ReplayRecord is a serializable record of a failed push request. It holds
+// everything needed to resend the notification, along with the failure
+// details. Its JSON encoding is stable: field names are not changed, and
+// any incompatible change is accompanied by a new ReplayRecordVersion.
+//
+// Signers, contexts and callbacks are not recorded. They are supplied
+// anew when the requests are replayed.
+type ReplayRecord struct {
+	Version     int      `json:"version"`
+	ApnsID      string   `json:"apns_id,omitempty"`
+	Recipient   string   `json:"recipient,omitempty"`
+	Topic       string   `json:"topic,omitempty"`
+	CollapseID  string   `json:"collapse_id,omitempty"`
+	Priority    Priority `json:"priority,omitempty"`
+	Expiration  int64    `json:"expiration,omitempty"`
+	PushType    PushType `json:"push_type,omitempty"`
+	ChannelID   string   `json:"channel_id,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Tag         string   `json:"tag,omitempty"`
+
+	// Payload is notification payload exactly as it was sent.
+	// It is base64-encoded in JSON.
+	Payload []byte `json:"payload"`
+
+	// Failure details. FailedAt is result's CompletedAt.
+	StatusCode int       `json:"status_code,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Err        string    `json:"error,omitempty"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// NewReplayRecord creates a replay record of the push result. Payloads
+// that are not supplied as a slice of bytes or a string are encoded
+// with enc, or with encoding/json if enc is nil. If the payload was not
+// retained in the result, ErrPayloadNotRetained is returned.
+// See ProcCfg.PayloadRetention.
+func NewReplayRecord(r *Result, enc PayloadEncoder) (*ReplayRecord, error) {
+	n := r.Notification
+	if n == nil || n.Payload == nil {
+		return nil, ErrPayloadNotRetained
+	}
+	body, err := n.newPayloadReader(enc)
+	if err != nil {
+		return nil, err
+	}
+	res := &ReplayRecord{
+		Version:     ReplayRecordVersion,
+		ApnsID:      n.ApnsID,
+		Recipient:   n.Recipient,
+		ContentType: r.ContentType,
+		Tag:         r.Tag,
+		Payload:     body.buf,
+		FailedAt:    r.CompletedAt,
+	}
+	if h := n.Header; h != nil {
+		res.Topic = h.Topic
+		res.CollapseID = h.CollapseID
+		res.Priority = h.Priority
+		if !h.Expiration.IsZero() {
+			res.Expiration = h.Expiration.Unix()
+		}
+		res.PushType = h.PushType
+		res.ChannelID = h.ChannelID
+	}
+	if resp := r.Response; resp != nil {
+		res.StatusCode = resp.StatusCode
+		res.Reason = resp.RejectionReason
+	}
+	if r.Err != nil {
+		res.Err = r.Err.Error()
+	}
+	return res, nil
+}
+
+// Request returns a new push request for resending the recorded
+// notification. The request is signed by client's signer and
+// its result is delivered to client's Callback.
+func (r *ReplayRecord) Request() (*Request, error) {
+	if r.Version != ReplayRecordVersion {
+		return nil, ErrUnsupportedReplayRecord
+	}
+	h := &Header{
+		Topic:      r.Topic,
+		CollapseID: r.CollapseID,
+		Priority:   r.Priority,
+		PushType:   r.PushType,
+		ChannelID:  r.ChannelID,
+	}
+	if r.Expiration != 0 {
+		h.Expiration = time.Unix(r.Expiration, 0)
+	}
+	return &Request{
+		Notification: &Notification{
+			ApnsID:    r.ApnsID,
+			Recipient: r.Recipient,
+			Header:    h,
+			Payload:   r.Payload,
+		},
+		ContentType: r.ContentType,
+		Tag:         r.Tag,
+	}, nil
+}
+
+// Replay resubmits previously failed push requests. Each request is
+// submitted afresh, with its attempt count reset, as if it was pushed
+// for the first time. Requests with no Context are given ctx. Replay stops
+// when ctx is done or when a request cannot be submitted, and returns
+// the number of requests that were submitted.
+//
+// Replay blocks in the same way Push does.
+func (c *Client) Replay(ctx context.Context, reqs []*Request) (int, error) {
+	for i, req := range reqs {
+		if ctx != NoContext {
+			select {
+			case <-ctx.Done():
+				return i, ctx.Err()
+			default:
+			}
+		}
+		fresh := &Request{
+			Notification: req.Notification,
+			Signer:       req.Signer,
+			Context:      req.Context,
+			Callback:     req.Callback,
+			OnComplete:   req.OnComplete,
+			ContentType:  req.ContentType,
+			MaxRetries:   req.MaxRetries,
+			NotBefore:    req.NotBefore,
+			Tag:          req.Tag,
+		}
+		if fresh.Context == NoContext {
+			fresh.Context = ctx
+		}
+		if err := c.push(fresh); err != nil {
+			return i, err
+		}
+	}
+	return len(reqs), nil
+}
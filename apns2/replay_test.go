@@ -0,0 +1,102 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayRecord(t *testing.T) {
+	exp := time.Unix(1500000000, 0)
+	res := &Result{
+		Notification: &Notification{
+			ApnsID:    "123e4567-e89b-12d3-a456-426655440000",
+			Recipient: "00fc13adff785122b4ad28809a3420982341241421348097878e577c991de8f0",
+			Header:    &Header{Topic: "com.example.Alert", Priority: PriorityLow, Expiration: exp},
+			Payload:   &Payload{APS: &APS{Alert: "Ping!"}},
+		},
+		Tag:         "campaign",
+		Response:    &Response{StatusCode: 503, RejectionReason: ReasonServiceUnavailable},
+		CompletedAt: exp.Add(time.Hour),
+	}
+	r, err := NewReplayRecord(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ReplayRecordVersion, r.Version)
+	assert.Equal(t, 503, r.StatusCode)
+	assert.True(t, res.CompletedAt.Equal(r.FailedAt))
+	assert.Equal(t, `{"aps":{"alert":"Ping!"}}`, string(r.Payload))
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r2 ReplayRecord
+	if err := json.Unmarshal(b, &r2); err != nil {
+		t.Fatal(err)
+	}
+	req, err := r2.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := req.Notification
+	assert.Equal(t, res.Notification.ApnsID, n.ApnsID)
+	assert.Equal(t, res.Notification.Recipient, n.Recipient)
+	assert.Equal(t, "com.example.Alert", n.Header.Topic)
+	assert.Equal(t, PriorityLow, n.Header.Priority)
+	assert.True(t, exp.Equal(n.Header.Expiration))
+	assert.Equal(t, r.Payload, n.Payload)
+	assert.Equal(t, "campaign", req.Tag)
+	// not retained
+	res.Notification = &Notification{Recipient: "abc"}
+	_, err = NewReplayRecord(res, nil)
+	assert.Equal(t, ErrPayloadNotRetained, err)
+	// unknown version
+	r2.Version = 100
+	_, err = r2.Request()
+	assert.Equal(t, ErrUnsupportedReplayRecord, err)
+}
+
+func TestClient_Replay(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 2)
+	res := &Result{Notification: testNotif_Good, Err: errors.New("failed")}
+	var reqs []*Request
+	for i := 0; i < 2; i++ {
+		r, err := NewReplayRecord(res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := r.Request()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Callback = cb
+		reqs = append(reqs, req)
+	}
+	// previously attempted requests are replayed afresh
+	reqs[1].attemptCnt = 3
+	reqs[1].isAdmitted = true
+	n, err := c.Replay(context.Background(), reqs)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, (<-cb).IsAccepted())
+	assert.True(t, (<-cb).IsAccepted())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = c.Replay(ctx, reqs)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, n)
+}
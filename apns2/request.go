@@ -4,8 +4,49 @@ package apns2
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
+// ErrContentTypeNotAllowed is returned if a request specifies a content type
+// that has not been registered with RegisterContentType.
+var ErrContentTypeNotAllowed = errors.New("apns2: content type not allowed")
+
+// DefaultContentType is the content type of notification payloads
+// for requests that do not specify one.
+const DefaultContentType = "application/json; charset=utf-8"
+
+var (
+	contentTypesMu sync.RWMutex
+	contentTypes   = map[string]bool{
+		DefaultContentType: true,
+		"application/json": true,
+	}
+)
+
+// RegisterContentType adds the specified value to the set of content types
+// requests are allowed to specify. It is intended for specialized push types
+// that require non-JSON payloads. Such payloads should be supplied
+// as a slice of bytes or a string.
+//
+// RegisterContentType is safe for use in concurrent goroutines.
+func RegisterContentType(contentType string) {
+	contentTypesMu.Lock()
+	defer contentTypesMu.Unlock()
+	contentTypes[contentType] = true
+}
+
+// IsContentTypeAllowed returns true if the specified content type
+// is either the default or has been registered with RegisterContentType.
+func IsContentTypeAllowed(contentType string) bool {
+	contentTypesMu.RLock()
+	defer contentTypesMu.RUnlock()
+	return contentTypes[contentType]
+}
+
 // Request holds all necessary information needed to submit a notification
 // to APN service. Requests can be directly submitted to Client's Queue.
 type Request struct {
@@ -29,7 +70,81 @@ type Request struct {
 	// will be delivered to client's Callback.
 	Callback chan<- *Result
 
+	// OnComplete, if not nil, is called with the outcome of the push
+	// once the request reaches its final state. It is called in addition
+	// to delivering the result to the callback channel, and regardless
+	// of ProcCfg.DiscardResults. OnComplete funcs are run on a bounded
+	// pool of goroutines, see ProcCfg.CompletionWorkers, so they may be
+	// called concurrently and in any order.
+	OnComplete func(*Response, error)
+
+	// ContentType, if not empty, overrides the default content type
+	// of the notification payload. The value must be allowed
+	// by IsContentTypeAllowed, otherwise the request fails
+	// with ErrContentTypeNotAllowed.
+	ContentType string
+
+	// MaxRetries, if not nil, overrides ProcCfg.MaxRetries for this request,
+	// allowing more or fewer retries to be made for individual requests.
+	MaxRetries *uint32
+
+	// NotBefore, if not zero, is the earliest time at which the request
+	// may be sent. Requests with a future NotBefore time are held back
+	// by the client and are released into the processing pipeline once
+	// they are due. Scheduled requests still awaiting release when
+	// the client is stopped fail with ErrPushInterrupted.
+	NotBefore time.Time
+
+	// Tag, if not empty, groups the request with other requests sharing
+	// the same tag for the purpose of aggregate outcome reporting.
+	// See Stats.Tags.
+	Tag string
+
 	attemptCnt int
+
+	// time at which the current attempt was queued for processing
+	queued time.Time
+	// time at which the first attempt was queued for processing
+	firstQueued time.Time
+	// time before which the next attempt must not be made
+	retryAt time.Time
+
+	// set for a replacement of a request rejected for its payload size
+	isResized bool
+	// set for a request resubmitted after provider token refresh
+	isReauthed bool
+	// set for a request resubmitted with a regenerated ApnsID
+	isRegenerated bool
+
+	// set once the request has been accepted for processing
+	isAdmitted bool
+	// set while the request is handed back to the submitter unattempted,
+	// see Client.resubmit
+	isReturned bool
+	// set while the request holds an in-flight slot of its topic
+	hasTopicSlot bool
+	// set while the request holds one of ProcCfg.MaxInFlightRetries slots
+	hasRetrySlot bool
+	// progress through ProcCfg.CollapseIDMinInterval limiting
+	collapseState uint8
+}
+
+// releaseTime returns the time at which the request is due
+// to be released into the processing pipeline.
+func (r *Request) releaseTime() time.Time {
+	if !r.retryAt.IsZero() {
+		return r.retryAt
+	}
+	return r.NotBefore
+}
+
+// retryCnt returns the number of reattempts made that count against
+// ProcCfg.MaxRetries. Resubmission with a regenerated ApnsID does not.
+func (r *Request) retryCnt() int {
+	if r.isRegenerated {
+		return r.attemptCnt - 1
+	}
+	return r.attemptCnt
 }
 
 // HasSigner returns true if the request has a custom signer supplied or if
@@ -44,3 +159,34 @@ func (r *Request) HasSigner() bool {
 type RequestError struct {
 	error
 }
+
+// assignApnsID sets a newly generated ApnsID on a copy of request's
+// notification if the request may be retried and its notification does not
+// have an ApnsID. maxRetries is the configured default retry limit.
+func assignApnsID(req *Request, maxRetries uint32) error {
+	if req.MaxRetries != nil {
+		maxRetries = *req.MaxRetries
+	}
+	if maxRetries == 0 || req.Notification == nil || req.Notification.ApnsID != "" {
+		return nil
+	}
+	id, err := newApnsID()
+	if err != nil {
+		return err
+	}
+	n := *req.Notification
+	n.ApnsID = id
+	req.Notification = &n
+	return nil
+}
+
+// newApnsID returns a random (version 4) UUID in canonical form.
+func newApnsID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// registerTestContentType registers the content type for the duration
+// of a test. The returned func removes it.
+func registerTestContentType(contentType string) func() {
+	RegisterContentType(contentType)
+	return func() {
+		contentTypesMu.Lock()
+		defer contentTypesMu.Unlock()
+		delete(contentTypes, contentType)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	assert.True(t, IsContentTypeAllowed(DefaultContentType))
+	assert.True(t, IsContentTypeAllowed("application/json"))
+	assert.False(t, IsContentTypeAllowed("application/x-test"))
+	defer registerTestContentType("application/x-test")()
+	assert.True(t, IsContentTypeAllowed("application/x-test"))
+}
+
+func TestClient_ContentType(t *testing.T) {
+	received := make(chan string, 2)
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Get("Content-Type")
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	defer registerTestContentType("application/x-test-wire")()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	// default
+	if err := c.push(&Request{Notification: testNotif_Good, Signer: DefaultSigner, Context: NoContext, Callback: cb}); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.Equal(t, DefaultContentType, <-received)
+	// registered
+	if err := c.push(&Request{Notification: testNotif_Good, Signer: DefaultSigner, Context: NoContext, Callback: cb, ContentType: "application/x-test-wire"}); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.True(t, r.IsAccepted())
+	assert.Equal(t, "application/x-test-wire", r.ContentType)
+	assert.Equal(t, "application/x-test-wire", <-received)
+	// not registered, never sent
+	if err := c.push(&Request{Notification: testNotif_Good, Signer: DefaultSigner, Context: NoContext, Callback: cb, ContentType: "application/x-unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	r = <-cb
+	if assert.NotNil(t, r.Err) {
+		assert.Contains(t, r.Err.Error(), ErrContentTypeNotAllowed.Error())
+	}
+	assert.Len(t, received, 0)
+}
+
+func TestNewApnsID(t *testing.T) {
+	id, err := newApnsID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+	id2, _ := newApnsID()
+	assert.NotEqual(t, id, id2)
+}
+
+func TestAssignApnsID(t *testing.T) {
+	// not retriable
+	req := &Request{Notification: testNotif_Good}
+	assert.Nil(t, assignApnsID(req, 0))
+	assert.True(t, req.Notification == testNotif_Good)
+	// retriable, original notification is not modified
+	assert.Nil(t, assignApnsID(req, 1))
+	assert.False(t, req.Notification == testNotif_Good)
+	assert.NotEmpty(t, req.Notification.ApnsID)
+	assert.Empty(t, testNotif_Good.ApnsID)
+	assert.Equal(t, testNotif_Good.Recipient, req.Notification.Recipient)
+	// existing id is kept
+	id := req.Notification.ApnsID
+	assert.Nil(t, assignApnsID(req, 1))
+	assert.Equal(t, id, req.Notification.ApnsID)
+	// per-request override
+	var none uint32
+	req = &Request{Notification: testNotif_Good, MaxRetries: &none}
+	assert.Nil(t, assignApnsID(req, 1))
+	assert.Empty(t, req.Notification.ApnsID)
+}
+
+func TestIsValidDeviceToken(t *testing.T) {
+	assert.True(t, IsValidDeviceToken(testNotif_Good.Recipient))
+	assert.True(t, IsValidDeviceToken("00FC13ADFF785122B4AD28809A3420982341241421348097878E577C991DE8F0"))
+	assert.True(t, IsValidDeviceToken(testNotif_Good.Recipient+testNotif_Good.Recipient))
+	assert.False(t, IsValidDeviceToken(""))
+	assert.False(t, IsValidDeviceToken("abc"))
+	assert.False(t, IsValidDeviceToken(testNotif_Good.Recipient[1:]))
+	assert.False(t, IsValidDeviceToken(testNotif_Good.Recipient[:63]+"g"))
+	assert.False(t, IsValidDeviceToken(testNotif_Good.Recipient+" "))
+}
@@ -25,8 +25,10 @@
 package apns2
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -129,6 +131,128 @@ const (
 	ReasonShutdown = "Shutdown"
 )
 
+// ReasonClass classifies APN service rejection reasons by the kind of action
+// they call for.
+type ReasonClass uint
+
+const (
+	// ReasonClassNone indicates no rejection.
+	ReasonClassNone ReasonClass = iota
+
+	// ReasonClassPermanent indicates a failure that will not go away
+	// if the same request is resubmitted.
+	ReasonClassPermanent
+
+	// ReasonClassRetriable indicates a transient failure. The same request
+	// can be resubmitted.
+	ReasonClassRetriable
+
+	// ReasonClassThrottled indicates that requests are being sent too often.
+	// The same request can be resubmitted after a delay.
+	ReasonClassThrottled
+
+	// ReasonClassBadToken indicates that the device token is not valid
+	// and should no longer be used.
+	ReasonClassBadToken
+
+	// ReasonClassAuth indicates an authentication failure with the provider
+	// certificate or the provider token.
+	ReasonClassAuth
+)
+
+// IsRetriable returns true if requests rejected for reasons of this class
+// can be resubmitted.
+func (c ReasonClass) IsRetriable() bool {
+	return c == ReasonClassRetriable || c == ReasonClassThrottled
+}
+
+var (
+	reasonsMu sync.RWMutex
+	reasons   = map[string]ReasonClass{
+		ReasonBadCollapseID:               ReasonClassPermanent,
+		ReasonBadDeviceToken:              ReasonClassBadToken,
+		ReasonBadExpirationDate:           ReasonClassPermanent,
+		ReasonBadMessageID:                ReasonClassPermanent,
+		ReasonBadPriority:                 ReasonClassPermanent,
+		ReasonBadTopic:                    ReasonClassPermanent,
+		ReasonDeviceTokenNotForTopic:      ReasonClassPermanent,
+		ReasonDuplicateHeaders:            ReasonClassPermanent,
+		ReasonIdleTimeout:                 ReasonClassRetriable,
+		ReasonMissingDeviceToken:          ReasonClassPermanent,
+		ReasonMissingTopic:                ReasonClassPermanent,
+		ReasonPayloadEmpty:                ReasonClassPermanent,
+		ReasonTopicDisallowed:             ReasonClassPermanent,
+		ReasonBadCertificate:              ReasonClassAuth,
+		ReasonBadCertificateEnvironment:   ReasonClassAuth,
+		ReasonExpiredProviderToken:        ReasonClassAuth,
+		ReasonForbidden:                   ReasonClassPermanent,
+		ReasonInvalidProviderToken:        ReasonClassAuth,
+		ReasonMissingProviderToken:        ReasonClassAuth,
+		ReasonBadPath:                     ReasonClassPermanent,
+		ReasonMethodNotAllowed:            ReasonClassPermanent,
+		ReasonUnregistered:                ReasonClassBadToken,
+		ReasonPayloadTooLarge:             ReasonClassPermanent,
+		ReasonTooManyProviderTokenUpdates: ReasonClassThrottled,
+		ReasonTooManyRequests:             ReasonClassThrottled,
+		ReasonInternalServerError:         ReasonClassRetriable,
+		ReasonServiceUnavailable:          ReasonClassRetriable,
+		ReasonShutdown:                    ReasonClassRetriable,
+	}
+)
+
+// RegisterReason associates the specified rejection reason with the class
+// that determines how responses carrying this reason are treated.
+// It can be used to add reasons newly introduced by APN service or to
+// override the classification of any of the predefined ones.
+//
+// RegisterReason is safe for use in concurrent goroutines.
+func RegisterReason(reason string, class ReasonClass) {
+	reasonsMu.Lock()
+	defer reasonsMu.Unlock()
+	reasons[reason] = class
+}
+
+// ReasonClassOf returns the class associated with the specified rejection
+// reason. If the reason is not known, ok is false.
+func ReasonClassOf(reason string) (class ReasonClass, ok bool) {
+	reasonsMu.RLock()
+	defer reasonsMu.RUnlock()
+	class, ok = reasons[reason]
+	return
+}
+
+// TimeoutError indicates that no response to a push request was received
+// from APN service in time. The notification may still have been delivered.
+type TimeoutError struct {
+
+	// ApnsID is the apns-id supplied in the Notification, if any.
+	// It can be used to inquire with Apple about the delivery status.
+	ApnsID string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.ApnsID == "" {
+		return fmt.Sprintf("apns2: request timed out: %v", e.Err)
+	}
+	return fmt.Sprintf("apns2: request with apns-id %s timed out: %v", e.ApnsID, e.Err)
+}
+
+// Timeout returns true. It allows TimeoutError to be recognized
+// as a timeout in the same way as net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// DefaultRetryEval is a retry eligibility evaluator that can be used
+// as ProcCfg.RetryEval. It only allows resubmitting requests that were
+// rejected for retriable reasons.
+func DefaultRetryEval(resp *Response, err error) bool {
+	return resp != nil && resp.IsRetriable()
+}
+
 // Response represents a result from the APN service indicating whether a
 // notification was accepted or rejected and (if applicable) any accompanying
 // data.
@@ -138,6 +262,11 @@ type Response struct {
 	// Notification, this will be a new unique UUID which has been created by apns2.
 	ApnsID string
 
+	// UniqueID is the value of apns-unique-id header. APN service only
+	// returns it in development environment. It can be used to query
+	// notification delivery status.
+	UniqueID string
+
 	// StatusCode is the HTTP status code returned by apns2.
 	// A 200 value indicates that the notification was successfully sent.
 	// For a list of other possible status codes, see table 6-4 in the Apple Local
@@ -154,6 +283,19 @@ type Response struct {
 	// confirmed that the device token was no longer valid for the topic.
 	// TODO Make Response.UnsubscribedAt a time.Time and handle unmarshalling better
 	UnsubscribedAt Time `json:"timestamp"`
+
+	// QueueLatency is the time from the moment the request was queued
+	// for processing to the moment it was sent to APN service.
+	QueueLatency time.Duration `json:"-"`
+
+	// SendLatency is the time from the moment the request was sent
+	// to APN service to the moment the response was received.
+	SendLatency time.Duration `json:"-"`
+
+	// BodyTruncated is true if response body exceeded
+	// CommsCfg.MaxResponseBodySize. The body of such a response is not
+	// parsed and RejectionReason is not set.
+	BodyTruncated bool `json:"-"`
 }
 
 // IsAccepted returns whether or not the notification was accepted by APN service.
@@ -162,6 +304,55 @@ func (c *Response) IsAccepted() bool {
 	return c.StatusCode == StatusAcccepted
 }
 
+// Class returns the class of response's rejection reason.
+// Reasons not registered with RegisterReason are classified
+// based on the response status code.
+func (c *Response) Class() ReasonClass {
+	if c.IsAccepted() {
+		return ReasonClassNone
+	}
+	if class, ok := ReasonClassOf(c.RejectionReason); ok {
+		return class
+	}
+	switch {
+	case c.StatusCode == http.StatusTooManyRequests:
+		return ReasonClassThrottled
+	case c.StatusCode >= http.StatusInternalServerError:
+		return ReasonClassRetriable
+	case c.StatusCode == http.StatusGone:
+		return ReasonClassBadToken
+	case c.StatusCode == http.StatusForbidden:
+		return ReasonClassAuth
+	}
+	return ReasonClassPermanent
+}
+
+// IsRetriable returns whether or not the rejected notification can be
+// resubmitted to APN service.
+func (c *Response) IsRetriable() bool {
+	return c.Class().IsRetriable()
+}
+
+// ShouldRemoveToken returns whether or not the device token the notification
+// was sent to is no longer valid and should not be used again.
+func (c *Response) ShouldRemoveToken() bool {
+	return c.Class() == ReasonClassBadToken
+}
+
+// IsBadDeviceToken returns whether or not the notification was rejected
+// for its device token being bad. Unlike Unregistered, this may also
+// indicate a token being sent to the wrong environment.
+func (c *Response) IsBadDeviceToken() bool {
+	return c.StatusCode == http.StatusBadRequest && c.RejectionReason == ReasonBadDeviceToken
+}
+
+// IsBadMessageID returns whether or not the notification was rejected
+// for its apns-id value. Such a notification can be resubmitted
+// with a new ApnsID, see ProcCfg.RegenerateBadApnsID.
+func (c *Response) IsBadMessageID() bool {
+	return c.StatusCode == http.StatusBadRequest && c.RejectionReason == ReasonBadMessageID
+}
+
 // Time represents a device uninstall time
 type Time struct {
 	time.Time
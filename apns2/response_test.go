@@ -0,0 +1,63 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseClass(t *testing.T) {
+	tcs := []struct {
+		resp      *Response
+		class     ReasonClass
+		retriable bool
+		remove    bool
+	}{
+		{&Response{StatusCode: 200}, ReasonClassNone, false, false},
+		{&Response{StatusCode: 400, RejectionReason: ReasonBadTopic}, ReasonClassPermanent, false, false},
+		{&Response{StatusCode: 400, RejectionReason: ReasonBadDeviceToken}, ReasonClassBadToken, false, true},
+		{&Response{StatusCode: 410, RejectionReason: ReasonUnregistered}, ReasonClassBadToken, false, true},
+		{&Response{StatusCode: 429, RejectionReason: ReasonTooManyRequests}, ReasonClassThrottled, true, false},
+		{&Response{StatusCode: 503, RejectionReason: ReasonShutdown}, ReasonClassRetriable, true, false},
+		{&Response{StatusCode: 403, RejectionReason: ReasonInvalidProviderToken}, ReasonClassAuth, false, false},
+		// unknown reasons fall back on status code
+		{&Response{StatusCode: 500, RejectionReason: "Unknown"}, ReasonClassRetriable, true, false},
+		{&Response{StatusCode: 429, RejectionReason: "Unknown"}, ReasonClassThrottled, true, false},
+		{&Response{StatusCode: 400, RejectionReason: "Unknown"}, ReasonClassPermanent, false, false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.class, tc.resp.Class(), tc.resp.RejectionReason)
+		assert.Equal(t, tc.retriable, tc.resp.IsRetriable(), tc.resp.RejectionReason)
+		assert.Equal(t, tc.remove, tc.resp.ShouldRemoveToken(), tc.resp.RejectionReason)
+	}
+}
+
+func TestResponse400Reasons(t *testing.T) {
+	badToken := &Response{StatusCode: 400, RejectionReason: ReasonBadDeviceToken}
+	badID := &Response{StatusCode: 400, RejectionReason: ReasonBadMessageID}
+	assert.True(t, badToken.IsBadDeviceToken())
+	assert.False(t, badToken.IsBadMessageID())
+	assert.True(t, badToken.ShouldRemoveToken())
+	assert.True(t, badID.IsBadMessageID())
+	assert.False(t, badID.IsBadDeviceToken())
+	assert.False(t, badID.ShouldRemoveToken())
+	assert.False(t, (&Response{StatusCode: 410, RejectionReason: ReasonUnregistered}).IsBadDeviceToken())
+	assert.False(t, (&Response{StatusCode: 200}).IsBadMessageID())
+}
+
+func TestRegisterReason(t *testing.T) {
+	resp := &Response{StatusCode: 400, RejectionReason: "TestNewReason"}
+	assert.False(t, resp.IsRetriable())
+	RegisterReason("TestNewReason", ReasonClassRetriable)
+	defer func() {
+		reasonsMu.Lock()
+		delete(reasons, "TestNewReason")
+		reasonsMu.Unlock()
+	}()
+	assert.True(t, resp.IsRetriable())
+	class, ok := ReasonClassOf("TestNewReason")
+	assert.True(t, ok)
+	assert.Equal(t, ReasonClassRetriable, class)
+}
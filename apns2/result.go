@@ -4,6 +4,10 @@ package apns2
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
 )
 
 // Result represents the outcome of an asynchronous push operation.
@@ -21,18 +25,106 @@ type Result struct {
 	// push request.
 	Context context.Context
 
+	// ContentType and Tag are the ones supplied in the push request, if any.
+	ContentType string
+	Tag         string
+
 	// Response represents a result from the APN service. If a push operation
 	// fails prior to communicating with APN servers, Response will be nil and
-	// Err field will have a non-nil value.
+	// Err field will have a non-nil value. If a push operation times out,
+	// Response is nil and Err is a *TimeoutError.
 	Response *Response
 
 	// Err, if not nil, is an error encontered while attempting a push.
 	// Note that nil Err does not necessarily indicate a successful attempt.
 	// You must also examine Response for additional status details.
 	Err error
+
+	// CompletedAt is the time at which the push request reached
+	// its final outcome.
+	CompletedAt time.Time
 }
 
 // IsAccepted returns whether or not the notification was accepted by APN service.
 func (r *Result) IsAccepted() bool {
 	return r.Err == nil && r.Response != nil && r.Response.StatusCode == StatusAcccepted
 }
+
+// AttemptEvent describes a single completed push attempt.
+// See ProcCfg.OnAttempt.
+type AttemptEvent struct {
+
+	// Notification is the notification that was pushed.
+	Notification *Notification
+
+	// Response is the response from APN service, if any.
+	Response *Response
+
+	// Err, if not nil, is an error encontered during the attempt.
+	Err error
+
+	// Attempt is the ordinal number of the attempt, starting with 1.
+	Attempt int
+
+	// Latency is the time from sending the request to receiving
+	// the response.
+	Latency time.Duration
+
+	// WillRetry indicates whether the push will be reattempted.
+	WillRetry bool
+}
+
+// RequestMeta describes a request that is about to be sent to APN service.
+// It carries no device tokens or payloads, making it safe for audit logs.
+// See CommsCfg.OnSend.
+type RequestMeta struct {
+
+	// Time at which the request is being sent.
+	Time time.Time
+
+	// TokenHash is hex-encoded SHA-256 hash of the device token, or empty
+	// for broadcast notifications. See TokenHash.
+	TokenHash string
+
+	// ChannelID is the broadcast channel identifier, if any.
+	ChannelID string
+
+	Topic    string
+	PushType PushType
+	ApnsID   string
+
+	// PayloadSize is the size of the request body as sent.
+	PayloadSize int64
+
+	// Attempt is the ordinal number of the attempt, starting with 1.
+	Attempt int
+
+	// Gateway is the APN service gateway the request is sent to.
+	Gateway string
+}
+
+// TokenHash returns hex-encoded SHA-256 hash of device token.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRequestMeta(req *Request, httpReq *http.Request, gateway string) RequestMeta {
+	n := req.Notification
+	res := RequestMeta{
+		Time:        time.Now(),
+		ApnsID:      n.ApnsID,
+		PayloadSize: httpReq.ContentLength,
+		Attempt:     req.attemptCnt + 1,
+		Gateway:     gateway,
+	}
+	if !n.IsBroadcast() {
+		res.TokenHash = TokenHash(n.Recipient)
+	}
+	if h := n.Header; h != nil {
+		res.ChannelID = h.ChannelID
+		res.Topic = h.Topic
+		res.PushType = h.PushType
+	}
+	return res
+}
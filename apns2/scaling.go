@@ -0,0 +1,106 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+	"time"
+)
+
+// ScaleDirection is the direction of a scaling event.
+type ScaleDirection int
+
+const (
+	// ScaleUp indicates an increase in the number of connections.
+	ScaleUp ScaleDirection = 1
+
+	// ScaleDown indicates a decrease in the number of connections.
+	ScaleDown ScaleDirection = -1
+)
+
+func (d ScaleDirection) String() string {
+	switch d {
+	case ScaleUp:
+		return "up"
+	case ScaleDown:
+		return "down"
+	}
+	return "none"
+}
+
+// Scaling reasons reported in ScaleEvent.
+const (
+	// ScaleReasonInitial is reported when the initial MinConns
+	// connections are being established.
+	ScaleReasonInitial = "initial"
+
+	// ScaleReasonBlocking is reported when scaling up in response
+	// to sustained blocking on the inbound channel.
+	ScaleReasonBlocking = "inbound blocking"
+
+	// ScaleReasonIdle is reported when winding down in response
+	// to sustained absence of blocking on the inbound channel.
+	ScaleReasonIdle = "inbound idle"
+
+	// ScaleReasonMaxConns is reported when winding down in response
+	// to MaxConns being lowered below the current number of connections.
+	ScaleReasonMaxConns = "MaxConns lowered"
+
+	// ScaleReasonFailover is reported when connections are being
+	// established with a fallback gateway, or back with the primary one.
+	// See CommsCfg.FallbackEndpoints.
+	ScaleReasonFailover = "failover"
+)
+
+// ScaleEvent describes a single scaling decision made by the governor.
+type ScaleEvent struct {
+
+	// Time at which the decision was made.
+	Time time.Time
+
+	// From is the number of connections, established and pending,
+	// before scaling.
+	From int
+
+	// To is the target number of connections.
+	To int
+
+	// Direction of scaling.
+	Direction ScaleDirection
+
+	// Reason is a short description of what triggered the scaling.
+	Reason string
+}
+
+// ScaleRecorder is a ready-made in-memory recorder of scaling events.
+// Its Record method can be assigned to ProcCfg.OnScale to capture
+// the scaling timeline of a test or a load experiment.
+// The zero value is ready to use.
+type ScaleRecorder struct {
+	mu     sync.Mutex
+	events []ScaleEvent
+}
+
+// Record appends a copy of the event to the recorded timeline.
+func (r *ScaleRecorder) Record(e *ScaleEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, *e)
+	r.mu.Unlock()
+}
+
+// Events returns a copy of all recorded events in the order
+// in which they occurred.
+func (r *ScaleRecorder) Events() []ScaleEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]ScaleEvent, len(r.events))
+	copy(res, r.events)
+	return res
+}
+
+// Reset discards all recorded events.
+func (r *ScaleRecorder) Reset() {
+	r.mu.Lock()
+	r.events = nil
+	r.mu.Unlock()
+}
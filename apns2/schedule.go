@@ -0,0 +1,160 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// scheduler holds requests with future NotBefore times, as well as retries
+// that are being backed off, and releases them into the processing pipeline
+// once they are due.
+type scheduler struct {
+	id      string
+	c       *Client
+	mu      sync.Mutex
+	reqs    requestHeap
+	stopped bool
+	wake    chan struct{}
+}
+
+func newScheduler(c *Client) *scheduler {
+	return &scheduler{
+		id:   c.Id + "-Scheduler",
+		c:    c,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (s *scheduler) add(req *Request) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		s.fail(req)
+		return
+	}
+	heap.Push(&s.reqs, req)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns the number of requests awaiting release
+// and the earliest release time, or zero time if there are none.
+func (s *scheduler) pending() (int, time.Time) {
+	if s == nil {
+		return 0, time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reqs) == 0 {
+		return 0, time.Time{}
+	}
+	return len(s.reqs), s.reqs[0].releaseTime()
+}
+
+// due removes and returns the earliest request if it is due at now.
+// Otherwise it returns nil and the time at which the earliest request
+// becomes due, or zero time if there are no requests.
+func (s *scheduler) due(now time.Time) (*Request, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reqs) == 0 {
+		return nil, time.Time{}
+	}
+	if next := s.reqs[0].releaseTime(); next.After(now) {
+		return nil, next
+	}
+	return heap.Pop(&s.reqs).(*Request), time.Time{}
+}
+
+func (s *scheduler) run(ctl <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	logInfo(s.id, "Running.")
+	tmr := time.NewTimer(time.Hour)
+	tmr.Stop()
+	for {
+		req, next := s.due(time.Now())
+		for req != nil {
+			if err := s.c.submit(req); err != nil {
+				// Interrupted. Scheduled requests are already admitted.
+				// Keep going so that the rest of the due ones are dropped
+				// too and the timer is armed for the earliest remaining one.
+				s.c.drop(req, err)
+			}
+			req, next = s.due(time.Now())
+		}
+		if !next.IsZero() {
+			tmr.Reset(next.Sub(time.Now()))
+		}
+		select {
+		case <-tmr.C:
+		case <-s.wake:
+			if !tmr.Stop() && !next.IsZero() {
+				// drain fired timer
+				select {
+				case <-tmr.C:
+				default:
+				}
+			}
+		case <-ctl:
+			tmr.Stop()
+			s.abandon()
+			logInfo(s.id, "Stopped.")
+			return
+		}
+	}
+}
+
+// abandon fails all requests awaiting release with ErrPushInterrupted.
+// Retries are released right away instead, as accepted requests are
+// processed to completion.
+func (s *scheduler) abandon() {
+	s.mu.Lock()
+	reqs := s.reqs
+	s.reqs = nil
+	s.stopped = true
+	s.mu.Unlock()
+	if len(reqs) > 0 {
+		logWarn(s.id, "Abandoning %d scheduled requests.", len(reqs))
+	}
+	for _, req := range reqs {
+		s.fail(req)
+	}
+}
+
+func (s *scheduler) fail(req *Request) {
+	if !req.retryAt.IsZero() {
+		req.retryAt = time.Time{}
+		if err := s.c.submit(req); err != nil {
+			s.c.drop(req, err)
+		}
+		return
+	}
+	s.c.decPending()
+	s.c.reject(req, ErrPushInterrupted)
+}
+
+// requestHeap is a min-heap of requests ordered by NotBefore time.
+type requestHeap []*Request
+
+func (h requestHeap) Len() int           { return len(h) }
+func (h requestHeap) Less(i, j int) bool { return h[i].releaseTime().Before(h[j].releaseTime()) }
+func (h requestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Request))
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	res := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return res
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerDue(t *testing.T) {
+	s := newScheduler(&Client{})
+	now := time.Now()
+	r1 := &Request{NotBefore: now.Add(2 * time.Second)}
+	r2 := &Request{NotBefore: now.Add(time.Second)}
+	r3 := &Request{NotBefore: now.Add(3 * time.Second)}
+	s.add(r1)
+	s.add(r2)
+	s.add(r3)
+	n, first := s.pending()
+	assert.Equal(t, 3, n)
+	assert.Equal(t, r2.NotBefore, first)
+	req, next := s.due(now)
+	assert.Nil(t, req)
+	assert.Equal(t, r2.NotBefore, next)
+	req, _ = s.due(now.Add(2 * time.Second))
+	assert.True(t, req == r2)
+	req, _ = s.due(now.Add(2 * time.Second))
+	assert.True(t, req == r1)
+	req, next = s.due(now.Add(2 * time.Second))
+	assert.Nil(t, req)
+	assert.Equal(t, r3.NotBefore, next)
+	// abandoned requests are failed
+	cb := make(chan *Result, 2)
+	s.c.ctl = make(chan struct{})
+	s.add(&Request{NotBefore: now, Callback: cb})
+	// r3 and the one just added, as if admitted by submit
+	s.c.pendingCnt = 2
+	s.abandon()
+	assert.Equal(t, ErrPushInterrupted, (<-cb).Err)
+	assert.Equal(t, int64(0), s.c.pendingCnt)
+	// added after stop
+	s.c.pendingCnt = 1
+	s.add(&Request{NotBefore: now, Callback: cb})
+	assert.Equal(t, ErrPushInterrupted, (<-cb).Err)
+	assert.Equal(t, int64(0), s.c.pendingCnt)
+	n, first = s.pending()
+	assert.Equal(t, 0, n)
+	assert.True(t, first.IsZero())
+}
+
+func TestSchedulerRetries(t *testing.T) {
+	c := &Client{
+		ctl: make(chan struct{}),
+		out: make(chan *Request, 2),
+		gov: &governor{},
+	}
+	s := newScheduler(c)
+	now := time.Now()
+	r1 := &Request{NotBefore: now.Add(time.Second)}
+	r2 := &Request{NotBefore: now.Add(-time.Second), retryAt: now.Add(2 * time.Second), isAdmitted: true}
+	s.add(r1)
+	s.add(r2)
+	// retry time takes precedence
+	req, _ := s.due(now.Add(time.Second))
+	assert.True(t, req == r1)
+	_, next := s.due(now.Add(time.Second))
+	assert.Equal(t, r2.retryAt, next)
+	// retries are released upon stop
+	s.add(r1)
+	s.abandon()
+	assert.True(t, <-c.out == r2)
+	assert.True(t, r2.retryAt.IsZero())
+	assert.Len(t, c.out, 0)
+	r3 := &Request{retryAt: now.Add(time.Second), isAdmitted: true}
+	s.add(r3)
+	assert.True(t, <-c.out == r3)
+}
+
+func TestSchedulerInterrupted(t *testing.T) {
+	cb := make(chan *Result, 2)
+	c := &Client{
+		ctl: make(chan struct{}),
+		out: make(chan *Request),
+		gov: &governor{},
+	}
+	s := newScheduler(c)
+	c.pendingCnt = 3
+	s.add(&Request{NotBefore: time.Now(), Callback: cb, isAdmitted: true})
+	s.add(&Request{NotBefore: time.Now(), Callback: cb, isAdmitted: true})
+	later := &Request{NotBefore: time.Now().Add(time.Hour), isAdmitted: true}
+	s.add(later)
+	// Nothing reads c.out, so the releases are interrupted by the stop.
+	close(c.ctl)
+	ctl := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.run(ctl, &wg)
+	// All due requests are dropped without waiting for a wake-up.
+	for i := 0; i < 2; i++ {
+		select {
+		case res := <-cb:
+			assert.Equal(t, ErrPushInterrupted, res.Err)
+		case <-time.After(time.Second):
+			t.Fatal("Should have dropped all due requests")
+		}
+	}
+	n, first := s.pending()
+	assert.Equal(t, 1, n)
+	assert.Equal(t, later.NotBefore, first)
+	close(ctl)
+	wg.Wait()
+	assert.Equal(t, int64(0), c.pendingCnt)
+}
+
+func TestClient_RetryBackOffs(t *testing.T) {
+	var attempts int32
+	var mu sync.Mutex
+	var times [2]time.Time
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&attempts, 1)
+			if n <= 2 {
+				mu.Lock()
+				times[n-1] = time.Now()
+				mu.Unlock()
+			}
+			if n == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"reason":"TooManyRequests"}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 1
+	c.ProcCfg.RetryEval = DefaultRetryEval
+	c.ProcCfg.RetryBackOffs = map[ReasonClass]RetryBackOff{
+		ReasonClassThrottled: {Base: 100 * time.Millisecond},
+		ReasonClassRetriable: {Base: time.Hour},
+	}
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, times[1].Sub(times[0]) >= 100*time.Millisecond)
+}
+
+func TestClient_RetryBackOffFunc(t *testing.T) {
+	var attempts int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"reason":"TooManyRequests"}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 1
+	c.ProcCfg.RetryEval = DefaultRetryEval
+	// The func takes precedence over RetryBackOffs.
+	c.ProcCfg.RetryBackOffs = map[ReasonClass]RetryBackOff{
+		ReasonClassThrottled: {Base: time.Hour},
+	}
+	calls := make(chan [2]uint32, 1)
+	c.ProcCfg.RetryBackOffFunc = func(attempt uint32, reason ReasonClass) time.Duration {
+		calls <- [2]uint32{attempt, uint32(reason)}
+		return 50 * time.Millisecond
+	}
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	start := time.Now()
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, [2]uint32{1, uint32(ReasonClassThrottled)}, <-calls)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestClient_MaxRetryAge(t *testing.T) {
+	var attempts int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"reason":"TooManyRequests"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 10
+	c.ProcCfg.RetryEval = DefaultRetryEval
+	c.ProcCfg.MaxRetryAge = 300 * time.Millisecond
+	c.ProcCfg.RetryBackOffs = map[ReasonClass]RetryBackOff{
+		ReasonClassThrottled: {Base: 200 * time.Millisecond},
+	}
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	start := time.Now()
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.Equal(t, ErrRetryExpired, r.Err)
+	// the second retry would not have been made in time
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().DroppedRequests[DropReasonRetryExpired])
+}
+
+func TestClient_NotBefore(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	queue := make(chan *Request)
+	c.Queue = queue
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	notBefore := time.Now().Add(100 * time.Millisecond)
+	queue <- &Request{Notification: testNotif_Good, Callback: cb, NotBefore: notBefore}
+	// handed over to the scheduler asynchronously
+	var st Stats
+	for i := 0; i < 50 && st.ScheduledPending == 0; i++ {
+		time.Sleep(time.Millisecond)
+		st = c.Stats()
+	}
+	assert.Equal(t, 1, st.ScheduledPending)
+	assert.True(t, notBefore.Equal(st.NextRelease))
+	r := <-cb
+	assert.False(t, time.Now().Before(notBefore))
+	st = c.Stats()
+	assert.Equal(t, 0, st.ScheduledPending)
+	assert.True(t, st.NextRelease.IsZero())
+	if assert.NotNil(t, r.Response) {
+		assert.Equal(t, 200, r.Response.StatusCode)
+	}
+}
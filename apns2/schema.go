@@ -0,0 +1,351 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PayloadSchemaError indicates that an encoded notification payload does
+// not conform to ProcCfg.PayloadSchema. Such notifications are failed
+// without being sent to APN service.
+type PayloadSchemaError struct {
+
+	// Path is the JSON pointer to the offending value, such as
+	// "/aps/alert/title". It is empty for the payload as a whole.
+	Path string
+
+	// Reason describes the violation.
+	Reason string
+}
+
+func (e *PayloadSchemaError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("apns2: payload does not conform to schema at %s: %s", path, e.Reason)
+}
+
+// PayloadSchema is a compiled JSON schema that encoded notification payloads
+// can be validated against. A subset of JSON Schema validation keywords
+// is supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum and maximum. Annotation keywords, such as title and
+// description, are ignored. Schemas using any other keywords are rejected
+// by CompilePayloadSchema.
+//
+// PayloadSchema is safe for use in concurrent goroutines.
+type PayloadSchema struct {
+	root *schemaNode
+}
+
+// CompilePayloadSchema compiles the JSON schema document for use
+// in ProcCfg.PayloadSchema.
+func CompilePayloadSchema(schema []byte) (*PayloadSchema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return nil, fmt.Errorf("apns2: invalid payload schema: %v", err)
+	}
+	root, err := compileSchemaNode(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadSchema{root: root}, nil
+}
+
+// Validate checks the encoded payload against the schema. It returns
+// a *PayloadSchemaError describing the first violation found, if any.
+func (s *PayloadSchema) Validate(payload []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return &PayloadSchemaError{Reason: "malformed JSON: " + err.Error()}
+	}
+	return s.root.validate(v, "")
+}
+
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+type schemaNode struct {
+	types        []string
+	enum         []interface{}
+	properties   map[string]*schemaNode
+	required     []string
+	noAdditional bool
+	additional   *schemaNode
+	items        *schemaNode
+	minItems     int
+	maxItems     int
+	minLength    int
+	maxLength    int
+	pattern      *regexp.Regexp
+	minimum      *float64
+	maximum      *float64
+}
+
+func compileSchemaNode(doc interface{}, path string) (*schemaNode, error) {
+	bad := func(format string, args ...interface{}) error {
+		at := path
+		if at == "" {
+			at = "/"
+		}
+		return fmt.Errorf("apns2: invalid payload schema at %s: %s", at, fmt.Sprintf(format, args...))
+	}
+	if b, ok := doc.(bool); ok {
+		// true accepts anything, false accepts nothing
+		res := &schemaNode{minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+		if !b {
+			res.enum = []interface{}{}
+		}
+		return res, nil
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, bad("schema must be an object or a boolean")
+	}
+	res := &schemaNode{minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	count := func(k string) (int, error) {
+		f, ok := m[k].(float64)
+		if !ok || f < 0 || f != math.Trunc(f) {
+			return 0, bad("%s must be a non-negative integer", k)
+		}
+		return int(f), nil
+	}
+	for k, v := range m {
+		var err error
+		switch k {
+		case "type":
+			switch t := v.(type) {
+			case string:
+				res.types = []string{t}
+			case []interface{}:
+				for _, e := range t {
+					s, ok := e.(string)
+					if !ok {
+						return nil, bad("type must be a string or an array of strings")
+					}
+					res.types = append(res.types, s)
+				}
+			default:
+				return nil, bad("type must be a string or an array of strings")
+			}
+			for _, t := range res.types {
+				switch t {
+				case "object", "array", "string", "number", "integer", "boolean", "null":
+				default:
+					return nil, bad("unknown type %q", t)
+				}
+			}
+		case "enum":
+			e, ok := v.([]interface{})
+			if !ok {
+				return nil, bad("enum must be an array")
+			}
+			res.enum = e
+		case "const":
+			res.enum = []interface{}{v}
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, bad("properties must be an object")
+			}
+			res.properties = make(map[string]*schemaNode, len(props))
+			for name, p := range props {
+				if res.properties[name], err = compileSchemaNode(p, path+"/properties/"+escapeJSONPointer(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			req, ok := v.([]interface{})
+			if !ok {
+				return nil, bad("required must be an array of strings")
+			}
+			for _, e := range req {
+				s, ok := e.(string)
+				if !ok {
+					return nil, bad("required must be an array of strings")
+				}
+				res.required = append(res.required, s)
+			}
+		case "additionalProperties":
+			if b, ok := v.(bool); ok {
+				res.noAdditional = !b
+			} else if res.additional, err = compileSchemaNode(v, path+"/additionalProperties"); err != nil {
+				return nil, err
+			}
+		case "items":
+			if res.items, err = compileSchemaNode(v, path+"/items"); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			res.minItems, err = count(k)
+		case "maxItems":
+			res.maxItems, err = count(k)
+		case "minLength":
+			res.minLength, err = count(k)
+		case "maxLength":
+			res.maxLength, err = count(k)
+		case "pattern":
+			s, ok := v.(string)
+			if !ok {
+				return nil, bad("pattern must be a string")
+			}
+			if res.pattern, err = regexp.Compile(s); err != nil {
+				return nil, bad("malformed pattern: %v", err)
+			}
+		case "minimum", "maximum":
+			f, ok := v.(float64)
+			if !ok {
+				return nil, bad("%s must be a number", k)
+			}
+			if k == "minimum" {
+				res.minimum = &f
+			} else {
+				res.maximum = &f
+			}
+		default:
+			if !schemaAnnotations[k] {
+				return nil, bad("unsupported keyword %q", k)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (n *schemaNode) validate(v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &PayloadSchemaError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+	if len(n.types) > 0 && !n.isOfType(v) {
+		return fail("expected %s, got %s", strings.Join(n.types, " or "), jsonTypeOf(v))
+	}
+	if n.enum != nil {
+		found := false
+		for _, e := range n.enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range n.required {
+			if _, ok := t[k]; !ok {
+				return fail("missing required property %q", k)
+			}
+		}
+		// sorted for deterministic error reporting
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "/" + escapeJSONPointer(k)
+			if sn, ok := n.properties[k]; ok {
+				if err := sn.validate(t[k], p); err != nil {
+					return err
+				}
+			} else if n.noAdditional {
+				return fail("property %q is not allowed", k)
+			} else if n.additional != nil {
+				if err := n.additional.validate(t[k], p); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if n.minItems >= 0 && len(t) < n.minItems {
+			return fail("expected at least %d items, got %d", n.minItems, len(t))
+		}
+		if n.maxItems >= 0 && len(t) > n.maxItems {
+			return fail("expected at most %d items, got %d", n.maxItems, len(t))
+		}
+		if n.items != nil {
+			for i, e := range t {
+				if err := n.items.validate(e, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		l := utf8.RuneCountInString(t)
+		if n.minLength >= 0 && l < n.minLength {
+			return fail("expected at least %d characters, got %d", n.minLength, l)
+		}
+		if n.maxLength >= 0 && l > n.maxLength {
+			return fail("expected at most %d characters, got %d", n.maxLength, l)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(t) {
+			return fail("value does not match pattern %q", n.pattern.String())
+		}
+	case float64:
+		if n.minimum != nil && t < *n.minimum {
+			return fail("expected at least %v, got %v", *n.minimum, t)
+		}
+		if n.maximum != nil && t > *n.maximum {
+			return fail("expected at most %v, got %v", *n.maximum, t)
+		}
+	}
+	return nil
+}
+
+func (n *schemaNode) isOfType(v interface{}) bool {
+	actual := jsonTypeOf(v)
+	for _, t := range n.types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf returns JSON schema type name of a decoded JSON value.
+// Whole numbers are reported as integers.
+func jsonTypeOf(v interface{}) string {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapeJSONPointer(s string) string {
+	return jsonPointerEscaper.Replace(s)
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayloadSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "Alert payload",
+	"type": "object",
+	"required": ["aps"],
+	"properties": {
+		"aps": {
+			"type": "object",
+			"required": ["alert"],
+			"properties": {
+				"alert": {
+					"type": ["string", "object"],
+					"minLength": 1,
+					"maxLength": 10,
+					"properties": {"title": {"type": "string"}}
+				},
+				"badge": {"type": "integer", "minimum": 0, "maximum": 99},
+				"sound": {"enum": ["default", "ping.aiff"]}
+			},
+			"additionalProperties": false
+		},
+		"ids": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2},
+		"a/b": {"const": true}
+	}
+}`
+
+func TestPayloadSchema(t *testing.T) {
+	s, err := CompilePayloadSchema([]byte(testPayloadSchema))
+	if !assert.NoError(t, err) {
+		return
+	}
+	tcs := []struct {
+		payload string
+		path    string
+	}{
+		{`{"aps":{"alert":"Ping!"}}`, ""},
+		{`{"aps":{"alert":{"title":"Hi"},"badge":3,"sound":"default"},"ids":["a","b"],"a/b":true,"x":1}`, ""},
+		{`[]`, "/"},
+		{`{}`, "/"},
+		{`{"aps":{"alert":""}}`, "/aps/alert"},
+		{`{"aps":{"alert":"Ping! Ping! Ping!"}}`, "/aps/alert"},
+		{`{"aps":{"alert":{"title":1}}}`, "/aps/alert/title"},
+		{`{"aps":{"alert":7}}`, "/aps/alert"},
+		{`{"aps":{"alert":"Ping!","badge":1.5}}`, "/aps/badge"},
+		{`{"aps":{"alert":"Ping!","badge":100}}`, "/aps/badge"},
+		{`{"aps":{"alert":"Ping!","sound":"loud"}}`, "/aps/sound"},
+		{`{"aps":{"alert":"Ping!","category":"c"}}`, "/aps"},
+		{`{"aps":{"alert":"Ping!"},"ids":["a","B"]}`, "/ids/1"},
+		{`{"aps":{"alert":"Ping!"},"ids":["a","b","c"]}`, "/ids"},
+		{`{"aps":{"alert":"Ping!"},"a/b":false}`, "/a~1b"},
+		{`{"aps":`, "/"},
+	}
+	for _, tc := range tcs {
+		err := s.Validate([]byte(tc.payload))
+		if tc.path == "" {
+			assert.NoError(t, err, tc.payload)
+			continue
+		}
+		if assert.IsType(t, &PayloadSchemaError{}, err, tc.payload) {
+			p := err.(*PayloadSchemaError).Path
+			if p == "" {
+				p = "/"
+			}
+			assert.Equal(t, tc.path, p, tc.payload)
+		}
+	}
+}
+
+func TestCompilePayloadSchema(t *testing.T) {
+	for _, sch := range []string{
+		`true`,
+		`{}`,
+		`{"properties": {"x": false}}`,
+	} {
+		_, err := CompilePayloadSchema([]byte(sch))
+		assert.NoError(t, err, sch)
+	}
+	for _, sch := range []string{
+		``,
+		`1`,
+		`{"type": "thing"}`,
+		`{"type": 1}`,
+		`{"maxLength": -1}`,
+		`{"pattern": "("}`,
+		`{"properties": {"x": {"oneOf": []}}}`,
+		`{"required": [1]}`,
+	} {
+		_, err := CompilePayloadSchema([]byte(sch))
+		assert.Error(t, err, sch)
+	}
+	s, _ := CompilePayloadSchema([]byte(`{"properties": {"x": false}}`))
+	assert.NoError(t, s.Validate([]byte(`{"y":1}`)))
+	assert.Error(t, s.Validate([]byte(`{"x":1}`)))
+}
+
+func TestClient_PayloadSchema(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	sch, err := CompilePayloadSchema([]byte(`{"properties": {"aps": {"required": ["sound"]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ProcCfg.PayloadSchema = sch
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	res := <-cb
+	if assert.IsType(t, &PayloadSchemaError{}, res.Err) {
+		assert.Equal(t, "/aps", res.Err.(*PayloadSchemaError).Path)
+	}
+	assert.Nil(t, res.Response)
+	assert.Equal(t, uint64(1), c.Stats().DroppedRequests[DropReasonSchema])
+	// the connection is kept
+	n := *testNotif_Good
+	n.Payload = []byte(`{"aps":{"alert":"Ping!","sound":"default"}}`)
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, (<-cb).IsAccepted())
+	assert.Equal(t, uint32(1), c.Stats().Conns)
+}
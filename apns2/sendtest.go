@@ -0,0 +1,61 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+)
+
+// SendTest sends a single alert notification with the given message
+// to the device identified by token and waits for its result. It is meant
+// for smoke tests, such as verifying credentials and connectivity in CI.
+//
+// The notification is sent through a one-shot client that copies c's
+// gateway, communication and processing settings, and credentials.
+// c itself is not used and need not be started. Topic may be left empty
+// if c authenticates with a certificate that has a single topic.
+//
+// The one-shot client is stopped and its connections are closed before
+// SendTest returns. If ctx is done before the result is available,
+// the client is terminated and ctx's error is returned.
+func (c *Client) SendTest(ctx context.Context, token, topic, message string) (*Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	id := c.Id
+	if len(id) == 0 {
+		id = "Client"
+	}
+	callback := make(chan *Result, 1)
+	oc := &Client{
+		Id:             id + "-Test",
+		Gateway:        c.Gateway,
+		CommsCfg:       c.CommsCfg,
+		ProcCfg:        c.ProcCfg,
+		Certificate:    c.Certificate,
+		RootCA:         c.RootCA,
+		Signer:         c.Signer,
+		PayloadEncoder: c.PayloadEncoder,
+		Callback:       callback,
+	}
+	if err := oc.Start(nil); err != nil {
+		return nil, err
+	}
+	n := &Notification{
+		Recipient: token,
+		Header:    &Header{Topic: topic},
+		Payload:   &Payload{APS: &APS{Alert: message}},
+	}
+	if err := oc.Push(n, DefaultSigner, ctx, DefaultCallback); err != nil {
+		oc.Kill()
+		return nil, err
+	}
+	select {
+	case res := <-callback:
+		oc.Stop()
+		return res, nil
+	case <-ctx.Done():
+		oc.Kill()
+		return nil, ctx.Err()
+	}
+}
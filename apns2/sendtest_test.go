@@ -0,0 +1,37 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_SendTest(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	res, err := c.SendTest(context.Background(), testNotif_Good.Recipient, "com.example.Alert", "Ping!")
+	assert.Nil(t, err)
+	if assert.NotNil(t, res) {
+		assert.True(t, res.IsAccepted())
+		assert.Equal(t, testNotif_Good.Recipient, res.Notification.Recipient)
+	}
+	res, err = c.SendTest(context.Background(), testNotif_BadDevice.Recipient, "com.example.Alert", "Ping!")
+	assert.Nil(t, err)
+	if assert.NotNil(t, res) && assert.NotNil(t, res.Response) {
+		assert.Equal(t, ReasonBadDeviceToken, res.Response.RejectionReason)
+	}
+	// the template client is not started
+	assert.Nil(t, c.Done())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = c.SendTest(ctx, testNotif_Good.Recipient, "com.example.Alert", "Ping!")
+	if err == nil {
+		assert.False(t, res.IsAccepted())
+	}
+}
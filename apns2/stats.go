@@ -0,0 +1,837 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/baobabus/go-apns/funit"
+)
+
+// Stats is a snapshot of Client's processing statistics.
+type Stats struct {
+
+	// Conns is the number of active connections to APN service.
+	Conns uint32
+
+	// Goroutines is the number of goroutines of client's streamers,
+	// pending streamer launches and retry forwarders. It excludes
+	// short-lived goroutines of individual roundtrips and a handful
+	// of goroutines that exist for client's entire lifetime.
+	// See ProcCfg.MaxGoroutines.
+	Goroutines int
+
+	// SaturatedAtMax is true if the client is saturated at MaxConns,
+	// i.e. demand exceeds the capacity allowed by MaxConns.
+	// See ProcCfg.OnSaturated.
+	SaturatedAtMax bool
+
+	// ScalingPressure is a continuous measure of how strongly the client
+	// is pushed to scale, in units of ProcCfg.MinSustain. Positive values
+	// reflect sustained inbound blocking while connections keep up,
+	// negative values reflect sustained absence of inbound blocking.
+	// The client scales up at 1 or more, unless capped, and winds down
+	// at -1 or less. Zero if there is no evidence either way.
+	ScalingPressure float64
+
+	// Retries is the number of push attempts that have been resubmitted
+	// for another attempt.
+	Retries uint64
+
+	// ScheduledPending is the number of requests held back until their
+	// NotBefore time, including retries being backed off as specified
+	// by ProcCfg.RetryBackOffs.
+	ScheduledPending int
+
+	// NextRelease is the time at which the earliest of ScheduledPending
+	// requests is due for release, or zero time if there are none.
+	NextRelease time.Time
+
+	// DroppedReceipts is the number of delivery receipts that were dropped
+	// because the receipt emitter could not keep up.
+	DroppedReceipts uint64
+
+	// DroppedRequests holds the number of push requests that left
+	// the processing pipeline without a response from APN service,
+	// segmented by drop reason. See DropReasonQuota and other
+	// DropReason constants.
+	DroppedRequests map[string]uint64
+
+	// CollapseIDs holds the number of notifications sent per collapse ID.
+	// Only the most recently used collapse IDs are included, as limited
+	// by ProcCfg.CollapseIDTrackSize. It is nil if collapse ID tracking
+	// is disabled.
+	CollapseIDs map[string]uint64
+
+	// Tags holds aggregate outcomes of push requests per request tag.
+	// Requests with no tag are not included.
+	Tags map[string]TagStats
+
+	// Attempts holds the distribution of final outcomes of push requests
+	// by the number of attempts made.
+	Attempts AttemptStats
+
+	// Dials holds the distribution of the time it takes to stand up
+	// new connections to APN service.
+	Dials DialStats
+
+	// GoAways is the number of GOAWAY frames received from APN service.
+	// APN service routinely cycles connections, but a spike may signal
+	// a problem.
+	GoAways uint64
+
+	// GoAwayRate is the rate of GOAWAY frames received from APN service
+	// over the past minute.
+	GoAwayRate funit.Measure
+
+	// RecentStatus holds the distribution of response status codes
+	// received from APN service over the past ProcCfg.StatusWindow.
+	RecentStatus StatusWindowStats
+
+	// ConnReuse holds the number of push requests served by connections
+	// over their lifetime. Only connections that have been closed
+	// are included.
+	ConnReuse ConnReuseStats
+
+	// SettleWindows is the number of times the governor entered a settle
+	// period following a scaling event.
+	SettleWindows uint64
+
+	// SettleTime is the cumulative time the governor spent in settle
+	// periods, during which no further scaling is evaluated. A settle
+	// period is considered to last for the duration of scale-up settle
+	// period, or until the next scaling event, whichever comes first.
+	SettleTime time.Duration
+}
+
+// TagStats holds aggregate outcomes of push requests sharing the same tag.
+// Each request is counted once, upon its final outcome, regardless
+// of the number of attempts made.
+type TagStats struct {
+
+	// Accepted is the number of notifications accepted by APN service.
+	Accepted uint64
+
+	// Failed is the number of notifications that were rejected
+	// or could not be delivered.
+	Failed uint64
+}
+
+// AttemptStats is a histogram of final outcomes of push requests
+// by the number of attempts made. It helps judging whether MaxRetries
+// is set appropriately.
+type AttemptStats struct {
+
+	// Accepted maps attempt number, starting from 1, to the number
+	// of notifications accepted by APN service on that attempt.
+	Accepted map[int]uint64
+
+	// Exhausted is the number of notifications that were given up on
+	// after a retriable failure because their retries were exhausted.
+	Exhausted uint64
+}
+
+// DialBuckets are the upper bounds of DialStats histogram buckets.
+var DialBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// DialStats is a histogram of durations of successful streamer launches,
+// from the start of the launch to the streamer becoming active, which
+// includes dialing and TLS handshake. It is distinct from request
+// round-trip latency and reflects how quickly the client can scale up.
+type DialStats struct {
+
+	// Counts holds the number of launches per DialBuckets bucket.
+	// The last element counts launches that took longer than
+	// the largest bucket bound.
+	Counts []uint64
+
+	// Count is the total number of successful launches.
+	Count uint64
+
+	// Failed is the number of failed launches, which are not
+	// included in the histogram.
+	Failed uint64
+
+	// Sum and Max are the total and the longest launch durations.
+	Sum time.Duration
+	Max time.Duration
+}
+
+// Percentile returns the upper bound of the bucket in which q-th quantile,
+// 0 < q <= 1, of launch durations falls. Max is returned for launches
+// beyond the largest bucket bound, and 0 if there have been no launches.
+func (s DialStats) Percentile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range s.Counts {
+		n += c
+		if n >= rank && i < len(DialBuckets) {
+			return DialBuckets[i]
+		}
+	}
+	return s.Max
+}
+
+// ConnReuseStats describes how many push requests connections to APN
+// service serve before being closed. Low reuse suggests that connections
+// are dying prematurely, while high reuse confirms healthy long-lived
+// connections.
+type ConnReuseStats struct {
+
+	// Conns is the number of closed connections.
+	Conns uint64
+
+	// Served is the total number of push requests served by them.
+	Served uint64
+
+	// Min and Max are the lowest and the highest numbers of push requests
+	// served by a single connection.
+	Min uint64
+	Max uint64
+}
+
+// StatusWindowStats holds the distribution of response status codes
+// over a sliding window. Unlike lifetime totals, it reveals sudden changes,
+// such as a spike in 429 responses.
+type StatusWindowStats struct {
+
+	// Window is the length of time the counts are taken over.
+	Window time.Duration
+
+	// Counts holds the number of responses per HTTP status code.
+	Counts map[int]uint64
+
+	// Rates holds the rate of responses per HTTP status code.
+	Rates map[int]funit.Measure
+}
+
+// Mean returns the average number of push requests served by a connection,
+// or 0 if no connections have been closed.
+func (s ConnReuseStats) Mean() float64 {
+	if s.Conns == 0 {
+		return 0
+	}
+	return float64(s.Served) / float64(s.Conns)
+}
+
+// Stats returns a snapshot of client's processing statistics.
+// It is safe to call Stats at any time, including before the client
+// is started and after it is stopped.
+func (c *Client) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	settleWindows, settleTime := c.settleTracker.totals(time.Now())
+	var goroutines int
+	var pressure float64
+	if c.gov != nil {
+		goroutines = c.gov.goroutines()
+		pressure = math.Float64frombits(atomic.LoadUint64(&c.gov.pressure))
+	}
+	scheduled, nextRelease := c.sched.pending()
+	goAways, goAwayRate := c.goAwayTracker.counts(time.Now())
+	recentStatus := c.statusTracker.counts(time.Now())
+	return Stats{
+		Conns:            atomic.LoadUint32(&c.connCnt),
+		ScheduledPending: scheduled,
+		NextRelease:      nextRelease,
+		Goroutines:       goroutines,
+		SaturatedAtMax:   c.CheckCapacity() != nil,
+		ScalingPressure:  pressure,
+		Retries:          atomic.LoadUint64(&c.retryCnt),
+		DroppedReceipts:  c.receipts.droppedCount(),
+		DroppedRequests:  c.dropTracker.counts(),
+		CollapseIDs:      c.collapseTracker.counts(),
+		Tags:             c.tagTracker.counts(),
+		Attempts:         c.attemptTracker.counts(),
+		Dials:            c.dialTracker.counts(),
+		ConnReuse:        c.reuseTracker.counts(),
+		GoAways:          goAways,
+		GoAwayRate:       goAwayRate,
+		RecentStatus:     recentStatus,
+		SettleWindows:    settleWindows,
+		SettleTime:       settleTime,
+	}
+}
+
+// ResetStats zeroes client's lifetime statistics counters, such as
+// Retries, DroppedReceipts, DroppedRequests, CollapseIDs, Tags, Attempts,
+// Dials, ConnReuse, GoAways, RecentStatus and settle time. It is intended
+// for per-campaign reporting with a long-lived client.
+// Gauges, such as Conns, and the ProcCfg.MaxTotal quota count
+// are not affected. Neither are connections to APN service.
+func (c *Client) ResetStats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	atomic.StoreUint64(&c.retryCnt, 0)
+	c.receipts.resetDropped()
+	c.dropTracker.reset()
+	c.collapseTracker.reset()
+	c.tagTracker.reset()
+	c.attemptTracker.reset()
+	c.dialTracker.reset()
+	c.reuseTracker.reset()
+	c.goAwayTracker.reset()
+	c.statusTracker.reset()
+	c.settleTracker.reset()
+}
+
+// tagTracker counts push request outcomes per request tag.
+// Nil tagTracker is valid and tracks nothing.
+type tagTracker struct {
+	mu   sync.Mutex
+	tags map[string]TagStats
+}
+
+func newTagTracker() *tagTracker {
+	return &tagTracker{tags: make(map[string]TagStats)}
+}
+
+func (t *tagTracker) record(tag string, accepted bool) {
+	if t == nil || tag == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts := t.tags[tag]
+	if accepted {
+		ts.Accepted++
+	} else {
+		ts.Failed++
+	}
+	t.tags[tag] = ts
+}
+
+func (t *tagTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags = make(map[string]TagStats)
+}
+
+func (t *tagTracker) counts() map[string]TagStats {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[string]TagStats, len(t.tags))
+	for k, v := range t.tags {
+		res[k] = v
+	}
+	return res
+}
+
+// attemptTracker counts final outcomes of push requests by the number
+// of attempts made. Nil attemptTracker is valid and tracks nothing.
+type attemptTracker struct {
+	mu        sync.Mutex
+	accepted  map[int]uint64
+	exhausted uint64
+}
+
+func newAttemptTracker() *attemptTracker {
+	return &attemptTracker{accepted: make(map[int]uint64)}
+}
+
+// record accounts for a request reaching its final outcome on the
+// specified attempt. Failures other than exhausted retries are ignored.
+func (t *attemptTracker) record(attempt int, accepted bool, exhausted bool) {
+	if t == nil || !accepted && !exhausted {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if accepted {
+		t.accepted[attempt]++
+	} else {
+		t.exhausted++
+	}
+}
+
+func (t *attemptTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.accepted = make(map[int]uint64)
+	t.exhausted = 0
+}
+
+func (t *attemptTracker) counts() AttemptStats {
+	if t == nil {
+		return AttemptStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := AttemptStats{
+		Accepted:  make(map[int]uint64, len(t.accepted)),
+		Exhausted: t.exhausted,
+	}
+	for k, v := range t.accepted {
+		res.Accepted[k] = v
+	}
+	return res
+}
+
+// Drop reasons reported in Stats.DroppedRequests.
+const (
+	// DropReasonQuota is reported for requests turned away
+	// once ProcCfg.MaxTotal quota has been reached.
+	DropReasonQuota = "quota exceeded"
+
+	// DropReasonShutdown is reported for requests abandoned
+	// because the client was shutting down.
+	DropReasonShutdown = "shutdown"
+
+	// DropReasonCanceled is reported for requests whose context
+	// was canceled before a response was received.
+	DropReasonCanceled = "canceled"
+
+	// DropReasonDeadline is reported for requests whose context deadline
+	// elapsed before a response was received.
+	DropReasonDeadline = "deadline exceeded"
+
+	// DropReasonTimeout is reported for requests that timed out
+	// in transit. APN service may have delivered them nonetheless.
+	DropReasonTimeout = "timeout"
+
+	// DropReasonAuth is reported for requests that could not be
+	// authenticated, as with no client certificate and no signer.
+	DropReasonAuth = "no authentication"
+
+	// DropReasonDeviceToken is reported for requests with malformed
+	// device tokens.
+	DropReasonDeviceToken = "malformed device token"
+
+	// DropReasonRetryOverflow is reported for retries that could not
+	// be handed back to the processing pipeline. See ProcCfg.RetryOverflow.
+	DropReasonRetryOverflow = "retry overflow"
+
+	// DropReasonCollapsed is reported for notifications dropped or
+	// superseded within ProcCfg.CollapseIDMinInterval.
+	DropReasonCollapsed = "collapsed"
+
+	// DropReasonRetryExpired is reported for requests that were no longer
+	// retried once ProcCfg.MaxRetryAge had elapsed.
+	DropReasonRetryExpired = "retry expired"
+
+	// DropReasonSchema is reported for notifications whose payloads
+	// do not conform to ProcCfg.PayloadSchema.
+	DropReasonSchema = "schema violation"
+
+	// DropReasonTransport is reported for requests that failed
+	// with any other error, such as a connection error.
+	DropReasonTransport = "transport error"
+)
+
+// dropReason returns the drop reason for a request that failed with err,
+// or an empty string if err is nil.
+func dropReason(err error) string {
+	switch err {
+	case nil:
+		return ""
+	case ErrQuotaExceeded:
+		return DropReasonQuota
+	case ErrPushInterrupted, ErrClientClosing, ErrClientNotRunning:
+		return DropReasonShutdown
+	case ErrCanceled, context.Canceled:
+		return DropReasonCanceled
+	case context.DeadlineExceeded:
+		return DropReasonDeadline
+	case ErrMissingAuth:
+		return DropReasonAuth
+	case ErrRetryOverflow:
+		return DropReasonRetryOverflow
+	case ErrCollapsed:
+		return DropReasonCollapsed
+	case ErrRetryExpired:
+		return DropReasonRetryExpired
+	}
+	if _, ok := err.(*DeviceTokenError); ok {
+		return DropReasonDeviceToken
+	}
+	if _, ok := err.(*PayloadSchemaError); ok {
+		return DropReasonSchema
+	}
+	if isTimeout(err) {
+		return DropReasonTimeout
+	}
+	return DropReasonTransport
+}
+
+// dropTracker counts dropped requests per drop reason.
+// Nil dropTracker is valid and tracks nothing.
+type dropTracker struct {
+	mu      sync.Mutex
+	reasons map[string]uint64
+}
+
+func newDropTracker() *dropTracker {
+	return &dropTracker{reasons: make(map[string]uint64)}
+}
+
+// record accounts for a request that failed with err.
+// Nil err is ignored.
+func (t *dropTracker) record(err error) {
+	if t == nil || err == nil {
+		return
+	}
+	reason := dropReason(err)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reasons[reason]++
+}
+
+func (t *dropTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reasons = make(map[string]uint64)
+}
+
+func (t *dropTracker) counts() map[string]uint64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[string]uint64, len(t.reasons))
+	for k, v := range t.reasons {
+		res[k] = v
+	}
+	return res
+}
+
+// dialTracker accumulates the distribution of streamer launch durations.
+// Nil dialTracker is valid and tracks nothing.
+type dialTracker struct {
+	mu    sync.Mutex
+	stats DialStats
+}
+
+func newDialTracker() *dialTracker {
+	return &dialTracker{stats: DialStats{Counts: make([]uint64, len(DialBuckets)+1)}}
+}
+
+// record accounts for a launch that took d and failed if failed is true.
+func (t *dialTracker) record(d time.Duration, failed bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if failed {
+		t.stats.Failed++
+		return
+	}
+	i := sort.Search(len(DialBuckets), func(i int) bool { return d <= DialBuckets[i] })
+	t.stats.Counts[i]++
+	t.stats.Count++
+	t.stats.Sum += d
+	if d > t.stats.Max {
+		t.stats.Max = d
+	}
+}
+
+func (t *dialTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = DialStats{Counts: make([]uint64, len(DialBuckets)+1)}
+}
+
+func (t *dialTracker) counts() DialStats {
+	if t == nil {
+		return DialStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.stats
+	res.Counts = append([]uint64(nil), t.stats.Counts...)
+	return res
+}
+
+// reuseTracker accumulates the numbers of push requests served
+// by closed connections.
+// Nil reuseTracker is valid and tracks nothing.
+type reuseTracker struct {
+	mu    sync.Mutex
+	stats ConnReuseStats
+}
+
+// record accounts for a closed connection that served n requests.
+func (t *reuseTracker) record(n uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stats.Conns == 0 || n < t.stats.Min {
+		t.stats.Min = n
+	}
+	if n > t.stats.Max {
+		t.stats.Max = n
+	}
+	t.stats.Conns++
+	t.stats.Served += n
+}
+
+func (t *reuseTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = ConnReuseStats{}
+}
+
+func (t *reuseTracker) counts() ConnReuseStats {
+	if t == nil {
+		return ConnReuseStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// goAwayRateWindow is the period over which GOAWAY rate is evaluated.
+const goAwayRateWindow = 60
+
+// goAwayTracker counts GOAWAY frames in total and per second
+// over the past goAwayRateWindow seconds.
+// Nil goAwayTracker is valid and tracks nothing.
+type goAwayTracker struct {
+	mu     sync.Mutex
+	total  uint64
+	perSec [goAwayRateWindow]uint64
+	secs   [goAwayRateWindow]int64
+}
+
+func (t *goAwayTracker) record(at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sec := at.Unix()
+	i := sec % goAwayRateWindow
+	if t.secs[i] != sec {
+		t.secs[i] = sec
+		t.perSec[i] = 0
+	}
+	t.perSec[i]++
+	t.total++
+}
+
+func (t *goAwayTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = 0
+	t.perSec = [goAwayRateWindow]uint64{}
+	t.secs = [goAwayRateWindow]int64{}
+}
+
+// counts returns the total number of GOAWAY frames and their rate
+// over the past goAwayRateWindow seconds as of now.
+func (t *goAwayTracker) counts(now time.Time) (uint64, funit.Measure) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n uint64
+	sec := now.Unix()
+	for i, s := range t.secs {
+		if sec-s < goAwayRateWindow && s <= sec {
+			n += t.perSec[i]
+		}
+	}
+	return t.total, funit.Measure(n) / goAwayRateWindow
+}
+
+// settleTracker accumulates time spent in governor's settle periods.
+// Nil settleTracker is valid and tracks nothing.
+type settleTracker struct {
+	mu      sync.Mutex
+	windows uint64
+	total   time.Duration
+	start   time.Time
+	end     time.Time
+}
+
+// enter records the start of a settle period of the specified duration.
+// A settle period that is still in effect is cut short.
+func (t *settleTracker) enter(now time.Time, period time.Duration) {
+	if t == nil || period <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total += t.elapsed(now)
+	t.windows++
+	t.start = now
+	t.end = now.Add(period)
+}
+
+// reset zeroes the totals. Time spent so far in a settle period that
+// is still in effect is discarded, but the rest of it will be counted.
+func (t *settleTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.windows = 0
+	t.total = 0
+	if !t.start.IsZero() {
+		now := time.Now()
+		if t.end.After(now) {
+			t.start = now
+		} else {
+			t.start = time.Time{}
+		}
+	}
+}
+
+func (t *settleTracker) totals(now time.Time) (uint64, time.Duration) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.windows, t.total + t.elapsed(now)
+}
+
+// elapsed returns the time spent so far in the most recent settle period.
+func (t *settleTracker) elapsed(now time.Time) time.Duration {
+	if t.start.IsZero() {
+		return 0
+	}
+	if t.end.Before(now) {
+		return t.end.Sub(t.start)
+	}
+	return now.Sub(t.start)
+}
+
+// maxStatusBuckets caps the number of buckets of statusTracker's window.
+const maxStatusBuckets = 1024
+
+// statusTracker counts response status codes over a sliding window
+// made up of a ring of buckets, each covering one polling interval.
+// Nil statusTracker is valid and tracks nothing.
+type statusTracker struct {
+	width   time.Duration
+	mu      sync.Mutex
+	slots   []int64
+	buckets []map[int]uint64
+}
+
+func newStatusTracker(window, interval time.Duration) *statusTracker {
+	if window <= 0 {
+		return nil
+	}
+	if interval <= 0 || interval > window {
+		interval = window
+	}
+	n := int((window + interval - 1) / interval)
+	if n > maxStatusBuckets {
+		n = maxStatusBuckets
+		interval = (window + maxStatusBuckets - 1) / maxStatusBuckets
+	}
+	return &statusTracker{
+		width:   interval,
+		slots:   make([]int64, n),
+		buckets: make([]map[int]uint64, n),
+	}
+}
+
+func (t *statusTracker) slot(at time.Time) int64 {
+	return at.UnixNano() / int64(t.width)
+}
+
+func (t *statusTracker) record(code int, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot := t.slot(at)
+	i := int(slot % int64(len(t.slots)))
+	if t.slots[i] != slot || t.buckets[i] == nil {
+		t.slots[i] = slot
+		t.buckets[i] = make(map[int]uint64)
+	}
+	t.buckets[i][code]++
+}
+
+func (t *statusTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.slots {
+		t.slots[i] = 0
+		t.buckets[i] = nil
+	}
+}
+
+// counts returns the distribution of status codes over the window
+// ending with the bucket that holds now.
+func (t *statusTracker) counts(now time.Time) StatusWindowStats {
+	if t == nil {
+		return StatusWindowStats{}
+	}
+	window := t.width * time.Duration(len(t.slots))
+	res := StatusWindowStats{
+		Window: window,
+		Counts: make(map[int]uint64),
+		Rates:  make(map[int]funit.Measure),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	slot := t.slot(now)
+	n := int64(len(t.slots))
+	for i, s := range t.slots {
+		if t.buckets[i] == nil || s > slot || slot-s >= n {
+			continue
+		}
+		for code, cnt := range t.buckets[i] {
+			res.Counts[code] += cnt
+		}
+	}
+	for code, cnt := range res.Counts {
+		res.Rates[code] = funit.Measure(float64(cnt) / window.Seconds())
+	}
+	return res
+}
@@ -0,0 +1,307 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apns/funit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettleTracker(t *testing.T) {
+	var nilTracker *settleTracker
+	nilTracker.enter(time.Now(), time.Second)
+	n, d := nilTracker.totals(time.Now())
+	assert.Equal(t, uint64(0), n)
+	assert.Equal(t, time.Duration(0), d)
+
+	tr := &settleTracker{}
+	t0 := time.Now()
+	n, d = tr.totals(t0)
+	assert.Equal(t, uint64(0), n)
+	assert.Equal(t, time.Duration(0), d)
+	// no settle period configured
+	tr.enter(t0, 0)
+	n, _ = tr.totals(t0)
+	assert.Equal(t, uint64(0), n)
+	// in progress
+	tr.enter(t0, 10*time.Second)
+	n, d = tr.totals(t0.Add(4 * time.Second))
+	assert.Equal(t, uint64(1), n)
+	assert.Equal(t, 4*time.Second, d)
+	// elapsed in full
+	n, d = tr.totals(t0.Add(20 * time.Second))
+	assert.Equal(t, uint64(1), n)
+	assert.Equal(t, 10*time.Second, d)
+	// next one cuts the previous one short
+	t1 := t0.Add(30 * time.Second)
+	tr.enter(t1, 10*time.Second)
+	tr.enter(t1.Add(3*time.Second), 10*time.Second)
+	n, d = tr.totals(t1.Add(5 * time.Second))
+	assert.Equal(t, uint64(3), n)
+	assert.Equal(t, 15*time.Second, d)
+}
+
+func TestAttemptTracker(t *testing.T) {
+	var nilTracker *attemptTracker
+	nilTracker.record(1, true, false)
+	assert.Equal(t, AttemptStats{}, nilTracker.counts())
+
+	tr := newAttemptTracker()
+	tr.record(1, true, false)
+	tr.record(1, true, false)
+	tr.record(3, true, false)
+	tr.record(4, false, true)
+	// non-retriable failures are not counted
+	tr.record(1, false, false)
+	st := tr.counts()
+	assert.Equal(t, map[int]uint64{1: 2, 3: 1}, st.Accepted)
+	assert.Equal(t, uint64(1), st.Exhausted)
+}
+
+func TestDropReason(t *testing.T) {
+	assert.Equal(t, "", dropReason(nil))
+	assert.Equal(t, DropReasonQuota, dropReason(ErrQuotaExceeded))
+	assert.Equal(t, DropReasonShutdown, dropReason(ErrPushInterrupted))
+	assert.Equal(t, DropReasonCanceled, dropReason(ErrCanceled))
+	assert.Equal(t, DropReasonCanceled, dropReason(context.Canceled))
+	assert.Equal(t, DropReasonDeadline, dropReason(context.DeadlineExceeded))
+	assert.Equal(t, DropReasonAuth, dropReason(ErrMissingAuth))
+	assert.Equal(t, DropReasonDeviceToken, dropReason(&DeviceTokenError{Token: "x"}))
+	assert.Equal(t, DropReasonSchema, dropReason(&PayloadSchemaError{Reason: "x"}))
+	assert.Equal(t, DropReasonTimeout, dropReason(&net.DNSError{IsTimeout: true}))
+	assert.Equal(t, DropReasonTransport, dropReason(errors.New("connection reset")))
+}
+
+func TestDropTracker(t *testing.T) {
+	var nilTracker *dropTracker
+	nilTracker.record(ErrCanceled)
+	assert.Nil(t, nilTracker.counts())
+
+	tr := newDropTracker()
+	tr.record(nil)
+	tr.record(ErrCanceled)
+	tr.record(ErrCanceled)
+	tr.record(ErrQuotaExceeded)
+	assert.Equal(t, map[string]uint64{DropReasonCanceled: 2, DropReasonQuota: 1}, tr.counts())
+	tr.reset()
+	assert.Len(t, tr.counts(), 0)
+}
+
+func TestDialTracker(t *testing.T) {
+	var nilTracker *dialTracker
+	nilTracker.record(time.Second, false)
+	assert.Equal(t, DialStats{}, nilTracker.counts())
+	assert.Equal(t, time.Duration(0), nilTracker.counts().Percentile(0.5))
+
+	tr := newDialTracker()
+	for i := 0; i < 8; i++ {
+		tr.record(40*time.Millisecond, false)
+	}
+	tr.record(time.Second, false)
+	tr.record(time.Minute, false)
+	tr.record(time.Second, true)
+	st := tr.counts()
+	assert.Equal(t, uint64(10), st.Count)
+	assert.Equal(t, uint64(1), st.Failed)
+	assert.Equal(t, time.Minute, st.Max)
+	assert.Equal(t, 61*time.Second+320*time.Millisecond, st.Sum)
+	assert.Equal(t, []uint64{8, 0, 0, 0, 1, 0, 0, 0, 1}, st.Counts)
+	assert.Equal(t, 50*time.Millisecond, st.Percentile(0.5))
+	assert.Equal(t, 50*time.Millisecond, st.Percentile(0.8))
+	assert.Equal(t, time.Second, st.Percentile(0.9))
+	assert.Equal(t, time.Minute, st.Percentile(0.99))
+	// snapshot is not affected by further recording
+	tr.record(time.Millisecond, false)
+	assert.Equal(t, uint64(8), st.Counts[0])
+	tr.reset()
+	assert.Equal(t, uint64(0), tr.counts().Count)
+	assert.Len(t, tr.counts().Counts, len(DialBuckets)+1)
+}
+
+func TestReuseTracker(t *testing.T) {
+	var nilTracker *reuseTracker
+	nilTracker.record(5)
+	assert.Equal(t, ConnReuseStats{}, nilTracker.counts())
+	assert.Equal(t, float64(0), nilTracker.counts().Mean())
+
+	tr := &reuseTracker{}
+	tr.record(10)
+	tr.record(0)
+	tr.record(20)
+	assert.Equal(t, ConnReuseStats{Conns: 3, Served: 30, Min: 0, Max: 20}, tr.counts())
+	assert.Equal(t, float64(10), tr.counts().Mean())
+	tr.reset()
+	tr.record(7)
+	assert.Equal(t, ConnReuseStats{Conns: 1, Served: 7, Min: 7, Max: 7}, tr.counts())
+}
+
+func TestGoAwayTracker(t *testing.T) {
+	var nilTracker *goAwayTracker
+	nilTracker.record(time.Now())
+	n, rate := nilTracker.counts(time.Now())
+	assert.Equal(t, uint64(0), n)
+	assert.Equal(t, funit.Measure(0), rate)
+
+	tr := &goAwayTracker{}
+	t0 := time.Unix(1000, 0)
+	tr.record(t0)
+	tr.record(t0.Add(500 * time.Millisecond))
+	tr.record(t0.Add(30 * time.Second))
+	n, rate = tr.counts(t0.Add(30 * time.Second))
+	assert.Equal(t, uint64(3), n)
+	assert.Equal(t, funit.Measure(3)/60, rate)
+	// older ones fall out of the window, but not out of the total
+	n, rate = tr.counts(t0.Add(70 * time.Second))
+	assert.Equal(t, uint64(3), n)
+	assert.Equal(t, funit.Measure(1)/60, rate)
+	// bucket reuse
+	tr.record(t0.Add(60 * time.Second))
+	n, rate = tr.counts(t0.Add(60 * time.Second))
+	assert.Equal(t, uint64(4), n)
+	assert.Equal(t, funit.Measure(2)/60, rate)
+	tr.reset()
+	n, rate = tr.counts(t0.Add(60 * time.Second))
+	assert.Equal(t, uint64(0), n)
+	assert.Equal(t, funit.Measure(0), rate)
+}
+
+func TestStatusTracker(t *testing.T) {
+	var nilTracker *statusTracker
+	nilTracker.record(200, time.Now())
+	assert.Equal(t, StatusWindowStats{}, nilTracker.counts(time.Now()))
+	assert.Nil(t, newStatusTracker(0, time.Second))
+
+	tr := newStatusTracker(time.Minute, 10*time.Second)
+	assert.Len(t, tr.slots, 6)
+	t0 := time.Unix(1000, 0)
+	tr.record(200, t0)
+	tr.record(200, t0.Add(5*time.Second))
+	tr.record(429, t0.Add(30*time.Second))
+	st := tr.counts(t0.Add(30 * time.Second))
+	assert.Equal(t, time.Minute, st.Window)
+	assert.Equal(t, map[int]uint64{200: 2, 429: 1}, st.Counts)
+	assert.Equal(t, funit.Measure(1)/60, st.Rates[429])
+	// older ones fall out of the window
+	st = tr.counts(t0.Add(65 * time.Second))
+	assert.Equal(t, map[int]uint64{429: 1}, st.Counts)
+	// bucket reuse
+	tr.record(429, t0.Add(60*time.Second))
+	st = tr.counts(t0.Add(60 * time.Second))
+	assert.Equal(t, map[int]uint64{429: 2}, st.Counts)
+	tr.reset()
+	assert.Len(t, tr.counts(t0.Add(60*time.Second)).Counts, 0)
+	// bucket count is capped
+	tr = newStatusTracker(time.Hour, time.Millisecond)
+	assert.Len(t, tr.slots, maxStatusBuckets)
+	assert.True(t, tr.width*maxStatusBuckets >= time.Hour)
+}
+
+func TestClient_RecentStatus(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.StatusWindow = time.Minute
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 2)
+	c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+	c.Push(testNotif_BadDevice, DefaultSigner, NoContext, cb)
+	<-cb
+	<-cb
+	st := c.Stats().RecentStatus
+	assert.Equal(t, uint64(1), st.Counts[StatusAcccepted])
+	assert.Equal(t, uint64(1), st.Counts[http.StatusBadRequest])
+	assert.Equal(t, time.Minute, st.Window)
+}
+
+func TestClient_ConnReuse(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	evs := make(chan *StreamerEvent, 100)
+	c.StreamerEvents = evs
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 3)
+	for i := 0; i < 3; i++ {
+		err = c.Push(testNotif_Good, DefaultSigner, NoContext, cb)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		<-cb
+	}
+	ds, err := c.DumpState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, ds.Streamers, 1) {
+		assert.Equal(t, uint64(3), ds.Streamers[0].Served)
+	}
+	c.Stop()
+	assert.Equal(t, ConnReuseStats{Conns: 1, Served: 3, Min: 3, Max: 3}, c.Stats().ConnReuse)
+	var exited *StreamerEvent
+	for done := false; !done; {
+		select {
+		case ev := <-evs:
+			if ev.Phase == StreamerExited {
+				exited = ev
+			}
+		default:
+			done = true
+		}
+	}
+	if assert.NotNil(t, exited) {
+		assert.Equal(t, uint64(3), exited.Served)
+	}
+}
+
+func TestClient_ResetStats(t *testing.T) {
+	c := &Client{
+		connCnt:         2,
+		retryCnt:        5,
+		collapseTracker: newCollapseTracker("test", 10, 0),
+		tagTracker:      newTagTracker(),
+		attemptTracker:  newAttemptTracker(),
+		dropTracker:     newDropTracker(),
+		settleTracker:   &settleTracker{},
+	}
+	c.collapseTracker.add("A")
+	c.tagTracker.record("T", true)
+	c.attemptTracker.record(1, true, false)
+	c.dropTracker.record(ErrCanceled)
+	c.settleTracker.enter(time.Now().Add(-time.Minute), time.Second)
+	st := c.Stats()
+	assert.Equal(t, uint64(5), st.Retries)
+	assert.Len(t, st.CollapseIDs, 1)
+	assert.Len(t, st.Tags, 1)
+	assert.Len(t, st.Attempts.Accepted, 1)
+	assert.Len(t, st.DroppedRequests, 1)
+	assert.Equal(t, uint64(1), st.SettleWindows)
+	assert.Equal(t, time.Second, st.SettleTime)
+	c.ResetStats()
+	st = c.Stats()
+	assert.Equal(t, uint32(2), st.Conns)
+	assert.Equal(t, uint64(0), st.Retries)
+	assert.Len(t, st.CollapseIDs, 0)
+	assert.Len(t, st.Tags, 0)
+	assert.Len(t, st.Attempts.Accepted, 0)
+	assert.Len(t, st.DroppedRequests, 0)
+	assert.Equal(t, uint64(0), st.SettleWindows)
+	assert.Equal(t, time.Duration(0), st.SettleTime)
+	// not started
+	(&Client{}).ResetStats()
+}
@@ -0,0 +1,205 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchStrategy decides which streamer a push request is handed to.
+// By default, when ProcCfg.DispatchStrategy is nil, all streamers read
+// push requests from a single shared channel, and it is up to Go runtime
+// which of the idle streamers picks up the next request. Specifying
+// a strategy replaces the shared channel with a dispatcher that hands each
+// request to the streamer selected by the strategy.
+//
+// The same strategy may be used by multiple clients concurrently.
+type DispatchStrategy interface {
+
+	// Pick returns the index of the streamer the request should be handed
+	// to. loads holds the number of in-flight requests of each of the
+	// currently active streamers and is never empty. Streamers are listed
+	// in the order they were started. Index values outside of loads range
+	// are wrapped around.
+	//
+	// If the selected streamer is not ready to accept the request,
+	// the dispatcher waits for it, unless the set of active streamers
+	// changes in the meantime, in which case Pick is called again.
+	Pick(req *Request, loads []int) int
+}
+
+// NewRoundRobinDispatch returns a DispatchStrategy that hands requests
+// to active streamers in turn.
+func NewRoundRobinDispatch() DispatchStrategy {
+	return &roundRobinDispatch{}
+}
+
+type roundRobinDispatch struct {
+	next uint32
+}
+
+func (d *roundRobinDispatch) Pick(req *Request, loads []int) int {
+	return int((atomic.AddUint32(&d.next, 1) - 1) % uint32(len(loads)))
+}
+
+// LeastLoadedDispatch is a DispatchStrategy that hands each request
+// to the streamer with the fewest in-flight requests.
+var LeastLoadedDispatch DispatchStrategy = leastLoadedDispatch{}
+
+type leastLoadedDispatch struct{}
+
+func (leastLoadedDispatch) Pick(req *Request, loads []int) int {
+	res := 0
+	for i, l := range loads {
+		if l < loads[res] {
+			res = i
+		}
+	}
+	return res
+}
+
+// NewPartitionedDispatch returns a DispatchStrategy that hands all requests
+// with the same key to the same streamer for as long as the number of active
+// streamers does not change. This can be used to keep requests for the same
+// device or topic on the same connection.
+func NewPartitionedDispatch(key func(*Request) string) DispatchStrategy {
+	return &partitionedDispatch{key: key}
+}
+
+type partitionedDispatch struct {
+	key func(*Request) string
+}
+
+func (d *partitionedDispatch) Pick(req *Request, loads []int) int {
+	h := fnv.New32a()
+	h.Write([]byte(d.key(req)))
+	return int(h.Sum32() % uint32(len(loads)))
+}
+
+// dispatcher hands requests from the client's outbound channel to individual
+// streamers as directed by the dispatch strategy.
+type dispatcher struct {
+	id       string
+	strategy DispatchStrategy
+
+	mu      sync.Mutex
+	targets []*streamer
+	changed chan struct{}
+	closed  bool
+}
+
+func newDispatcher(id string, strategy DispatchStrategy) *dispatcher {
+	return &dispatcher{
+		id:       id,
+		strategy: strategy,
+		changed:  make(chan struct{}),
+	}
+}
+
+// add makes the streamer eligible for receiving requests. The streamer
+// must have its feed channel set.
+func (d *dispatcher) add(s *streamer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		close(s.feed)
+		return
+	}
+	d.targets = append(d.targets, s)
+	d.notifyLocked()
+}
+
+func (d *dispatcher) remove(s *streamer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, t := range d.targets {
+		if t == s {
+			d.targets = append(d.targets[:i:i], d.targets[i+1:]...)
+			d.notifyLocked()
+			return
+		}
+	}
+}
+
+func (d *dispatcher) notifyLocked() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func (d *dispatcher) snapshot() ([]*streamer, chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.targets, d.changed
+}
+
+// Must be called exactly once. If wg is not nil, wg.Done is called
+// once the dispatcher stops.
+func (d *dispatcher) run(in <-chan *Request, ctl <-chan struct{}, wg *sync.WaitGroup) {
+	logInfo(d.id, "Running.")
+	defer func() {
+		logInfo(d.id, "Stopped.")
+		if wg != nil {
+			wg.Done()
+		}
+	}()
+	for {
+		select {
+		case req, ok := <-in:
+			if !ok {
+				// Soft shutdown: let streamers know there is no more input.
+				d.mu.Lock()
+				d.closed = true
+				for _, t := range d.targets {
+					close(t.feed)
+				}
+				d.targets = nil
+				d.mu.Unlock()
+				return
+			}
+			if !d.dispatch(req, ctl) {
+				return
+			}
+		case <-ctl:
+			return
+		}
+	}
+}
+
+// dispatch hands the request over to a streamer. It returns false
+// if interrupted by ctl.
+func (d *dispatcher) dispatch(req *Request, ctl <-chan struct{}) bool {
+	for {
+		targets, changed := d.snapshot()
+		if len(targets) == 0 {
+			select {
+			case <-changed:
+				continue
+			case <-ctl:
+				return false
+			}
+		}
+		loads := make([]int, len(targets))
+		for i, t := range targets {
+			loads[i] = t.load()
+		}
+		i := d.strategy.Pick(req, loads) % len(targets)
+		if i < 0 {
+			i += len(targets)
+		}
+		t := targets[i]
+		select {
+		case t.feed <- req:
+			return true
+		case <-t.draining:
+			// Winding down or recycling; it may take a while to exit.
+			d.remove(t)
+		case <-t.exited:
+			d.remove(t)
+		case <-changed:
+		case <-ctl:
+			return false
+		}
+	}
+}
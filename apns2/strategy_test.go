@@ -0,0 +1,111 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundRobinDispatch(t *testing.T) {
+	d := NewRoundRobinDispatch()
+	loads := []int{0, 0, 0}
+	var res []int
+	for i := 0; i < 4; i++ {
+		res = append(res, d.Pick(&Request{}, loads))
+	}
+	assert.Equal(t, []int{0, 1, 2, 0}, res)
+}
+
+func TestLeastLoadedDispatch(t *testing.T) {
+	assert.Equal(t, 0, LeastLoadedDispatch.Pick(&Request{}, []int{0}))
+	assert.Equal(t, 2, LeastLoadedDispatch.Pick(&Request{}, []int{5, 3, 1, 4}))
+	assert.Equal(t, 1, LeastLoadedDispatch.Pick(&Request{}, []int{2, 1, 1}))
+}
+
+func TestPartitionedDispatch(t *testing.T) {
+	d := NewPartitionedDispatch(func(r *Request) string { return r.Notification.Recipient })
+	loads := []int{0, 0, 0, 0}
+	a := d.Pick(&Request{Notification: testNotif_Good}, loads)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, a, d.Pick(&Request{Notification: testNotif_Good}, loads))
+	}
+	assert.True(t, a >= 0 && a < len(loads))
+}
+
+func newTestDispatchTarget() *streamer {
+	return &streamer{feed: make(chan *Request), exited: make(chan struct{}), draining: make(chan struct{})}
+}
+
+func TestDispatcher(t *testing.T) {
+	d := newDispatcher("test", NewRoundRobinDispatch())
+	in := make(chan *Request)
+	ctl := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+	go d.run(in, ctl, &wg)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	s1 := newTestDispatchTarget()
+	s2 := newTestDispatchTarget()
+	// waits for a streamer
+	r1 := &Request{}
+	go func() { in <- r1 }()
+	time.Sleep(10 * time.Millisecond)
+	d.add(s1)
+	assert.True(t, <-s1.feed == r1)
+	// exited streamers are skipped
+	d.add(s2)
+	close(s1.exited)
+	for i := 0; i < 3; i++ {
+		r := &Request{}
+		in <- r
+		assert.True(t, <-s2.feed == r)
+	}
+	// draining streamers are skipped even though they have not exited
+	s4 := newTestDispatchTarget()
+	d.add(s4)
+	close(s2.draining)
+	for i := 0; i < 3; i++ {
+		r := &Request{}
+		in <- r
+		assert.True(t, <-s4.feed == r)
+	}
+	// soft shutdown closes feeds
+	close(in)
+	<-done
+	_, ok := <-s4.feed
+	assert.False(t, ok)
+	s3 := newTestDispatchTarget()
+	d.add(s3)
+	_, ok = <-s3.feed
+	assert.False(t, ok)
+}
+
+func TestClient_DispatchStrategy(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.MinConns = 2
+	c.ProcCfg.DispatchStrategy = LeastLoadedDispatch
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := make(chan *Result, 20)
+	for i := 0; i < 20; i++ {
+		if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		assert.True(t, (<-cb).IsAccepted())
+	}
+	assert.Nil(t, c.Stop())
+}
@@ -3,13 +3,17 @@
 package apns2
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apns/http2x"
 	"github.com/baobabus/go-apns/syncx"
 )
 
@@ -27,6 +31,19 @@ type streamer struct {
 
 	warmStart bool
 
+	// feed, if not nil, is the sending side of in, used by the dispatcher
+	feed chan *Request
+	// closed once the streamer stops consuming requests
+	exited chan struct{}
+	// closed as soon as the streamer stops taking new requests, which
+	// may be well before it exits if it drains in-flight roundtrips
+	draining     chan struct{}
+	drainingOnce sync.Once
+
+	// gate, if not nil, holds the streamer back from consuming requests
+	// until it is closed by the governor.
+	gate chan struct{}
+
 	startOnce sync.Once
 	startErr  error
 
@@ -36,10 +53,46 @@ type streamer struct {
 	waitCtr syncx.TickTockCounter
 	// cumulative request sizes in bytes
 	sizeCtr syncx.Counter
+	// number of push requests served by the connection, accessed atomically
+	served uint64
+	// time of the last response from APN service in Unix nanoseconds,
+	// accessed atomically
+	lastResponse int64
+	// time the number of in-flight roundtrips last went up from zero
+	// in Unix nanoseconds, accessed atomically
+	busySince int64
+	// time the streamer started running
+	started time.Time
 
 	// wait group for spawned HTTP/2 roundrips
 	wg sync.WaitGroup
 
+	// tracker of recent attempt failures
+	errTracker *errRateTracker
+
+	// gateway URL the streamer is connected to
+	gateway string
+
+	// closed when the connection is to be gracefully recycled,
+	// see triggerRecycle
+	recycle     chan struct{}
+	recycleOnce sync.Once
+
+	// signaled when the connection is to be abandoned, see quit
+	quitc chan struct{}
+
+	// closed by the governor to wind the streamer down
+	windDown chan struct{}
+	// only accessed by the governor
+	isWindingDown  bool
+	isUnresponsive bool
+
+	// cancel funcs of in-flight roundtrips
+	inFlightMu sync.Mutex
+	inFlight   map[*Request]context.CancelFunc
+	// set when in-flight roundtrips are abandoned, accessed atomically
+	abandoned int32
+
 	didQuit  bool
 	inClosed bool
 }
@@ -47,7 +100,10 @@ type streamer struct {
 func (s *streamer) start(wg *sync.WaitGroup) error {
 	s.startOnce.Do(func() {
 		logInfo(s.id, "Starting.")
-		s.httpClient, s.startErr = NewHTTPClient(s.c.Gateway, s.c.CommsCfg, s.c.Certificate, s.c.RootCA)
+		if s.gateway == "" {
+			s.gateway = s.c.Gateway
+		}
+		s.httpClient, s.startErr = NewHTTPClient(s.gateway, s.c.CommsCfg, s.c.Certificate, s.c.RootCA)
 		if s.startErr != nil {
 			return
 		}
@@ -58,6 +114,9 @@ func (s *streamer) start(wg *sync.WaitGroup) error {
 		s.httpClient.precise = s.gov.cfg.AllowHTTP2Incursion && s.gov.cfg.UsePreciseHTTP2Metrics
 		s.httpClient.pollInt = pollInt
 		s.httpClient.cfgCap = s.c.CommsCfg.MaxConcurrentStreams
+		s.httpClient.ipSlots = s.c.ipSlots
+		s.httpClient.dns = s.c.dnsCache
+		s.httpClient.onGoAway = s.goAway
 		if s.warmStart {
 			// This can also be accomplished by sending a malformed http.Request.
 			// No reflection is required, but it's still a kludge and results
@@ -70,19 +129,78 @@ func (s *streamer) start(wg *sync.WaitGroup) error {
 		if wg != nil {
 			wg.Add(1)
 		}
+		s.recycle = make(chan struct{})
+		s.quitc = make(chan struct{}, 1)
+		s.started = time.Now()
 		go s.run(wg)
 	})
 	return s.startErr
 }
 
 func (s *streamer) run(wg *sync.WaitGroup) {
-	logInfo(s.id, "Running.")
+	logEvent(s.id, LogInfo, LogEventStreamerRunning, s.logFields(), "Running.")
+	gate := s.gate
+	if gate != nil {
+		s.gov.emitStreamerEvent(s.id, StreamerProbing, "", nil)
+	} else {
+		s.gov.emitStreamerEvent(s.id, StreamerActive, "", nil)
+	}
+	var reason string
+	flow := s.c.flowState()
+	if d := s.c.CommsCfg.ResolveInterval; d > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.runResolver(d, stop)
+	}
+	// local prefetch buffer, if any, and tokens for its free slots
+	var buf chan *Request
+	var room chan struct{}
+	stop := make(chan struct{})
+	stopPrefetch := func() {
+		if stop != nil {
+			close(stop)
+			stop = nil
+		}
+	}
+	startPrefetch := func() {
+		if n := s.c.CommsCfg.PrefetchDepth; n > 0 {
+			buf = make(chan *Request, n)
+			room = make(chan struct{}, n)
+			for i := uint32(0); i < n; i++ {
+				room <- struct{}{}
+			}
+			go s.prefetch(buf, room, stop)
+		}
+	}
+	if gate == nil {
+		startPrefetch()
+	}
 	for done := false; !done; {
+		// Reads from nil channel block, so we stay idle until the gate opens
+		// and for as long as the client is paused.
+		in := s.in
+		if buf != nil {
+			in = buf
+		}
+		if gate != nil || flow.paused {
+			in = nil
+		}
 		select {
-		case req, ok := <-s.in:
+		case <-gate:
+			gate = nil
+			startPrefetch()
+			s.gov.emitStreamerEvent(s.id, StreamerActive, "", nil)
+		case <-flow.changed:
+			flow = s.c.flowState()
+		case req, ok := <-in:
+			if ok && room != nil {
+				room <- struct{}{}
+			}
 			if !ok {
 				// soft shutdown - wait for pending roundtrips to complete
-				logInfo(s.id, "Stopping.")
+				reason = StreamerReasonInputClosed
+				logEvent(s.id, LogInfo, LogEventStreamerDraining, s.logFields(LogFieldReason, reason), "Stopping.")
+				s.gov.emitStreamerEvent(s.id, StreamerDraining, reason, nil)
 				// TODO Switch from WaitGroup to channel signal
 				s.wg.Wait()
 				done = true
@@ -90,21 +208,51 @@ func (s *streamer) run(wg *sync.WaitGroup) {
 				break
 			}
 			s.exec(req)
-		case _, ok := <-s.ctl:
-			if ok {
-				// unusable connection
-				s.didQuit = true
-				logInfo(s.id, "Quitting.")
-			} else {
-				// hard shutdown - do not wait for pending roundtrips to complete
-				logInfo(s.id, "Terminating.")
+		case <-s.windDown:
+			reason = StreamerReasonWoundDown
+			logEvent(s.id, LogInfo, LogEventStreamerDraining, s.logFields(LogFieldReason, reason), "Winding down.")
+			s.gov.emitStreamerEvent(s.id, StreamerDraining, reason, nil)
+			stopPrefetch()
+			s.stopIntake()
+			if s.drain(s.gov.cfg.WindDownGrace) {
+				logInfo(s.id, "Abandoned in-flight requests.")
 			}
+			done = true
+		case <-s.recycle:
+			// graceful recycle - let pending roundtrips complete
+			reason = StreamerReasonRecycled
+			logEvent(s.id, LogInfo, LogEventStreamerDraining, s.logFields(LogFieldReason, reason), "Recycling.")
+			s.gov.emitStreamerEvent(s.id, StreamerDraining, reason, nil)
+			stopPrefetch()
+			s.stopIntake()
+			s.wg.Wait()
+			s.didQuit = true
+			done = true
+		case <-s.quitc:
+			// unusable connection
+			s.didQuit = true
+			reason = StreamerReasonQuit
+			logInfo(s.id, "Quitting.")
+			// TODO Cancel pending roundtrips' contexts.
+			done = true
+		case <-s.ctl:
+			// hard shutdown - do not wait for pending roundtrips to complete
+			reason = StreamerReasonTerminated
+			logInfo(s.id, "Terminating.")
 			// TODO Cancel pending roundtrips' contexts.
 			done = true
 		}
 	}
+	stopPrefetch()
+	s.stopIntake()
+	if buf != nil {
+		s.unfetch(buf, reason == StreamerReasonTerminated)
+	}
+	close(s.exited)
 	// This will only have effect if all roundtrips are finished.
 	s.httpClient.Close()
+	served := atomic.LoadUint64(&s.served)
+	s.gov.emitStreamerExited(s.id, reason, served)
 	// read from ctl prevents blocking on done if the governor
 	// was commanded to terminate in the meantime
 	select {
@@ -114,15 +262,22 @@ func (s *streamer) run(wg *sync.WaitGroup) {
 	if wg != nil {
 		wg.Done()
 	}
-	logInfo(s.id, "Stopped.")
+	logEvent(s.id, LogInfo, LogEventStreamerStopped, s.logFields(LogFieldReason, reason, LogFieldServed, served), "Stopped after serving %d requests.", served)
 }
 
 func (s *streamer) exec(req *Request) {
 	logTrace(0, s.id, "Serving %v.", req)
 	if s.c.Certificate == nil && (req.Signer == NoSigner || !s.c.HasSigner() && !req.HasSigner()) {
+		// A held back request may already have its topic slot handed over.
+		s.releaseTopicSlot(req)
 		s.callBack(req, nil, ErrMissingAuth)
 		return
 	}
+	if n := req.Notification; s.c.ProcCfg.ValidateDeviceTokens && n != nil && !n.IsBroadcast() && !IsValidDeviceToken(n.Recipient) {
+		s.releaseTopicSlot(req)
+		s.callBack(req, nil, &DeviceTokenError{Token: n.Recipient})
+		return
+	}
 	hasCtx := req.Context != NoContext
 	canceled := false
 	// TODO Move the below to HTTP/2 stream wait code
@@ -134,6 +289,7 @@ func (s *streamer) exec(req *Request) {
 		}
 	}
 	if canceled {
+		s.releaseTopicSlot(req)
 		s.callBack(req, nil, ErrCanceled)
 		return
 	}
@@ -153,51 +309,406 @@ func (s *streamer) exec(req *Request) {
 			}
 		}
 	}
+	if !s.c.topicLimiter.acquire(req) {
+		// Held back until an in-flight request for the same topic completes.
+		return
+	}
 	// 1. Acquire HTTP/2 stream
 	// This can block and is the primary source of back pressure.
 	st, err := s.httpClient.ReservedStream(cancel)
 	if err != nil {
+		s.releaseTopicSlot(req)
 		s.callBack(req, nil, err)
 		return
 	}
 	// 2. go submit()
 	s.wg.Add(1)
 	go func() {
-		defer st.Close()
 		defer s.wg.Done()
+		sent := time.Now()
 		resp, err := s.submit(req)
-		if err != nil && uint32(req.attemptCnt) < s.gov.cfg.MaxRetries && s.isRetriable(resp, err) {
+		// The stream is done with. It must not stay reserved while
+		// the request is handed over to the retry path, which may block.
+		st.Close()
+		if err == nil && resp != nil {
+			s.c.statusTracker.record(resp.StatusCode, time.Now())
+		}
+		s.releaseTopicSlot(req)
+		// Every completed attempt counts, including ones that are retried.
+		if s.errTracker.record(isConnError(resp, err)) {
+			logEvent(s.id, LogWarn, LogEventStreamerErrorRate, s.logFields(), "Error rate exceeded. Abandoning connection.")
+			s.quit()
+		}
+		if resp != nil {
+			resp.SendLatency = time.Since(sent)
+			if !req.queued.IsZero() {
+				resp.QueueLatency = sent.Sub(req.queued)
+			}
+		}
+		resized := s.resized(req, resp, err)
+		failed := err != nil || resp == nil || !resp.IsAccepted()
+		// Authentication failures are fatal unless fixed by a token refresh.
+		isAuthErr := err == nil && resp != nil && resp.Class() == ReasonClassAuth
+		isSkewed := isAuthErr && s.isClockSkewed(req, resp, sent)
+		reauth := isAuthErr && s.reauth(req, sent)
+		var newID string
+		if err == nil && resp != nil {
+			newID = s.regeneratedApnsID(req, resp)
+		}
+		regen := newID != ""
+		// Roundtrips we canceled upon abandoning the connection while winding
+		// down are retriable, subject to the same limits as other failures.
+		interrupted := s.isInterrupted(req, resp, err)
+		canRetry := failed && !isAuthErr && (interrupted || s.isRetriable(resp, err))
+		exhausted := canRetry && uint32(req.retryCnt()) >= s.maxRetries(req)
+		willRetry := resized != nil || reauth || regen || canRetry && !exhausted
+		if willRetry && isPastDeadline(req, time.Now()) {
+			// The caller is no longer interested, so do not waste another send.
+			willRetry = false
+			resized = nil
+			err = context.DeadlineExceeded
+		}
+		if willRetry && s.gov.cfg.isRetryExpired(req, time.Now()) {
+			willRetry = false
+			resized = nil
+			err = ErrRetryExpired
+		}
+		if s.gov.cfg.OnAttempt != nil {
+			s.gov.cfg.OnAttempt(&AttemptEvent{
+				Notification: req.Notification,
+				Response:     resp,
+				Err:          err,
+				Attempt:      req.attemptCnt + 1,
+				Latency:      time.Since(sent),
+				WillRetry:    willRetry,
+			})
+		}
+		if resized != nil {
+			// Replacement takes over the original request, so it is not new.
+			resized.attemptCnt = req.attemptCnt + 1
+			resized.isResized = true
+			resized.isAdmitted = true
+			resized.hasRetrySlot = req.hasRetrySlot
+			resized.firstQueued = req.firstQueued
+			keepApnsID(resized, req)
+			s.gov.retry <- resized
+			return
+		}
+		if willRetry {
 			req.attemptCnt++
-			// Retry is serviced in a timely manner, so no need to worry about blocking.
-			// There's just a potential issue with retry forwarder stopping reads
-			// due to a signal on its ctl channel with streamers still running.
-			// Forwarder's ctl channel shoulnd't be shared with governor.
+			req.isReauthed = req.isReauthed || reauth
+			if regen {
+				n := *req.Notification
+				n.ApnsID = newID
+				req.Notification = &n
+				req.isRegenerated = true
+			}
+			req.retryAt = time.Time{}
+			if !reauth && !regen {
+				if d := s.retryDelay(req, resp); d > 0 {
+					req.retryAt = time.Now().Add(d)
+				}
+			}
+			atomic.AddUint64(&s.c.retryCnt, 1)
+			// This blocks once all retry forwarders are busy, which holds up
+			// this goroutine but not the streamer, as the stream is released.
 			s.gov.retry <- req
 			return
 		}
+		s.c.attemptTracker.record(req.attemptCnt+1, !failed, exhausted)
+		if resp == nil && err != context.DeadlineExceeded && isTimeout(err) {
+			err = s.timeoutError(req, err)
+		}
 		s.callBack(req, resp, err)
+		if isAuthErr {
+			s.c.setAuthFailure(&AuthError{StatusCode: resp.StatusCode, Reason: resp.RejectionReason, ClockSkewSuspected: isSkewed})
+		} else if resp != nil && resp.IsAccepted() {
+			s.c.setAuthFailure(nil)
+		}
 		if !s.isConnUsable(resp, err) {
-			// Each worker is given its own ctl channel, but we cannot close it here.
-			// Writing to it accomplishes the same thing. Just do not block.
-			var v struct{}
+			s.quit()
+		}
+	}()
+}
+
+// prefetch moves requests from streamer's input to buf, holding one
+// of the room tokens for each buffered request, until the input is
+// closed or stop is closed. buf is closed upon return.
+func (s *streamer) prefetch(buf chan<- *Request, room <-chan struct{}, stop <-chan struct{}) {
+	defer close(buf)
+	flow := s.c.flowState()
+	for {
+		select {
+		case <-room:
+		case <-stop:
+			return
+		}
+		for taken := false; !taken; {
+			in := s.in
+			if flow.paused {
+				in = nil
+			}
 			select {
-			case s.ctl <- v:
-			default:
+			case <-flow.changed:
+				flow = s.c.flowState()
+			case req, ok := <-in:
+				if !ok {
+					return
+				}
+				// Never blocks as a slot is reserved.
+				buf <- req
+				taken = true
+			case <-stop:
+				return
 			}
 		}
+	}
+}
+
+// unfetch resubmits requests left in the prefetch buffer once the streamer
+// stops consuming them. Upon hard shutdown they are abandoned along with
+// all other pending requests.
+func (s *streamer) unfetch(buf <-chan *Request, terminated bool) {
+	var reqs []*Request
+	for req := range buf {
+		if !terminated {
+			reqs = append(reqs, req)
+		}
+	}
+	if len(reqs) > 0 {
+		s.c.resubmit(reqs...)
+		logInfo(s.id, "Resubmitted %d prefetched requests.", len(reqs))
+	}
+}
+
+// logFields returns the fields of streamer's structured log records
+// followed by the given key and value pairs.
+func (s *streamer) logFields(kv ...interface{}) logFields {
+	res := logFields{LogFieldStreamerID: s.id}
+	if !s.started.IsZero() {
+		res[LogFieldConnAge] = time.Since(s.started).Seconds()
+	}
+	if s.httpClient != nil {
+		inFlight, _ := s.httpClient.connState()
+		res[LogFieldInFlight] = inFlight
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		res[kv[i].(string)] = kv[i+1]
+	}
+	return res
+}
+
+// quit signals the streamer to abandon its connection without waiting
+// for pending roundtrips to complete.
+func (s *streamer) quit() {
+	// ctl channel is closed by the governor upon hard stop, so sending
+	// on it from roundtrips that complete afterwards would panic.
+	// A separate channel that is never closed is used instead.
+	select {
+	case s.quitc <- struct{}{}:
+	default:
+	}
+}
+
+// liveness returns the number of requests in flight and the time elapsed
+// since the last response from APN service or since the connection last
+// became busy, whichever is later. Time spent idle with nothing in flight
+// is thus not held against the connection. If neither has happened yet,
+// the time is measured from when the streamer started running.
+func (s *streamer) liveness(now time.Time) (inFlight uint32, idle time.Duration) {
+	last := s.started
+	n := atomic.LoadInt64(&s.lastResponse)
+	if b := atomic.LoadInt64(&s.busySince); b > n {
+		n = b
+	}
+	if n != 0 {
+		last = time.Unix(0, n)
+	}
+	if s.httpClient != nil {
+		inFlight, _ = s.httpClient.connState()
+	}
+	return inFlight, now.Sub(last)
+}
+
+// goAway accounts for a GOAWAY frame received from APN service.
+func (s *streamer) goAway(ev *http2x.FrameEvent) {
+	s.c.goAwayTracker.record(ev.Time)
+	fields := s.logFields("last_stream_id", ev.LastStreamID, "error_code", ev.ErrCode.String())
+	logEvent(s.id, LogInfo, LogEventGoAway, fields, "Received GOAWAY with last stream %d and error code %v.", ev.LastStreamID, ev.ErrCode)
+}
+
+// releaseTopicSlot frees request's topic slot and resubmits a held back
+// request for the same topic, if any.
+func (s *streamer) releaseTopicSlot(req *Request) {
+	if next := s.c.topicLimiter.release(req); next != nil {
+		// Not a retry. It must not be subject to retry limits or dropped.
+		s.c.resubmit(next)
+	}
+}
+
+// load returns the number of in-flight roundtrips.
+func (s *streamer) load() int {
+	s.inFlightMu.Lock()
+	defer s.inFlightMu.Unlock()
+	return len(s.inFlight)
+}
+
+// track registers an in-flight roundtrip so that it can be canceled
+// if the streamer abandons it. The returned release func must be called
+// when the roundtrip is complete.
+func (s *streamer) track(req *Request) (context.Context, func()) {
+	base := req.Context
+	if base == NoContext {
+		base = context.Background()
+	}
+	ctx, cancel := context.WithCancel(base)
+	s.inFlightMu.Lock()
+	if s.inFlight == nil {
+		s.inFlight = make(map[*Request]context.CancelFunc)
+	}
+	if len(s.inFlight) == 0 {
+		atomic.StoreInt64(&s.busySince, time.Now().UnixNano())
+	}
+	s.inFlight[req] = cancel
+	s.inFlightMu.Unlock()
+	return ctx, func() {
+		s.inFlightMu.Lock()
+		delete(s.inFlight, req)
+		s.inFlightMu.Unlock()
+		cancel()
+	}
+}
+
+// drain waits for in-flight roundtrips to complete. If grace is positive
+// and roundtrips are still in flight once it expires, they are canceled
+// and their requests are resubmitted. It returns true if any roundtrips
+// were abandoned.
+func (s *streamer) drain(grace time.Duration) bool {
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
 	}()
+	var expired <-chan time.Time
+	if grace > 0 {
+		tmr := time.NewTimer(grace)
+		defer tmr.Stop()
+		expired = tmr.C
+	}
+	select {
+	case <-finished:
+		return false
+	case <-expired:
+	case <-s.ctl:
+		return false
+	}
+	atomic.StoreInt32(&s.abandoned, 1)
+	s.inFlightMu.Lock()
+	for _, cancel := range s.inFlight {
+		cancel()
+	}
+	s.inFlightMu.Unlock()
+	select {
+	case <-finished:
+	case <-s.ctl:
+	}
+	return true
+}
+
+// stopIntake signals that the streamer takes no more new requests.
+// It is safe to call it more than once.
+func (s *streamer) stopIntake() {
+	s.drainingOnce.Do(func() {
+		if s.draining != nil {
+			close(s.draining)
+		}
+	})
+}
+
+// triggerRecycle initiates graceful recycling of the streamer.
+// It is safe to call it more than once and from concurrent goroutines.
+func (s *streamer) triggerRecycle() {
+	s.recycleOnce.Do(func() { close(s.recycle) })
+}
+
+// resolveMissLimit is the number of consecutive lookups that must miss
+// connection's address before the connection is recycled.
+const resolveMissLimit = 3
+
+// runResolver periodically re-resolves APN service host name and triggers
+// graceful recycling of the streamer if its connection address has been
+// missing from resolveMissLimit consecutive lookups.
+func (s *streamer) runResolver(interval time.Duration, stop <-chan struct{}) {
+	host, _, err := net.SplitHostPort(s.httpClient.addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return
+	}
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+	misses := 0
+	var lastIP net.IP
+	for {
+		select {
+		case <-tkr.C:
+		case <-stop:
+			return
+		}
+		ip := s.httpClient.connAddr()
+		if ip == nil {
+			continue
+		}
+		if !ip.Equal(lastIP) {
+			misses = 0
+			lastIP = ip
+		}
+		ctx, cancel := context.Background(), func() {}
+		if d := s.c.CommsCfg.DialTimeout; d > 0 {
+			ctx, cancel = context.WithTimeout(ctx, d)
+		}
+		ok, err := isAddrResolved(ctx, s.c.dnsCache.lookupIPAddr, host, ip)
+		cancel()
+		if err != nil {
+			logWarn(s.id, "Failed to resolve %s: %v", host, err)
+			continue
+		}
+		if ok {
+			misses = 0
+			continue
+		}
+		if misses++; misses >= resolveMissLimit {
+			logInfo(s.id, "Connection address %v is no longer resolved for %s.", ip, host)
+			s.triggerRecycle()
+			return
+		}
+	}
 }
 
 // Submits request to APN service and returns APN response or an error.
-func (s *streamer) submit(req *Request) (*Response, error) {
-	url := s.c.Gateway + RequestRoot + req.Notification.Recipient
+func (s *streamer) submit(req *Request) (_ *Response, rerr error) {
+	if req.Notification == nil {
+		return nil, &RequestError{ErrMissingNotification}
+	}
+	url := s.gateway + req.Notification.path()
 	httpReq, err := http.NewRequest("POST", url, nil)
 	if err != nil {
 		return nil, &RequestError{err}
 	}
-	if err := req.Notification.write(httpReq); err != nil {
+	if err := req.Notification.write(httpReq, s.c.PayloadEncoder); err != nil {
 		return nil, &RequestError{err}
 	}
+	if sch := s.gov.cfg.PayloadSchema; sch != nil {
+		if body, ok := httpReq.Body.(*sliceReader); ok {
+			if err := sch.Validate(body.buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if ct := req.ContentType; ct != "" {
+		if !IsContentTypeAllowed(ct) {
+			return nil, &RequestError{ErrContentTypeNotAllowed}
+		}
+		httpReq.Header.Set("Content-Type", ct)
+	}
 	signer := req.Signer
 	if signer == nil {
 		signer = s.c.Signer
@@ -207,8 +718,22 @@ func (s *streamer) submit(req *Request) (*Response, error) {
 			return nil, &RequestError{err}
 		}
 	}
-	if req.Context != NoContext {
-		httpReq = httpReq.WithContext(req.Context)
+	ctx, release := s.track(req)
+	defer release()
+	httpReq = httpReq.WithContext(ctx)
+	if f := s.c.CommsCfg.OnSend; f != nil {
+		f(newRequestMeta(req, httpReq, s.gateway))
+	}
+	var capture *CaptureRecord
+	if s.c.captures.sampled(req) {
+		capture = newCaptureRecord(req, httpReq, s.gateway)
+		defer func() {
+			capture.Duration = time.Since(capture.Time)
+			if rerr != nil {
+				capture.Err = rerr.Error()
+			}
+			s.c.captures.put(capture)
+		}()
 	}
 	logTrace(2, s.id, "http.Request: %v\n", httpReq)
 	httpResp, err := s.httpClient.Do(httpReq)
@@ -216,67 +741,352 @@ func (s *streamer) submit(req *Request) (*Response, error) {
 		return nil, err
 	}
 	s.sizeCtr.Add(uint64(estimatedRequestWireSize(httpReq)))
+	atomic.AddUint64(&s.served, 1)
+	atomic.StoreInt64(&s.lastResponse, time.Now().UnixNano())
+	if h := req.Notification.Header; h != nil {
+		s.c.collapseTracker.add(h.CollapseID)
+	}
 	logTrace(2, s.id, "http.Response: %v\n", httpResp)
 	defer httpResp.Body.Close()
 	res := &Response{
 		StatusCode: httpResp.StatusCode,
 		ApnsID:     httpResp.Header.Get("apns-id"),
+		UniqueID:   httpResp.Header.Get("apns-unique-id"),
+	}
+	body, truncated, err := readBody(httpResp.Body, s.c.CommsCfg.maxResponseBodySize())
+	if capture != nil {
+		capture.StatusCode = httpResp.StatusCode
+		capture.ResponseHeader = httpResp.Header
+		capture.ResponseBody = body
 	}
-	decoder := json.NewDecoder(httpResp.Body)
-	if err := decoder.Decode(&res); err != nil && err != io.EOF {
+	if err != nil {
 		return &Response{}, &RequestError{err}
 	}
+	if truncated {
+		logWarn(s.id, "Response body with status %d exceeds %d bytes and is discarded.", res.StatusCode, len(body))
+		res.BodyTruncated = true
+		return res, nil
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &res); err != nil {
+			return &Response{}, &RequestError{err}
+		}
+	}
 	return res, nil
 }
 
 func (s *streamer) callBack(req *Request, resp *Response, err error) {
+	s.c.complete(req)
+	s.gov.releaseRetrySlot(req)
+	s.c.dropTracker.record(err)
+	s.c.tagTracker.record(req.Tag, err == nil && resp != nil && resp.IsAccepted())
+	if s.c.receipts != nil {
+		s.c.receipts.put(newDeliveryReceipt(req, resp, err))
+	}
+	if req.OnComplete != nil {
+		s.runOnComplete(req, resp, err)
+	}
+	tgt := resultTarget(req, s.out, s.gov.cfg.DiscardResults)
+	if tgt == nil {
+		return
+	}
 	res := &Result{
 		Notification: req.Notification,
 		Signer:       req.Signer,
 		Context:      req.Context,
+		ContentType:  req.ContentType,
+		Tag:          req.Tag,
 		Response:     resp,
 		Err:          err,
+		CompletedAt:  time.Now(),
 	}
-	if req.Callback == NoCallback {
+	if s.gov.cfg.PayloadRetention == DropPayloadOnFailure && !res.IsAccepted() && res.Notification != nil {
+		n := *res.Notification
+		n.Payload = nil
+		res.Notification = &n
+	}
+	isBlocked := false
+	select {
+	case tgt <- res:
+	default:
+		isBlocked = true
+	}
+	if !isBlocked {
 		return
 	}
-	tgt := s.out
-	if req.Callback != nil {
-		tgt = req.Callback
+	s.waitCtr.Tick()
+	select {
+	case tgt <- res:
+	case <-s.ctl:
 	}
-	if tgt != nil && tgt != NoCallback {
-		isBlocked := false
-		select {
-		case tgt <- res:
-		default:
-			isBlocked = true
-		}
-		if !isBlocked {
-			return
-		}
-		s.waitCtr.Tick()
-		select {
-		case tgt <- res:
-		case <-s.ctl:
-		}
-		s.waitCtr.Tock()
+	s.waitCtr.Tock()
+}
+
+// retryDelay returns the delay before the request that failed
+// with resp is retried.
+func (s *streamer) retryDelay(req *Request, resp *Response) time.Duration {
+	class := ReasonClassNone
+	if resp != nil {
+		class = resp.Class()
+	}
+	if f := s.gov.cfg.RetryBackOffFunc; f != nil {
+		return f(uint32(req.attemptCnt), class)
+	}
+	if len(s.gov.cfg.RetryBackOffs) == 0 {
+		return 0
+	}
+	bo, ok := s.gov.cfg.RetryBackOffs[class]
+	if !ok {
+		return 0
+	}
+	return bo.delay(req.attemptCnt, s.c.rnd)
+}
+
+// runOnComplete hands request's OnComplete func over to client's completion
+// pool, waiting for room in the pool's queue if necessary.
+func (s *streamer) runOnComplete(req *Request, resp *Response, err error) {
+	f := func() { req.OnComplete(resp, err) }
+	if s.c.completions.tryPut(f) {
+		return
+	}
+	s.waitCtr.Tick()
+	s.c.completions.put(f, s.ctl)
+	s.waitCtr.Tock()
+}
+
+// timeoutError returns the error to report for a request that timed out.
+// Apple may have delivered the notification nonetheless, so the error
+// carries the client-supplied apns-id, if any, for correlation.
+func (s *streamer) timeoutError(req *Request, err error) *TimeoutError {
+	res := &TimeoutError{Err: err}
+	if req.Notification != nil {
+		res.ApnsID = req.Notification.ApnsID
+	}
+	logTrace(1, s.id, "%v", res)
+	return res
+}
+
+// isTimeout returns true if err indicates that a request timed out.
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
+// isPastDeadline returns true if request's context has a deadline
+// and it has elapsed.
+func isPastDeadline(req *Request, now time.Time) bool {
+	if req.Context == NoContext {
+		return false
+	}
+	d, ok := req.Context.Deadline()
+	return ok && !now.Before(d)
+}
+
+// skewDetector is implemented by signers that can tell whether their
+// provider token was generated shortly before a given time.
+type skewDetector interface {
+	isFreshToken(at time.Time) bool
+	clockSkewSuspected()
+}
+
+// isClockSkewed returns true if the request was rejected for its provider
+// token immediately after the token had been generated. APN service rejects
+// tokens that appear to be issued in the future or too long ago, so this
+// indicates that local clock is likely skewed. It must be called before
+// the token is invalidated.
+func (s *streamer) isClockSkewed(req *Request, resp *Response, sent time.Time) bool {
+	if resp.RejectionReason != ReasonInvalidProviderToken && resp.RejectionReason != ReasonExpiredProviderToken {
+		return false
+	}
+	signer := req.Signer
+	if signer == nil {
+		signer = s.c.Signer
+	}
+	sd, ok := signer.(skewDetector)
+	if !ok || !sd.isFreshToken(sent) {
+		return false
+	}
+	logWarn(s.id, "Freshly generated provider token rejected with %s. Local clock may be skewed.", resp.RejectionReason)
+	sd.clockSkewSuspected()
+	return true
+}
+
+// reauth invalidates request signer's provider token, if the signer supports
+// it, so that the request can be resubmitted once with a fresh token.
+// It returns false if the request should not be resubmitted.
+func (s *streamer) reauth(req *Request, sent time.Time) bool {
+	if req.isReauthed {
+		return false
+	}
+	signer := req.Signer
+	if signer == nil {
+		signer = s.c.Signer
+	}
+	inv, ok := signer.(TokenInvalidator)
+	if !ok {
+		return false
 	}
+	inv.InvalidateToken(sent)
+	return true
+}
+
+// regeneratedApnsID returns a new ApnsID for the request rejected by APN
+// service with BadMessageId, or "" if it should not be resubmitted with one.
+func (s *streamer) regeneratedApnsID(req *Request, resp *Response) string {
+	if !s.gov.cfg.RegenerateBadApnsID || req.isRegenerated || req.Notification == nil || !resp.IsBadMessageID() {
+		return ""
+	}
+	id, err := newApnsID()
+	if err != nil {
+		logWarn(s.id, "Failed to regenerate ApnsID: %v", err)
+		return ""
+	}
+	logTrace(1, s.id, "Replacing bad ApnsID %q with %q.", req.Notification.ApnsID, id)
+	return id
+}
+
+// maxRetries returns the number of retries allowed for the request.
+func (s *streamer) maxRetries(req *Request) uint32 {
+	if req.MaxRetries != nil {
+		return *req.MaxRetries
+	}
+	return s.gov.cfg.MaxRetries
+}
+
+// resized returns a replacement for the request rejected by APN service
+// for its payload size, or nil if no replacement should be made.
+func (s *streamer) resized(req *Request, resp *Response, err error) *Request {
+	f := s.gov.cfg.OnPayloadTooLarge
+	if f == nil || err != nil || resp == nil || resp.StatusCode != http.StatusRequestEntityTooLarge || req.isResized {
+		return nil
+	}
+	return f(req)
+}
+
+// keepApnsID carries ApnsID of the original request over to its replacement
+// so that APN service sees all attempts as the same notification.
+func keepApnsID(repl *Request, orig *Request) {
+	if repl.Notification == nil || orig.Notification == nil {
+		return
+	}
+	id := orig.Notification.ApnsID
+	if id == "" || repl.Notification.ApnsID != "" {
+		return
+	}
+	n := *repl.Notification
+	n.ApnsID = id
+	repl.Notification = &n
 }
 
 func (s *streamer) isRetriable(resp *Response, err error) bool {
 	if resp == nil && err != nil {
 		return false
 	}
+	// Rejections are only retried if their reason is of a retriable class.
+	if err == nil && resp != nil && !resp.IsAccepted() && !resp.Class().IsRetriable() {
+		return false
+	}
 	if s.gov.cfg.RetryEval != nil {
 		return s.gov.cfg.RetryEval(resp, err)
 	}
 	return false
 }
 
+// isInterrupted returns true if the roundtrip of req failed with err
+// because it was canceled upon the streamer abandoning its connection,
+// rather than for a reason of its own.
+func (s *streamer) isInterrupted(req *Request, resp *Response, err error) bool {
+	if resp != nil || err == nil || atomic.LoadInt32(&s.abandoned) == 0 {
+		return false
+	}
+	switch err.(type) {
+	case *RequestError, *PayloadSchemaError:
+		return false
+	}
+	return req.Context == NoContext || req.Context.Err() == nil
+}
+
+// isConnError returns true if push attempt outcome may be indicative
+// of a problem with the connection or the server at the other end of it.
+func isConnError(resp *Response, err error) bool {
+	if resp == nil {
+		switch err.(type) {
+		case *RequestError, *PayloadSchemaError:
+			return false
+		}
+		return err != nil
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// errRateTracker tracks failures among a fixed number of most recent
+// push attempts. It is safe for use in concurrent goroutines.
+// Nil errRateTracker tracks nothing.
+type errRateTracker struct {
+	maxRate funit.Measure
+	mu      sync.Mutex
+	samples []bool
+	pos     int
+	cnt     int
+	errs    int
+	tripped bool
+}
+
+func newErrRateTracker(window uint32, maxRate funit.Measure) *errRateTracker {
+	if window == 0 || maxRate <= 0 {
+		return nil
+	}
+	return &errRateTracker{maxRate: maxRate, samples: make([]bool, window)}
+}
+
+// record registers the outcome of a push attempt. It returns true
+// the first time the error rate over a full window exceeds the maximum.
+func (t *errRateTracker) record(isErr bool) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.samples[t.pos] {
+		t.errs--
+	}
+	t.samples[t.pos] = isErr
+	if isErr {
+		t.errs++
+	}
+	t.pos = (t.pos + 1) % len(t.samples)
+	if t.cnt < len(t.samples) {
+		t.cnt++
+	}
+	if t.tripped || t.cnt < len(t.samples) {
+		return false
+	}
+	t.tripped = funit.Measure(t.errs) > t.maxRate*funit.Measure(t.cnt)
+	return t.tripped
+}
+
+// rate returns the share of failed attempts among the tracked ones.
+func (t *errRateTracker) rate() funit.Measure {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cnt == 0 {
+		return 0
+	}
+	return funit.Measure(t.errs) / funit.Measure(t.cnt)
+}
+
 func (s *streamer) isConnUsable(resp *Response, err error) bool {
 	if resp == nil && err != nil {
 		switch err.(type) {
-		case *RequestError:
+		case *RequestError, *PayloadSchemaError:
 			// Request-level error
 			return true
 		default:
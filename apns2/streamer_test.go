@@ -0,0 +1,752 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apns/cryptox"
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apns/http2x"
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestCallBackPayloadRetention(t *testing.T) {
+	out := make(chan *Result, 1)
+	s := &streamer{
+		id:  "test",
+		c:   &Client{},
+		gov: &governor{},
+		out: out,
+		ctl: make(chan struct{}),
+	}
+	req := &Request{Notification: testNotif_Good}
+	ok := &Response{StatusCode: 200}
+	// retained by default
+	s.callBack(req, nil, errors.New("failed"))
+	r := <-out
+	assert.True(t, r.Notification == testNotif_Good)
+	// dropped on failure only
+	s.gov.cfg.PayloadRetention = DropPayloadOnFailure
+	s.callBack(req, ok, nil)
+	r = <-out
+	assert.True(t, r.Notification == testNotif_Good)
+	s.callBack(req, nil, errors.New("failed"))
+	r = <-out
+	assert.Nil(t, r.Notification.Payload)
+	assert.Equal(t, testNotif_Good.Recipient, r.Notification.Recipient)
+	assert.NotNil(t, testNotif_Good.Payload)
+}
+
+func TestCallBackDiscard(t *testing.T) {
+	s := &streamer{
+		id:  "test",
+		c:   &Client{},
+		gov: &governor{},
+		ctl: make(chan struct{}),
+	}
+	ok := &Response{StatusCode: 200}
+	// nil callback does not block
+	s.callBack(&Request{Notification: testNotif_Good}, ok, nil)
+	s.callBack(&Request{Notification: testNotif_Good, Callback: NoCallback}, ok, nil)
+	// per-request callback takes precedence
+	cb := make(chan *Result, 1)
+	s.callBack(&Request{Notification: testNotif_Good, Callback: cb}, ok, nil)
+	assert.Len(t, cb, 1)
+	<-cb
+	// fire-and-forget discards all results
+	s.gov.cfg.DiscardResults = true
+	s.out = cb
+	s.callBack(&Request{Notification: testNotif_Good, Callback: cb}, ok, nil)
+	s.callBack(&Request{Notification: testNotif_Good}, ok, nil)
+	assert.Len(t, cb, 0)
+	assert.Equal(t, uint64(5), s.c.completedCnt)
+}
+
+func TestCallBackTags(t *testing.T) {
+	s := &streamer{
+		id:  "test",
+		c:   &Client{tagTracker: newTagTracker()},
+		gov: &governor{},
+		ctl: make(chan struct{}),
+	}
+	ok := &Response{StatusCode: 200}
+	bad := &Response{StatusCode: 400, RejectionReason: ReasonBadDeviceToken}
+	s.callBack(&Request{Notification: testNotif_Good, Tag: "A"}, ok, nil)
+	s.callBack(&Request{Notification: testNotif_Good, Tag: "A"}, bad, nil)
+	s.callBack(&Request{Notification: testNotif_Good, Tag: "B"}, nil, errors.New("failed"))
+	s.callBack(&Request{Notification: testNotif_Good, Tag: "B", attemptCnt: 2}, ok, nil)
+	s.callBack(&Request{Notification: testNotif_Good}, ok, nil)
+	assert.Equal(t, map[string]TagStats{
+		"A": {Accepted: 1, Failed: 1},
+		"B": {Accepted: 1, Failed: 1},
+	}, s.c.Stats().Tags)
+	assert.Nil(t, (&Client{}).Stats().Tags)
+}
+
+func TestGoAway(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{goAwayTracker: &goAwayTracker{}}}
+	now := time.Now()
+	s.goAway(&http2x.FrameEvent{Time: now, Type: http2.FrameGoAway, LastStreamID: 7})
+	s.goAway(&http2x.FrameEvent{Time: now, Type: http2.FrameGoAway, LastStreamID: 9})
+	n, rate := s.c.goAwayTracker.counts(now)
+	assert.Equal(t, uint64(2), n)
+	assert.Equal(t, funit.Measure(2)/60, rate)
+}
+
+func TestIsPastDeadline(t *testing.T) {
+	now := time.Now()
+	assert.False(t, isPastDeadline(&Request{}, now))
+	assert.False(t, isPastDeadline(&Request{Context: context.Background()}, now))
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Second))
+	defer cancel()
+	req := &Request{Context: ctx}
+	assert.False(t, isPastDeadline(req, now))
+	assert.True(t, isPastDeadline(req, now.Add(time.Second)))
+}
+
+type testInvalidator struct {
+	invalidated int
+}
+
+func (s *testInvalidator) SignRequest(r *http.Request) error {
+	return nil
+}
+
+func (s *testInvalidator) InvalidateToken(issuedBefore time.Time) {
+	s.invalidated++
+}
+
+func TestReauth(t *testing.T) {
+	inv := &testInvalidator{}
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{}}
+	// no signer
+	assert.False(t, s.reauth(&Request{}, time.Now()))
+	// signer unable to refresh
+	assert.False(t, s.reauth(&Request{Signer: NoSigner}, time.Now()))
+	// client's signer
+	s.c.Signer = inv
+	assert.True(t, s.reauth(&Request{}, time.Now()))
+	assert.Equal(t, 1, inv.invalidated)
+	// only once
+	assert.False(t, s.reauth(&Request{isReauthed: true}, time.Now()))
+	assert.Equal(t, 1, inv.invalidated)
+}
+
+func TestRegeneratedApnsID(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{}}
+	badID := &Response{StatusCode: 400, RejectionReason: ReasonBadMessageID}
+	req := &Request{Notification: testNotif_Good}
+	// disabled
+	assert.Empty(t, s.regeneratedApnsID(req, badID))
+	s.gov.cfg.RegenerateBadApnsID = true
+	assert.Len(t, s.regeneratedApnsID(req, badID), 36)
+	assert.Empty(t, s.regeneratedApnsID(req, &Response{StatusCode: 400, RejectionReason: ReasonBadTopic}))
+	// only once
+	assert.Empty(t, s.regeneratedApnsID(&Request{Notification: testNotif_Good, isRegenerated: true}, badID))
+}
+
+func TestClient_RegenerateBadApnsID(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			ids = append(ids, r.Header.Get("apns-id"))
+			n := len(ids)
+			mu.Unlock()
+			if n == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"reason":"BadMessageId"}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.ProcCfg.RegenerateBadApnsID = true
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n := *testNotif_Good
+	n.ApnsID = "123e4567-e89b-12d3-a456-426655440000"
+	cb := make(chan *Result, 1)
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.True(t, r.IsAccepted())
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, ids, 2) {
+		assert.Equal(t, n.ApnsID, ids[0])
+		assert.NotEqual(t, ids[0], ids[1])
+		assert.Equal(t, ids[1], r.Notification.ApnsID)
+	}
+}
+
+func TestClient_RegenerateBadApnsIDMaxRetries(t *testing.T) {
+	var attempts int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch atomic.AddInt32(&attempts, 1) {
+			case 1:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"reason":"BadMessageId"}`))
+			case 2:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.RegenerateBadApnsID = true
+	c.ProcCfg.RetryEval = DefaultRetryEval
+	c.ProcCfg.MaxRetries = 1
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n := *testNotif_Good
+	n.ApnsID = "123e4567-e89b-12d3-a456-426655440000"
+	cb := make(chan *Result, 1)
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	// The regenerated attempt does not use up the only retry.
+	assert.True(t, r.IsAccepted())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestIsClockSkewed(t *testing.T) {
+	signingKey, err := cryptox.PKCS8PrivateKeyFromFile("../cryptox/test_data/pk_valid.p8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &JWTSigner{KeyID: "ABC123DEFG", TeamID: "DEF123GHIJ", SigningKey: signingKey}
+	s := &streamer{id: "test", c: &Client{Signer: signer}, gov: &governor{}}
+	invalid := &Response{StatusCode: 403, RejectionReason: ReasonInvalidProviderToken}
+	tk, err := signer.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, s.isClockSkewed(&Request{}, invalid, tk.IssuedAt.Add(time.Second)))
+	assert.False(t, s.isClockSkewed(&Request{}, invalid, tk.IssuedAt.Add(time.Hour)))
+	assert.False(t, s.isClockSkewed(&Request{}, &Response{StatusCode: 403, RejectionReason: ReasonBadCertificate}, tk.IssuedAt))
+	assert.False(t, s.isClockSkewed(&Request{Signer: NoSigner}, invalid, tk.IssuedAt))
+	assert.Contains(t, (&AuthError{StatusCode: 403, Reason: ReasonInvalidProviderToken, ClockSkewSuspected: true}).Error(), "clock skew")
+}
+
+func TestAuthFailure(t *testing.T) {
+	c := &Client{}
+	c.setAuthFailure(nil)
+	ok, err := c.Healthy()
+	assert.True(t, ok)
+	c.setAuthFailure(&AuthError{StatusCode: 403, Reason: ReasonInvalidProviderToken})
+	ok, err = c.Healthy()
+	assert.False(t, ok)
+	assert.IsType(t, &AuthError{}, err)
+	c.setAuthFailure(nil)
+	ok, err = c.Healthy()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+}
+
+func TestDrain(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{}, ctl: make(chan struct{})}
+	// nothing in flight
+	assert.False(t, s.drain(time.Second))
+	// completes within grace period
+	ctx, release := s.track(&Request{})
+	s.wg.Add(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+		s.wg.Done()
+	}()
+	assert.False(t, s.drain(time.Second))
+	assert.Equal(t, int32(0), s.abandoned)
+	// abandoned after grace period
+	ctx, release = s.track(&Request{})
+	s.wg.Add(1)
+	go func() {
+		<-ctx.Done()
+		release()
+		s.wg.Done()
+	}()
+	assert.True(t, s.drain(10*time.Millisecond))
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Equal(t, int32(1), s.abandoned)
+	assert.Len(t, s.inFlight, 0)
+}
+
+func TestIsInterrupted(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{}}
+	req := &Request{}
+	err := errors.New("canceled")
+	// not abandoned
+	assert.False(t, s.isInterrupted(req, nil, err))
+	s.abandoned = 1
+	assert.True(t, s.isInterrupted(req, nil, err))
+	assert.False(t, s.isInterrupted(req, nil, nil))
+	assert.False(t, s.isInterrupted(req, &Response{StatusCode: 500}, err))
+	assert.False(t, s.isInterrupted(req, nil, &RequestError{err}))
+	// canceled by the caller
+	ctx, cancel := context.WithCancel(context.Background())
+	req.Context = ctx
+	assert.True(t, s.isInterrupted(req, nil, err))
+	cancel()
+	assert.False(t, s.isInterrupted(req, nil, err))
+}
+
+func TestPrefetch(t *testing.T) {
+	in := make(chan *Request)
+	s := &streamer{
+		id:  "test",
+		c:   &Client{flow: &flowState{changed: make(chan struct{})}, retry: make(chan *Request), ctl: make(chan struct{})},
+		gov: &governor{},
+		in:  in,
+		ctl: make(chan struct{}),
+	}
+	buf := make(chan *Request, 2)
+	room := make(chan struct{}, 2)
+	room <- struct{}{}
+	room <- struct{}{}
+	stop := make(chan struct{})
+	go s.prefetch(buf, room, stop)
+	reqs := []*Request{{}, {}, {}}
+	in <- reqs[0]
+	in <- reqs[1]
+	// buffer is full
+	select {
+	case in <- reqs[2]:
+		t.Fatal("Should not have prefetched beyond depth")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, reqs[0], <-buf)
+	room <- struct{}{}
+	in <- reqs[2]
+	// leftovers are handed straight back to the submitter
+	close(stop)
+	s.unfetch(buf, false)
+	for _, req := range reqs[1:] {
+		r := <-s.c.retry
+		assert.True(t, r == req)
+		assert.True(t, r.isReturned)
+	}
+	// leftovers are abandoned upon hard shutdown
+	buf = make(chan *Request, 1)
+	buf <- &Request{}
+	close(buf)
+	s.unfetch(buf, true)
+	select {
+	case <-s.c.retry:
+		t.Fatal("Should have abandoned prefetched request")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestClient_PrefetchWindDown(t *testing.T) {
+	release := make(chan struct{})
+	var received int32
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&received, 1)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = 5 * time.Second
+	c.CommsCfg.MaxConcurrentStreams = 1
+	c.CommsCfg.PrefetchDepth = 4
+	c.ProcCfg.MinConns = 2
+	c.ProcCfg.MaxConns = 2
+	c.ProcCfg.RetryOverflow = RetryOverflowDrop
+	const total = 16
+	cb := make(chan *Result, total)
+	c.Callback = cb
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	go func() {
+		for i := 0; i < total; i++ {
+			c.Push(testNotif_Good, DefaultSigner, NoContext, DefaultCallback)
+		}
+	}()
+	for atomic.LoadInt32(&received) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// Let prefetch buffers fill up.
+	time.Sleep(50 * time.Millisecond)
+	// Wind one connection down, bypassing the MinConns check of SetMaxConns.
+	c.gov.maxConns <- 1
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < total; i++ {
+		select {
+		case r := <-cb:
+			assert.True(t, r.IsAccepted(), "%v", r.Err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got %d of %d results", i, total)
+		}
+	}
+}
+
+func TestClient_Prefetch(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.CommsCfg.PrefetchDepth = 4
+	cb := make(chan *Result, 50)
+	c.Callback = cb
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := c.Push(testNotif_Good, DefaultSigner, NoContext, DefaultCallback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Nil(t, c.Stop())
+	n := 0
+	for r := range cb {
+		assert.True(t, r.IsAccepted())
+		n++
+	}
+	assert.Equal(t, 50, n)
+}
+
+func TestMaxRetries(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{cfg: ProcCfg{MaxRetries: 2}}}
+	assert.Equal(t, uint32(2), s.maxRetries(&Request{}))
+	var n uint32 = 5
+	assert.Equal(t, uint32(5), s.maxRetries(&Request{MaxRetries: &n}))
+	n = 0
+	assert.Equal(t, uint32(0), s.maxRetries(&Request{MaxRetries: &n}))
+}
+
+func TestClient_RetryRejectionClasses(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			attempts[r.URL.Path]++
+			mu.Unlock()
+			if strings.HasSuffix(r.URL.Path, testNotif_BadDevice.Recipient) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"reason":"BadTopic"}`))
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 2
+	c.ProcCfg.RetryEval = func(*Response, error) bool { return true }
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 2)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(testNotif_BadDevice, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	<-cb
+	mu.Lock()
+	defer mu.Unlock()
+	// retriable rejection is retried up to MaxRetries
+	assert.Equal(t, 3, attempts["/3/device/"+testNotif_Good.Recipient])
+	// permanent rejection is not retried even though RetryEval allows it
+	assert.Equal(t, 1, attempts["/3/device/"+testNotif_BadDevice.Recipient])
+}
+
+func TestResized(t *testing.T) {
+	s := &streamer{id: "test", c: &Client{}, gov: &governor{}}
+	req := &Request{Notification: testNotif_Good}
+	tooLarge := &Response{StatusCode: 413, RejectionReason: ReasonPayloadTooLarge}
+	repl := &Request{Notification: testNotif_Good}
+	// no hook
+	assert.Nil(t, s.resized(req, tooLarge, nil))
+	s.gov.cfg.OnPayloadTooLarge = func(r *Request) *Request {
+		assert.True(t, r == req)
+		return repl
+	}
+	assert.True(t, s.resized(req, tooLarge, nil) == repl)
+	assert.Nil(t, s.resized(req, &Response{StatusCode: 400}, nil))
+	assert.Nil(t, s.resized(req, nil, errors.New("failed")))
+	// only once
+	assert.Nil(t, s.resized(&Request{Notification: testNotif_Good, isResized: true}, tooLarge, nil))
+	// hook declines
+	s.gov.cfg.OnPayloadTooLarge = func(r *Request) *Request { return nil }
+	assert.Nil(t, s.resized(req, tooLarge, nil))
+}
+
+func TestKeepApnsID(t *testing.T) {
+	n := *testNotif_Good
+	n.ApnsID = "123e4567-e89b-12d3-a456-426655440000"
+	repl := &Request{Notification: testNotif_Good}
+	keepApnsID(repl, &Request{Notification: &n})
+	assert.Equal(t, n.ApnsID, repl.Notification.ApnsID)
+	assert.Empty(t, testNotif_Good.ApnsID)
+	// replacement's own id is kept
+	other := *testNotif_Good
+	other.ApnsID = "223e4567-e89b-12d3-a456-426655440000"
+	repl = &Request{Notification: &other}
+	keepApnsID(repl, &Request{Notification: &n})
+	assert.Equal(t, other.ApnsID, repl.Notification.ApnsID)
+}
+
+func TestErrRateTracker(t *testing.T) {
+	var nt *errRateTracker
+	assert.False(t, nt.record(true))
+	assert.Nil(t, newErrRateTracker(0, 50*funit.Percent))
+	assert.Nil(t, newErrRateTracker(4, 0))
+	et := newErrRateTracker(4, 50*funit.Percent)
+	// window not full yet
+	assert.False(t, et.record(true))
+	assert.False(t, et.record(true))
+	assert.False(t, et.record(true))
+	// full window, 3 of 4 failed, but oldest sample is evicted next
+	assert.True(t, et.record(false))
+	// only trips once
+	assert.False(t, et.record(true))
+	et = newErrRateTracker(4, 50*funit.Percent)
+	et.record(false)
+	et.record(false)
+	et.record(true)
+	// 2 of 4 is not above 50%
+	assert.False(t, et.record(true))
+	// 3 of 4
+	assert.True(t, et.record(true))
+}
+
+func TestClient_ConnErrorRateCountsRetries(t *testing.T) {
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"reason":"ServiceUnavailable"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = time.Second
+	c.ProcCfg.MaxRetries = 10
+	c.ProcCfg.RetryEval = func(*Response, error) bool { return true }
+	c.ProcCfg.ConnErrorWindow = 4
+	c.ProcCfg.MaxConnErrorRate = 50 * funit.Percent
+	events := make(chan *StreamerEvent, 100)
+	c.StreamerEvents = events
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Kill()
+	// A single request keeps being retried, and its retries alone
+	// must trip the error rate.
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, NoCallback); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Phase == StreamerExited {
+				assert.Equal(t, StreamerReasonQuit, ev.Reason)
+				return
+			}
+		case <-timeout:
+			t.Fatal("Connection should have been abandoned")
+		}
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	assert.True(t, isConnError(nil, errors.New("transport")))
+	assert.False(t, isConnError(nil, &RequestError{errors.New("request")}))
+	assert.False(t, isConnError(nil, &PayloadSchemaError{Reason: "schema"}))
+	assert.False(t, isConnError(&Response{StatusCode: 400}, nil))
+	assert.True(t, isConnError(&Response{StatusCode: 503}, nil))
+}
+
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(context.DeadlineExceeded))
+	assert.False(t, isTimeout(context.Canceled))
+	assert.False(t, isTimeout(errors.New("failed")))
+	assert.False(t, isTimeout(nil))
+}
+
+func TestClient_TimeoutApnsID(t *testing.T) {
+	s := mustNewMockServerWithCfg(t, apns2mock.CommsCfg{
+		MaxConcurrentStreams: 500,
+		MaxConns:             1000,
+		ResponseTime:         time.Second,
+	})
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.RequestTimeout = 100 * time.Millisecond
+	err := c.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	n := *testNotif_Good
+	n.ApnsID = "123e4567-e89b-12d3-a456-426655440000"
+	cb := make(chan *Result, 1)
+	if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.Nil(t, r.Response)
+	if err, ok := r.Err.(*TimeoutError); assert.True(t, ok, "%v", r.Err) {
+		assert.Equal(t, n.ApnsID, err.ApnsID)
+		assert.True(t, err.Timeout())
+		assert.Contains(t, err.Error(), n.ApnsID)
+		assert.NotNil(t, err.Err)
+	}
+	assert.False(t, r.IsAccepted())
+	assert.Equal(t, DropReasonTimeout, dropReason(r.Err))
+}
+
+func TestClient_ResponseBodyLimit(t *testing.T) {
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"reason":"` + strings.Repeat("X", 1000) + `"}`))
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.MaxResponseBodySize = 100
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	r := <-cb
+	assert.Nil(t, r.Err)
+	if assert.NotNil(t, r.Response) {
+		assert.True(t, r.Response.BodyTruncated)
+		assert.Equal(t, http.StatusBadRequest, r.Response.StatusCode)
+		assert.Equal(t, "", r.Response.RejectionReason)
+	}
+}
+
+func TestClient_OnSend(t *testing.T) {
+	s := mustNewMockServer(t)
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	metas := make(chan RequestMeta, 1)
+	c.CommsCfg.OnSend = func(m RequestMeta) { metas <- m }
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cb := make(chan *Result, 1)
+	if err := c.Push(testNotif_Good, DefaultSigner, NoContext, cb); err != nil {
+		t.Fatal(err)
+	}
+	<-cb
+	m := <-metas
+	assert.Equal(t, TokenHash(testNotif_Good.Recipient), m.TokenHash)
+	assert.Len(t, m.TokenHash, 64)
+	assert.NotContains(t, m.TokenHash, testNotif_Good.Recipient)
+	assert.Equal(t, "com.example.Alert", m.Topic)
+	assert.Equal(t, 1, m.Attempt)
+	assert.Equal(t, s.URL, m.Gateway)
+	assert.True(t, m.PayloadSize > 0)
+	assert.False(t, m.Time.IsZero())
+}
+
+func TestNewRequestMeta(t *testing.T) {
+	n := &Notification{Header: &Header{Topic: "com.example.Live", ChannelID: "ch1"}}
+	httpReq, _ := http.NewRequest("POST", "https://localhost", strings.NewReader("{}"))
+	m := newRequestMeta(&Request{Notification: n, attemptCnt: 2}, httpReq, "https://localhost")
+	assert.Equal(t, "", m.TokenHash)
+	assert.Equal(t, "ch1", m.ChannelID)
+	assert.Equal(t, int64(2), m.PayloadSize)
+	assert.Equal(t, 3, m.Attempt)
+}
+
+func TestStreamerLivenessIdleConnection(t *testing.T) {
+	now := time.Now()
+	s := &streamer{started: now.Add(-time.Hour)}
+	atomic.StoreInt64(&s.lastResponse, now.Add(-30*time.Minute).UnixNano())
+	_, idle := s.liveness(now)
+	assert.Equal(t, 30*time.Minute, idle)
+	// A burst after a long quiet period is measured from its start.
+	_, release := s.track(&Request{Context: NoContext})
+	_, idle = s.liveness(time.Now())
+	assert.True(t, idle < time.Second, "idle %v", idle)
+	// Further requests do not move the start of the busy period.
+	busy := atomic.LoadInt64(&s.busySince)
+	_, release2 := s.track(&Request{Context: NoContext})
+	assert.Equal(t, busy, atomic.LoadInt64(&s.busySince))
+	release()
+	release2()
+}
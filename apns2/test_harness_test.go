@@ -5,29 +5,16 @@ package apns2
 import (
 	"time"
 
+	"github.com/baobabus/go-apns/apns2/apns2test"
 	"github.com/baobabus/go-apns/funit"
 	"github.com/baobabus/go-apnsmock/apns2mock"
 )
 
 var (
-	apnsMockComms_Typical = apns2mock.CommsCfg{
-		MaxConcurrentStreams: 500,
-		MaxConns:             1000,
-		ConnectionDelay:      1 * time.Second,
-		ResponseTime:         20 * time.Millisecond,
-	}
-	apnsMockComms_30ms = apns2mock.CommsCfg{
-		MaxConcurrentStreams: 500,
-		MaxConns:             1000,
-		ConnectionDelay:      30 * time.Millisecond,
-		ResponseTime:         30 * time.Millisecond,
-	}
-	apnsMockComms_NoDelay = apns2mock.CommsCfg{
-		MaxConcurrentStreams: 500,
-		MaxConns:             1000,
-		ConnectionDelay:      0,
-		ResponseTime:         0,
-	}
+	apnsMockComms_Typical = apns2test.CommsTypical
+	apnsMockComms_30ms    = apns2test.Comms30ms
+	apnsMockComms_NoDelay = apns2test.CommsNoDelay
+
 	commsTest_Fast = CommsCfg{
 		DialTimeout:          20 * time.Millisecond,
 		MinDialBackOff:       100 * time.Millisecond,
@@ -0,0 +1,95 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"sync"
+)
+
+// topicLimiter caps the number of in-flight push requests per topic
+// across all streamers of a client. Requests over the limit are held
+// back and are handed back to the processing pipeline one at a time
+// as in-flight requests for the same topic complete. Streamers are
+// therefore never blocked by a saturated topic and keep serving others.
+// Nil topicLimiter imposes no limits.
+type topicLimiter struct {
+	limits map[string]int
+	mu     sync.Mutex
+	topics map[string]*topicSlots
+}
+
+type topicSlots struct {
+	inFlight int
+	waiting  []*Request
+}
+
+func newTopicLimiter(limits map[string]int) *topicLimiter {
+	if len(limits) == 0 {
+		return nil
+	}
+	res := &topicLimiter{
+		limits: make(map[string]int, len(limits)),
+		topics: make(map[string]*topicSlots),
+	}
+	// The map is copied so that later changes to ProcCfg have no effect.
+	for k, v := range limits {
+		res.limits[k] = v
+	}
+	return res
+}
+
+func requestTopic(req *Request) string {
+	if req.Notification == nil || req.Notification.Header == nil {
+		return ""
+	}
+	return req.Notification.Header.Topic
+}
+
+// acquire takes an in-flight slot for the request's topic. If none is
+// available, the request is held back and false is returned.
+func (l *topicLimiter) acquire(req *Request) bool {
+	if l == nil || req.hasTopicSlot {
+		return true
+	}
+	topic := requestTopic(req)
+	limit := l.limits[topic]
+	if limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ts := l.topics[topic]
+	if ts == nil {
+		ts = &topicSlots{}
+		l.topics[topic] = ts
+	}
+	if ts.inFlight < limit {
+		ts.inFlight++
+		req.hasTopicSlot = true
+		return true
+	}
+	ts.waiting = append(ts.waiting, req)
+	return false
+}
+
+// release frees the request's slot. If another request for the same topic
+// is held back, the slot is handed over to it and the request is returned
+// for resubmission.
+func (l *topicLimiter) release(req *Request) *Request {
+	if l == nil || !req.hasTopicSlot {
+		return nil
+	}
+	req.hasTopicSlot = false
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ts := l.topics[requestTopic(req)]
+	if len(ts.waiting) > 0 {
+		next := ts.waiting[0]
+		ts.waiting[0] = nil
+		ts.waiting = ts.waiting[1:]
+		next.hasTopicSlot = true
+		return next
+	}
+	ts.inFlight--
+	return nil
+}
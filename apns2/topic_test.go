@@ -0,0 +1,126 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package apns2
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTopicRequest(topic string) *Request {
+	return &Request{Notification: &Notification{Header: &Header{Topic: topic}}}
+}
+
+func TestTopicLimiter(t *testing.T) {
+	var nl *topicLimiter
+	assert.True(t, nl.acquire(newTopicRequest("A")))
+	assert.Nil(t, nl.release(newTopicRequest("A")))
+	assert.Nil(t, newTopicLimiter(nil))
+
+	l := newTopicLimiter(map[string]int{"A": 2})
+	a1, a2, a3, a4 := newTopicRequest("A"), newTopicRequest("A"), newTopicRequest("A"), newTopicRequest("A")
+	assert.True(t, l.acquire(a1))
+	assert.True(t, l.acquire(a2))
+	assert.False(t, l.acquire(a3))
+	assert.False(t, l.acquire(a4))
+	// other topics are not limited
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.acquire(newTopicRequest("B")))
+	}
+	assert.True(t, l.acquire(&Request{Notification: &Notification{}}))
+	// slots are handed over in order
+	assert.True(t, l.release(a1) == a3)
+	assert.True(t, l.acquire(a3))
+	assert.True(t, l.release(a2) == a4)
+	assert.Nil(t, l.release(a3))
+	assert.Nil(t, l.release(a4))
+	assert.Equal(t, 0, l.topics["A"].inFlight)
+	// releasing without a slot has no effect
+	assert.Nil(t, l.release(a1))
+	assert.Equal(t, 0, l.topics["A"].inFlight)
+}
+
+func TestClient_TopicConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	maxInFlight := map[string]int{}
+	s, err := apns2mock.NewServer(
+		apnsMockComms_NoDelay,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			topic := r.Header.Get("apns-topic")
+			mu.Lock()
+			inFlight[topic]++
+			if inFlight[topic] > maxInFlight[topic] {
+				maxInFlight[topic] = inFlight[topic]
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight[topic]--
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}),
+		apns2mock.AutoCert,
+		apns2mock.AutoKey,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := mustNewClient_Signer_Good(t, s)
+	c.CommsCfg.DialTimeout = time.Second
+	c.CommsCfg.RequestTimeout = 5 * time.Second
+	c.ProcCfg.MinConns = 3
+	c.ProcCfg.MaxConns = 3
+	c.ProcCfg.TopicConcurrency = map[string]int{"com.example.Noisy": 1}
+	if err := c.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cnt := 10
+	cb := make(chan *Result, 2*cnt)
+	for i := 0; i < cnt; i++ {
+		for _, topic := range []string{"com.example.Noisy", "com.example.Alert"} {
+			n := *testNotif_Good
+			n.Header = &Header{Topic: topic}
+			if err := c.Push(&n, DefaultSigner, NoContext, cb); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 2*cnt; i++ {
+		assert.True(t, (<-cb).IsAccepted())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, maxInFlight["com.example.Noisy"])
+}
+
+func TestStreamerReleaseTopicSlot(t *testing.T) {
+	c := &Client{
+		retry:        make(chan *Request, 1),
+		ctl:          make(chan struct{}),
+		topicLimiter: newTopicLimiter(map[string]int{"A": 1}),
+	}
+	defer close(c.ctl)
+	// Governor's retry channel is not serviced. Held back requests
+	// are not retries and must not go through it.
+	s := &streamer{c: c, gov: &governor{retry: make(chan *Request)}}
+	a1, a2 := newTopicRequest("A"), newTopicRequest("A")
+	assert.True(t, c.topicLimiter.acquire(a1))
+	assert.False(t, c.topicLimiter.acquire(a2))
+	s.releaseTopicSlot(a1)
+	select {
+	case req := <-c.retry:
+		assert.True(t, req == a2)
+		assert.True(t, req.isReturned)
+		assert.True(t, req.hasTopicSlot)
+	case <-time.After(time.Second):
+		t.Fatal("Held back request not resubmitted")
+	}
+}
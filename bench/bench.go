@@ -0,0 +1,213 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package bench
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/baobabus/go-apns/apns2"
+	"github.com/baobabus/go-apns/apns2/apns2test"
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apnsmock/apns2mock"
+)
+
+// Mock APN service communication profiles.
+var (
+	MockTypical = apns2test.CommsTypical
+	Mock30ms    = apns2test.Comms30ms
+	MockSlow    = apns2mock.CommsCfg{
+		MaxConcurrentStreams: 500,
+		MaxConns:             1000,
+		ConnectionDelay:      2 * time.Second,
+		ResponseTime:         200 * time.Millisecond,
+	}
+	MockNoDelay = apns2test.CommsNoDelay
+)
+
+var (
+	// ErrNoNotifications is returned when benchmark configuration does not
+	// call for any notifications to be sent.
+	ErrNoNotifications = errors.New("bench: no notifications to send")
+
+	// ErrTimeout is returned when the outcome of all notifications is not
+	// known within Config.Timeout.
+	ErrTimeout = errors.New("bench: run timed out")
+)
+
+// DefaultTimeout is the time limit of a benchmark run that is used
+// when Config.Timeout is not set.
+const DefaultTimeout = 5 * time.Minute
+
+// Config specifies the parameters of a benchmark run.
+type Config struct {
+
+	// Mock is the communication profile of the mock APN service,
+	// such as MockTypical.
+	Mock apns2mock.CommsCfg
+
+	// Handler is the request handler of the mock APN service.
+	// If nil, apns2mock.AllOkayHandler is used.
+	Handler http.Handler
+
+	// CommsCfg contains communication settings of the client under test.
+	CommsCfg apns2.CommsCfg
+
+	// ProcCfg contains processing settings of the client under test.
+	ProcCfg apns2.ProcCfg
+
+	// Notifications is the number of notifications to push.
+	Notifications int
+
+	// SampleInterval is the time between connection count samples.
+	// If 0, ProcCfg.PollInterval is used, or 100ms if that is not set.
+	SampleInterval time.Duration
+
+	// Timeout is the time limit of the run. If the outcome of all
+	// notifications is not known by then, Run returns the report so far
+	// along with ErrTimeout. If 0, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Sample is a connection count observation.
+type Sample struct {
+	Elapsed time.Duration
+	Conns   uint32
+}
+
+// Report holds the outcome of a benchmark run.
+type Report struct {
+
+	// Sent is the number of notifications with known outcome, including
+	// those that failed to be pushed.
+	Sent int
+
+	// PushFailed is the number of notifications that were not accepted
+	// for processing by the client, such as when its MaxTotal quota
+	// was reached.
+	PushFailed int
+
+	// Accepted is the number of notifications accepted by the mock service.
+	Accepted int
+
+	// Elapsed is the total run time.
+	Elapsed time.Duration
+
+	// Throughput is the achieved rate expressed in notifications per second.
+	// Notifications that failed to be pushed do not count.
+	Throughput funit.Measure
+
+	// Retries is the number of resubmitted push attempts.
+	Retries uint64
+
+	// RetryRate is the ratio of resubmitted attempts to notifications
+	// sent, not counting those that failed to be pushed.
+	RetryRate float64
+
+	// Conns holds connection count samples taken over the course of the run.
+	Conns []Sample
+}
+
+// Run pushes cfg.Notifications through a new client against a new mock
+// APN service and reports the results. If the run does not complete within
+// cfg.Timeout, the partial report is returned along with ErrTimeout.
+func Run(cfg Config) (*Report, error) {
+	if cfg.Notifications <= 0 {
+		return nil, ErrNoNotifications
+	}
+	h := cfg.Handler
+	if h == nil {
+		h = apns2mock.AllOkayHandler
+	}
+	s, err := apns2mock.NewServer(cfg.Mock, h, apns2mock.AutoCert, apns2mock.AutoKey)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cb := make(chan *apns2.Result, 100)
+	c := &apns2.Client{
+		Id:       "Bench",
+		Gateway:  s.URL,
+		RootCA:   s.RootCertificate,
+		Signer:   &apns2.JWTSigner{KeyID: "ABC123DEFG", TeamID: "DEF123GHIJ", SigningKey: key},
+		CommsCfg: cfg.CommsCfg,
+		ProcCfg:  cfg.ProcCfg,
+		Callback: cb,
+	}
+	if err := c.Start(nil); err != nil {
+		return nil, err
+	}
+	sampleInt := cfg.SampleInterval
+	if sampleInt <= 0 {
+		sampleInt = cfg.ProcCfg.PollInterval
+	}
+	if sampleInt <= 0 {
+		sampleInt = 100 * time.Millisecond
+	}
+	notif := &apns2.Notification{
+		Recipient: "00fc13adff785122b4ad28809a3420982341241421348097878e577c991de8f0",
+		Header:    &apns2.Header{Topic: "com.example.Bench"},
+		Payload:   &apns2.Payload{APS: &apns2.APS{Alert: "Bench"}},
+	}
+	res := &Report{}
+	start := time.Now()
+	// Notifications that fail to be pushed never produce a result.
+	failed := make(chan int, 1)
+	go func() {
+		for i := 0; i < cfg.Notifications; i++ {
+			if c.Push(notif, apns2.DefaultSigner, apns2.NoContext, apns2.DefaultCallback) != nil {
+				failed <- cfg.Notifications - i
+				return
+			}
+		}
+	}()
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+	tkr := time.NewTicker(sampleInt)
+	results := (<-chan *apns2.Result)(cb)
+	var rerr error
+	for res.Sent < cfg.Notifications && rerr == nil {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				// Client stopped itself, e.g. upon reaching MaxTotal.
+				results = nil
+				break
+			}
+			res.Sent++
+			if r.IsAccepted() {
+				res.Accepted++
+			}
+		case n := <-failed:
+			res.Sent += n
+			res.PushFailed = n
+		case now := <-tkr.C:
+			res.Conns = append(res.Conns, Sample{Elapsed: now.Sub(start), Conns: c.Stats().Conns})
+		case <-tmr.C:
+			rerr = ErrTimeout
+		}
+	}
+	tkr.Stop()
+	res.Elapsed = time.Since(start)
+	stats := c.Stats()
+	c.Kill()
+	delivered := res.Sent - res.PushFailed
+	res.Throughput = funit.Measure(delivered) / funit.Measure(res.Elapsed.Seconds())
+	res.Retries = stats.Retries
+	if delivered > 0 {
+		res.RetryRate = float64(res.Retries) / float64(delivered)
+	}
+	return res, rerr
+}
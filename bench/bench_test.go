@@ -0,0 +1,98 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package bench
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apns/apns2"
+	"github.com/baobabus/go-apns/funit"
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	r, err := Run(Config{
+		Mock: MockNoDelay,
+		CommsCfg: apns2.CommsCfg{
+			DialTimeout:          1 * time.Second,
+			RequestTimeout:       1 * time.Second,
+			MaxConcurrentStreams: 500,
+		},
+		ProcCfg:       apns2.MinBlockingProcConfig,
+		Notifications: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 100, r.Sent)
+	assert.Equal(t, 100, r.Accepted)
+	assert.True(t, r.Throughput > 0/funit.Second)
+	assert.Equal(t, uint64(0), r.Retries)
+}
+
+func TestRunPushFailure(t *testing.T) {
+	procCfg := apns2.MinBlockingProcConfig
+	procCfg.MaxTotal = 10
+	done := make(chan *Report, 1)
+	go func() {
+		r, err := Run(Config{
+			Mock: MockNoDelay,
+			CommsCfg: apns2.CommsCfg{
+				DialTimeout:          1 * time.Second,
+				RequestTimeout:       1 * time.Second,
+				MaxConcurrentStreams: 500,
+			},
+			ProcCfg:       procCfg,
+			Notifications: 20,
+		})
+		assert.Nil(t, err)
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		assert.Equal(t, 20, r.Sent)
+		assert.Equal(t, 10, r.Accepted)
+		assert.Equal(t, 10, r.PushFailed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should have completed")
+	}
+}
+
+func TestRunNoNotifications(t *testing.T) {
+	_, err := Run(Config{})
+	assert.Equal(t, ErrNoNotifications, err)
+}
+
+func TestRunTimeout(t *testing.T) {
+	done := make(chan error, 1)
+	go func() {
+		r, err := Run(Config{
+			Mock: MockNoDelay,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(500 * time.Millisecond)
+				apns2mock.AllOkayHandler.ServeHTTP(w, r)
+			}),
+			CommsCfg: apns2.CommsCfg{
+				DialTimeout:          1 * time.Second,
+				RequestTimeout:       1 * time.Second,
+				MaxConcurrentStreams: 500,
+			},
+			ProcCfg:       apns2.MinBlockingProcConfig,
+			Notifications: 10,
+			Timeout:       50 * time.Millisecond,
+		})
+		if assert.NotNil(t, r) {
+			assert.True(t, r.Sent < 10)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrTimeout, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should have timed out")
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+// Package bench provides a harness for measuring throughput of apns2
+// processing pipeline against a mock APN service. It is intended to aid
+// in tuning scaling parameters, such as MinSustain, SettlePeriod and Scale,
+// for a particular latency profile.
+package bench
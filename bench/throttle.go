@@ -0,0 +1,67 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package bench
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PerDeviceThrottleHandler returns an http.Handler that models APN service
+// throttling of excessive pushes to a single device. Requests for a device
+// token that has already been pushed limit times within the trailing window
+// are rejected with 429 TooManyRequests. All other requests are passed on
+// to next.
+//
+// Rejected requests do not count towards the limit.
+func PerDeviceThrottleHandler(limit int, window time.Duration, next http.Handler) http.Handler {
+	return &deviceThrottler{
+		limit:  limit,
+		window: window,
+		next:   next,
+		pushes: make(map[string][]time.Time),
+	}
+}
+
+type deviceThrottler struct {
+	limit  int
+	window time.Duration
+	next   http.Handler
+	mu     sync.Mutex
+	pushes map[string][]time.Time
+}
+
+func (t *deviceThrottler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tkn := strings.TrimPrefix(r.URL.Path, "/3/device/")
+	if !t.allow(tkn, time.Now()) {
+		w.Header().Set("apns-id", r.Header.Get("apns-id"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"reason": "TooManyRequests"})
+		return
+	}
+	t.next.ServeHTTP(w, r)
+}
+
+// allow records a push to device token tkn at time now and reports
+// whether it is within the limit.
+func (t *deviceThrottler) allow(tkn string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ts := t.pushes[tkn]
+	cutoff := now.Add(-t.window)
+	i := 0
+	for i < len(ts) && !ts[i].After(cutoff) {
+		i++
+	}
+	ts = ts[i:]
+	if len(ts) >= t.limit {
+		t.pushes[tkn] = ts
+		return false
+	}
+	t.pushes[tkn] = append(ts, now)
+	return true
+}
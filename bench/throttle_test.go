@@ -0,0 +1,36 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package bench
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apnsmock/apns2mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerDeviceThrottleHandler(t *testing.T) {
+	h := PerDeviceThrottleHandler(2, time.Hour, apns2mock.AllOkayHandler)
+	push := func(tkn string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/3/device/"+tkn, nil))
+		return w
+	}
+	assert.Equal(t, http.StatusOK, push("aa").Code)
+	assert.Equal(t, http.StatusOK, push("aa").Code)
+	w := push("aa")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "TooManyRequests")
+	assert.Equal(t, http.StatusOK, push("bb").Code)
+}
+
+func TestDeviceThrottlerWindow(t *testing.T) {
+	d := PerDeviceThrottleHandler(1, time.Second, apns2mock.AllOkayHandler).(*deviceThrottler)
+	now := time.Now()
+	assert.True(t, d.allow("aa", now))
+	assert.False(t, d.allow("aa", now.Add(500*time.Millisecond)))
+	assert.True(t, d.allow("aa", now.Add(1500*time.Millisecond)))
+}
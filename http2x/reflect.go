@@ -51,6 +51,57 @@ func GetMaxConcurrentStreams(c *http2.ClientConn) uint32 {
 	return *res
 }
 
+// FlowWindows is a snapshot of send flow-control windows
+// of an HTTP/2 client connection.
+type FlowWindows struct {
+
+	// Conn is the number of DATA bytes the connection is currently
+	// allowed to send.
+	Conn int32
+
+	// InitialStream is the initial per-stream window size
+	// as advertised by the peer.
+	InitialStream uint32
+
+	// Streams is the number of active streams in the connection.
+	Streams int
+
+	// MinStream is the smallest send window among active streams.
+	// It is equal to InitialStream if there are no active streams.
+	MinStream int32
+}
+
+// GetFlowWindows returns a snapshot of send flow-control windows of c
+// using reflection. It properly guards its reads with c's mutex.
+// If the windows cannot be determined due to http2.ClientConn
+// incompatibility, ErrIncompatibleHTTP2Layer error is returned.
+func GetFlowWindows(c *http2.ClientConn) (FlowWindows, error) {
+	var res FlowWindows
+	if !http2Compat || !flowCompat || c == nil {
+		return res, ErrIncompatibleHTTP2Layer
+	}
+	rc := reflect.Indirect(reflect.ValueOf(c))
+	mu := (*sync.Mutex)(ptrToFieldValue(rc, clientConn.mu))
+	mu.Lock()
+	defer mu.Unlock()
+	res.Conn = int32(rc.FieldByIndex(clientConn.flowN).Int())
+	res.InitialStream = uint32(rc.FieldByIndex(clientConn.initialWindowSize).Uint())
+	res.MinStream = int32(res.InitialStream)
+	streams := rc.FieldByIndex(clientConn.streams)
+	res.Streams = streams.Len()
+	for _, k := range streams.MapKeys() {
+		cs := streams.MapIndex(k)
+		if cs.IsNil() {
+			continue
+		}
+		n := int32(cs.Elem().FieldByIndex(clientStream.flowN).Int())
+		if n < res.MinStream {
+			res.MinStream = n
+		}
+	}
+	return res, nil
+}
+
 var dummyReq http.Request
 
 // GetClientConnPool returns http2.Transport t's ClientConnPool. If t is not a
@@ -97,8 +148,20 @@ var clientConn struct {
 	maxConcurrentStreams []int
 	closed               []int
 	goAway               []int
+	flowN                []int
+	initialWindowSize    []int
+	streams              []int
+}
+
+var clientStream struct {
+	flowN []int
 }
 
+// True if it is confirmed that flow control related fields
+// are as expected. Checked separately so that incompatibility here
+// does not affect other metrics.
+var flowCompat = true
+
 var transport struct {
 	connPoolOrDef []int
 }
@@ -142,6 +205,23 @@ func init() {
 	} else {
 		http2Compat = false
 	}
+	// Validate flow control related fields
+	clientConn.flowN, flowCompat = flowNIndex(c)
+	if f, ok := c.FieldByName("initialWindowSize"); ok && f.Type.Kind() == reflect.Uint32 {
+		clientConn.initialWindowSize = f.Index
+	} else {
+		flowCompat = false
+	}
+	if f, ok := c.FieldByName("streams"); ok && f.Type.Kind() == reflect.Map &&
+		f.Type.Elem().Kind() == reflect.Ptr && f.Type.Elem().Elem().Kind() == reflect.Struct {
+		clientConn.streams = f.Index
+		var ok bool
+		if clientStream.flowN, ok = flowNIndex(f.Type.Elem().Elem()); !ok {
+			flowCompat = false
+		}
+	} else {
+		flowCompat = false
+	}
 	// Validate http2.Transport structure
 	t := reflect.TypeOf(&http2.Transport{}).Elem()
 	if f, ok := t.FieldByName("connPoolOrDef"); ok {
@@ -154,3 +234,16 @@ func init() {
 		http2Compat = false
 	}
 }
+
+// flowNIndex returns the index of flow.n field of struct type t.
+func flowNIndex(t reflect.Type) ([]int, bool) {
+	f, ok := t.FieldByName("flow")
+	if !ok || f.Type.Kind() != reflect.Struct {
+		return nil, false
+	}
+	n, ok := f.Type.FieldByName("n")
+	if !ok || n.Type.Kind() != reflect.Int32 {
+		return nil, false
+	}
+	return append(append([]int{}, f.Index...), n.Index...), true
+}
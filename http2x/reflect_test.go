@@ -33,3 +33,10 @@ func TestGetClientConnPool(t *testing.T) {
 		t.Fatal("Should have gotten connection pool")
 	}
 }
+
+func TestGetFlowWindows(t *testing.T) {
+	_, err := GetFlowWindows(nil)
+	if err != ErrIncompatibleHTTP2Layer {
+		t.Fatal("Should have failed for nil connection")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package http2x
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+const frameHeaderLen = 9
+
+// WithSettings wraps c so that the specified settings are appended to
+// the initial SETTINGS frame that follows the client connection preface.
+// It allows settings that http2.Transport does not expose to be advertised
+// to the server. If the first write to c does not consist of the client
+// preface followed by a complete SETTINGS frame, it is passed through
+// unaltered.
+//
+// If c provides ConnectionState, as *tls.Conn does, so does the returned
+// connection.
+func WithSettings(c net.Conn, settings ...http2.Setting) net.Conn {
+	if len(settings) == 0 {
+		return c
+	}
+	extra := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		extra = append(extra,
+			byte(s.ID>>8), byte(s.ID),
+			byte(s.Val>>24), byte(s.Val>>16), byte(s.Val>>8), byte(s.Val))
+	}
+	res := &settingsConn{Conn: c, extra: extra}
+	if cs, ok := c.(connectionStater); ok {
+		return &tlsSettingsConn{settingsConn: res, cs: cs}
+	}
+	return res
+}
+
+type settingsConn struct {
+	net.Conn
+	extra []byte
+	once  sync.Once
+}
+
+func (c *settingsConn) Write(b []byte) (n int, err error) {
+	done := false
+	c.once.Do(func() {
+		if p, ok := c.inject(b); ok {
+			done = true
+			if _, err = c.Conn.Write(p); err == nil {
+				n = len(b)
+			}
+		}
+	})
+	if done {
+		return n, err
+	}
+	return c.Conn.Write(b)
+}
+
+// inject returns b with c.extra appended to the payload of the initial
+// SETTINGS frame.
+func (c *settingsConn) inject(b []byte) ([]byte, bool) {
+	pl := len(http2.ClientPreface)
+	if len(b) < pl+frameHeaderLen || !bytes.Equal(b[:pl], []byte(http2.ClientPreface)) {
+		return nil, false
+	}
+	h := b[pl : pl+frameHeaderLen]
+	if http2.FrameType(h[3]) != http2.FrameSettings || h[4] != 0 || h[5]|h[6]|h[7]|h[8] != 0 {
+		return nil, false
+	}
+	fl := int(h[0])<<16 | int(h[1])<<8 | int(h[2])
+	end := pl + frameHeaderLen + fl
+	if len(b) < end {
+		return nil, false
+	}
+	fl += len(c.extra)
+	res := make([]byte, 0, len(b)+len(c.extra))
+	res = append(res, b[:pl]...)
+	res = append(res, byte(fl>>16), byte(fl>>8), byte(fl))
+	res = append(res, h[3:]...)
+	res = append(res, b[pl+frameHeaderLen:end]...)
+	res = append(res, c.extra...)
+	res = append(res, b[end:]...)
+	return res, true
+}
+
+type tlsSettingsConn struct {
+	*settingsConn
+	cs connectionStater
+}
+
+func (c *tlsSettingsConn) ConnectionState() tls.ConnectionState {
+	return c.cs.ConnectionState()
+}
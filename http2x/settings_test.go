@@ -0,0 +1,71 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package http2x
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestWithSettings(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	c := WithSettings(cc, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 7})
+	go func() {
+		var buf bytes.Buffer
+		buf.WriteString(http2.ClientPreface)
+		fr := http2.NewFramer(&buf, nil)
+		fr.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+		fr.WriteWindowUpdate(0, 1000)
+		c.Write(buf.Bytes())
+		c.Close()
+	}()
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(sc, preface); err != nil {
+		t.Fatal(err)
+	}
+	if string(preface) != http2.ClientPreface {
+		t.Fatal("Preface altered")
+	}
+	fr := http2.NewFramer(nil, sc)
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, ok := f.(*http2.SettingsFrame)
+	if !ok {
+		t.Fatal("Expected SETTINGS frame, got ", f)
+	}
+	if v, ok := sf.Value(http2.SettingEnablePush); !ok || v != 0 {
+		t.Fatal("Original setting lost")
+	}
+	if v, ok := sf.Value(http2.SettingMaxConcurrentStreams); !ok || v != 7 {
+		t.Fatal("Setting not injected")
+	}
+	f, err = fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(*http2.WindowUpdateFrame); !ok {
+		t.Fatal("Expected WINDOW_UPDATE frame, got ", f)
+	}
+}
+
+func TestWithSettingsPassThrough(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	c := WithSettings(cc, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 7})
+	go func() {
+		c.Write([]byte("hello"))
+		c.Close()
+	}()
+	b, _ := ioutil.ReadAll(sc)
+	if string(b) != "hello" {
+		t.Fatal("Unexpected data: ", string(b))
+	}
+}
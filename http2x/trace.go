@@ -0,0 +1,256 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package http2x
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// FrameEvent describes a single HTTP/2 frame observed on a connection.
+// Only frame headers and payloads of small control frames are inspected.
+// HEADERS and DATA payloads are not decoded.
+type FrameEvent struct {
+
+	// Time is the time at which the frame was fully read or written.
+	Time time.Time
+
+	// LocalAddr and RemoteAddr identify the connection.
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+
+	// Outbound is true for frames written to the connection
+	// and false for frames read from it.
+	Outbound bool
+
+	Type     http2.FrameType
+	Flags    http2.Flags
+	StreamID uint32
+
+	// Length is the length of the frame payload.
+	Length int
+
+	// ErrCode is the error code of RST_STREAM and GOAWAY frames.
+	ErrCode http2.ErrCode
+
+	// LastStreamID is the last stream identifier of GOAWAY frames.
+	LastStreamID uint32
+
+	// Increment is the window size increment of WINDOW_UPDATE frames.
+	Increment uint32
+
+	// Settings holds the parameters of SETTINGS frames.
+	Settings []http2.Setting
+}
+
+// FrameHandler is called for every frame observed by a traced connection.
+// It is called synchronously from connection reads and writes, so it must
+// be quick and must not block.
+type FrameHandler func(*FrameEvent)
+
+// WithFrameTracer wraps client side connection c so that every HTTP/2 frame
+// read from or written to it is reported to h. The client connection
+// preface is skipped.
+// If h is nil, c is returned as is.
+//
+// If c provides ConnectionState, as *tls.Conn does, so does the returned
+// connection.
+func WithFrameTracer(c net.Conn, h FrameHandler) net.Conn {
+	if h == nil {
+		return c
+	}
+	res := &tracedConn{
+		Conn: c,
+		in:   frameScanner{h: h, local: c.LocalAddr(), remote: c.RemoteAddr()},
+		out:  frameScanner{h: h, local: c.LocalAddr(), remote: c.RemoteAddr(), outbound: true, skip: len(http2.ClientPreface)},
+	}
+	if cs, ok := c.(connectionStater); ok {
+		return &tlsTracedConn{tracedConn: res, cs: cs}
+	}
+	return res
+}
+
+// WithGoAwayHandler wraps client side connection c so that every GOAWAY
+// frame read from it is reported to h. Only inbound frames are scanned,
+// which makes it cheaper than WithFrameTracer.
+// If h is nil, c is returned as is.
+//
+// If c provides ConnectionState, as *tls.Conn does, so does the returned
+// connection.
+func WithGoAwayHandler(c net.Conn, h FrameHandler) net.Conn {
+	if h == nil {
+		return c
+	}
+	res := &tracedConn{
+		Conn: c,
+		in:   frameScanner{h: h, local: c.LocalAddr(), remote: c.RemoteAddr(), filtered: true, only: http2.FrameGoAway},
+	}
+	if cs, ok := c.(connectionStater); ok {
+		return &tlsTracedConn{tracedConn: res, cs: cs}
+	}
+	return res
+}
+
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+type tracedConn struct {
+	net.Conn
+	in  frameScanner
+	out frameScanner
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.scan(b[:n])
+	return n, err
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.out.h != nil {
+		c.out.scan(b[:n])
+	}
+	return n, err
+}
+
+type tlsTracedConn struct {
+	*tracedConn
+	cs connectionStater
+}
+
+func (c *tlsTracedConn) ConnectionState() tls.ConnectionState {
+	return c.cs.ConnectionState()
+}
+
+// maxTracedPayload is the maximum number of payload bytes retained
+// for decoding of a control frame.
+const maxTracedPayload = 16 * 6
+
+// frameScanner extracts frames from a stream of bytes
+// flowing in one direction.
+type frameScanner struct {
+	mu       sync.Mutex
+	h        FrameHandler
+	local    net.Addr
+	remote   net.Addr
+	outbound bool
+
+	// if filtered, only frames of type only are decoded and reported
+	filtered bool
+	only     http2.FrameType
+
+	// number of bytes still to be skipped, such as the preface
+	skip int
+
+	hdr  [frameHeaderLen]byte
+	hdrN int
+	// payload bytes of the current frame that are still to come
+	rem     int
+	payload []byte
+}
+
+func (s *frameScanner) scan(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(b) > 0 {
+		if s.skip > 0 {
+			n := minInt(s.skip, len(b))
+			s.skip -= n
+			b = b[n:]
+			continue
+		}
+		if s.hdrN < frameHeaderLen {
+			n := copy(s.hdr[s.hdrN:], b)
+			s.hdrN += n
+			b = b[n:]
+			if s.hdrN < frameHeaderLen {
+				return
+			}
+			s.rem = int(s.hdr[0])<<16 | int(s.hdr[1])<<8 | int(s.hdr[2])
+			s.payload = s.payload[:0]
+		}
+		n := minInt(s.rem, len(b))
+		if s.isDecoded() && len(s.payload) < maxTracedPayload {
+			s.payload = append(s.payload, b[:minInt(n, maxTracedPayload-len(s.payload))]...)
+		}
+		s.rem -= n
+		b = b[n:]
+		if s.rem == 0 {
+			if s.isReported() {
+				s.emit()
+			}
+			s.hdrN = 0
+		}
+	}
+}
+
+// isReported returns true if the current frame is to be reported.
+func (s *frameScanner) isReported() bool {
+	return !s.filtered || http2.FrameType(s.hdr[3]) == s.only
+}
+
+func (s *frameScanner) isDecoded() bool {
+	if !s.isReported() {
+		return false
+	}
+	switch http2.FrameType(s.hdr[3]) {
+	case http2.FrameRSTStream, http2.FrameGoAway, http2.FrameWindowUpdate, http2.FrameSettings:
+		return true
+	}
+	return false
+}
+
+func (s *frameScanner) emit() {
+	h := s.hdr
+	ev := &FrameEvent{
+		Time:       time.Now(),
+		LocalAddr:  s.local,
+		RemoteAddr: s.remote,
+		Outbound:   s.outbound,
+		Type:       http2.FrameType(h[3]),
+		Flags:      http2.Flags(h[4]),
+		StreamID:   uint32(h[5]&0x7f)<<24 | uint32(h[6])<<16 | uint32(h[7])<<8 | uint32(h[8]),
+		Length:     int(h[0])<<16 | int(h[1])<<8 | int(h[2]),
+	}
+	p := s.payload
+	switch ev.Type {
+	case http2.FrameRSTStream:
+		if len(p) >= 4 {
+			ev.ErrCode = http2.ErrCode(be32(p))
+		}
+	case http2.FrameGoAway:
+		if len(p) >= 8 {
+			ev.LastStreamID = be32(p) & 0x7fffffff
+			ev.ErrCode = http2.ErrCode(be32(p[4:]))
+		}
+	case http2.FrameWindowUpdate:
+		if len(p) >= 4 {
+			ev.Increment = be32(p) & 0x7fffffff
+		}
+	case http2.FrameSettings:
+		for ; len(p) >= 6; p = p[6:] {
+			ev.Settings = append(ev.Settings, http2.Setting{
+				ID:  http2.SettingID(uint16(p[0])<<8 | uint16(p[1])),
+				Val: be32(p[2:]),
+			})
+		}
+	}
+	s.h(ev)
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package http2x
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestWithFrameTracer(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	var evs []*FrameEvent
+	c := WithFrameTracer(cc, func(ev *FrameEvent) { evs = append(evs, ev) })
+	var out bytes.Buffer
+	out.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(&out, nil)
+	fr.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+	fr.WriteData(1, true, []byte("hello"))
+	fr.WriteRSTStream(3, http2.ErrCodeRefusedStream)
+	var in bytes.Buffer
+	fr = http2.NewFramer(&in, nil)
+	fr.WriteWindowUpdate(0, 1000)
+	fr.WriteGoAway(5, http2.ErrCodeNo, nil)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		b := out.Bytes()
+		// split writes must be handled
+		c.Write(b[:len(http2.ClientPreface)+4])
+		c.Write(b[len(http2.ClientPreface)+4:])
+	}()
+	if _, err := io.ReadFull(sc, make([]byte, out.Len())); err != nil {
+		t.Fatal(err)
+	}
+	<-written
+	go func() {
+		sc.Write(in.Bytes())
+		sc.Close()
+	}()
+	ioutil.ReadAll(c)
+	if len(evs) != 5 {
+		t.Fatal("Expected 5 frames, got ", len(evs))
+	}
+	ev := evs[0]
+	if !ev.Outbound || ev.Type != http2.FrameSettings || len(ev.Settings) != 1 || ev.Settings[0].ID != http2.SettingEnablePush {
+		t.Fatal("Unexpected SETTINGS event: ", ev)
+	}
+	ev = evs[1]
+	if ev.Type != http2.FrameData || ev.StreamID != 1 || ev.Length != 5 || !ev.Flags.Has(http2.FlagDataEndStream) {
+		t.Fatal("Unexpected DATA event: ", ev)
+	}
+	ev = evs[2]
+	if ev.Type != http2.FrameRSTStream || ev.StreamID != 3 || ev.ErrCode != http2.ErrCodeRefusedStream {
+		t.Fatal("Unexpected RST_STREAM event: ", ev)
+	}
+	ev = evs[3]
+	if ev.Outbound || ev.Type != http2.FrameWindowUpdate || ev.Increment != 1000 {
+		t.Fatal("Unexpected WINDOW_UPDATE event: ", ev)
+	}
+	ev = evs[4]
+	if ev.Type != http2.FrameGoAway || ev.LastStreamID != 5 || ev.ErrCode != http2.ErrCodeNo {
+		t.Fatal("Unexpected GOAWAY event: ", ev)
+	}
+}
+
+func TestWithFrameTracerDisabled(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	defer sc.Close()
+	if WithFrameTracer(cc, nil) != cc {
+		t.Fatal("Connection should not be wrapped")
+	}
+}
+
+func TestWithGoAwayHandler(t *testing.T) {
+	cc, sc := net.Pipe()
+	var evs []*FrameEvent
+	c := WithGoAwayHandler(cc, func(ev *FrameEvent) { evs = append(evs, ev) })
+	if WithGoAwayHandler(cc, nil) != cc {
+		t.Fatal("Connection should not be wrapped")
+	}
+	var out bytes.Buffer
+	fr := http2.NewFramer(&out, nil)
+	fr.WriteGoAway(1, http2.ErrCodeNo, nil)
+	var in bytes.Buffer
+	fr = http2.NewFramer(&in, nil)
+	fr.WriteWindowUpdate(0, 1000)
+	fr.WriteGoAway(7, http2.ErrCodeEnhanceYourCalm, nil)
+	go func() {
+		c.Write(out.Bytes())
+	}()
+	if _, err := io.ReadFull(sc, make([]byte, out.Len())); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		sc.Write(in.Bytes())
+		sc.Close()
+	}()
+	ioutil.ReadAll(c)
+	if len(evs) != 1 {
+		t.Fatal("Expected 1 frame, got ", len(evs))
+	}
+	ev := evs[0]
+	if ev.Outbound || ev.Type != http2.FrameGoAway || ev.LastStreamID != 7 || ev.ErrCode != http2.ErrCodeEnhanceYourCalm {
+		t.Fatal("Unexpected GOAWAY event: ", ev)
+	}
+}
+
+func TestGoAwayScannerSkipsOtherFrames(t *testing.T) {
+	var buf bytes.Buffer
+	fr := http2.NewFramer(&buf, nil)
+	fr.WriteData(1, false, make([]byte, 100))
+	fr.WriteWindowUpdate(0, 1000)
+	fr.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100})
+	b := buf.Bytes()
+	s := frameScanner{h: func(ev *FrameEvent) { t.Fatal("Unexpected event: ", ev) }, filtered: true, only: http2.FrameGoAway}
+	if n := testing.AllocsPerRun(100, func() { s.scan(b) }); n != 0 {
+		t.Fatal("Expected no allocations, got ", n)
+	}
+}
@@ -0,0 +1,5 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+// Package statsd provides an optional emitter of apns2 client metrics
+// to a statsd or DogStatsD endpoint.
+package statsd
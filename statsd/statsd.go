@@ -0,0 +1,126 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baobabus/go-apns/apns2"
+)
+
+// Emitter sends apns2 client metrics to a statsd endpoint over UDP.
+// It is safe for use in concurrent goroutines.
+//
+// Per-attempt metrics are emitted by OnAttempt, which is intended to be set
+// as apns2.ProcCfg.OnAttempt hook. Client-wide gauges are emitted by Run
+// or EmitStats.
+type Emitter struct {
+	prefix string
+	tags   bool
+	conn   net.Conn
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+// New creates an Emitter sending to the statsd endpoint at addr.
+// All metric names are prefixed with prefix. If dogStatsD is true,
+// metrics are tagged with status and topic in DogStatsD format.
+// Otherwise status is made part of the metric name.
+func New(addr string, prefix string, dogStatsD bool) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Emitter{prefix: prefix, tags: dogStatsD, conn: conn}, nil
+}
+
+// OnAttempt emits metrics for a single push attempt: an attempt counter,
+// a latency timer and, if the attempt is to be retried, a retry counter.
+func (e *Emitter) OnAttempt(ev *apns2.AttemptEvent) {
+	status := "error"
+	if ev.Response != nil {
+		status = strconv.Itoa(ev.Response.StatusCode)
+	}
+	topic := ""
+	if ev.Notification != nil && ev.Notification.Header != nil {
+		topic = ev.Notification.Header.Topic
+	}
+	ms := float64(ev.Latency) / float64(time.Millisecond)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf.Reset()
+	if e.tags {
+		tags := "|#status:" + status
+		if topic != "" {
+			tags += ",topic:" + topic
+		}
+		fmt.Fprintf(&e.buf, "%sattempt:1|c%s\n", e.prefix, tags)
+		fmt.Fprintf(&e.buf, "%slatency:%g|ms%s\n", e.prefix, ms, tags)
+		if ev.WillRetry {
+			fmt.Fprintf(&e.buf, "%sretry:1|c%s\n", e.prefix, tags)
+		}
+	} else {
+		fmt.Fprintf(&e.buf, "%sattempt.%s:1|c\n", e.prefix, status)
+		fmt.Fprintf(&e.buf, "%slatency:%g|ms\n", e.prefix, ms)
+		if ev.WillRetry {
+			fmt.Fprintf(&e.buf, "%sretry:1|c\n", e.prefix)
+		}
+	}
+	e.flushLocked()
+}
+
+// EmitStats emits client-wide gauges taken from a Stats snapshot.
+// Dropped requests are emitted per drop reason, which is tagged
+// or made part of the metric name the same way as status in OnAttempt.
+func (e *Emitter) EmitStats(s apns2.Stats) {
+	reasons := make([]string, 0, len(s.DroppedRequests))
+	for r := range s.DroppedRequests {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf.Reset()
+	fmt.Fprintf(&e.buf, "%sconns:%d|g\n", e.prefix, s.Conns)
+	fmt.Fprintf(&e.buf, "%sretries:%d|g\n", e.prefix, s.Retries)
+	for _, r := range reasons {
+		name := strings.Replace(r, " ", "_", -1)
+		if e.tags {
+			fmt.Fprintf(&e.buf, "%sdropped:%d|g|#reason:%s\n", e.prefix, s.DroppedRequests[r], name)
+		} else {
+			fmt.Fprintf(&e.buf, "%sdropped.%s:%d|g\n", e.prefix, name, s.DroppedRequests[r])
+		}
+	}
+	e.flushLocked()
+}
+
+// Run periodically emits client's Stats until ctl is closed.
+func (e *Emitter) Run(c *apns2.Client, interval time.Duration, ctl <-chan struct{}) {
+	tkr := time.NewTicker(interval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-tkr.C:
+			e.EmitStats(c.Stats())
+		case <-ctl:
+			return
+		}
+	}
+}
+
+// Close closes emitter's connection.
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
+
+func (e *Emitter) flushLocked() {
+	// Metrics are best effort. Delivery errors are ignored.
+	e.conn.Write(bytes.TrimRight(e.buf.Bytes(), "\n"))
+}
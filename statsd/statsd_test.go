@@ -0,0 +1,75 @@
+// Copyright 2017 Aleksey Blinov. All rights reserved.
+
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/baobabus/go-apns/apns2"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustListen(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func mustRead(t *testing.T, pc net.PacketConn) string {
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestOnAttempt(t *testing.T) {
+	pc := mustListen(t)
+	defer pc.Close()
+	ev := &apns2.AttemptEvent{
+		Notification: &apns2.Notification{Header: &apns2.Header{Topic: "com.example"}},
+		Response:     &apns2.Response{StatusCode: 429},
+		Latency:      1500 * time.Microsecond,
+		WillRetry:    true,
+	}
+	e, err := New(pc.LocalAddr().String(), "apns2.", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.OnAttempt(ev)
+	assert.Equal(t, "apns2.attempt.429:1|c\napns2.latency:1.5|ms\napns2.retry:1|c", mustRead(t, pc))
+	d, err := New(pc.LocalAddr().String(), "apns2.", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.OnAttempt(ev)
+	assert.Equal(t, "apns2.attempt:1|c|#status:429,topic:com.example\n"+
+		"apns2.latency:1.5|ms|#status:429,topic:com.example\n"+
+		"apns2.retry:1|c|#status:429,topic:com.example", mustRead(t, pc))
+}
+
+func TestEmitStats(t *testing.T) {
+	pc := mustListen(t)
+	defer pc.Close()
+	e, err := New(pc.LocalAddr().String(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.EmitStats(apns2.Stats{Conns: 3, Retries: 7})
+	assert.Equal(t, "conns:3|g\nretries:7|g", mustRead(t, pc))
+	dropped := map[string]uint64{apns2.DropReasonTimeout: 2, apns2.DropReasonDeviceToken: 1}
+	e.EmitStats(apns2.Stats{Conns: 3, Retries: 7, DroppedRequests: dropped})
+	assert.Equal(t, "conns:3|g\nretries:7|g\ndropped.malformed_device_token:1|g\ndropped.timeout:2|g", mustRead(t, pc))
+	e.tags = true
+	e.EmitStats(apns2.Stats{DroppedRequests: dropped})
+	assert.Equal(t, "conns:0|g\nretries:0|g\ndropped:1|g|#reason:malformed_device_token\ndropped:2|g|#reason:timeout", mustRead(t, pc))
+}